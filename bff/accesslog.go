@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/tracing"
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+
+	// maxRequestIDLen bounds client supplied request IDs.
+	maxRequestIDLen = 128
+)
+
+// requestID assigns every request an ID, reusing a sane client supplied one, returns it in the
+// X-Request-ID header and attaches a logger carrying it to the request context, so controller
+// log lines of the request can be correlated with its access log line.
+func requestID(log *zerolog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = xid.New().String()
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		l := log.With().Str(requestIDKey, id).Logger()
+		c.Request = c.Request.WithContext(l.WithContext(c.Request.Context()))
+
+		c.Next()
+	}
+}
+
+// traceContext continues the trace of a request carrying a traceparent header, so tasks it creates
+// are recorded under the trace of the caller.
+func traceContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, ok := tracing.Parse(c.GetHeader(tracing.Header)); ok {
+			ctx := tracing.WithTraceID(c.Request.Context(), id)
+			l := zerolog.Ctx(ctx).With().Str("trace_id", id).Logger()
+			c.Request = c.Request.WithContext(l.WithContext(ctx))
+		}
+
+		c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// accessLog writes one structured line per request with the request-scoped logger.
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		l := zerolog.Ctx(c.Request.Context())
+		status := c.Writer.Status()
+
+		var e *zerolog.Event
+		switch {
+		case status >= http.StatusInternalServerError:
+			e = l.Error()
+		case status >= http.StatusBadRequest:
+			e = l.Warn()
+		default:
+			e = l.Info()
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		e.Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Str("route", route).
+			Int("status", status).
+			Int("bytes", max(c.Writer.Size(), 0)).
+			Dur("latency", time.Since(start)).
+			Str("client_ip", c.ClientIP()).
+			Str("user_agent", c.Request.UserAgent())
+		if len(c.Errors) != 0 {
+			e.Str("errors", c.Errors.String())
+		}
+		e.Msg("request served")
+	}
+}
+
+// recovery turns a handler panic into a 500 logged with the request-scoped logger.
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		zerolog.Ctx(c.Request.Context()).Error().Any("panic", err).Msg("handler panicked")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "internal server error",
+		})
+	})
+}
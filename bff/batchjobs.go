@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/rs/zerolog"
+)
+
+// batchWaitInterval is how often a legacy upload checks whether its batch finished.
+const batchWaitInterval = time.Second
+
+// batchWorkers check the rows of submission CSV batches in the background, so an upload only
+// records its batch. Rows left by a stopped process are claimed again once their lease runs out.
+type batchWorkers struct {
+	// wake shortens the wait for batches submitted to this process.
+	wake chan struct{}
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// StartBatchWorkers starts the configured number of batch workers until Shutdown is called.
+func (a *API) StartBatchWorkers(ctx context.Context) {
+	ctx, a.batches.stop = context.WithCancel(ctx)
+	a.batches.wake = make(chan struct{}, a.batchCfg.Workers)
+
+	for range a.batchCfg.Workers {
+		a.batches.wg.Add(1)
+		go func() {
+			defer a.batches.wg.Done()
+			a.runBatchWorker(ctx)
+		}()
+	}
+}
+
+// wakeBatchWorkers makes idle workers claim rows without waiting for the next poll.
+func (a *API) wakeBatchWorkers() {
+	for range a.batchCfg.Workers {
+		select {
+		case a.batches.wake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// stopBatchWorkers stops the batch workers and waits until ctx is done for the rows they check.
+// The rows stay claimed until their lease runs out.
+func (a *API) stopBatchWorkers(ctx context.Context) error {
+	if a.batches.stop == nil {
+		return nil
+	}
+	a.batches.stop()
+
+	done := make(chan struct{})
+	go func() {
+		a.batches.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("batch workers did not stop: %w", ctx.Err())
+	}
+}
+
+func (a *API) runBatchWorker(ctx context.Context) {
+	ticker := time.NewTicker(a.batchCfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Check rows until none is left, then wait for new batches.
+		for {
+			ok, err := a.checkBatchRow(ctx)
+			if err != nil {
+				a.log.Error().Err(err).Msg("check batch row failed")
+			}
+			if err != nil || !ok {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.batches.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkBatchRow claims a row of a batch, checks its video and records the outcome.
+// It reports false when no row was left to claim.
+func (a *API) checkBatchRow(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	row, ok, err := a.taskContoller.ClaimBatchRow(ctx)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	logger := a.log.With().Int64("batch_id", row.BatchID).Int32("row", row.RowNo).Str("uuid", row.UUID).Logger()
+	ctx = logger.WithContext(ctx)
+
+	start := time.Now()
+	res, err := a.checkRow(ctx, row)
+	if ctx.Err() != nil {
+		// The worker is stopping; the row is claimed again once its lease runs out.
+		return false, nil
+	}
+	if err != nil {
+		// A failed row is counted in the batch summary and left out of the result.
+		logger.Error().Err(err).Msg("run copyright failed")
+	}
+
+	if err := a.taskContoller.CompleteBatchRow(ctx, row, model.BatchRowResult{
+		Failed:       err != nil,
+		IsDuplicate:  res.IsDuplicate,
+		DuplicateFor: res.DuplicateFor,
+		Score:        res.Score,
+		Latency:      time.Since(start),
+	}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkRow checks the video of a row, waiting for the task created by an earlier claim if there is one.
+func (a *API) checkRow(ctx context.Context, row model.BatchRow) (copyrightCheck, error) {
+	id := row.TaskID
+	if id == 0 {
+		var err error
+		id, err = a.startCopyright(ctx, VideoLinkRequest{
+			Link:   row.Link,
+			Name:   row.UUID,
+			Source: row.Source,
+		})
+		if err != nil {
+			return copyrightCheck{}, err
+		}
+
+		if err := a.taskContoller.SetBatchRowTask(ctx, row, id); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Int64("task_id", id).Msg("set batch row task failed")
+		}
+	}
+
+	return a.finishCopyright(ctx, id)
+}
+
+// waitBatch waits until the batch finishes or ctx is done and returns its summary.
+func (a *API) waitBatch(ctx context.Context, id int64) (model.BatchSummary, error) {
+	ticker := time.NewTicker(batchWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		s, err := a.taskContoller.GetBatchSummary(ctx, id)
+		if err != nil {
+			return model.BatchSummary{}, err
+		}
+		if !s.FinishedAt.IsZero() {
+			return s, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return model.BatchSummary{}, ctx.Err()
+		}
+	}
+}
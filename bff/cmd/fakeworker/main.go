@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/fakeworker"
+	"github.com/rs/zerolog"
+)
+
+// fakeworker stands in for the audio and video ML services, answering their Kafka requests with
+// canned results so the pipeline runs end to end without GPUs.
+func main() {
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := fakeworker.Run(ctx, os.Args[1:], &log); err != nil {
+		log.Error().Err(err).Msg("fake worker failed")
+		os.Exit(1)
+	}
+}
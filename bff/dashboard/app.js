@@ -0,0 +1,155 @@
+// The dashboard polls the API with the credentials saved in the browser.
+const API = "/v1";
+const REFRESH_INTERVAL_MS = 5000;
+const RECENT_TASKS = 20;
+
+const tokenInput = document.getElementById("token");
+const apiKeyInput = document.getElementById("api-key");
+const autoRefresh = document.getElementById("auto-refresh");
+
+tokenInput.value = localStorage.getItem("dashboard.token") || "";
+apiKeyInput.value = localStorage.getItem("dashboard.apiKey") || "";
+
+document.getElementById("credentials").addEventListener("submit", (event) => {
+  event.preventDefault();
+  localStorage.setItem("dashboard.token", tokenInput.value);
+  localStorage.setItem("dashboard.apiKey", apiKeyInput.value);
+  refresh();
+});
+
+async function get(path) {
+  const headers = {};
+  if (tokenInput.value) {
+    headers["Authorization"] = "Bearer " + tokenInput.value;
+  }
+  if (apiKeyInput.value) {
+    headers["X-API-Key"] = apiKeyInput.value;
+  }
+
+  const resp = await fetch(API + path, { headers });
+  if (!resp.ok) {
+    let message = resp.statusText;
+    try {
+      message = (await resp.json()).message || message;
+    } catch (_) {
+      // Not a JSON error body.
+    }
+    throw new Error(resp.status + " " + message);
+  }
+  return resp.json();
+}
+
+function showError(id, err) {
+  const el = document.getElementById(id);
+  el.hidden = !err;
+  el.textContent = err ? err.message : "";
+}
+
+function setText(id, value) {
+  document.getElementById(id).textContent = value;
+}
+
+// bestMatch describes the most probable candidate of a modality.
+function bestMatch(candidates) {
+  if (!candidates || candidates.length === 0) {
+    return "-";
+  }
+  const best = candidates.reduce((a, b) => (b.probability > a.probability ? b : a));
+  return best.name + " (" + best.probability.toFixed(2) + ")";
+}
+
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  row.appendChild(td);
+  return td;
+}
+
+async function refreshHealth() {
+  try {
+    const [sources, versions] = await Promise.all([
+      get("/admin/reports/sources?limit=1000"),
+      get("/admin/index-versions"),
+    ]);
+
+    const total = { tasks: 0, in_progress: 0, failed: 0 };
+    for (const s of sources) {
+      total.tasks += s.tasks;
+      total.in_progress += s.in_progress;
+      total.failed += s.failed;
+    }
+    setText("tasks-total", total.tasks);
+    setText("tasks-in-progress", total.in_progress);
+    setText("tasks-failed", total.failed);
+
+    const active = versions.find((v) => v.active);
+    setText("index-version", active ? active.version : "-");
+    showError("health-error", null);
+  } catch (err) {
+    showError("health-error", err);
+  }
+}
+
+// STAGE_STEPS maps the pipeline stages of a task to progress steps. Either modality may be
+// checked first, so both count as the same step.
+const STAGE_STEPS = {
+  uploading: 0,
+  audio_extraction: 1,
+  queued: 2,
+  audio_checked: 3,
+  video_checked: 3,
+  done: 4,
+};
+
+function progressBar(stage) {
+  const bar = document.createElement("progress");
+  bar.max = STAGE_STEPS.done;
+  bar.value = STAGE_STEPS[stage] || 0;
+  bar.title = stage || "-";
+  return bar;
+}
+
+async function refreshRecent() {
+  try {
+    const list = await get("/tasks?order=desc&limit=" + RECENT_TASKS);
+
+    const body = document.getElementById("tasks");
+    body.replaceChildren();
+    for (const t of list.tasks) {
+      const row = document.createElement("tr");
+      cell(row, t.task_id);
+      cell(row, t.status).className = "status-" + t.status;
+      cell(row, "").appendChild(progressBar(t.stage));
+      cell(row, bestMatch(t.video_copyright));
+      cell(row, bestMatch(t.audio_copyright));
+      cell(row, t.index_version || "-");
+
+      const trace = cell(row, "");
+      if (t.trace_url) {
+        const link = document.createElement("a");
+        link.href = t.trace_url;
+        link.target = "_blank";
+        link.rel = "noopener";
+        link.textContent = "trace";
+        trace.appendChild(link);
+      }
+      body.appendChild(row);
+    }
+    showError("recent-error", null);
+  } catch (err) {
+    showError("recent-error", err);
+  }
+}
+
+async function refresh() {
+  await Promise.all([refreshHealth(), refreshRecent()]);
+  setText("updated", new Date().toLocaleTimeString());
+}
+
+setInterval(() => {
+  if (autoRefresh.checked) {
+    refresh();
+  }
+}, REFRESH_INTERVAL_MS);
+
+refresh();
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// readyzTimeout bounds the checks of a readiness probe.
+const readyzTimeout = 2 * time.Second
+
+// ReadyzResponse reports the health of every dependency, "ok" or the error of its check.
+type ReadyzResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readyz serves the readiness probe: 200 when every dependency is healthy, 503 otherwise.
+func readyz(ctl *taskcontroller.TaskController) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+		defer cancel()
+
+		resp := ReadyzResponse{Status: "ok", Checks: map[string]string{}}
+		status := http.StatusOK
+		for name, err := range ctl.Readiness(ctx) {
+			if err != nil {
+				resp.Checks[name] = err.Error()
+				resp.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			resp.Checks[name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
+	"math"
 	"net/http"
-	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,6 +20,15 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/auth"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/csvdialect"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ratelimit"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/resultschema"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/scoring"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/tracing"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/urlnorm"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/urlpolicy"
 	"github.com/gulldan/cp2024yappy/bff/pkg/config"
 	"github.com/rs/zerolog"
 
@@ -25,11 +37,28 @@ import (
 	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
 )
 
-var dst = "submission.csv"
+// maxSubmissionSize bounds the multipart body of a submission CSV upload.
+const maxSubmissionSize = 64 << 20
+
+// errNoSubmissionFile is returned for a multipart request without the file part.
+var errNoSubmissionFile = errors.New("file part is missing")
+
+// apiKeyHeader carries the client API key.
+const apiKeyHeader = "X-API-Key"
+
+// storagePrefix is the route of the presigned URLs of a storage kept in a local directory.
+const storagePrefix = "/storage"
+
+const (
+	defaultTasksLimit = 50
+	maxTasksLimit     = 1000
+)
 
 type VideoLinkRequest struct {
-	Link string `json:"link"`
-	Name string `json:"-"`
+	Link     string       `json:"link" binding:"required,url" description:"video link" example:"https://example.com/video.mp4"`
+	Priority string       `json:"priority" binding:"omitempty,oneof=interactive batch" description:"interactive, the default, or batch; batch tasks are sent to the ML services once no interactive task waits"`
+	Name     string       `json:"-"`
+	Source   model.Source `json:"-"`
 }
 
 type VideoLinkResponse struct {
@@ -37,95 +66,226 @@ type VideoLinkResponse struct {
 	IsDuplicate  bool   `json:"is_duplicate,omitempty"`
 }
 
+// CheckVideoDuplicateResponse is the v1 shape of the duplicate check result;
+// unlike the legacy VideoLinkResponse it always reports is_duplicate.
+type CheckVideoDuplicateResponse struct {
+	IsDuplicate  bool            `json:"is_duplicate"`
+	DuplicateFor string          `json:"duplicate_for,omitempty"`
+	Matches      []MatchResponse `json:"matches" description:"all candidate references ranked by score, the first decides"`
+}
+
+// CheckPendingResponse answers a synchronous check that outlasted the wait budget. The check goes on;
+// its task is polled at TaskURL, no sooner than RetryAfter seconds apart, until the status is done or fail.
+type CheckPendingResponse struct {
+	TaskID     int64  `json:"task_id"`
+	Status     string `json:"status" description:"status of the task: in_progress"`
+	TaskURL    string `json:"task_url" description:"task to poll for the result, also sent in the Location header"`
+	RetryAfter int    `json:"retry_after" description:"seconds to wait between polls, also sent in the Retry-After header"`
+	Message    string `json:"message"`
+}
+
+type UploadURLResponse struct {
+	ObjectKey string `json:"object_key"`
+	UploadURL string `json:"upload_url"`
+}
+
+type TaskFromObjectRequest struct {
+	ObjectKey string `json:"object_key" binding:"required" description:"object_key returned by /task/upload-url"`
+	Name      string `json:"name" description:"video name, defaults to the object key"`
+	Priority  string `json:"priority" binding:"omitempty,oneof=interactive batch" description:"interactive, the default, or batch"`
+}
+
+type TaskCreatedResponse struct {
+	TaskID int64 `json:"task_id"`
+}
+
+type CopyrightResponse struct {
+	Name        string            `json:"name"`
+	Probability float64           `json:"probability" description:"probability of the best matching segment"`
+	Segments    []SegmentResponse `json:"segments,omitempty" description:"matching segments in order of their start in the checked video, when the ML service reports them"`
+	Copied      float64           `json:"copied_seconds,omitempty" description:"length of the checked video covered by the matching segments, seconds"`
+}
+
+// MatchResponse is a candidate reference scored from the probabilities of both modalities.
+type MatchResponse struct {
+	Name             string  `json:"name"`
+	Score            float64 `json:"score" description:"score by the decision strategy, compared with its threshold"`
+	VideoProbability float64 `json:"video_probability" description:"0 when the video modality did not find the reference"`
+	AudioProbability float64 `json:"audio_probability" description:"0 when the audio modality did not find the reference"`
+}
+
+type SegmentResponse struct {
+	Start          float64 `json:"start" description:"start of the match in the checked video, seconds"`
+	End            float64 `json:"end"`
+	ReferenceStart float64 `json:"reference_start" description:"start of the match in the reference, seconds"`
+	ReferenceEnd   float64 `json:"reference_end"`
+	Probability    float64 `json:"probability"`
+}
+
+type TaskResponse struct {
+	TaskID               int64                 `json:"task_id"`
+	Status               string                `json:"status"`
+	Stage                string                `json:"stage,omitempty" description:"pipeline step reached: uploading, audio_extraction, queued, audio_checked, video_checked or done; a failed task keeps the step it failed in"`
+	Priority             string                `json:"priority,omitempty" description:"interactive or batch"`
+	VideoCopyright       []CopyrightResponse   `json:"video_copyright,omitempty"`
+	AudioCopyright       []CopyrightResponse   `json:"audio_copyright,omitempty"`
+	IndexVersion         string                `json:"index_version,omitempty"`
+	ParentTaskID         int64                 `json:"parent_task_id,omitempty"`
+	QueuePosition        int64                 `json:"queue_position,omitempty" description:"approximate position among pending tasks"`
+	DownloadVerification string                `json:"download_verification,omitempty" description:"how the downloaded video was verified: content_md5, etag or none"`
+	Partial              bool                  `json:"partial,omitempty" description:"the candidates are intermediate, some modalities are still being processed"`
+	PendingModalities    []string              `json:"pending_modalities,omitempty" description:"modalities without results yet: audio, video"`
+	OverdueModalities    []string              `json:"overdue_modalities,omitempty" description:"pending modalities whose results are later than their SLA"`
+	FailureReason        string                `json:"failure_reason,omitempty" description:"why a failed task was given up on"`
+	CreatedAt            *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt            *time.Time            `json:"updated_at,omitempty" description:"when the status or a result of the task last changed"`
+	Deadline             *time.Time            `json:"deadline,omitempty" description:"when the task is failed unless finished"`
+	TraceID              string                `json:"trace_id,omitempty" description:"W3C trace ID of the task processing"`
+	TraceURL             string                `json:"trace_url,omitempty" description:"link to the trace in the trace viewer"`
+	Hashes               map[string]string     `json:"hashes,omitempty" description:"digests of the video by algorithm: md5, sha256, xxh3, and its perceptual hash as dhash"`
+	Timings              []StageTimingResponse `json:"timings,omitempty" description:"when the upload, audio extraction and checks of the task started and finished"`
+	Duration             float64               `json:"duration,omitempty" description:"length of the video, seconds"`
+	Width                int                   `json:"width,omitempty" description:"pixels"`
+	Height               int                   `json:"height,omitempty" description:"pixels"`
+	FileSize             int64                 `json:"file_size,omitempty" description:"bytes stored for the video"`
+	ContentHash          string                `json:"content_hash,omitempty" description:"MD5 of the video as uploaded"`
+	Matches              []MatchResponse       `json:"matches,omitempty" description:"candidate references of both modalities ranked by score"`
+}
+
+type TaskListResponse struct {
+	Tasks      []TaskResponse `json:"tasks"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
 type API struct {
 	log           *zerolog.Logger
 	r             *gin.Engine
+	srv           *http.Server
+	tls           bool
 	taskContoller *taskcontroller.TaskController
+	results       *resultschema.Registry
+	auth          *auth.Authenticator
+	limitByIP     *ratelimit.Limiter
+	limitByKey    *ratelimit.Limiter
+	// apiKeys holds the fingerprints of the registered API keys, see config.QuotaConfig.
+	apiKeys      map[string]bool
+	quotaEnabled bool
+	// traceURL is the trace viewer URL template linked from task responses.
+	traceURL string
+	// checkWait bounds the wait of synchronous checks, see config.ServerConfig.CheckWaitBudget.
+	checkWait       time.Duration
+	checkRetryAfter time.Duration
+	// scorer decides duplicates from the results of the modalities.
+	scorer scoring.Scorer
+	// threshold is the lowest score of a duplicate.
+	threshold float64
+	batchCfg  config.BatchConfig
+	batches   batchWorkers
 }
 
-func New(cfg *config.Config, log *zerolog.Logger, f fs.FS) (*API, error) {
+func New(cfg *config.Config, log *zerolog.Logger, f fs.FS, ctl *taskcontroller.TaskController) (*API, error) {
+	results, err := resultschema.New()
+	if err != nil {
+		return nil, err
+	}
+
+	scorer, err := scoring.New(scoring.Config{
+		Strategy:    cfg.Decision.Strategy,
+		VideoWeight: cfg.Decision.VideoWeight,
+		AudioWeight: cfg.Decision.AudioWeight,
+		Threshold:   cfg.Decision.Threshold,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	a := &API{
-		log: log,
+		log:             log,
+		taskContoller:   ctl,
+		results:         results,
+		limitByIP:       ratelimit.New(cfg.RateLimit.IPRate, cfg.RateLimit.IPBurst, cfg.RateLimit.IdleTTL),
+		limitByKey:      ratelimit.New(cfg.RateLimit.KeyRate, cfg.RateLimit.KeyBurst, cfg.RateLimit.IdleTTL),
+		traceURL:        cfg.TraceURLTemplate,
+		checkWait:       cfg.Server.CheckWaitBudget,
+		checkRetryAfter: cfg.Server.CheckRetryAfter,
+		scorer:          scorer,
+		threshold:       cfg.Decision.Threshold,
+		batchCfg:        cfg.Batch,
+		apiKeys:         make(map[string]bool),
+		quotaEnabled:    cfg.Quota.Enabled(),
+	}
+	for _, key := range cfg.Quota.Keys() {
+		a.apiKeys[apiKeyID(key)] = true
+	}
+	switch {
+	case cfg.Auth.Issuer != "":
+		a.auth = auth.New(auth.Config{
+			Issuer:     cfg.Auth.Issuer,
+			Audience:   cfg.Auth.Audience,
+			RolesClaim: cfg.Auth.RolesClaim,
+			KeysMaxAge: cfg.Auth.KeysMaxAge,
+		}, &http.Client{Timeout: 10 * time.Second})
+	case cfg.Auth.Disabled:
+		log.Warn().Msg("authentication disabled, the admin and result routes are served to anyone")
+		a.auth = auth.Disabled()
+	default:
+		log.Warn().Msg("no OIDC issuer configured, the admin and result routes are refused")
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(requestID(log), traceContext(), accessLog(), recovery())
 	router.Use(cors.New(cors.Config{
 		AllowOriginFunc: func(origin string) bool {
 			return true
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD", "PATCH"},
 		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"Location"},
+		ExposeHeaders:    []string{"Location", "Retry-After", "Deprecation", "Sunset", "Link", requestIDHeader, resultschema.Header, batchIDHeader, batchSummaryHeader, batchTokenHeader, batchTokenExpiresHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
-	router.MaxMultipartMemory = 32 << 20
-
-	router.POST("/check-video-duplicate", a.CheckVideoDuplicate)
-	f, _ = fs.Sub(f, "swagger-ui/docs")
-	router.StaticFS("/docs", http.FS(f))
-	router.POST("/upload", func(c *gin.Context) {
-		// single file
-		file, _ := c.FormFile("file")
-
-		// Upload the file to specific dst.
-		if err := c.SaveUploadedFile(file, dst); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"message": "Save upload file failed: " + err.Error(),
-			})
-			return
-		}
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
 
-		outputFile, err := os.Create("output.csv")
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"message": "create submission file failed: " + err.Error(),
-			})
-			return
-		}
-		defer outputFile.Close()
+	docs, _ := fs.Sub(f, "swagger-ui/docs")
+	router.StaticFS("/docs", http.FS(docs))
 
-		writer := csv.NewWriter(outputFile)
+	// The operations dashboard polls the API with the credentials entered in the browser.
+	dashboard, _ := fs.Sub(f, "dashboard")
+	router.StaticFS("/dashboard", http.FS(dashboard))
 
-		videos := readCsv()
-		for _, v := range videos {
-			id, copyrighted, err := a.runCopyright(VideoLinkRequest{
-				Link: v.Link,
-				Name: v.UUID,
-			})
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-					"message": "run copyright failed: " + err.Error(),
-				})
-				continue
-			}
-
-			record := []string{
-				v.Created.Format(time.RFC3339),
-				v.UUID,
-				v.Link,
-				strconv.FormatBool(copyrighted),
-				id,
-			}
-
-			if err := writer.Write(record); err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-					"message": "write submission file failed: " + err.Error(),
-				})
-				continue
-			}
-		}
+	spec := apispec.New("Video Duplicate Checker API", apiV1)
+	router.GET("/openapi.json", spec.Handler())
+	router.GET("/readyz", gin.WrapH(readyz(ctl)))
 
-		writer.Flush()
-		c.File("output.csv")
-	})
+	// A storage kept in a local directory serves its presigned URLs here; the signature authorizes them.
+	if h := ctl.StorageHandler(); h != nil {
+		router.Any(storagePrefix+"/*object", gin.WrapH(http.StripPrefix(storagePrefix, h)))
+	}
 
-	a.r = router
+	a.registerRoutes(router.Group(latestPrefix, withAPIVersion(apiV1)), spec)
+	a.registerRoutes(router.Group("", withAPIVersion(apiLegacy), deprecated(cfg.LegacyAPISunset)), nil)
 
-	var err error
-	a.taskContoller, err = taskcontroller.New(cfg, log)
-	if err != nil {
-		return nil, err
+	a.r = router
+	a.srv = &http.Server{
+		Addr:              ":" + cfg.HTTPPort,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	if cfg.Server.TLSCertFile != "" && cfg.Server.TLSKeyFile != "" {
+		// Load the key pair up front so a misconfiguration fails the start instead of every handshake.
+		cert, err := tls.LoadX509KeyPair(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls key pair failed: %w", err)
+		}
+		a.srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		a.tls = true
 	}
 
 	return a, nil
@@ -137,21 +297,48 @@ type Video struct {
 	Link    string
 }
 
-func readCsv() []Video {
-	file, err := os.Open(dst)
+// readSubmission parses the submission CSV streamed from the file part of a multipart request,
+// so the upload is never spooled to disk.
+func readSubmission(r *http.Request, aliases map[string]string) ([]Video, error) {
+	mr, err := r.MultipartReader()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errNoSubmissionFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return readCsv(part, aliases)
+		}
+	}
+}
 
-	// Read the header
+// submissionColumns is the column order of submission files whose header names are not recognized.
+var submissionColumns = []string{"created", "uuid", "link"}
+
+// readCsv parses a submission in the dialect sniffed from it. Columns are found by their header
+// names, mapped by aliases, or else expected in the order created, uuid, link.
+func readCsv(r io.Reader, aliases map[string]string) ([]Video, error) {
+	reader, err := csvdialect.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map the columns by the header.
 	header, err := reader.Read()
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("read header failed: %w", err)
+	}
+	columns := csvdialect.MapHeader(header, aliases)
+	if !columns.Has(submissionColumns...) {
+		columns = csvdialect.Positional(submissionColumns...)
 	}
-	fmt.Println("Header:", header)
 
 	// Read the records
 	var videos []Video
@@ -161,18 +348,21 @@ func readCsv() []Video {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+		if len(record) < len(submissionColumns) {
+			return nil, fmt.Errorf("line %d: want created, uuid and link columns", len(videos)+2)
 		}
 
-		created, err := time.Parse("2006-01-02 15:04:05", record[0])
+		created, err := csvdialect.ParseTime(columns.Field(record, "created"))
 		if err != nil {
-			log.Fatalf("Error parsing date: %v", err)
+			return nil, fmt.Errorf("line %d: parse created failed: %w", len(videos)+2, err)
 		}
 
 		video := Video{
 			Created: created,
-			UUID:    record[1],
-			Link:    record[2],
+			UUID:    columns.Field(record, "uuid"),
+			Link:    columns.Field(record, "link"),
 		}
 
 		videos = append(videos, video)
@@ -182,43 +372,158 @@ func readCsv() []Video {
 		return videos[i].Created.Before(videos[j].Created)
 	})
 
-	return videos
+	return videos, nil
 }
 
+// Start serves the API until Shutdown is called.
 func (a *API) Start() error {
-	return a.r.Run(":7083")
+	a.log.Info().Str("addr", a.srv.Addr).Bool("tls", a.tls).Msg("http server started")
+
+	var err error
+	if a.tls {
+		err = a.srv.ListenAndServeTLS("", "")
+	} else {
+		err = a.srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
 }
 
-func (a *API) RunCSV(c *gin.Context) {
+// Shutdown stops accepting connections and the batch workers, and waits for in-flight requests
+// and rows until ctx is done.
+func (a *API) Shutdown(ctx context.Context) error {
+	return errors.Join(a.srv.Shutdown(ctx), a.stopBatchWorkers(ctx))
 }
 
-func (a *API) CheckVideoDuplicate(c *gin.Context) {
-	var v VideoLinkRequest
-	if err := c.BindJSON(&v); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"message": "failed to bind json: " + err.Error(),
+func (a *API) RunCSV(c *gin.Context) {
+	// Parse the submission while it streams in.
+	videos, err := readSubmission(c.Request, a.batchCfg.Columns)
+	if err != nil {
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			apispec.AbortBodyTooLarge(c, tooLarge.Limit)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "file", Reason: err.Error()}},
 		})
 		return
 	}
 
-	if v.Link == "" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"message": "No video link",
+	rows := make([]model.BatchRow, len(videos))
+	for i, v := range videos {
+		rows[i] = model.BatchRow{
+			Created: v.Created,
+			UUID:    v.UUID,
+			Link:    v.Link,
+		}
+	}
+
+	// Record the batch; the batch workers check its rows in the background.
+	batch, err := a.taskContoller.StartBatch(c.Request.Context(), requestSource(c), rows)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "start batch failed: " + err.Error(),
 		})
 		return
 	}
 
-	id, copyrighted, err := a.runCopyright(v)
+	logger := zerolog.Ctx(c.Request.Context())
+
+	// Issue the token the frontend reads the batch with; without it the batch needs API credentials.
+	token, err := a.taskContoller.IssueBatchToken(c.Request.Context(), batch.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("batch_id", batch.ID).Msg("issue batch token failed")
+	}
+
+	a.wakeBatchWorkers()
+
+	if requestAPIVersion(c) != apiLegacy {
+		setBatchTokenHeaders(c, token)
+		// The batch routes are siblings of the upload route in the same API version.
+		resp := batchJobToResponse(path.Dir(c.Request.URL.Path), batch)
+		c.Header("Location", resp.StatusURL)
+		c.JSON(http.StatusAccepted, resp)
+		return
+	}
+
+	// Legacy clients expect the result CSV in the response, so the request waits for the batch.
+	batch, err = a.waitBatch(c.Request.Context(), batch.ID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "wait for batch failed: " + err.Error(),
+		})
+		return
+	}
+
+	result, err := a.taskContoller.GetBatchResult(c.Request.Context(), batch.ID)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"message": "run copyright failed: " + err.Error(),
+			"message": "get batch result failed: " + err.Error(),
+		})
+		return
+	}
+
+	setBatchHeaders(c, batch)
+	setBatchTokenHeaders(c, token)
+	c.Data(http.StatusOK, csvContentType, result)
+}
+
+func (a *API) CheckVideoDuplicate(c *gin.Context) {
+	v := apispec.Body[VideoLinkRequest](c)
+	v.Source = requestSource(c)
+	v.Source.Priority = model.Priority(v.Priority)
+
+	// The check is not cancelled with the request, so it completes after a 202 answer too.
+	ctx := context.WithoutCancel(c.Request.Context())
+	id, err := a.startCopyright(ctx, v)
+	if err != nil {
+		abortCheck(c, err)
+		return
+	}
+
+	// Wait for the result within the budget.
+	done := make(chan checkOutcome, 1)
+	go func() {
+		res, err := a.finishCopyright(ctx, id)
+		done <- checkOutcome{res: res, err: err}
+	}()
+
+	var budget <-chan time.Time
+	if a.checkWait > 0 {
+		timer := time.NewTimer(a.checkWait)
+		defer timer.Stop()
+		budget = timer.C
+	}
+
+	var out checkOutcome
+	select {
+	case out = <-done:
+	case <-budget:
+		a.checkPending(c, id)
+		return
+	}
+	if out.err != nil {
+		abortCheck(c, out.err)
+		return
+	}
+	res := out.res
+
+	if requestAPIVersion(c) != apiLegacy {
+		c.JSON(http.StatusOK, CheckVideoDuplicateResponse{
+			IsDuplicate:  res.IsDuplicate,
+			DuplicateFor: res.DuplicateFor,
+			Matches:      res.Matches,
 		})
 		return
 	}
 
-	if copyrighted {
+	if res.IsDuplicate {
 		c.JSON(http.StatusOK, VideoLinkResponse{
-			DuplicateFor: id,
+			DuplicateFor: res.DuplicateFor,
 			IsDuplicate:  true,
 		})
 		return
@@ -231,100 +536,500 @@ func (a *API) CheckVideoDuplicate(c *gin.Context) {
 	}
 }
 
-func (a *API) runCopyright(v VideoLinkRequest) (string, bool, error) {
-	resp, err := http.Get(v.Link)
-	if err != nil {
-		a.log.Error().Err(err).Msg("failed to get video")
-		return "", false, err
-	}
-	defer resp.Body.Close()
+// checkOutcome is the result of a check finished in the background.
+type checkOutcome struct {
+	res copyrightCheck
+	err error
+}
 
-	fileNameSpl := strings.Split(v.Link, "/")
-	fileName := fileNameSpl[len(fileNameSpl)-1]
-	if v.Name != "" {
-		fileName = v.Name
+// abortCheck maps a failed check to its response status.
+func abortCheck(c *gin.Context, err error) {
+	if errors.Is(err, taskcontroller.ErrQuotaExceeded) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": err.Error(),
+		})
+		return
 	}
-	id, err := a.taskContoller.CreateTask(context.Background(), resp.Body, fileName)
+	if status, ok := rejectedVideoStatus(err); ok {
+		c.AbortWithStatusJSON(status, gin.H{
+			"message": "video rejected: " + err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, urlpolicy.ErrForbidden) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "link rejected: " + err.Error(),
+		})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+		"message": "run copyright failed: " + err.Error(),
+	})
+}
+
+// checkPending answers a check that outlasted the wait budget with the task to poll.
+func (a *API) checkPending(c *gin.Context, id int64) {
+	// The task route is a sibling of the check route in the same API version.
+	taskURL := path.Join(path.Dir(c.Request.URL.Path), "task", strconv.FormatInt(id, 10))
+	retryAfter := int(math.Ceil(a.checkRetryAfter.Seconds()))
+
+	c.Header("Location", taskURL)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusAccepted, CheckPendingResponse{
+		TaskID:     id,
+		Status:     model.TaskStatusInProgress.String(),
+		TaskURL:    taskURL,
+		RetryAfter: retryAfter,
+		Message:    "check still running: poll task_url every retry_after seconds until status is done or fail; a done task ranks the candidate references in its matches",
+	})
+}
+
+func (a *API) GetUploadURL(c *gin.Context) {
+	key, url, err := a.taskContoller.GetUploadURL(c.Request.Context())
 	if err != nil {
-		return "", false, fmt.Errorf("failed to create task: %w", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get upload url failed: " + err.Error(),
+		})
+		return
 	}
 
-	for {
-		time.Sleep(time.Millisecond * 100)
-		m, err := a.taskContoller.GetTask(context.Background(), id)
-		if err != nil {
-			return "", false, fmt.Errorf("failed to get task: %w", err)
+	c.JSON(http.StatusOK, UploadURLResponse{
+		ObjectKey: key,
+		UploadURL: url,
+	})
+}
+
+func (a *API) CreateTaskFromObject(c *gin.Context) {
+	req := apispec.Body[TaskFromObjectRequest](c)
+
+	src := requestSource(c)
+	src.Priority = model.Priority(req.Priority)
+	id, err := a.taskContoller.CreateTaskFromObject(c.Request.Context(), req.ObjectKey, req.Name, src)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrObjectNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "object not uploaded: " + req.ObjectKey,
+			})
+			return
+		}
+		if errors.Is(err, taskcontroller.ErrQuotaExceeded) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if status, ok := rejectedVideoStatus(err); ok {
+			c.AbortWithStatusJSON(status, gin.H{
+				"message": "video rejected: " + err.Error(),
+			})
+			return
 		}
 
-		if m.Status == model.TaskStatusDone {
-			id, copyrighted := isCopyrighted(m.VideoCopyright, m.AudioCopyright)
-			if copyrighted {
-				return id, copyrighted, nil
-			} else {
-				if err := a.taskContoller.UploadToDatabaseAudio(context.Background(), m.TaskID); err != nil {
-					a.log.Error().Err(err).Msg("update database audio failed")
-				}
-				if err := a.taskContoller.UploadToDatabaseVideo(context.Background(), m.TaskID); err != nil {
-					a.log.Error().Err(err).Msg("update database video failed")
-				}
-
-				return id, copyrighted, nil
-			}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "create task failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, TaskCreatedResponse{
+		TaskID: id,
+	})
+}
+
+func (a *API) GetTask(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	task, err := a.taskContoller.GetTask(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
 		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task failed: " + err.Error(),
+		})
+		return
+	}
+
+	hashes, err := a.taskContoller.GetTaskHashes(c.Request.Context(), id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task hashes failed: " + err.Error(),
+		})
+		return
+	}
+
+	timings, err := a.taskContoller.GetTaskTimings(c.Request.Context(), id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task timings failed: " + err.Error(),
+		})
+		return
 	}
+
+	resp := a.taskToResponse(task)
+	resp.Hashes = hashes
+	resp.Timings = stageTimingsToResponse(timings)
+
+	c.JSON(http.StatusOK, resp)
 }
 
-func isCopyrighted(videoCopyright []model.Copyright, audioCopyright []model.Copyright) (string, bool) {
-	if len(videoCopyright) == 0 || len(audioCopyright) == 0 {
-		return "", false
+func (a *API) GetTasksByHash(c *gin.Context) {
+	tasks, err := a.taskContoller.GetTasksByHash(c.Request.Context(), c.Param("algorithm"), strings.ToLower(c.Param("digest")))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get tasks by hash failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := TaskListResponse{
+		Tasks: make([]TaskResponse, len(tasks)),
+	}
+	for i := range tasks {
+		resp.Tasks[i] = a.taskToResponse(tasks[i])
 	}
 
-	videoMap := map[string]float64{}
-	for _, v := range videoCopyright {
-		videoMap[v.Name] = v.Probability
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) GetTasks(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
 	}
 
-	audioMap := map[string]float64{}
-	for _, a := range audioCopyright {
-		audioMap[a.Name] = a.Probability
+	newestFirst := false
+	switch c.Query("order") {
+	case "", "asc":
+	case "desc":
+		newestFirst = true
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "order", Reason: "must be one of asc, desc"}},
+		})
+		return
 	}
 
-	for k := range videoMap {
-		if _, ok := audioMap[k]; !ok {
-			delete(videoMap, k)
-			continue
+	tasks, next, err := a.taskContoller.GetTasks(c.Request.Context(), limit, c.Query("cursor"), newestFirst)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
 		}
 
-		videoMap[k] = 2 * (videoMap[k] * audioMap[k]) / (videoMap[k] + audioMap[k])
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get tasks failed: " + err.Error(),
+		})
+		return
 	}
 
-	if len(videoMap) == 0 {
-		return "", false
+	resp := TaskListResponse{
+		Tasks:      make([]TaskResponse, len(tasks)),
+		NextCursor: next,
 	}
+	for i := range tasks {
+		resp.Tasks[i] = a.taskToResponse(tasks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
 
-	var finProbs []model.Copyright
-	for k, v := range videoMap {
-		finProbs = append(finProbs, model.Copyright{
-			Name:        k,
-			Probability: v,
+// parseTaskID returns the task id path parameter, already validated as an integer by the route spec.
+func parseTaskID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid task id: " + err.Error(),
 		})
+		return 0, false
 	}
 
-	for i := range finProbs {
-		if finProbs[i].Probability < 0.75 {
-			finProbs[i] = finProbs[len(finProbs)-1]
-			finProbs[len(finProbs)-1] = model.Copyright{}
-			finProbs = finProbs[:len(finProbs)-1]
+	return id, true
+}
+
+// parseLimit returns the page size query parameter or the default one.
+func parseLimit(c *gin.Context) (uint64, bool) {
+	l := c.Query("limit")
+	if l == "" {
+		return defaultTasksLimit, true
+	}
+
+	limit, err := strconv.ParseUint(l, 10, 64)
+	if err != nil || limit == 0 || limit > maxTasksLimit {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("limit must be between 1 and %d", maxTasksLimit),
+		})
+		return 0, false
+	}
+
+	return limit, true
+}
+
+// requestSource describes the client of the request for abuse analysis.
+// The client IP honours X-Forwarded-For from trusted proxies only.
+func requestSource(c *gin.Context) model.Source {
+	return model.Source{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		APIKeyID:  apiKeyID(c.GetHeader(apiKeyHeader)),
+		Account:   c.GetString(accountKey),
+	}
+}
+
+// apiKeyID returns a stable fingerprint of an API key so the key itself is never stored.
+func apiKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// rejectedVideoStatus maps a video that failed upload validation to its response status.
+func rejectedVideoStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, taskcontroller.ErrNotVideo), errors.Is(err, taskcontroller.ErrUnsupportedContainer):
+		return http.StatusUnsupportedMediaType, true
+	case errors.Is(err, taskcontroller.ErrVideoTooLarge), errors.Is(err, urlpolicy.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, taskcontroller.ErrVideoTooLong):
+		return http.StatusUnprocessableEntity, true
+	default:
+		return 0, false
+	}
+}
+
+func (a *API) taskToResponse(t model.Task) TaskResponse {
+	return TaskResponse{
+		TaskID:               t.TaskID,
+		Status:               t.Status.String(),
+		Stage:                t.Stage,
+		Priority:             string(t.Source.Priority),
+		VideoCopyright:       copyrightsToResponse(t.VideoCopyright),
+		AudioCopyright:       copyrightsToResponse(t.AudioCopyright),
+		IndexVersion:         t.IndexVersion,
+		ParentTaskID:         t.ParentTaskID,
+		QueuePosition:        t.QueuePosition,
+		DownloadVerification: t.DownloadVerification,
+		Partial:              len(t.Pending) != 0,
+		PendingModalities:    modalitiesToResponse(t.Pending),
+		OverdueModalities:    modalitiesToResponse(t.Overdue),
+		FailureReason:        t.FailureReason,
+		CreatedAt:            optionalTime(t.CreatedAt),
+		UpdatedAt:            optionalTime(t.UpdatedAt),
+		Deadline:             optionalTime(t.Deadline),
+		TraceID:              t.TraceID,
+		TraceURL:             tracing.URL(a.traceURL, t.TraceID),
+		Duration:             t.Video.Duration.Seconds(),
+		Width:                t.Video.Width,
+		Height:               t.Video.Height,
+		FileSize:             t.Video.Size,
+		ContentHash:          t.Video.ContentHash,
+		Matches:              a.rankMatches(t.VideoCopyright, t.AudioCopyright),
+	}
+}
+
+// optionalTime returns nil for the zero time, so it is left out of responses.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func modalitiesToResponse(m []model.Modality) []string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	resp := make([]string, len(m))
+	for i := range m {
+		resp[i] = string(m[i])
+	}
+
+	return resp
+}
+
+func copyrightsToResponse(c []model.Copyright) []CopyrightResponse {
+	resp := make([]CopyrightResponse, len(c))
+	for i := range c {
+		resp[i] = CopyrightResponse{
+			Name:        c[i].Name,
+			Probability: c[i].Probability,
+			Copied:      c[i].CopiedDuration(),
+		}
+		for _, s := range c[i].Segments {
+			resp[i].Segments = append(resp[i].Segments, SegmentResponse(s))
 		}
+		sort.SliceStable(resp[i].Segments, func(a, b int) bool {
+			return resp[i].Segments[a].Start < resp[i].Segments[b].Start
+		})
 	}
 
-	sort.Slice(finProbs, func(i, j int) bool {
-		return finProbs[i].Probability < finProbs[j].Probability
+	return resp
+}
+
+// errTaskFailed is returned when the task of a checked video fails.
+var errTaskFailed = errors.New("task failed")
+
+// copyrightCheck is the outcome of checking a video against the references.
+type copyrightCheck struct {
+	DuplicateFor string
+	IsDuplicate  bool
+	// Score is the best match score over the references, see decide.
+	Score float64
+	// Matches are all candidate references ranked by score.
+	Matches []MatchResponse
+}
+
+// runCopyright checks a video link to completion. The work is not cancelled with the request
+// but keeps the request-scoped logger.
+func (a *API) runCopyright(ctx context.Context, v VideoLinkRequest) (copyrightCheck, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	id, err := a.startCopyright(ctx, v)
+	if err != nil {
+		return copyrightCheck{}, err
+	}
+
+	return a.finishCopyright(ctx, id)
+}
+
+// startCopyright creates the task checking a video link and returns its id.
+func (a *API) startCopyright(ctx context.Context, v VideoLinkRequest) (int64, error) {
+	link, err := urlnorm.Normalize(v.Link)
+	if err != nil {
+		return 0, fmt.Errorf("invalid link: %w", err)
+	}
+
+	fileNameSpl := strings.Split(strings.SplitN(link, "?", 2)[0], "/")
+	fileName := fileNameSpl[len(fileNameSpl)-1]
+	if v.Name != "" {
+		fileName = v.Name
+	}
+	id, err := a.taskContoller.CreateTaskFromLink(ctx, link, fileName, v.Source)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get video")
+		return 0, fmt.Errorf("failed to create task: %w", err)
+	}
+
+	return id, nil
+}
+
+// finishCopyright waits for the task of a checked video and registers the video as an original
+// unless it is a duplicate.
+func (a *API) finishCopyright(ctx context.Context, id int64) (copyrightCheck, error) {
+	logger := zerolog.Ctx(ctx)
+
+	// Wait for the detection result; the consumers signal the completion of the task.
+	m, err := a.taskContoller.WaitTask(ctx, id)
+	if err != nil {
+		return copyrightCheck{}, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	if m.Status != model.TaskStatusDone {
+		return copyrightCheck{}, errTaskFailed
+	}
+
+	res := a.decide(m.VideoCopyright, m.AudioCopyright)
+	if res.IsDuplicate {
+		return res, nil
+	}
+
+	if err := a.taskContoller.UploadToDatabaseAudio(ctx, m.TaskID); err != nil {
+		logger.Error().Err(err).Msg("update database audio failed")
+	}
+	if err := a.taskContoller.UploadToDatabaseVideo(ctx, m.TaskID); err != nil {
+		logger.Error().Err(err).Msg("update database video failed")
+	}
+	if err := a.taskContoller.RegisterOriginal(ctx, m.TaskID); err != nil {
+		logger.Error().Err(err).Msg("register original failed")
+	}
+
+	return res, nil
+}
+
+// decide scores the results of the modalities by the configured strategy and decides whether
+// the checked video is a duplicate of the best match.
+func (a *API) decide(videoCopyright []model.Copyright, audioCopyright []model.Copyright) copyrightCheck {
+	v := a.scorer.Score(candidates(videoCopyright), candidates(audioCopyright))
+	matches := a.rankMatches(videoCopyright, audioCopyright)
+	if !v.Duplicate {
+		return copyrightCheck{Score: v.Score, Matches: matches}
+	}
+
+	return copyrightCheck{
+		DuplicateFor: v.Match,
+		IsDuplicate:  true,
+		Score:        v.Score,
+		Matches:      matches,
+	}
+}
+
+// rankMatches scores every reference found by either modality on its own and ranks them by score,
+// ties by name, so reviewers see the near misses next to the match that decided.
+func (a *API) rankMatches(videoCopyright []model.Copyright, audioCopyright []model.Copyright) []MatchResponse {
+	byName := map[string]*MatchResponse{}
+	var matches []*MatchResponse
+	get := func(name string) *MatchResponse {
+		m, ok := byName[name]
+		if !ok {
+			m = &MatchResponse{Name: name}
+			byName[name] = m
+			matches = append(matches, m)
+		}
+		return m
+	}
+	for _, c := range videoCopyright {
+		m := get(c.Name)
+		m.VideoProbability = max(m.VideoProbability, c.Probability)
+	}
+	for _, c := range audioCopyright {
+		m := get(c.Name)
+		m.AudioProbability = max(m.AudioProbability, c.Probability)
+	}
+
+	resp := make([]MatchResponse, 0, len(matches))
+	for _, m := range matches {
+		var video, audio []scoring.Candidate
+		if m.VideoProbability > 0 {
+			video = []scoring.Candidate{{Name: m.Name, Probability: m.VideoProbability}}
+		}
+		if m.AudioProbability > 0 {
+			audio = []scoring.Candidate{{Name: m.Name, Probability: m.AudioProbability}}
+		}
+		m.Score = a.scorer.Score(video, audio).Score
+		resp = append(resp, *m)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].Score != resp[j].Score {
+			return resp[i].Score > resp[j].Score
+		}
+		return resp[i].Name < resp[j].Name
 	})
 
-	if len(finProbs) == 0 {
-		return "", false
+	return resp
+}
+
+// candidates converts the results of a modality to scoring candidates.
+func candidates(c []model.Copyright) []scoring.Candidate {
+	res := make([]scoring.Candidate, 0, len(c))
+	for _, v := range c {
+		res = append(res, scoring.Candidate{Name: v.Name, Probability: v.Probability})
 	}
 
-	return finProbs[0].Name, true
+	return res
 }
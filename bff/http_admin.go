@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+type IndexVersionResponse struct {
+	Version   string    `json:"version"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SetIndexVersionRequest struct {
+	Version string `json:"version" binding:"required" example:"v2"`
+}
+
+type CompareTaskRequest struct {
+	IndexVersion string `json:"index_version" binding:"required" example:"v2"`
+}
+
+type AdminTaskResponse struct {
+	TaskResponse
+	SourceIP  string     `json:"source_ip,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	APIKeyID  string     `json:"api_key_id,omitempty" description:"fingerprint of the API key"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" description:"when the task was deleted, absent for a task that is not"`
+}
+
+type AdminTaskListResponse struct {
+	Tasks      []AdminTaskResponse `json:"tasks"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type TaskStatusListResponse struct {
+	Tasks      []AdminTaskResponse `json:"tasks"`
+	Total      int64               `json:"total" description:"number of all tasks of the status and age"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type SourceStatResponse struct {
+	Source     string `json:"source"`
+	Tasks      int64  `json:"tasks"`
+	InProgress int64  `json:"in_progress"`
+	Failed     int64  `json:"failed"`
+}
+
+type RegistrationResponse struct {
+	Modality  string    `json:"modality"`
+	Status    string    `json:"status" description:"registered or failed"`
+	Attempts  int32     `json:"attempts"`
+	Error     string    `json:"error,omitempty" description:"last error of a failed registration"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (a *API) GetIndexVersions(c *gin.Context) {
+	versions, err := a.taskContoller.GetIndexVersions(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get index versions failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]IndexVersionResponse, len(versions))
+	for i := range versions {
+		resp[i] = indexVersionToResponse(versions[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) SetIndexVersion(c *gin.Context) {
+	req := apispec.Body[SetIndexVersionRequest](c)
+
+	v, err := a.taskContoller.SetActiveIndexVersion(c.Request.Context(), req.Version)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "set index version failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, indexVersionToResponse(v))
+}
+
+func (a *API) CompareTask(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	req := apispec.Body[CompareTaskRequest](c)
+
+	newID, err := a.taskContoller.CompareTask(c.Request.Context(), id, req.IndexVersion, model.PriorityInteractive)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "compare task failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, TaskCreatedResponse{
+		TaskID: newID,
+	})
+}
+
+func (a *API) ResetModality(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	modality := model.Modality(c.Param("modality"))
+	if modality != model.ModalityAudio && modality != model.ModalityVideo {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "modality", Reason: "must be one of audio, video"}},
+		})
+		return
+	}
+
+	if err := a.taskContoller.ResetModality(c.Request.Context(), id, modality); err != nil {
+		switch {
+		case errors.Is(err, taskcontroller.ErrTaskNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+		case errors.Is(err, taskcontroller.ErrModalityPending):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"message": "the " + string(modality) + " result of the task is still pending",
+			})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "reset modality failed: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	task, err := a.taskContoller.GetTask(c.Request.Context(), id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, a.taskToResponse(task))
+}
+
+func (a *API) GetTaskComparisons(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	tasks, err := a.taskContoller.GetTaskComparisons(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get comparisons failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]TaskResponse, len(tasks))
+	for i := range tasks {
+		resp[i] = a.taskToResponse(tasks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) GetRegistrations(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	regs, err := a.taskContoller.GetRegistrations(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get registrations failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, registrationsToResponse(regs))
+}
+
+func registrationsToResponse(regs []model.Registration) []RegistrationResponse {
+	resp := make([]RegistrationResponse, len(regs))
+	for i, r := range regs {
+		resp[i] = RegistrationResponse{
+			Modality:  string(r.Modality),
+			Status:    r.Status,
+			Attempts:  r.Attempts,
+			Error:     r.Error,
+			UpdatedAt: r.UpdatedAt,
+		}
+	}
+
+	return resp
+}
+
+func (a *API) SearchTasks(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	filter := model.TaskFilter{
+		SourceIP:  c.Query("source_ip"),
+		UserAgent: c.Query("user_agent"),
+		APIKeyID:  c.Query("api_key_id"),
+	}
+
+	tasks, next, err := a.taskContoller.SearchTasks(c.Request.Context(), filter, limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "search tasks failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := AdminTaskListResponse{
+		Tasks:      make([]AdminTaskResponse, len(tasks)),
+		NextCursor: next,
+	}
+	for i := range tasks {
+		resp.Tasks[i] = a.adminTaskToResponse(tasks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) GetTasksByStatus(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	status, ok := model.ParseTaskStatus(c.Query("status"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "status must be one of in_progress, done, failed",
+		})
+		return
+	}
+
+	var olderThan time.Duration
+	if s := c.Query("older_than"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "older_than must be a non-negative duration, e.g. 30m",
+			})
+			return
+		}
+		olderThan = d
+	}
+
+	tasks, total, next, err := a.taskContoller.GetTasksByStatus(c.Request.Context(), status, time.Now().Add(-olderThan), limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get tasks by status failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := TaskStatusListResponse{
+		Tasks:      make([]AdminTaskResponse, len(tasks)),
+		Total:      total,
+		NextCursor: next,
+	}
+	for i := range tasks {
+		resp.Tasks[i] = a.adminTaskToResponse(tasks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) DeleteTask(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	if err := a.taskContoller.DeleteTask(c.Request.Context(), id); err != nil {
+		a.abortDeletion(c, "delete task failed: ", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (a *API) RestoreTask(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	if err := a.taskContoller.RestoreTask(c.Request.Context(), id); err != nil {
+		a.abortDeletion(c, "restore task failed: ", err)
+		return
+	}
+
+	task, err := a.taskContoller.GetTask(c.Request.Context(), id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, a.taskToResponse(task))
+}
+
+func (a *API) PurgeTask(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	if err := a.taskContoller.PurgeTask(c.Request.Context(), id); err != nil {
+		a.abortDeletion(c, "purge task failed: ", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// abortDeletion responds with the status of an error deleting, restoring or purging a task.
+func (a *API) abortDeletion(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, taskcontroller.ErrTaskNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "task not found",
+		})
+	case errors.Is(err, taskcontroller.ErrTaskDeleted):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "task is deleted already",
+		})
+	case errors.Is(err, taskcontroller.ErrTaskNotDeleted):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "task is not deleted",
+		})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": prefix + err.Error(),
+		})
+	}
+}
+
+func (a *API) GetDeletedTasks(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	tasks, next, err := a.taskContoller.GetDeletedTasks(c.Request.Context(), limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get deleted tasks failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := AdminTaskListResponse{
+		Tasks:      make([]AdminTaskResponse, len(tasks)),
+		NextCursor: next,
+	}
+	for i := range tasks {
+		resp.Tasks[i] = a.adminTaskToResponse(tasks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) adminTaskToResponse(t model.Task) AdminTaskResponse {
+	return AdminTaskResponse{
+		TaskResponse: a.taskToResponse(t),
+		SourceIP:     t.Source.IP,
+		UserAgent:    t.Source.UserAgent,
+		APIKeyID:     t.Source.APIKeyID,
+		DeletedAt:    optionalTime(t.DeletedAt),
+	}
+}
+
+func (a *API) GetSourceReport(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	by := c.DefaultQuery("by", taskcontroller.SourceByIP)
+
+	stats, err := a.taskContoller.GetSourceReport(c.Request.Context(), by, limit)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidDimension) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "by must be one of ip, user_agent, api_key",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get source report failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]SourceStatResponse, len(stats))
+	for i, s := range stats {
+		resp[i] = SourceStatResponse{
+			Source:     s.Source,
+			Tasks:      s.Tasks,
+			InProgress: s.InProgress,
+			Failed:     s.Failed,
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func indexVersionToResponse(v model.IndexVersion) IndexVersionResponse {
+	return IndexVersionResponse{
+		Version:   v.Version,
+		Active:    v.Active,
+		CreatedAt: v.CreatedAt,
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/auth"
+)
+
+type AuditEventResponse struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor" description:"subject:<sub>, api_key:<fingerprint>, ip:<address> or system"`
+	Action     string          `json:"action" example:"task.created"`
+	TaskID     int64           `json:"task_id,omitempty"`
+	Details    json.RawMessage `json:"details"`
+}
+
+type AuditLogResponse struct {
+	Events     []AuditEventResponse `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// auditActor attributes the audited actions of a request to the authenticated subject, the API key or the client IP.
+func auditActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(taskcontroller.WithActor(c.Request.Context(), requestActor(c)))
+
+		c.Next()
+	}
+}
+
+func requestActor(c *gin.Context) string {
+	if s := auth.Subject(c); s != "" {
+		return "subject:" + s
+	}
+	if id := apiKeyID(c.GetHeader(apiKeyHeader)); id != "" {
+		return "api_key:" + id
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func (a *API) GetAuditLog(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	filter := model.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+	}
+	if id := c.Query("task_id"); id != "" {
+		filter.TaskID, _ = strconv.ParseInt(id, 10, 64)
+	}
+
+	events, next, err := a.taskContoller.GetAuditLog(c.Request.Context(), filter, limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get audit log failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := AuditLogResponse{
+		Events:     make([]AuditEventResponse, len(events)),
+		NextCursor: next,
+	}
+	for i, e := range events {
+		resp.Events[i] = AuditEventResponse{
+			ID:         e.ID,
+			OccurredAt: e.OccurredAt,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			TaskID:     e.TaskID,
+			Details:    e.Details,
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// The result CSV of a submission carries the summary of its batch in these headers,
+// so the CSV itself keeps the submission format.
+const (
+	batchIDHeader      = "X-Batch-ID"
+	batchSummaryHeader = "X-Batch-Summary"
+	// batchTokenHeader carries the token scoped to reading the batch, both with the result and
+	// in the requests reading the batch; batchTokenExpiresHeader tells when it expires.
+	batchTokenHeader        = "X-Batch-Token"
+	batchTokenExpiresHeader = "X-Batch-Token-Expires"
+)
+
+// batchTokenQuery passes the batch token in download links, where headers cannot be set.
+const batchTokenQuery = "token"
+
+const csvContentType = "text/csv; charset=utf-8"
+
+// Batch job statuses.
+const (
+	batchRunning = "running"
+	batchDone    = "done"
+)
+
+type ScoreBucketResponse struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int64   `json:"count"`
+}
+
+type BatchSummaryResponse struct {
+	ID         int64                 `json:"id"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty" description:"absent while the batch is running"`
+	TotalRows  int64                 `json:"total_rows"`
+	Rows       int64                 `json:"rows" description:"rows checked so far"`
+	Duplicates int64                 `json:"duplicates"`
+	Failures   int64                 `json:"failures"`
+	AvgLatency float64               `json:"avg_latency_ms"`
+	Scores     []ScoreBucketResponse `json:"scores" description:"distribution of the best match score of the rows checked successfully, empty while the batch is running"`
+	Resources  ResourceUsageResponse `json:"resources" description:"resources used by checking the rows so far"`
+}
+
+// ResourceUsageResponse estimates what checking videos used, to attribute the cost of the infrastructure.
+type ResourceUsageResponse struct {
+	BytesDownloaded  int64   `json:"bytes_downloaded" description:"videos fetched from their links; videos served from the download cache are not counted"`
+	BytesStored      int64   `json:"bytes_stored" description:"videos and their extracted audio written to object storage"`
+	FFmpegCPUSeconds float64 `json:"ffmpeg_cpu_seconds" description:"user and system CPU time of ffmpeg and ffprobe"`
+	MLRequests       int64   `json:"ml_requests" description:"requests sent to the ML services, retries included"`
+}
+
+func batchSummaryToResponse(s model.BatchSummary) BatchSummaryResponse {
+	resp := BatchSummaryResponse{
+		ID:         s.ID,
+		StartedAt:  s.StartedAt,
+		TotalRows:  s.Total,
+		Rows:       s.Rows,
+		Duplicates: s.Duplicates,
+		Failures:   s.Failures,
+		AvgLatency: float64(s.AvgLatency) / float64(time.Millisecond),
+		Scores:     make([]ScoreBucketResponse, len(s.Scores)),
+		Resources: ResourceUsageResponse{
+			BytesDownloaded:  s.Resources.BytesDownloaded,
+			BytesStored:      s.Resources.BytesStored,
+			FFmpegCPUSeconds: s.Resources.FFmpegCPU.Seconds(),
+			MLRequests:       s.Resources.MLRequests,
+		},
+	}
+	if !s.FinishedAt.IsZero() {
+		resp.FinishedAt = &s.FinishedAt
+	}
+	for i, b := range s.Scores {
+		resp.Scores[i] = ScoreBucketResponse{
+			Min:   b.Min,
+			Max:   b.Max,
+			Count: b.Count,
+		}
+	}
+
+	return resp
+}
+
+// BatchJobResponse tells the status and the progress of a submission CSV batch checked in the background.
+type BatchJobResponse struct {
+	ID            int64      `json:"id"`
+	Status        string     `json:"status" description:"running or done"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty" description:"absent while the batch is running"`
+	TotalRows     int64      `json:"total_rows"`
+	ProcessedRows int64      `json:"processed_rows"`
+	Duplicates    int64      `json:"duplicates"`
+	Failures      int64      `json:"failures"`
+	Progress      float64    `json:"progress" description:"share of the rows checked, from 0 to 1"`
+	StatusURL     string     `json:"status_url"`
+	SummaryURL    string     `json:"summary_url"`
+	ResultURL     string     `json:"result_url" description:"result CSV, available once the batch is done"`
+}
+
+// batchJobToResponse describes a batch with the URLs of its routes under base, the prefix of the API version.
+func batchJobToResponse(base string, s model.BatchSummary) BatchJobResponse {
+	statusURL := path.Join(base, "batches", strconv.FormatInt(s.ID, 10))
+	resp := BatchJobResponse{
+		ID:            s.ID,
+		Status:        batchRunning,
+		StartedAt:     s.StartedAt,
+		TotalRows:     s.Total,
+		ProcessedRows: s.Rows,
+		Duplicates:    s.Duplicates,
+		Failures:      s.Failures,
+		Progress:      1,
+		StatusURL:     statusURL,
+		SummaryURL:    statusURL + "/summary",
+		ResultURL:     statusURL + "/result",
+	}
+	if !s.FinishedAt.IsZero() {
+		resp.Status = batchDone
+		resp.FinishedAt = &s.FinishedAt
+	}
+	if s.Total > 0 {
+		resp.Progress = float64(s.Rows) / float64(s.Total)
+	}
+
+	return resp
+}
+
+// setBatchHeaders adds the ID and the summary of a finished batch to its result.
+func setBatchHeaders(c *gin.Context, s model.BatchSummary) {
+	c.Header(batchIDHeader, strconv.FormatInt(s.ID, 10))
+	if summary, err := json.Marshal(batchSummaryToResponse(s)); err == nil {
+		c.Header(batchSummaryHeader, string(summary))
+	}
+}
+
+func (a *API) GetBatch(c *gin.Context) {
+	id, ok := parseBatchID(c)
+	if !ok {
+		return
+	}
+
+	s, err := a.taskContoller.GetBatchSummary(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrBatchNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get batch failed: " + err.Error(),
+		})
+		return
+	}
+
+	// The batch routes hang off the prefix of the API version the request was routed to.
+	c.JSON(http.StatusOK, batchJobToResponse(path.Dir(path.Dir(c.Request.URL.Path)), s))
+}
+
+func (a *API) GetBatchSummary(c *gin.Context) {
+	id, ok := parseBatchID(c)
+	if !ok {
+		return
+	}
+
+	s, err := a.taskContoller.GetBatchSummary(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrBatchNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get batch summary failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, batchSummaryToResponse(s))
+}
+
+// setBatchTokenHeaders hands the batch token to the client; nothing is set when no token was issued.
+func setBatchTokenHeaders(c *gin.Context, t model.BatchToken) {
+	if t.Token == "" {
+		return
+	}
+	c.Header(batchTokenHeader, t.Token)
+	c.Header(batchTokenExpiresHeader, t.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// batchAccess lets requests carrying a token of the batch in the path through, and hands the
+// others to the fallback authentication.
+func (a *API) batchAccess(fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader(batchTokenHeader)
+		if token == "" {
+			token = c.Query(batchTokenQuery)
+		}
+		if token == "" {
+			fallback(c)
+			return
+		}
+
+		id, ok := parseBatchID(c)
+		if !ok {
+			return
+		}
+
+		if err := a.taskContoller.CheckBatchToken(c.Request.Context(), id, token); err != nil {
+			if errors.Is(err, taskcontroller.ErrInvalidBatchToken) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "check batch token failed: " + err.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// parseBatchID returns the batch ID path parameter, answering 400 when it is invalid.
+func parseBatchID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid batch id: " + err.Error(),
+		})
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (a *API) GetBatchResult(c *gin.Context) {
+	id, ok := parseBatchID(c)
+	if !ok {
+		return
+	}
+
+	result, err := a.taskContoller.GetBatchResult(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, taskcontroller.ErrBatchNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+		case errors.Is(err, taskcontroller.ErrBatchRunning):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "get batch result failed: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="batch-%d.csv"`, id))
+	c.Data(http.StatusOK, csvContentType, result)
+}
+
+// RevokeBatchTokensResponse tells how many tokens of a batch were revoked.
+type RevokeBatchTokensResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+func (a *API) RevokeBatchTokens(c *gin.Context) {
+	id, ok := parseBatchID(c)
+	if !ok {
+		return
+	}
+
+	n, err := a.taskContoller.RevokeBatchTokens(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrBatchNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "revoke batch tokens failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RevokeBatchTokensResponse{Revoked: n})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// DeadLetterResponse is a Kafka message that could not be applied and was published to the dead-letter topic.
+type DeadLetterResponse struct {
+	ID             int64            `json:"id"`
+	Topic          string           `json:"topic" description:"topic the message was read from"`
+	Partition      int              `json:"partition"`
+	Offset         int64            `json:"offset"`
+	Key            string           `json:"key,omitempty"`
+	Value          string           `json:"value"`
+	Headers        []HeaderResponse `json:"headers,omitempty" description:"headers of the original message"`
+	Error          string           `json:"error" description:"why the message could not be applied"`
+	Attempts       int              `json:"attempts"`
+	DeadLetteredAt time.Time        `json:"dead_lettered_at"`
+	ReplayedAt     *time.Time       `json:"replayed_at,omitempty" description:"when the message was last published to its topic again"`
+}
+
+type HeaderResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type DeadLetterListResponse struct {
+	DeadLetters []DeadLetterResponse `json:"dead_letters"`
+	NextCursor  string               `json:"next_cursor,omitempty"`
+}
+
+func (a *API) ListDeadLetters(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	filter := model.DeadLetterFilter{
+		Topic: c.Query("topic"),
+	}
+	if s := c.Query("pending"); s != "" {
+		filter.Pending, _ = strconv.ParseBool(s)
+	}
+
+	letters, next, err := a.taskContoller.ListDeadLetters(c.Request.Context(), filter, limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "list dead letters failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := DeadLetterListResponse{
+		DeadLetters: make([]DeadLetterResponse, len(letters)),
+		NextCursor:  next,
+	}
+	for i, l := range letters {
+		resp.DeadLetters[i] = deadLetterToResponse(l)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) ReplayDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid dead letter id: " + err.Error(),
+		})
+		return
+	}
+
+	if err := a.taskContoller.ReplayDeadLetter(c.Request.Context(), id); err != nil {
+		if errors.Is(err, taskcontroller.ErrDeadLetterNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "dead letter not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "replay dead letter failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func deadLetterToResponse(l model.DeadLetter) DeadLetterResponse {
+	resp := DeadLetterResponse{
+		ID:             l.ID,
+		Topic:          l.Topic,
+		Partition:      l.Partition,
+		Offset:         l.Offset,
+		Key:            string(l.Key),
+		Value:          string(l.Value),
+		Error:          l.Error,
+		Attempts:       l.Attempts,
+		DeadLetteredAt: l.DeadLetteredAt,
+		ReplayedAt:     optionalTime(l.ReplayedAt),
+	}
+	for _, h := range l.Headers {
+		resp.Headers = append(resp.Headers, HeaderResponse{Key: h.Key, Value: string(h.Value)})
+	}
+
+	return resp
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// TaskEventResponse is a change of the status of a task.
+type TaskEventResponse struct {
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty" description:"absent for the status the task was created with"`
+	Stage          string    `json:"stage" description:"stage of the task after the change"`
+	Actor          string    `json:"actor" description:"who changed the status, system for the service itself"`
+	Reason         string    `json:"reason,omitempty" description:"failure reason of a failed task, else the audited action that changed the status"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+func (a *API) GetTaskHistory(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	events, err := a.taskContoller.GetTaskHistory(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get task history failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]TaskEventResponse, len(events))
+	for i, e := range events {
+		resp[i] = TaskEventResponse{
+			Status:     e.Status.String(),
+			Stage:      e.Stage,
+			Actor:      e.Actor,
+			Reason:     e.Reason,
+			OccurredAt: e.OccurredAt,
+		}
+		if e.HasPrevious {
+			resp[i].PreviousStatus = e.PreviousStatus.String()
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// MetadataResponse holds the properties of the video of a task probed at ingest; properties the probe
+// did not report are left out.
+type MetadataResponse struct {
+	Format     string  `json:"format,omitempty" description:"container formats as named by ffprobe, e.g. mov,mp4,m4a,3gp,3g2,mj2"`
+	VideoCodec string  `json:"video_codec,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty" description:"empty for a video without sound"`
+	Width      int     `json:"width,omitempty" description:"pixels"`
+	Height     int     `json:"height,omitempty" description:"pixels"`
+	FPS        float64 `json:"fps,omitempty" description:"average frame rate"`
+	BitRate    int64   `json:"bit_rate,omitempty" description:"overall bit rate, bits per second"`
+	Duration   float64 `json:"duration,omitempty" description:"seconds"`
+}
+
+func (a *API) GetTaskMetadata(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	md, err := a.taskContoller.GetTaskMetadata(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, taskcontroller.ErrTaskNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+		case errors.Is(err, taskcontroller.ErrMetadataNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "metadata not found",
+			})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "get metadata failed: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, MetadataResponse{
+		Format:     md.Format,
+		VideoCodec: md.VideoCodec,
+		AudioCodec: md.AudioCodec,
+		Width:      md.Width,
+		Height:     md.Height,
+		FPS:        md.FrameRate,
+		BitRate:    md.BitRate,
+		Duration:   md.Duration.Seconds(),
+	})
+}
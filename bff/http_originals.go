@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+)
+
+// OrigVideoResponse is a video registered in the reference catalog as an original.
+type OrigVideoResponse struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Hash         string    `json:"hash,omitempty" description:"MD5 of the video"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+type OrigVideoListResponse struct {
+	Videos     []OrigVideoResponse `json:"videos"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type RegisterOrigVideoRequest struct {
+	ObjectKey string `json:"object_key" binding:"required" description:"object_key returned by /task/upload-url"`
+	Name      string `json:"name" description:"name of the original, defaults to the object key"`
+}
+
+// RegisteredOrigVideoResponse is an original registered explicitly, with the task holding its media and
+// the outcome of pushing it to the indexes of the ML services.
+type RegisteredOrigVideoResponse struct {
+	ID            int64                  `json:"id"`
+	Name          string                 `json:"name"`
+	Hash          string                 `json:"hash,omitempty" description:"MD5 of the video"`
+	RegisteredAt  time.Time              `json:"registered_at"`
+	TaskID        int64                  `json:"task_id" description:"task holding the media of the original"`
+	Registrations []RegistrationResponse `json:"registrations" description:"registrations with the ML services, also listed by GET /admin/task/:id/registrations"`
+}
+
+func (a *API) SearchOrigVideos(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	filter := model.OrigVideoFilter{
+		Name: c.Query("name"),
+		Hash: strings.ToLower(c.Query("hash")),
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"registered_after", &filter.RegisteredAfter},
+		{"registered_before", &filter.RegisteredBefore},
+	} {
+		s := c.Query(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": p.name + " must be an RFC 3339 time, e.g. 2024-10-01T00:00:00Z",
+			})
+			return
+		}
+		*p.t = t
+	}
+
+	videos, next, err := a.taskContoller.SearchOrigVideos(c.Request.Context(), filter, limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "invalid cursor",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "search original videos failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := OrigVideoListResponse{
+		Videos:     make([]OrigVideoResponse, len(videos)),
+		NextCursor: next,
+	}
+	for i, v := range videos {
+		resp.Videos[i] = origVideoToResponse(v)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func origVideoToResponse(v model.OrigVideo) OrigVideoResponse {
+	return OrigVideoResponse{
+		ID:           v.ID,
+		Name:         v.Name,
+		Hash:         v.Hash,
+		RegisteredAt: v.RegisteredAt,
+	}
+}
+
+func (a *API) RegisterOrigVideo(c *gin.Context) {
+	req := apispec.Body[RegisterOrigVideoRequest](c)
+
+	ctx := c.Request.Context()
+	orig, taskID, err := a.taskContoller.RegisterOriginalFromObject(ctx, req.ObjectKey, req.Name, requestSource(c))
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrObjectNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "object not uploaded: " + req.ObjectKey,
+			})
+			return
+		}
+		if errors.Is(err, taskcontroller.ErrOriginalExists) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if status, ok := rejectedVideoStatus(err); ok {
+			c.AbortWithStatusJSON(status, gin.H{
+				"message": "video rejected: " + err.Error(),
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "register original failed: " + err.Error(),
+		})
+		return
+	}
+
+	regs, err := a.taskContoller.GetRegistrations(ctx, taskID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get registrations failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, RegisteredOrigVideoResponse{
+		ID:            orig.ID,
+		Name:          orig.Name,
+		Hash:          orig.Hash,
+		RegisteredAt:  orig.RegisteredAt,
+		TaskID:        taskID,
+		Registrations: registrationsToResponse(regs),
+	})
+}
+
+func (a *API) DelistOrigVideo(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid original id: " + err.Error(),
+		})
+		return
+	}
+
+	if _, err := a.taskContoller.DelistOrigVideo(c.Request.Context(), id); err != nil {
+		if errors.Is(err, taskcontroller.ErrOriginalNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "original not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "delist original failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// Content types of the files of an HLS rendition.
+const (
+	hlsPlaylistContentType = "application/vnd.apple.mpegurl"
+	hlsSegmentContentType  = "video/mp2t"
+)
+
+func (a *API) GetTaskPlayback(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("file")
+	file, size, err := a.taskContoller.GetTaskPlayback(c.Request.Context(), id, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, taskcontroller.ErrTaskNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+		case errors.Is(err, taskcontroller.ErrPlaybackNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "playback not found",
+			})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "get playback failed: " + err.Error(),
+			})
+		}
+		return
+	}
+	defer file.Close()
+
+	contentType := hlsSegmentContentType
+	if path.Ext(name) == ".m3u8" {
+		contentType = hlsPlaylistContentType
+	}
+
+	servePreview(c, contentType, file, size)
+}
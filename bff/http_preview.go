@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+)
+
+const (
+	// previewContentType is the type of the preview images, screenshots taken by ffmpeg.
+	previewContentType = "image/png"
+	// spriteContentType is the type of the sprite sheets.
+	spriteContentType = "image/jpeg"
+)
+
+// SpriteResponse lays out the sprite sheet of a task served at /task/:id/sprite/image.
+type SpriteResponse struct {
+	Columns     int       `json:"columns"`
+	Rows        int       `json:"rows"`
+	FrameWidth  int       `json:"frame_width" description:"width of a frame in the sheet, pixels"`
+	FrameHeight int       `json:"frame_height" description:"height of a frame in the sheet, pixels"`
+	Timestamps  []float64 `json:"timestamps" description:"position of each frame in the video, seconds, tiled left to right and top to bottom"`
+}
+
+func (a *API) GetTaskPreview(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	preview, size, err := a.taskContoller.GetTaskPreview(c.Request.Context(), id)
+	if err != nil {
+		abortPreview(c, err)
+		return
+	}
+	defer preview.Close()
+
+	servePreview(c, previewContentType, preview, size)
+}
+
+func (a *API) GetTaskSprite(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	sprite, err := a.taskContoller.GetTaskSprite(c.Request.Context(), id)
+	if err != nil {
+		abortPreview(c, err)
+		return
+	}
+
+	timestamps := make([]float64, len(sprite.Timestamps))
+	for i, t := range sprite.Timestamps {
+		timestamps[i] = t.Seconds()
+	}
+
+	c.JSON(http.StatusOK, SpriteResponse{
+		Columns:     sprite.Columns,
+		Rows:        sprite.Rows,
+		FrameWidth:  sprite.FrameWidth,
+		FrameHeight: sprite.FrameHeight,
+		Timestamps:  timestamps,
+	})
+}
+
+func (a *API) GetTaskSpriteImage(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	sprite, size, err := a.taskContoller.GetTaskSpriteImage(c.Request.Context(), id)
+	if err != nil {
+		abortPreview(c, err)
+		return
+	}
+	defer sprite.Close()
+
+	servePreview(c, spriteContentType, sprite, size)
+}
+
+// servePreview streams a preview image, or another media file of a task, of size bytes.
+func servePreview(c *gin.Context, contentType string, img objectstorage.ReadAtCloser, size int64) {
+	c.DataFromReader(http.StatusOK, size, contentType, io.NewSectionReader(img, 0, size), nil)
+}
+
+// abortPreview answers a failed lookup of a preview image.
+func abortPreview(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, taskcontroller.ErrTaskNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "task not found",
+		})
+	case errors.Is(err, taskcontroller.ErrPreviewNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "preview not found",
+		})
+	case errors.Is(err, taskcontroller.ErrSpriteNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "sprite sheet not found",
+		})
+	case errors.Is(err, taskcontroller.ErrSceneNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "scene not found",
+		})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get preview failed: " + err.Error(),
+		})
+	}
+}
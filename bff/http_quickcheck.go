@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/urlnorm"
+)
+
+type QuickCheckRequest struct {
+	Link string `json:"link" binding:"required,url" description:"video link" example:"https://example.com/video.mp4"`
+}
+
+// QuickCheckResponse is a verdict from local evidence only. It is final only when
+// full_check_recommended is false; otherwise /check-video-duplicate decides.
+type QuickCheckResponse struct {
+	Tier                 string  `json:"tier" description:"high for an exact copy of an original, medium for a perceptual match, low without local evidence"`
+	Evidence             string  `json:"evidence" description:"exact_match, perceptual_match or none"`
+	DuplicateFor         string  `json:"duplicate_for,omitempty" description:"original the video matches"`
+	Probability          float64 `json:"probability,omitempty"`
+	Cached               bool    `json:"cached" description:"whether the link was found in the download cache; uncached links are not downloaded"`
+	FullCheckRecommended bool    `json:"full_check_recommended"`
+}
+
+func (a *API) QuickCheck(c *gin.Context) {
+	req := apispec.Body[QuickCheckRequest](c)
+
+	link, err := urlnorm.Normalize(req.Link)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "link", Reason: err.Error()}},
+		})
+		return
+	}
+
+	res, err := a.taskContoller.QuickCheck(c.Request.Context(), link)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "quick check failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, QuickCheckResponse{
+		Tier:                 res.Tier,
+		Evidence:             res.Evidence,
+		DuplicateFor:         res.DuplicateFor,
+		Probability:          res.Probability,
+		Cached:               res.Cached,
+		FullCheckRecommended: res.FullCheckRecommended,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// RecheckResponse is the verdict of a re-check of a library video, kept apart from its verdict at upload.
+type RecheckResponse struct {
+	TaskID            int64           `json:"task_id" description:"comparison task of the re-check"`
+	Status            string          `json:"status"`
+	ReferencesThrough int64           `json:"references_through" description:"latest reference task the re-check covers"`
+	CreatedAt         time.Time       `json:"created_at"`
+	IsDuplicate       bool            `json:"is_duplicate"`
+	DuplicateFor      string          `json:"duplicate_for,omitempty"`
+	Score             float64         `json:"score" description:"best match score over the references other than the video itself"`
+	Matches           []MatchResponse `json:"matches,omitempty" description:"candidate references other than the video itself ranked by score"`
+}
+
+func (a *API) GetTaskRechecks(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	rechecks, err := a.taskContoller.GetTaskRechecks(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get rechecks failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]RecheckResponse, len(rechecks))
+	for i := range rechecks {
+		resp[i] = a.recheckToResponse(rechecks[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// recheckToResponse decides a finished re-check like a check at upload. The video is itself a reference
+// by then, so it is left out of the candidates.
+func (a *API) recheckToResponse(r model.Recheck) RecheckResponse {
+	resp := RecheckResponse{
+		TaskID:            r.Check.TaskID,
+		Status:            r.Check.Status.String(),
+		ReferencesThrough: r.ReferencesThrough,
+		CreatedAt:         r.CreatedAt,
+	}
+	if r.Check.Status != model.TaskStatusDone {
+		return resp
+	}
+
+	res := a.decide(withoutReference(r.Check.VideoCopyright, r.VideoName), withoutReference(r.Check.AudioCopyright, r.VideoName))
+	resp.IsDuplicate = res.IsDuplicate
+	resp.DuplicateFor = res.DuplicateFor
+	resp.Score = res.Score
+	resp.Matches = res.Matches
+
+	return resp
+}
+
+// withoutReference returns the candidates other than the named reference.
+func withoutReference(c []model.Copyright, name string) []model.Copyright {
+	out := make([]model.Copyright, 0, len(c))
+	for _, v := range c {
+		if v.Name != name {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
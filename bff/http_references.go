@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+)
+
+// graphFormatDOT exports the reference graph in the Graphviz DOT language.
+const graphFormatDOT = "dot"
+
+type ReferenceGraphResponse struct {
+	Nodes []ReferenceNodeResponse `json:"nodes"`
+	Edges []ReferenceEdgeResponse `json:"edges"`
+}
+
+type ReferenceNodeResponse struct {
+	ID          string     `json:"id" description:"video name of the reference"`
+	TaskID      int64      `json:"task_id,omitempty" description:"task of the reference, 0 for references found only as candidates"`
+	CheckTaskID int64      `json:"check_task_id,omitempty" description:"latest task checking the reference against the index"`
+	CheckStatus string     `json:"check_status,omitempty"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+}
+
+type ReferenceEdgeResponse struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Score  float64 `json:"score" description:"fused score of the match, the higher of both directions"`
+}
+
+func (a *API) GetReferenceGraph(c *gin.Context) {
+	minScore := a.threshold
+	if s, ok := c.GetQuery("min_score"); ok {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > 1 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+				Message: "request validation failed",
+				Errors:  []apispec.FieldError{{Field: "min_score", Reason: "must be a number from 0 to 1"}},
+			})
+			return
+		}
+		minScore = v
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != graphFormatDOT {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "format", Reason: "must be json or dot"}},
+		})
+		return
+	}
+
+	checks, err := a.taskContoller.GetReferenceChecks(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get reference checks failed: " + err.Error(),
+		})
+		return
+	}
+
+	graph := a.referenceGraph(checks, minScore)
+	if format == graphFormatDOT {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graphToDOT(graph)))
+		return
+	}
+
+	c.JSON(http.StatusOK, graph)
+}
+
+// referenceGraph links every checked reference to the candidates of its latest check scoring at least
+// minScore by the scoring strategy. Edges are undirected and keep the higher score of both directions.
+func (a *API) referenceGraph(checks []model.ReferenceCheck, minScore float64) ReferenceGraphResponse {
+	nodes := map[string]ReferenceNodeResponse{}
+	edges := map[[2]string]float64{}
+
+	for _, rc := range checks {
+		checkedAt := rc.CheckedAt
+		nodes[rc.VideoName] = ReferenceNodeResponse{
+			ID:          rc.VideoName,
+			TaskID:      rc.TaskID,
+			CheckTaskID: rc.Check.TaskID,
+			CheckStatus: rc.Check.Status.String(),
+			CheckedAt:   &checkedAt,
+		}
+		if rc.Check.Status != model.TaskStatusDone {
+			continue
+		}
+
+		for _, m := range a.rankMatches(rc.Check.VideoCopyright, rc.Check.AudioCopyright) {
+			// A reference always matches itself.
+			if m.Name == rc.VideoName || m.Score < minScore {
+				continue
+			}
+			if _, ok := nodes[m.Name]; !ok {
+				nodes[m.Name] = ReferenceNodeResponse{ID: m.Name}
+			}
+
+			key := [2]string{rc.VideoName, m.Name}
+			if key[0] > key[1] {
+				key[0], key[1] = key[1], key[0]
+			}
+			edges[key] = max(edges[key], m.Score)
+		}
+	}
+
+	// Return the graph in a stable order.
+	resp := ReferenceGraphResponse{
+		Nodes: make([]ReferenceNodeResponse, 0, len(nodes)),
+		Edges: make([]ReferenceEdgeResponse, 0, len(edges)),
+	}
+	for _, n := range nodes {
+		resp.Nodes = append(resp.Nodes, n)
+	}
+	sort.Slice(resp.Nodes, func(i, j int) bool {
+		return resp.Nodes[i].ID < resp.Nodes[j].ID
+	})
+	for key, score := range edges {
+		resp.Edges = append(resp.Edges, ReferenceEdgeResponse{Source: key[0], Target: key[1], Score: score})
+	}
+	sort.Slice(resp.Edges, func(i, j int) bool {
+		if resp.Edges[i].Source != resp.Edges[j].Source {
+			return resp.Edges[i].Source < resp.Edges[j].Source
+		}
+		return resp.Edges[i].Target < resp.Edges[j].Target
+	})
+
+	return resp
+}
+
+// graphToDOT renders the reference graph as an undirected Graphviz graph weighted by score.
+func graphToDOT(g ReferenceGraphResponse) string {
+	var sb strings.Builder
+	sb.WriteString("graph references {\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&sb, "  %s;\n", strconv.Quote(n.ID))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "  %s -- %s [weight=%.4f];\n", strconv.Quote(e.Source), strconv.Quote(e.Target), e.Score)
+	}
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/resultschema"
+
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// maxResultSize bounds the body of a pushed result.
+const maxResultSize = 1 << 20
+
+type ResultPushResponse struct {
+	ResultID      string `json:"result_id"`
+	Applied       bool   `json:"applied"`
+	SchemaVersion string `json:"schema_version"`
+}
+
+func (a *API) PushResult(c *gin.Context) {
+	modality := model.Modality(c.Param("modality"))
+	if modality != model.ModalityAudio && modality != model.ModalityVideo {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "request validation failed",
+			Errors:  []apispec.FieldError{{Field: "modality", Reason: "must be one of audio, video"}},
+		})
+		return
+	}
+
+	version, err := a.results.Negotiate(c.GetHeader(resultschema.Header))
+	if err != nil {
+		c.Header(resultschema.Header, strings.Join(a.results.Versions(), ", "))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header(resultschema.Header, version)
+
+	// The body is bounded by the maxResultSize limit of the route.
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			apispec.AbortBodyTooLarge(c, tooLarge.Limit)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "read result failed: " + err.Error(),
+		})
+		return
+	}
+
+	violations, err := a.results.Validate(version, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid result: " + err.Error(),
+		})
+		return
+	}
+	if len(violations) != 0 {
+		errs := make([]apispec.FieldError, len(violations))
+		for i, v := range violations {
+			errs[i] = apispec.FieldError{Field: v.Field, Reason: v.Reason}
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+			Message: "result does not match schema version " + version,
+			Errors:  errs,
+		})
+		return
+	}
+
+	resultID := c.GetHeader("Idempotency-Key")
+	if resultID == "" {
+		sum := sha256.Sum256(payload)
+		resultID = hex.EncodeToString(sum[:])
+	}
+
+	applied, err := a.taskContoller.PushResult(c.Request.Context(), modality, resultID, payload)
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrTaskNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "task not found",
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "push result failed: " + err.Error(),
+		})
+		return
+	}
+
+	status := http.StatusAccepted
+	if !applied {
+		status = http.StatusOK
+	}
+
+	c.JSON(status, ResultPushResponse{
+		ResultID:      resultID,
+		Applied:       applied,
+		SchemaVersion: version,
+	})
+}
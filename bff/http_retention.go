@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+
+	taskcontroller "github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+)
+
+// RetentionRequest replaces the lifecycle of a bucket; zero disables a rule.
+type RetentionRequest struct {
+	ExpireDays      int    `json:"expire_days" binding:"min=0" description:"days after which objects are deleted"`
+	TransitionDays  int    `json:"transition_days" binding:"min=0" description:"days after which objects move to transition_class"`
+	TransitionClass string `json:"transition_class" description:"storage class or remote tier, required with transition_days" example:"GLACIER"`
+}
+
+type RetentionResponse struct {
+	Bucket          string `json:"bucket"`
+	ExpireDays      int    `json:"expire_days,omitempty"`
+	TransitionDays  int    `json:"transition_days,omitempty"`
+	TransitionClass string `json:"transition_class,omitempty"`
+}
+
+func retentionToResponse(r model.BucketRetention) RetentionResponse {
+	return RetentionResponse{
+		Bucket:          r.Bucket,
+		ExpireDays:      r.ExpireDays,
+		TransitionDays:  r.TransitionDays,
+		TransitionClass: r.TransitionClass,
+	}
+}
+
+func (a *API) GetRetention(c *gin.Context) {
+	buckets, err := a.taskContoller.GetRetention(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, taskcontroller.ErrRetentionUnsupported) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get retention failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]RetentionResponse, len(buckets))
+	for i := range buckets {
+		resp[i] = retentionToResponse(buckets[i])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) SetRetention(c *gin.Context) {
+	req := apispec.Body[RetentionRequest](c)
+
+	r := model.BucketRetention{
+		Bucket:          c.Param("bucket"),
+		ExpireDays:      req.ExpireDays,
+		TransitionDays:  req.TransitionDays,
+		TransitionClass: req.TransitionClass,
+	}
+
+	if err := a.taskContoller.SetRetention(c.Request.Context(), r); err != nil {
+		switch {
+		case errors.Is(err, objectstorage.ErrInvalidRetention):
+			c.AbortWithStatusJSON(http.StatusBadRequest, apispec.ValidationErrorResponse{
+				Message: "request validation failed",
+				Errors:  []apispec.FieldError{{Field: "transition_class", Reason: "must be set together with transition_days"}},
+			})
+		case errors.Is(err, taskcontroller.ErrUnknownBucket):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+		case errors.Is(err, taskcontroller.ErrRetentionUnsupported):
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+				"message": err.Error(),
+			})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "set retention failed: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, retentionToResponse(r))
+}
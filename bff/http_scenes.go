@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyframeContentType is the type of the keyframes of the scenes.
+const keyframeContentType = "image/jpeg"
+
+// SceneResponse is a scene of the video of a task; its keyframe is served at /task/:id/scenes/:scene/keyframe.
+type SceneResponse struct {
+	Index      int     `json:"index"`
+	Start      float64 `json:"start" description:"start of the scene, seconds"`
+	End        float64 `json:"end" description:"end of the scene, seconds"`
+	KeyframeAt float64 `json:"keyframe_at" description:"position of the keyframe of the scene, seconds"`
+}
+
+func (a *API) GetTaskScenes(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	scenes, err := a.taskContoller.GetTaskScenes(c.Request.Context(), id)
+	if err != nil {
+		abortPreview(c, err)
+		return
+	}
+
+	resp := make([]SceneResponse, len(scenes))
+	for i, s := range scenes {
+		resp[i] = SceneResponse{
+			Index:      s.Index,
+			Start:      s.Start.Seconds(),
+			End:        s.End.Seconds(),
+			KeyframeAt: s.KeyframeAt.Seconds(),
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) GetTaskKeyframe(c *gin.Context) {
+	id, ok := parseTaskID(c)
+	if !ok {
+		return
+	}
+
+	scene, err := strconv.Atoi(c.Param("scene"))
+	if err != nil || scene < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "invalid scene: " + c.Param("scene"),
+		})
+		return
+	}
+
+	keyframe, size, err := a.taskContoller.GetTaskKeyframe(c.Request.Context(), id, scene)
+	if err != nil {
+		abortPreview(c, err)
+		return
+	}
+	defer keyframe.Close()
+
+	servePreview(c, keyframeContentType, keyframe, size)
+}
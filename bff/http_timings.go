@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// defaultStageStatsWindow is how far back the stage statistics look unless asked otherwise.
+const defaultStageStatsWindow = 24 * time.Hour
+
+// StageTimingResponse is when a timed stage of a task started and finished.
+type StageTimingResponse struct {
+	Stage      string     `json:"stage" description:"upload, audio_extraction, audio_check or video_check"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" description:"absent while the stage runs"`
+	Duration   float64    `json:"duration,omitempty" description:"seconds; absent while the stage runs"`
+}
+
+// StageStatsResponse are the percentiles of the durations of a timed stage.
+type StageStatsResponse struct {
+	Stage string  `json:"stage" description:"upload, audio_extraction, audio_check or video_check"`
+	Tasks int64   `json:"tasks" description:"tasks that finished the stage in the window"`
+	P50   float64 `json:"p50" description:"seconds"`
+	P90   float64 `json:"p90" description:"seconds"`
+	P99   float64 `json:"p99" description:"seconds"`
+}
+
+func (a *API) GetStageStats(c *gin.Context) {
+	window := defaultStageStatsWindow
+	if s := c.Query("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "window must be a positive duration, e.g. 24h",
+			})
+			return
+		}
+		window = d
+	}
+
+	stats, err := a.taskContoller.GetStageStats(c.Request.Context(), time.Now().Add(-window))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get stage stats failed: " + err.Error(),
+		})
+		return
+	}
+
+	resp := make([]StageStatsResponse, len(stats))
+	for i, s := range stats {
+		resp[i] = StageStatsResponse{
+			Stage: string(s.Stage),
+			Tasks: s.Tasks,
+			P50:   s.P50.Seconds(),
+			P90:   s.P90.Seconds(),
+			P99:   s.P99.Seconds(),
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func stageTimingsToResponse(timings []model.StageTiming) []StageTimingResponse {
+	if len(timings) == 0 {
+		return nil
+	}
+
+	resp := make([]StageTimingResponse, len(timings))
+	for i, t := range timings {
+		resp[i] = StageTimingResponse{
+			Stage:      string(t.Stage),
+			StartedAt:  t.StartedAt,
+			FinishedAt: optionalTime(t.FinishedAt),
+		}
+		if !t.FinishedAt.IsZero() {
+			resp[i].Duration = t.FinishedAt.Sub(t.StartedAt).Seconds()
+		}
+	}
+
+	return resp
+}
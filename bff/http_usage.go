@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/auth"
+)
+
+// accountKey is the context key of the account resolved by resolveAccount.
+const accountKey = "account"
+
+type UsageResponse struct {
+	Account string       `json:"account" description:"subject of the token or fingerprint of the API key"`
+	Usage   UsageAmounts `json:"usage"`
+	Quota   UsageAmounts `json:"quota" description:"limits of every account, 0 is unlimited"`
+}
+
+type UsageAmounts struct {
+	Tasks        int64   `json:"tasks"`
+	VideoMinutes float64 `json:"video_minutes"`
+	Bytes        int64   `json:"bytes"`
+}
+
+// resolveAccount records the account the quotas count the tasks of the request against. While a quota
+// limit is set, a request without one is rejected, so omitting the API key or sending a new one does not
+// evade the quota.
+func (a *API) resolveAccount(c *gin.Context) {
+	account, ok := a.requestAccount(c)
+	if !ok && a.quotaEnabled {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "a token or a registered API key is required",
+		})
+		return
+	}
+
+	c.Set(accountKey, account)
+	c.Next()
+}
+
+// requestAccount returns the account of the request: the subject of its token or else the fingerprint of
+// its API key. While a quota limit is set, only registered API keys are accounts.
+func (a *API) requestAccount(c *gin.Context) (string, bool) {
+	if sub := auth.Subject(c); sub != "" {
+		// The prefix keeps subjects apart from key fingerprints.
+		return "sub:" + sub, true
+	}
+
+	key := apiKeyID(c.GetHeader(apiKeyHeader))
+	if key == "" || (a.quotaEnabled && !a.apiKeys[key]) {
+		return "", false
+	}
+
+	return key, true
+}
+
+func (a *API) GetUsage(c *gin.Context) {
+	account, ok := a.requestAccount(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "a token or a registered API key is required",
+		})
+		return
+	}
+
+	usage, err := a.taskContoller.GetUsage(c.Request.Context(), account)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "get usage failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, UsageResponse{
+		Account: account,
+		Usage:   usageToResponse(usage),
+		Quota:   usageToResponse(a.taskContoller.Quota()),
+	})
+}
+
+func usageToResponse(u model.Usage) UsageAmounts {
+	return UsageAmounts{
+		Tasks:        u.Tasks,
+		VideoMinutes: u.VideoSeconds / 60,
+		Bytes:        u.Bytes,
+	}
+}
@@ -0,0 +1,166 @@
+package taskcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// systemActor is recorded for actions the service takes on its own, e.g. applying results from the ML services.
+const systemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the audited actions taken with it to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor carried by ctx, falling back to the system actor.
+func actorFrom(ctx context.Context) string {
+	if a, _ := ctx.Value(actorKey{}).(string); a != "" {
+		return a
+	}
+
+	return systemActor
+}
+
+// attribute attributes the status changes of the transaction of q to the actor of ctx and reason in
+// the task history; outside a transaction it has no effect.
+func attribute(ctx context.Context, q *pgsql.Queries, reason string) error {
+	if err := q.SetAuditContext(ctx, pgsql.SetAuditContextParams{
+		Actor:  actorFrom(ctx),
+		Reason: reason,
+	}); err != nil {
+		return fmt.Errorf("set audit context failed: %w", err)
+	}
+
+	return nil
+}
+
+// audit appends an event to the audit log through q, so it can share a transaction with the action.
+// Verdicts and overrides are queued for the verdict stream by the same statement. The status changes
+// of the transaction are attributed to the action in the task history.
+func (ctl *TaskController) audit(ctx context.Context, q *pgsql.Queries, action string, taskID int64, details any) error {
+	// Marshal the details of the action.
+	b, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+
+	if err := attribute(ctx, q, action); err != nil {
+		return err
+	}
+
+	// Append the event of a verdict together with its entry in the verdict stream.
+	if model.VerdictKind(action) != "" {
+		if err := q.InsertVerdictAuditEvent(ctx, pgsql.InsertVerdictAuditEventParams{
+			Actor:   actorFrom(ctx),
+			Action:  action,
+			TaskID:  pgtype.Int8{Int64: taskID, Valid: taskID != 0},
+			Details: b,
+		}); err != nil {
+			return fmt.Errorf("insert audit event failed: %w", err)
+		}
+
+		return nil
+	}
+
+	// Append the event.
+	if err := q.InsertAuditEvent(ctx, pgsql.InsertAuditEventParams{
+		Actor:   actorFrom(ctx),
+		Action:  action,
+		TaskID:  pgtype.Int8{Int64: taskID, Valid: taskID != 0},
+		Details: b,
+	}); err != nil {
+		return fmt.Errorf("insert audit event failed: %w", err)
+	}
+
+	return nil
+}
+
+// recordAudit appends an event for an action that already happened; a failure is logged, not returned.
+func (ctl *TaskController) recordAudit(ctx context.Context, action string, taskID int64, details any) {
+	if err := ctl.audit(ctx, ctl.pgConn, action, taskID, details); err != nil {
+		ctl.logger(ctx).Error().Err(err).Str("action", action).Int64("task_id", taskID).Msg("failed to record audit event")
+	}
+}
+
+// GetAuditLog retrieves a page of audit events matching the filter using keyset pagination.
+func (ctl *TaskController) GetAuditLog(ctx context.Context, filter model.AuditFilter, limit uint64, cursor string) ([]model.AuditEvent, string, error) {
+	// Decode the cursor into the last event ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Retrieve one event more than requested to find out whether there is a next page.
+	rows, err := ctl.pgConn.ListAuditEvents(ctx, pgsql.ListAuditEventsParams{
+		ID:      after,
+		Actor:   optionalText(filter.Actor),
+		Action:  optionalText(filter.Action),
+		TaskID:  pgtype.Int8{Int64: filter.TaskID, Valid: filter.TaskID != 0},
+		MaxRows: int32(limit + 1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("list audit events failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more events.
+	var next string
+	if uint64(len(rows)) > limit {
+		rows = rows[:limit]
+		next = encodeCursor(rows[len(rows)-1].ID)
+	}
+
+	// Convert the rows to the application model.
+	events := make([]model.AuditEvent, len(rows))
+	for i := range rows {
+		events[i] = auditEventToModel(rows[i])
+	}
+
+	return events, next, nil
+}
+
+// GetTaskHistory returns the changes of the status of a task in order.
+func (ctl *TaskController) GetTaskHistory(ctx context.Context, taskID int64) ([]model.TaskEvent, error) {
+	// Check that the task exists and is not deleted.
+	task, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("get task failed: %w", err)
+	}
+	if task.DeletedAt.Valid {
+		return nil, ErrTaskNotFound
+	}
+
+	rows, err := ctl.pgConn.GetTaskEvents(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task events failed: %w", err)
+	}
+
+	events := make([]model.TaskEvent, len(rows))
+	for i, r := range rows {
+		events[i] = model.TaskEvent{
+			ID:             r.ID,
+			Status:         statusToModel(r.Status),
+			PreviousStatus: statusToModel(r.PreviousStatus.TaskStatus),
+			HasPrevious:    r.PreviousStatus.Valid,
+			Stage:          string(r.Stage),
+			Actor:          r.Actor,
+			Reason:         r.Reason.String,
+			OccurredAt:     r.OccurredAt.Time,
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,345 @@
+package taskcontroller
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// ErrBatchNotFound is returned when the batch does not exist.
+	ErrBatchNotFound = errors.New("batch not found")
+	// ErrBatchRunning is returned for the result of a batch that has not finished.
+	ErrBatchRunning = errors.New("batch is still running")
+	// ErrInvalidBatchToken is returned for a batch token that is unknown, expired, revoked or scoped to another batch.
+	ErrInvalidBatchToken = errors.New("invalid batch token")
+)
+
+// StartBatch records a new batch of rows submitted by src and returns its empty summary.
+// The rows are checked in the background by the batch workers, see ClaimBatchRow.
+func (ctl *TaskController) StartBatch(ctx context.Context, src model.Source, rows []model.BatchRow) (model.BatchSummary, error) {
+	// Record the batch together with its rows, so the workers never see a partial batch.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return model.BatchSummary{}, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	id, err := q.CreateBatch(ctx, pgsql.CreateBatchParams{
+		TotalRows: int64(len(rows)),
+		SourceIp:  optionalText(src.IP),
+		UserAgent: optionalText(src.UserAgent),
+		ApiKeyID:  optionalText(src.APIKeyID),
+		Account:   optionalText(src.Account),
+	})
+	if err != nil {
+		return model.BatchSummary{}, fmt.Errorf("create batch failed: %w", err)
+	}
+
+	for i, r := range rows {
+		if err := q.InsertBatchRow(ctx, pgsql.InsertBatchRowParams{
+			BatchID: id,
+			RowNo:   int32(i + 1),
+			Created: pgtype.Timestamptz{Time: r.Created, Valid: true},
+			Uuid:    r.UUID,
+			Link:    r.Link,
+		}); err != nil {
+			return model.BatchSummary{}, fmt.Errorf("insert batch row %d failed: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return model.BatchSummary{}, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	s := model.NewBatchSummary(id, time.Now())
+	s.Total = int64(len(rows))
+
+	// A batch without rows has nothing to wait for.
+	if len(rows) == 0 {
+		if err := ctl.finishBatch(ctx, id); err != nil {
+			return model.BatchSummary{}, err
+		}
+		s.FinishedAt = time.Now()
+	}
+
+	return s, nil
+}
+
+// ClaimBatchRow leases the next unchecked row of the oldest batch for the configured lease and reports
+// false when no row is left. Rows are claimed with SKIP LOCKED, so the workers of several processes
+// share the batches; a row whose lease ran out is claimed again.
+func (ctl *TaskController) ClaimBatchRow(ctx context.Context) (model.BatchRow, bool, error) {
+	r, err := ctl.pgConn.ClaimBatchRow(ctx, pgtype.Timestamptz{
+		Time:  time.Now().Add(-ctl.cfg.Batch.RowLease),
+		Valid: true,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.BatchRow{}, false, nil
+		}
+		return model.BatchRow{}, false, fmt.Errorf("claim batch row failed: %w", err)
+	}
+
+	return model.BatchRow{
+		BatchID: r.BatchID,
+		RowNo:   r.RowNo,
+		Created: r.Created.Time,
+		UUID:    r.Uuid,
+		Link:    r.Link,
+		TaskID:  r.TaskID.Int64,
+		Source: model.Source{
+			IP:        r.SourceIp.String,
+			UserAgent: r.UserAgent.String,
+			APIKeyID:  r.ApiKeyID.String,
+			Account:   r.Account.String,
+			Priority:  model.PriorityBatch,
+		},
+	}, true, nil
+}
+
+// SetBatchRowTask records the task checking a row, so a worker claiming the row again waits for it
+// instead of checking the video twice.
+func (ctl *TaskController) SetBatchRowTask(ctx context.Context, r model.BatchRow, taskID int64) error {
+	if err := ctl.pgConn.SetBatchRowTask(ctx, pgsql.SetBatchRowTaskParams{
+		BatchID: r.BatchID,
+		RowNo:   r.RowNo,
+		TaskID:  pgtype.Int8{Int64: taskID, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("set batch row task failed: %w", err)
+	}
+
+	return nil
+}
+
+// CompleteBatchRow records the outcome of a row and finishes its batch once every row is checked.
+func (ctl *TaskController) CompleteBatchRow(ctx context.Context, r model.BatchRow, res model.BatchRowResult) error {
+	if err := ctl.pgConn.CompleteBatchRow(ctx, pgsql.CompleteBatchRowParams{
+		BatchID:      r.BatchID,
+		RowNo:        r.RowNo,
+		Failed:       res.Failed,
+		IsDuplicate:  res.IsDuplicate,
+		DuplicateFor: optionalText(res.DuplicateFor),
+		Score:        res.Score,
+		LatencyMs:    float64(res.Latency) / float64(time.Millisecond),
+	}); err != nil {
+		return fmt.Errorf("complete batch row failed: %w", err)
+	}
+
+	return ctl.finishBatch(ctx, r.BatchID)
+}
+
+// finishBatch stores the summary and the result CSV of a batch whose rows are all checked and marks
+// it finished. Batches with unchecked rows, and batches finished already, are left as they are.
+func (ctl *TaskController) finishBatch(ctx context.Context, batchID int64) error {
+	rows, err := ctl.pgConn.GetBatchRows(ctx, batchID)
+	if err != nil {
+		return fmt.Errorf("get batch rows failed: %w", err)
+	}
+
+	// Summarize the rows and write the result in the order of the submission.
+	s := model.NewBatchSummary(batchID, time.Time{})
+	var result bytes.Buffer
+	writer := csv.NewWriter(&result)
+	for _, r := range rows {
+		if !r.ProcessedAt.Valid {
+			return nil
+		}
+
+		latency := time.Duration(r.LatencyMs * float64(time.Millisecond))
+		s.Record(latency, r.Score, r.IsDuplicate, r.Failed)
+		if r.Failed {
+			// A failed row is counted in the batch summary and left out of the result.
+			continue
+		}
+
+		if err := writer.Write([]string{
+			r.Created.Time.Format(time.RFC3339),
+			r.Uuid,
+			r.Link,
+			strconv.FormatBool(r.IsDuplicate),
+			r.DuplicateFor.String,
+		}); err != nil {
+			return fmt.Errorf("write batch result failed: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("write batch result failed: %w", err)
+	}
+
+	// Encode the score distribution.
+	scores, err := json.Marshal(s.Scores)
+	if err != nil {
+		return fmt.Errorf("failed to marshal score histogram: %w", err)
+	}
+
+	// Store the summary and mark the batch finished; another worker may have done so already.
+	n, err := ctl.pgConn.FinishBatch(ctx, pgsql.FinishBatchParams{
+		BatchID:        batchID,
+		RowsProcessed:  s.Rows,
+		Duplicates:     s.Duplicates,
+		Failures:       s.Failures,
+		AvgLatencyMs:   float64(s.AvgLatency) / float64(time.Millisecond),
+		ScoreHistogram: scores,
+		ResultCsv:      result.Bytes(),
+	})
+	if err != nil {
+		return fmt.Errorf("finish batch failed: %w", err)
+	}
+	if n != 0 {
+		ctl.logger(ctx).Info().Int64("batch_id", batchID).Int64("rows", s.Rows).Int64("failures", s.Failures).Msg("batch finished")
+	}
+
+	return nil
+}
+
+// GetBatchSummary returns the summary of a batch. While the batch is running the counters tell its
+// progress and the score distribution is empty; it is filled in when the batch finishes.
+func (ctl *TaskController) GetBatchSummary(ctx context.Context, id int64) (model.BatchSummary, error) {
+	b, err := ctl.pgConn.GetBatch(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.BatchSummary{}, ErrBatchNotFound
+		}
+		return model.BatchSummary{}, fmt.Errorf("get batch failed: %w", err)
+	}
+
+	// Add up the resources used by the tasks of the rows; results re-requested after the batch
+	// finished count too.
+	u, err := ctl.pgConn.GetBatchResourceUsage(ctx, id)
+	if err != nil {
+		return model.BatchSummary{}, fmt.Errorf("get batch resource usage failed: %w", err)
+	}
+	resources := model.ResourceUsage{
+		BytesDownloaded: u.BytesDownloaded,
+		BytesStored:     u.BytesStored,
+		FFmpegCPU:       time.Duration(u.FfmpegCpuSeconds * float64(time.Second)),
+		MLRequests:      u.MlRequests,
+	}
+
+	if b.FinishedAt.Valid {
+		s, err := batchToModel(b)
+		s.Resources = resources
+		return s, err
+	}
+
+	// Count the rows checked so far.
+	p, err := ctl.pgConn.GetBatchProgress(ctx, id)
+	if err != nil {
+		return model.BatchSummary{}, fmt.Errorf("get batch progress failed: %w", err)
+	}
+
+	s := model.NewBatchSummary(b.BatchID, b.StartedAt.Time)
+	s.Total = b.TotalRows
+	s.Rows = p.Processed
+	s.Duplicates = p.Duplicates
+	s.Failures = p.Failures
+	s.Resources = resources
+
+	return s, nil
+}
+
+// GetBatchResult returns the stored result of a finished batch.
+func (ctl *TaskController) GetBatchResult(ctx context.Context, id int64) ([]byte, error) {
+	b, err := ctl.pgConn.GetBatch(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBatchNotFound
+		}
+		return nil, fmt.Errorf("get batch failed: %w", err)
+	}
+	if !b.FinishedAt.Valid {
+		return nil, ErrBatchRunning
+	}
+
+	return b.ResultCsv, nil
+}
+
+// IssueBatchToken creates a token granting read access to the batch for the configured lifetime.
+// The token is returned once; only its hash is stored.
+func (ctl *TaskController) IssueBatchToken(ctx context.Context, batchID int64) (model.BatchToken, error) {
+	// Generate a random token.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return model.BatchToken{}, fmt.Errorf("failed to generate batch token: %w", err)
+	}
+	token := model.BatchToken{
+		Token:     base64.RawURLEncoding.EncodeToString(b),
+		ExpiresAt: time.Now().Add(ctl.cfg.Auth.BatchTokenTTL),
+	}
+
+	if err := ctl.pgConn.CreateBatchToken(ctx, pgsql.CreateBatchTokenParams{
+		TokenHash: batchTokenHash(token.Token),
+		BatchID:   batchID,
+		ExpiresAt: pgtype.Timestamptz{Time: token.ExpiresAt, Valid: true},
+	}); err != nil {
+		return model.BatchToken{}, fmt.Errorf("create batch token failed: %w", err)
+	}
+
+	return token, nil
+}
+
+// CheckBatchToken returns ErrInvalidBatchToken unless the token grants access to the batch.
+func (ctl *TaskController) CheckBatchToken(ctx context.Context, batchID int64, token string) error {
+	ok, err := ctl.pgConn.HasValidBatchToken(ctx, pgsql.HasValidBatchTokenParams{
+		TokenHash: batchTokenHash(token),
+		BatchID:   batchID,
+	})
+	if err != nil {
+		return fmt.Errorf("check batch token failed: %w", err)
+	}
+	if !ok {
+		return ErrInvalidBatchToken
+	}
+
+	return nil
+}
+
+// RevokeBatchTokens revokes every token of the batch and returns how many were not revoked yet.
+func (ctl *TaskController) RevokeBatchTokens(ctx context.Context, batchID int64) (int64, error) {
+	// Make sure the batch exists.
+	if _, err := ctl.pgConn.GetBatch(ctx, batchID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrBatchNotFound
+		}
+		return 0, fmt.Errorf("get batch failed: %w", err)
+	}
+
+	n, err := ctl.pgConn.RevokeBatchTokens(ctx, batchID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke batch tokens failed: %w", err)
+	}
+
+	ctl.recordAudit(ctx, model.AuditBatchTokensRevoked, 0, map[string]any{
+		"batch_id": batchID,
+		"revoked":  n,
+	})
+
+	return n, nil
+}
+
+// batchTokenHash returns the hex SHA-256 of a batch token, under which it is stored.
+func batchTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
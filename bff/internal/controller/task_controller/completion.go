@@ -0,0 +1,120 @@
+package taskcontroller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// completionPollInterval is how often a waiter re-reads its task when no completion arrives in process,
+// which happens when the results are consumed by a separate worker.
+const completionPollInterval = 2 * time.Second
+
+var (
+	completionWaiters = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bff_completion_waiters",
+		Help: "Callers subscribed to the completion of a task in process.",
+	})
+	completionWaitersRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bff_completion_waiters_rejected_total",
+		Help: "Subscriptions refused because the task had the maximum number of waiters; they poll instead.",
+	})
+	completionSignalsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bff_completion_signals_dropped_total",
+		Help: "Completion signals dropped because the waiter had not taken the previous one yet.",
+	})
+)
+
+// completionRegistry wakes the callers waiting for tasks to finish.
+type completionRegistry struct {
+	// maxPerTask caps the waiters of one task, zero is unlimited.
+	maxPerTask int
+
+	mu      sync.Mutex
+	waiters map[int64][]chan struct{}
+}
+
+// subscribe returns a channel signalled when the task finishes and a function that unsubscribes it.
+// Over the cap of waiters of the task the channel is nil and never signalled, so the caller only polls.
+func (r *completionRegistry) subscribe(taskID int64) (<-chan struct{}, func()) {
+	r.mu.Lock()
+	if r.maxPerTask > 0 && len(r.waiters[taskID]) >= r.maxPerTask {
+		r.mu.Unlock()
+		completionWaitersRejected.Inc()
+		return nil, func() {}
+	}
+
+	ch := make(chan struct{}, 1)
+	if r.waiters == nil {
+		r.waiters = map[int64][]chan struct{}{}
+	}
+	r.waiters[taskID] = append(r.waiters[taskID], ch)
+	r.mu.Unlock()
+	completionWaiters.Inc()
+
+	return ch, func() {
+		completionWaiters.Dec()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		chs := r.waiters[taskID]
+		for i := range chs {
+			if chs[i] == ch {
+				chs = append(chs[:i], chs[i+1:]...)
+				break
+			}
+		}
+		if len(chs) == 0 {
+			delete(r.waiters, taskID)
+			return
+		}
+		r.waiters[taskID] = chs
+	}
+}
+
+// notify signals the waiters of the task without blocking.
+func (r *completionRegistry) notify(taskID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ch := range r.waiters[taskID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			completionSignalsDropped.Inc()
+		}
+	}
+}
+
+// WaitTask waits until the task is no longer in progress and returns it. Results applied by this
+// process wake the waiter right away; the task is re-read periodically to pick up results applied elsewhere.
+func (ctl *TaskController) WaitTask(ctx context.Context, id int64) (model.Task, error) {
+	// Subscribe before the first read, so a completion between the read and the wait is not missed.
+	done, unsubscribe := ctl.completions.subscribe(id)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(completionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		task, err := ctl.GetTask(ctx, id)
+		if err != nil {
+			return model.Task{}, err
+		}
+		if task.Status != model.TaskStatusInProgress {
+			return task, nil
+		}
+
+		select {
+		case <-done:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return model.Task{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// maxRetryBackoff caps the doubling delay between the tries of a message.
+const maxRetryBackoff = 30 * time.Second
+
+// Headers added to a dead-lettered message; the original key, value and headers are kept.
+const (
+	deadLetterErrorHeader     = "x-dead-letter-error"
+	deadLetterTopicHeader     = "x-dead-letter-topic"
+	deadLetterPartitionHeader = "x-dead-letter-partition"
+	deadLetterOffsetHeader    = "x-dead-letter-offset"
+	deadLetterAttemptsHeader  = "x-dead-letter-attempts"
+)
+
+var deadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_kafka_dead_letter_messages_total",
+	Help: "Messages routed to the dead-letter topic by source topic.",
+}, []string{"topic"})
+
+// maxRestartBackoff caps the doubling delay between the restarts of a reader.
+const maxRestartBackoff = time.Minute
+
+var consumerRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_kafka_consumer_restarts_total",
+	Help: "Restarts of Kafka readers that failed, by topic.",
+}, []string{"topic"})
+
+// errReaderStopped is reported for a reader that stopped before its context was done.
+var errReaderStopped = errors.New("reader stopped unexpectedly")
+
+// consumerSupervisor tracks the readers started by StartConsumers, so they are closed on shutdown.
+type consumerSupervisor struct {
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// superviseConsumer applies the messages of a topic with process, supervised by superviseReader.
+func (ctl *TaskController) superviseConsumer(ctx context.Context, cfg kafka.ReaderConfig, process func(ctx context.Context, msg kafka.Message) error) {
+	ctl.superviseReader(ctx, cfg, func(ctx context.Context, r *kafka.Reader) error {
+		return ctl.runConsumer(ctx, r, process)
+	})
+}
+
+// superviseReader runs a reader of cfg until ctx is done, closing it afterwards. A reader that fails or
+// panics is closed and created again after a delay doubling from Kafka.RestartBackoff; the delay starts
+// over once a reader ran longer than the longest delay.
+func (ctl *TaskController) superviseReader(ctx context.Context, cfg kafka.ReaderConfig, run func(ctx context.Context, r *kafka.Reader) error) {
+	ctl.consumers.wg.Add(1)
+	go func() {
+		defer ctl.consumers.wg.Done()
+
+		backoff := ctl.cfg.Kafka.RestartBackoff
+		for {
+			started := time.Now()
+			err := ctl.runReader(ctx, cfg, run)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(started) > maxRestartBackoff {
+				backoff = ctl.cfg.Kafka.RestartBackoff
+			}
+
+			consumerRestarts.WithLabelValues(cfg.Topic).Inc()
+			ctl.log.Error().Err(err).Str("topic", cfg.Topic).Dur("backoff", backoff).Msg("kafka reader failed, restarting")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxRestartBackoff)
+		}
+	}()
+}
+
+// runReader creates a reader of cfg and runs it, turning a panic into an error. The reader is closed
+// afterwards, leaving its consumer group; offsets are committed as messages are handled.
+func (ctl *TaskController) runReader(ctx context.Context, cfg kafka.ReaderConfig, run func(ctx context.Context, r *kafka.Reader) error) (err error) {
+	r := kafka.NewReader(cfg)
+	defer func() {
+		if errClose := r.Close(); errClose != nil {
+			ctl.log.Error().Err(errClose).Str("topic", cfg.Topic).Msg("close kafka reader failed")
+		}
+	}()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("reader panicked: %v", p)
+		}
+	}()
+
+	err = run(ctx, r)
+	if err == nil && ctx.Err() == nil {
+		err = errReaderStopped
+	}
+
+	return err
+}
+
+// StopConsumers closes the readers started by StartConsumers once the messages they handle are committed
+// and stops the background checks started with them. It waits until ctx is done at most.
+func (ctl *TaskController) StopConsumers(ctx context.Context) error {
+	if ctl.consumers.stop == nil {
+		return nil
+	}
+	ctl.consumers.stop()
+
+	done := make(chan struct{})
+	go func() {
+		ctl.consumers.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("kafka readers did not stop: %w", ctx.Err())
+	}
+}
+
+// runConsumer handles the messages of a reader until ctx is done or reading fails. A message is committed
+// once it is applied or dead-lettered, so a message interrupted by shutdown is delivered again.
+func (ctl *TaskController) runConsumer(ctx context.Context, r *kafka.Reader, process func(ctx context.Context, msg kafka.Message) error) error {
+	for {
+		// Read the next message without committing it.
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read message failed: %w", err)
+		}
+
+		if !ctl.handleMessage(ctx, msg, process) {
+			return nil
+		}
+
+		if err := r.CommitMessages(ctx, msg); err != nil {
+			ctl.log.Error().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("commit message failed")
+		}
+	}
+}
+
+// handleMessage processes a message, retrying transient failures with exponential backoff.
+// Malformed messages, messages of unknown tasks and messages out of retries go to the dead-letter topic.
+// It returns false when ctx is done before the message is applied or dead-lettered, so it is not committed.
+func (ctl *TaskController) handleMessage(ctx context.Context, msg kafka.Message, process func(ctx context.Context, msg kafka.Message) error) bool {
+	backoff := ctl.cfg.Kafka.RetryBackoff
+	attempts := 0
+	for {
+		attempts++
+		err := process(ctx, msg)
+		if err == nil {
+			return true
+		}
+
+		if permanent(err) || attempts >= ctl.cfg.Kafka.RetryAttempts {
+			return ctl.deadLetter(ctx, msg, err, attempts) == nil
+		}
+
+		ctl.log.Warn().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Int("attempt", attempts).
+			Dur("backoff", backoff).Msg("process message failed, retrying")
+
+		// Wait before the next try.
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// pruneLedgers deletes the entries of the processed-message and pushed-result ledgers older than their
+// retention, by which time the topics no longer hold the messages they deduplicate.
+func (ctl *TaskController) pruneLedgers(ctx context.Context) error {
+	if ctl.cfg.Kafka.LedgerRetention <= 0 {
+		return nil
+	}
+
+	before := pgtype.Timestamptz{Time: time.Now().Add(-ctl.cfg.Kafka.LedgerRetention), Valid: true}
+	for {
+		n, err := ctl.pgConn.PruneProcessedMessages(ctx, pgsql.PruneProcessedMessagesParams{
+			ProcessedBefore: before,
+			MaxRows:         pruneBatchSize,
+		})
+		if err != nil {
+			return fmt.Errorf("prune processed messages failed: %w", err)
+		}
+		if n < pruneBatchSize {
+			break
+		}
+	}
+
+	for {
+		n, err := ctl.pgConn.PrunePushedResults(ctx, pgsql.PrunePushedResultsParams{
+			PushedBefore: before,
+			MaxRows:      pruneBatchSize,
+		})
+		if err != nil {
+			return fmt.Errorf("prune pushed results failed: %w", err)
+		}
+		if n < pruneBatchSize {
+			return nil
+		}
+	}
+}
+
+// permanent reports whether retrying a message cannot help.
+func permanent(err error) bool {
+	return errors.Is(err, ErrMalformedMessage) || errors.Is(err, ErrTaskNotFound)
+}
+
+// deadLetter publishes a message that could not be applied to the dead-letter topic with the error attached
+// and indexes it for ListDeadLetters. Without a dead-letter topic the message is only logged. A failed write
+// is retried with exponential backoff until ctx is done, and the error of ctx is returned then, so the
+// message is not committed and is delivered again.
+func (ctl *TaskController) deadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	logger := ctl.log.Error().Err(cause).Str("topic", msg.Topic).Int("partition", msg.Partition).
+		Int64("offset", msg.Offset).Int("attempts", attempts)
+
+	if ctl.cfg.Kafka.DeadLetterTopic == "" {
+		logger.Bytes("value", msg.Value).Msg("message dropped")
+		return nil
+	}
+
+	headers := append(append([]kafka.Header(nil), msg.Headers...),
+		kafka.Header{Key: deadLetterErrorHeader, Value: []byte(cause.Error())},
+		kafka.Header{Key: deadLetterTopicHeader, Value: []byte(msg.Topic)},
+		kafka.Header{Key: deadLetterPartitionHeader, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: deadLetterOffsetHeader, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: deadLetterAttemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+	)
+
+	backoff := ctl.cfg.Kafka.RetryBackoff
+	for {
+		err := ctl.producer.WriteMessages(ctx, kafka.Message{
+			Topic:   ctl.cfg.Kafka.DeadLetterTopic,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: headers,
+		})
+		if err == nil {
+			break
+		}
+
+		ctl.log.Warn().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Dur("backoff", backoff).
+			Msg("dead-letter message failed, retrying")
+
+		// Wait before the next try; the message stays uncommitted if ctx is done first.
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dead-letter message failed: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+
+	deadLettered.WithLabelValues(msg.Topic).Inc()
+	logger.Msg("message dead-lettered")
+
+	ctl.recordDeadLetter(ctx, msg, cause, attempts)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package taskcontroller
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// cursorPrefix versions the cursor format so it can change without breaking old tokens silently.
+const cursorPrefix = "t1:"
+
+// encodeCursor builds an opaque pagination token pointing after the given task ID.
+func encodeCursor(taskID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(taskID, 10)))
+}
+
+// decodeCursor parses a pagination token; an empty token points before the first task.
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	id, ok := strings.CutPrefix(string(raw), cursorPrefix)
+	if !ok {
+		return 0, ErrInvalidCursor
+	}
+
+	taskID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || taskID < 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return taskID, nil
+}
@@ -0,0 +1,146 @@
+package taskcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ErrDeadLetterNotFound is returned when no dead-lettered message has the given ID.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// recordDeadLetter indexes a message published to the dead-letter topic, so it can be inspected and
+// replayed. A failure is only logged, as the message is in the dead-letter topic already.
+func (ctl *TaskController) recordDeadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) {
+	headers := make([]model.Header, len(msg.Headers))
+	for i, h := range msg.Headers {
+		headers[i] = model.Header{Key: h.Key, Value: h.Value}
+	}
+	b, err := json.Marshal(headers)
+	if err != nil {
+		ctl.log.Error().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("failed to marshal dead letter headers")
+		return
+	}
+
+	if err := ctl.pgConn.InsertDeadLetter(context.WithoutCancel(ctx), pgsql.InsertDeadLetterParams{
+		Topic:        msg.Topic,
+		MsgPartition: int32(msg.Partition),
+		MsgOffset:    msg.Offset,
+		MsgKey:       msg.Key,
+		MsgValue:     msg.Value,
+		Headers:      b,
+		Error:        cause.Error(),
+		Attempts:     int32(attempts),
+	}); err != nil {
+		ctl.log.Error().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("failed to record dead letter")
+	}
+}
+
+// ListDeadLetters retrieves a page of the dead-lettered messages matching the filter in the order they
+// were dead-lettered first, using keyset pagination.
+func (ctl *TaskController) ListDeadLetters(ctx context.Context, filter model.DeadLetterFilter, limit uint64, cursor string) ([]model.DeadLetter, string, error) {
+	// Decode the cursor into the last dead letter ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Retrieve one message more than requested to find out whether there is a next page.
+	rows, err := ctl.pgConn.ListDeadLetters(ctx, pgsql.ListDeadLettersParams{
+		ID:      after,
+		Topic:   optionalText(filter.Topic),
+		Pending: filter.Pending,
+		MaxRows: int32(limit + 1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("list dead letters failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more messages.
+	var next string
+	if uint64(len(rows)) > limit {
+		rows = rows[:limit]
+		next = encodeCursor(rows[len(rows)-1].ID)
+	}
+
+	letters := make([]model.DeadLetter, len(rows))
+	for i, r := range rows {
+		if letters[i], err = deadLetterToModel(r); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return letters, next, nil
+}
+
+// ReplayDeadLetter publishes a dead-lettered message to the topic it was read from again, with its
+// original key, value and headers, so the consumer applies it once more.
+func (ctl *TaskController) ReplayDeadLetter(ctx context.Context, id int64) error {
+	row, err := ctl.pgConn.GetDeadLetter(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrDeadLetterNotFound, id)
+		}
+		return fmt.Errorf("get dead letter failed: %w", err)
+	}
+
+	letter, err := deadLetterToModel(row)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]kafka.Header, len(letter.Headers))
+	for i, h := range letter.Headers {
+		headers[i] = kafka.Header{Key: h.Key, Value: h.Value}
+	}
+	if err := ctl.producer.WriteMessages(ctx, kafka.Message{
+		Topic:   letter.Topic,
+		Key:     letter.Key,
+		Value:   letter.Value,
+		Headers: headers,
+	}); err != nil {
+		return fmt.Errorf("replay dead letter failed: %w", err)
+	}
+
+	if err := ctl.pgConn.MarkDeadLetterReplayed(ctx, id); err != nil {
+		return fmt.Errorf("mark dead letter replayed failed: %w", err)
+	}
+
+	ctl.recordAudit(ctx, model.AuditDeadLetterReplayed, 0, map[string]any{
+		"dead_letter_id": id,
+		"topic":          letter.Topic,
+		"partition":      letter.Partition,
+		"offset":         letter.Offset,
+	})
+
+	return nil
+}
+
+// deadLetterToModel converts a PostgreSQL dead letter row to a model dead letter.
+func deadLetterToModel(r pgsql.KafkaDeadLetter) (model.DeadLetter, error) {
+	var headers []model.Header
+	if err := json.Unmarshal(r.Headers, &headers); err != nil {
+		return model.DeadLetter{}, fmt.Errorf("failed to unmarshal dead letter headers: %w", err)
+	}
+
+	return model.DeadLetter{
+		ID:             r.ID,
+		Topic:          r.Topic,
+		Partition:      int(r.MsgPartition),
+		Offset:         r.MsgOffset,
+		Key:            r.MsgKey,
+		Value:          r.MsgValue,
+		Headers:        headers,
+		Error:          r.Error,
+		Attempts:       int(r.Attempts),
+		DeadLetteredAt: r.DeadLetteredAt.Time,
+		ReplayedAt:     r.ReplayedAt.Time,
+	}, nil
+}
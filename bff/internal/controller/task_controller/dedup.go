@@ -0,0 +1,200 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/multihash"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/phash"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/samplehash"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// dedupModeSample hashes only the edges of stored objects until an original shares the sample.
+const dedupModeSample = "sample"
+
+// getSampleHashFromVideo calculates the sampled hash of a video file stored in Minio with range reads.
+func (ctl *TaskController) getSampleHashFromVideo(ctx context.Context, id, bucket string) (string, error) {
+	// Open the video file for random access.
+	obj, size, err := ctl.storage.GetFileReaderAt(ctx, id, bucket)
+	if err != nil {
+		return "", fmt.Errorf("failed to get reader from minio: %w", err)
+	}
+	defer obj.Close()
+
+	return samplehash.Sum(obj, size, ctl.cfg.Dedup.SampleSize)
+}
+
+// hashStoredVideo returns the hash used for the content key of a stored video and whether it is the full MD5.
+// In sample mode the full hash is only calculated when an original video may be an exact duplicate.
+func (ctl *TaskController) hashStoredVideo(ctx context.Context, id, bucket string) (string, bool, error) {
+	if ctl.cfg.Dedup.Mode == dedupModeSample && ctl.cfg.Dedup.SampleSize > 0 {
+		// Calculate the sampled hash.
+		sample, err := ctl.getSampleHashFromVideo(ctx, id, bucket)
+		if err != nil {
+			return "", false, err
+		}
+
+		// Check whether any original video may share the content.
+		candidates, err := ctl.pgConn.HasOrigVideoCandidates(ctx, pgtype.Text{String: sample, Valid: true})
+		if err != nil {
+			return "", false, fmt.Errorf("failed to look up sample hash candidates: %w", err)
+		}
+		if !candidates {
+			return sample, false, nil
+		}
+	}
+
+	// Calculate the full hash.
+	hash, err := ctl.getHashFromVideo(ctx, id, bucket)
+	if err != nil {
+		return "", false, err
+	}
+
+	return hash, true, nil
+}
+
+// perceptualScreenshotSize is the side of the screenshots the perceptual hash is computed from.
+const perceptualScreenshotSize = 64
+
+// originalMatch is an original video a new video was found to duplicate without the ML services.
+type originalMatch struct {
+	VideoID     string
+	Probability float64
+	// Kind names the match in the audit log: exact_match or perceptual_match.
+	Kind string
+}
+
+// perceptualHash computes the perceptual hash of a local video file from evenly spaced screenshots.
+// It returns an empty hash when perceptual dedup is disabled.
+func (ctl *TaskController) perceptualHash(ctx context.Context, filename string) (string, error) {
+	if ctl.cfg.Dedup.PerceptualFrames <= 0 {
+		return "", nil
+	}
+
+	// Take the screenshots and ensure they are removed after hashing.
+	shots, err := ctl.ffmpegFor(ctx).GetScreenshotsFromVideo(filename, ctl.cfg.Dedup.PerceptualFrames, perceptualScreenshotSize)
+	for _, shot := range shots {
+		ctl.tempFS.Track("perceptual-hash", shot)
+		defer ctl.tempFS.Remove(shot)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to take screenshots: %w", err)
+	}
+
+	// Hash every screenshot in the order of the video.
+	hash := make(phash.Hash, 0, len(shots))
+	for _, shot := range shots {
+		frame, err := decodeScreenshot(shot)
+		if err != nil {
+			return "", err
+		}
+		hash = append(hash, phash.DHash(frame))
+	}
+
+	return hash.String(), nil
+}
+
+// decodeScreenshot reads a screenshot taken by ffmpeg.
+func decodeScreenshot(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open screenshot: %w", err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+
+	return img, nil
+}
+
+// findPerceptualMatch returns the original closest to the perceptual hash of a video if it is within
+// the configured distance. The probability of the match falls from 1 with the share of differing bits.
+func (ctl *TaskController) findPerceptualMatch(ctx context.Context, digest string) (originalMatch, bool, error) {
+	hash, err := phash.Parse(digest)
+	if err != nil {
+		return originalMatch{}, false, err
+	}
+
+	originals, err := ctl.pgConn.GetOrigVideosWithPerceptualHash(ctx)
+	if err != nil {
+		return originalMatch{}, false, fmt.Errorf("failed to get perceptual hashes of original videos: %w", err)
+	}
+
+	// Find the closest original; originals with unreadable or incomparable hashes are skipped.
+	var best originalMatch
+	bestDistance := math.Inf(1)
+	for _, orig := range originals {
+		other, err := phash.Parse(orig.PerceptualHash.String)
+		if err != nil {
+			continue
+		}
+		distance, ok := phash.Distance(hash, other)
+		if !ok || distance >= bestDistance {
+			continue
+		}
+		bestDistance = distance
+		best = originalMatch{
+			VideoID:     orig.VideoID.String,
+			Probability: 1 - distance/phash.FrameBits,
+			Kind:        "perceptual_match",
+		}
+	}
+
+	if bestDistance > ctl.cfg.Dedup.PerceptualMaxDistance {
+		return originalMatch{}, false, nil
+	}
+
+	return best, true, nil
+}
+
+// RegisterOriginal records the video of a task as an original with its MD5 and perceptual hashes and
+// its audio fingerprint, so later copies are decided without the ML services. Videos registered already
+// are skipped.
+func (ctl *TaskController) RegisterOriginal(ctx context.Context, taskID int64) error {
+	task, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		return fmt.Errorf("get task failed: %w", err)
+	}
+
+	_, err = ctl.pgConn.GetOrigVideo(ctx, task.VideoName)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("get original video failed: %w", err)
+	}
+
+	hashes, err := ctl.GetTaskHashes(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	// Tasks created while audio fingerprinting was disabled have no fingerprint.
+	fingerprint, err := ctl.pgConn.GetTaskAudioFingerprint(ctx, taskID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("get task audio fingerprint failed: %w", err)
+	}
+
+	if _, err := ctl.pgConn.CreateOrigVideo(ctx, pgsql.CreateOrigVideoParams{
+		VideoID:          task.VideoName,
+		VideoHash:        optionalText(hashes[multihash.MD5]),
+		PerceptualHash:   optionalText(hashes[phash.Algorithm]),
+		AudioFingerprint: fingerprint,
+	}); err != nil {
+		return fmt.Errorf("create original video failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+var (
+	// ErrTaskDeleted is returned when deleting a task that is deleted already.
+	ErrTaskDeleted = errors.New("task is deleted")
+	// ErrTaskNotDeleted is returned when restoring or purging a task that is not deleted.
+	ErrTaskNotDeleted = errors.New("task is not deleted")
+)
+
+// DeleteTask soft-deletes a task: GetTask and the listings skip it until it is restored or
+// purged. Its results, media and audit events are kept.
+func (ctl *TaskController) DeleteTask(ctx context.Context, taskID int64) error {
+	return ctl.changeDeletion(ctx, taskID, model.AuditTaskDeleted, func(q *pgsql.Queries, task pgsql.Task) (int64, error) {
+		if task.DeletedAt.Valid {
+			return 0, ErrTaskDeleted
+		}
+		return q.SoftDeleteTask(ctx, pgsql.SoftDeleteTaskParams{TaskID: taskID, Version: task.Version})
+	})
+}
+
+// RestoreTask undoes the deletion of a task.
+func (ctl *TaskController) RestoreTask(ctx context.Context, taskID int64) error {
+	return ctl.changeDeletion(ctx, taskID, model.AuditTaskRestored, func(q *pgsql.Queries, task pgsql.Task) (int64, error) {
+		if !task.DeletedAt.Valid {
+			return 0, ErrTaskNotDeleted
+		}
+		return q.RestoreTask(ctx, pgsql.RestoreTaskParams{TaskID: taskID, Version: task.Version})
+	})
+}
+
+// PurgeTask removes a deleted task and the rows describing it for good; tasks and batch rows referring
+// to it lose the reference. Its audit events are kept, the last one recording what was purged.
+// The references of the task to its video, audio, preview, sprite sheet and keyframes are released in the
+// same transaction, and the objects no other task refers to are deleted.
+func (ctl *TaskController) PurgeTask(ctx context.Context, taskID int64) error {
+	return ctl.changeDeletion(ctx, taskID, model.AuditTaskPurged, func(q *pgsql.Queries, task pgsql.Task) (int64, error) {
+		if !task.DeletedAt.Valid {
+			return 0, ErrTaskNotDeleted
+		}
+
+		// Collect the objects before the rows naming them are purged.
+		objects, err := ctl.taskObjects(ctx, q, task)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := q.PurgeTask(ctx, pgsql.PurgeTaskParams{TaskID: taskID, Version: task.Version})
+		if err != nil || n == 0 {
+			return n, err
+		}
+
+		for _, o := range objects {
+			if err := ctl.dropObjectRef(ctx, q, o.name, o.bucket); err != nil {
+				return 0, err
+			}
+		}
+
+		return n, nil
+	})
+}
+
+// changeDeletion applies change to a locked task and audits it in one transaction. The audit event
+// records the status of the task and its video before the change.
+func (ctl *TaskController) changeDeletion(ctx context.Context, taskID int64, action string, change func(q *pgsql.Queries, task pgsql.Task) (int64, error)) error {
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Lock the task, so concurrent changes apply one after the other.
+	task, err := q.GetTaskForUpdate(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+		}
+		return fmt.Errorf("get task failed: %w", err)
+	}
+
+	n, err := change(q, task)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+	}
+
+	if err := ctl.audit(ctx, q, action, taskID, map[string]any{
+		"status":     task.Status.TaskStatus,
+		"video_name": task.VideoName.String,
+		"video_file": task.VideoFile.String,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	ctl.logger(ctx).Info().Int64("task_id", taskID).Str("action", action).Msg("task deletion changed")
+
+	return nil
+}
+
+// GetDeletedTasks retrieves a page of the deleted tasks using keyset pagination.
+func (ctl *TaskController) GetDeletedTasks(ctx context.Context, limit uint64, cursor string) ([]model.Task, string, error) {
+	// Decode the cursor into the last task ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Retrieve one task more than requested to find out whether there is a next page.
+	pgtasks, err := ctl.pgConn.GetDeletedTasks(ctx, pgsql.GetDeletedTasksParams{
+		TaskID: after,
+		Limit:  int32(limit + 1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("get deleted tasks failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more tasks.
+	var next string
+	if uint64(len(pgtasks)) > limit {
+		pgtasks = pgtasks[:limit]
+		next = encodeCursor(pgtasks[len(pgtasks)-1].TaskID)
+	}
+
+	// Convert the retrieved tasks from the database model to the application model.
+	tasks, err := taskSliceToModel(pgtasks)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tasks, next, nil
+}
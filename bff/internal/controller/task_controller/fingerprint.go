@@ -0,0 +1,150 @@
+package taskcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/chromaprint"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+var audioFingerprintAnswers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_audio_fingerprint_answers_total",
+	Help: "Audio checks answered from the stored fingerprints instead of the audio ML service, by reason: match or fallback.",
+}, []string{"reason"})
+
+// audioFingerprint fingerprints a local audio file as configured, encoded for storage.
+// It returns no fingerprint when audio fingerprinting is disabled.
+func (ctl *TaskController) audioFingerprint(ctx context.Context, filename string) ([]byte, error) {
+	if ctl.cfg.Dedup.AudioFingerprintLength <= 0 {
+		return nil, nil
+	}
+
+	fp, err := ctl.ffmpegFor(ctx).GetAudioFingerprint(filename, ctl.cfg.Dedup.AudioFingerprintLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint audio: %w", err)
+	}
+
+	return chromaprint.Fingerprint(fp).Bytes(), nil
+}
+
+// recordAudioFingerprint stores the audio fingerprint of a task for lookups and registration as an
+// original; a failure is only logged, as the task is checked without it.
+func (ctl *TaskController) recordAudioFingerprint(ctx context.Context, taskID int64, fingerprint []byte) {
+	if len(fingerprint) == 0 {
+		return
+	}
+
+	if err := ctl.pgConn.InsertTaskAudioFingerprint(ctx, pgsql.InsertTaskAudioFingerprintParams{
+		TaskID:      taskID,
+		Fingerprint: fingerprint,
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to record audio fingerprint")
+	}
+}
+
+// findAudioMatch returns the original whose audio fingerprint agrees most with the given one if it
+// reaches the configured similarity. The probability of the match is the similarity.
+func (ctl *TaskController) findAudioMatch(ctx context.Context, fingerprint []byte) (originalMatch, bool, error) {
+	fp, err := chromaprint.Parse(fingerprint)
+	if err != nil {
+		return originalMatch{}, false, err
+	}
+
+	originals, err := ctl.pgConn.GetOrigVideosWithAudioFingerprint(ctx)
+	if err != nil {
+		return originalMatch{}, false, fmt.Errorf("failed to get audio fingerprints of original videos: %w", err)
+	}
+
+	// Find the closest original; originals with unreadable or incomparable fingerprints are skipped.
+	var best originalMatch
+	for _, orig := range originals {
+		other, err := chromaprint.Parse(orig.AudioFingerprint)
+		if err != nil {
+			continue
+		}
+		similarity, ok := chromaprint.Similarity(fp, other)
+		if !ok || similarity <= best.Probability {
+			continue
+		}
+		best = originalMatch{
+			VideoID:     orig.VideoID.String,
+			Probability: similarity,
+			Kind:        "audio_fingerprint_match",
+		}
+	}
+
+	if best.Probability < ctl.cfg.Dedup.AudioMinSimilarity {
+		return originalMatch{}, false, nil
+	}
+
+	return best, true, nil
+}
+
+// audioResult builds the audio copyright result of a task in the form of the audio ML service:
+// the matched original, or no reference without a match.
+func audioResult(taskID int64, match originalMatch, matched bool) ([]byte, error) {
+	res := model.KafkaResponse{TaskID: taskID, Copy: []model.Copyright{}}
+	if matched {
+		res.Copy = append(res.Copy, model.Copyright{Name: match.VideoID, Probability: match.Probability})
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audio result: %w", err)
+	}
+
+	return b, nil
+}
+
+// answerAudioFromFingerprint stores the audio result of a task whose audio requests ran out of retries
+// from its audio fingerprint, deciding the task like a result of the audio ML service would.
+// It returns false for a task without a fingerprint, which is left to fail.
+func (ctl *TaskController) answerAudioFromFingerprint(ctx context.Context, taskID int64) (bool, error) {
+	fingerprint, err := ctl.pgConn.GetTaskAudioFingerprint(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get task audio fingerprint failed: %w", err)
+	}
+
+	match, matched, err := ctl.findAudioMatch(ctx, fingerprint)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := audioResult(taskID, match, matched)
+	if err != nil {
+		return false, err
+	}
+
+	// Apply the result as any other; the task may have been decided meanwhile.
+	applied, err := ctl.applyCopyrightResult(ctx, model.ModalityAudio, result, func(*pgsql.Queries) (int64, error) {
+		return 1, nil
+	}, func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error) {
+		return q.ApplyTaskAudioCopyright(ctx, pgsql.ApplyTaskAudioCopyrightParams{
+			TaskID:         k.TaskID,
+			AudioCopyright: result,
+			Version:        version,
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		return true, nil
+	}
+
+	audioFingerprintAnswers.WithLabelValues("fallback").Inc()
+	ctl.log.Warn().Int64("task_id", taskID).Bool("matched", matched).Msg("audio result overdue, answered from audio fingerprints")
+
+	return true, nil
+}
@@ -0,0 +1,63 @@
+package taskcontroller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// maxHashMatches caps the tasks returned for a digest.
+const maxHashMatches = 1000
+
+// recordHashes stores the digests of the video of a task. Failures are logged only,
+// so a task is never lost over its digests.
+func (ctl *TaskController) recordHashes(ctx context.Context, taskID int64, hashes map[string]string) {
+	// Store the digests in a stable order.
+	algorithms := make([]string, 0, len(hashes))
+	for algorithm := range hashes {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	for _, algorithm := range algorithms {
+		if err := ctl.pgConn.InsertTaskHash(ctx, pgsql.InsertTaskHashParams{
+			TaskID:    taskID,
+			Algorithm: algorithm,
+			Digest:    hashes[algorithm],
+		}); err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Str("algorithm", algorithm).Msg("failed to record task hash")
+		}
+	}
+}
+
+// GetTaskHashes returns the digests of the video of a task by algorithm.
+func (ctl *TaskController) GetTaskHashes(ctx context.Context, taskID int64) (map[string]string, error) {
+	rows, err := ctl.pgConn.GetTaskHashes(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task hashes failed: %w", err)
+	}
+
+	hashes := make(map[string]string, len(rows))
+	for _, r := range rows {
+		hashes[r.Algorithm] = r.Digest
+	}
+
+	return hashes, nil
+}
+
+// GetTasksByHash returns the tasks whose video has the digest, oldest first.
+func (ctl *TaskController) GetTasksByHash(ctx context.Context, algorithm, digest string) ([]model.Task, error) {
+	pgtasks, err := ctl.pgConn.GetTasksByHash(ctx, pgsql.GetTasksByHashParams{
+		Algorithm: algorithm,
+		Digest:    digest,
+		Limit:     maxHashMatches,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get tasks by hash failed: %w", err)
+	}
+
+	return taskSliceToModel(pgtasks)
+}
@@ -0,0 +1,80 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrNotChecked is reported for a dependency whose first health check has not finished yet.
+var ErrNotChecked = errors.New("not checked yet")
+
+var storageUp = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bff_storage_up",
+	Help: "Whether the last health check of the object storage succeeded.",
+})
+
+// healthState holds the result of the last health check of the storage.
+type healthState struct {
+	mu         sync.RWMutex
+	checked    bool
+	storageErr error
+}
+
+// StartHealthChecks checks the storage right away and then periodically until ctx is done,
+// so readiness reflects an outage without probing the storage on every request.
+func (ctl *TaskController) StartHealthChecks(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ctl.cfg.Storage.HealthInterval)
+		defer ticker.Stop()
+
+		for {
+			ctl.checkStorage(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkStorage pings the storage, bounded by the check interval, and records the result.
+func (ctl *TaskController) checkStorage(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, ctl.cfg.Storage.HealthInterval)
+	defer cancel()
+
+	err := ctl.storage.Ping(ctx)
+	if err != nil {
+		ctl.log.Warn().Err(err).Msg("storage health check failed")
+		storageUp.Set(0)
+	} else {
+		storageUp.Set(1)
+	}
+
+	ctl.health.mu.Lock()
+	ctl.health.checked = true
+	ctl.health.storageErr = err
+	ctl.health.mu.Unlock()
+}
+
+// Readiness reports the health of each dependency by name, nil for a healthy one. The storage is
+// reported as of its last periodic check; the database is pinged with ctx.
+func (ctl *TaskController) Readiness(ctx context.Context) map[string]error {
+	ctl.health.mu.RLock()
+	storageErr := ctl.health.storageErr
+	if !ctl.health.checked {
+		storageErr = ErrNotChecked
+	}
+	ctl.health.mu.RUnlock()
+
+	return map[string]error{
+		"storage":  storageErr,
+		"postgres": ctl.pgPool.Ping(ctx),
+	}
+}
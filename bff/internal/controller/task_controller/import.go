@@ -0,0 +1,132 @@
+package taskcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// importUserAgent is recorded as the user agent of imported tasks, so source reports show the history apart.
+const importUserAgent = "import-submissions"
+
+// ImportDecision records a historical decision as a done task and registers the original it refers to,
+// so reports and duplicate lookups include it. The verdict is stored as a result of both modalities
+// with probability 1, which reads back as the same verdict. It returns false when a task for the
+// video already exists, so a file can be imported again.
+func (ctl *TaskController) ImportDecision(ctx context.Context, d model.Decision) (bool, error) {
+	// Skip videos that already have a task.
+	exists, err := ctl.pgConn.HasTaskForVideoName(ctx, pgtype.Text{String: d.UUID, Valid: true})
+	if err != nil {
+		return false, fmt.Errorf("check existing task failed: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	// Create the task, its verdict and the original in one transaction.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	taskID, err := q.ReserveTaskID(ctx)
+	if err != nil {
+		return false, fmt.Errorf("reserve task id failed: %w", err)
+	}
+
+	task, err := q.CreateTask(ctx, pgsql.CreateTaskParams{
+		TaskID:    taskID,
+		Status:    pgsql.NullTaskStatus{TaskStatus: pgsql.TaskStatusDone, Valid: true},
+		VideoName: pgtype.Text{String: d.UUID, Valid: true},
+		UserAgent: pgtype.Text{String: importUserAgent, Valid: true},
+		Stage:     pgsql.TaskStageDone,
+		Priority:  pgsql.TaskPriorityBatch,
+	})
+	if err != nil {
+		return false, fmt.Errorf("create task failed: %w", err)
+	}
+
+	// Store the verdict; an original has no candidates.
+	verdict := model.KafkaResponse{TaskID: taskID, Copy: []model.Copyright{}}
+	if d.DuplicateFor != "" {
+		verdict.Copy = append(verdict.Copy, model.Copyright{Name: d.DuplicateFor, Probability: 1})
+	}
+	copyright, err := json.Marshal(verdict)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal copyright to json: %w", err)
+	}
+
+	// The task is new to this transaction, so each update finds the version the previous one left.
+	if _, err := q.UpdateTaskVideoCopyright(ctx, pgsql.UpdateTaskVideoCopyrightParams{
+		TaskID:         taskID,
+		VideoCopyright: copyright,
+		Version:        task.Version,
+	}); err != nil {
+		return false, fmt.Errorf("failed to update task copyright: %w", err)
+	}
+	if _, err := q.UpdateTaskAudioCopyright(ctx, pgsql.UpdateTaskAudioCopyrightParams{
+		TaskID:         taskID,
+		AudioCopyright: copyright,
+		Version:        task.Version + 1,
+	}); err != nil {
+		return false, fmt.Errorf("failed to update task copyright: %w", err)
+	}
+
+	// Register the original: the video itself, or the one it duplicates.
+	original := d.UUID
+	if d.DuplicateFor != "" {
+		original = d.DuplicateFor
+	}
+	if err := registerImportedOriginal(ctx, q, original); err != nil {
+		return false, err
+	}
+
+	if err := ctl.audit(ctx, q, model.AuditTaskImported, taskID, map[string]any{
+		"created":       d.Created.Format(time.RFC3339),
+		"link":          d.Link,
+		"duplicate_for": d.DuplicateFor,
+		"file":          d.File,
+	}); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// registerImportedOriginal records an original video unless it is known already. Its hashes are unknown,
+// as the media of imported decisions is not fetched, so like other originals without a sample hash it
+// matches every sample in sample dedup mode.
+func registerImportedOriginal(ctx context.Context, q *pgsql.Queries, videoID string) error {
+	id := pgtype.Text{String: videoID, Valid: true}
+
+	_, err := q.GetOrigVideo(ctx, id)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("get original video failed: %w", err)
+	}
+
+	if _, err := q.CreateOrigVideo(ctx, pgsql.CreateOrigVideoParams{VideoID: id}); err != nil {
+		return fmt.Errorf("create original video failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// activeIndexVersion returns the reference index version new tasks are checked against.
+// The configured version is used until an admin activates one explicitly.
+func (ctl *TaskController) activeIndexVersion(ctx context.Context) (string, error) {
+	version, err := ctl.pgConn.GetActiveIndexVersion(ctx)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ctl.cfg.IndexVersion, nil
+		}
+		return "", fmt.Errorf("get active index version failed: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetIndexVersions lists all known reference index versions.
+func (ctl *TaskController) GetIndexVersions(ctx context.Context) ([]model.IndexVersion, error) {
+	// Retrieve the index versions from the database.
+	versions, err := ctl.pgConn.ListIndexVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list index versions failed: %w", err)
+	}
+
+	// Convert the rows to the application model.
+	m := make([]model.IndexVersion, len(versions))
+	for i := range versions {
+		m[i] = indexVersionToModel(versions[i])
+	}
+
+	return m, nil
+}
+
+// SetActiveIndexVersion switches new tasks to the given reference index version.
+func (ctl *TaskController) SetActiveIndexVersion(ctx context.Context, version string) (model.IndexVersion, error) {
+	// Start a transaction so exactly one version is active at any time.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return model.IndexVersion{}, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Remember the version being replaced for the audit log.
+	previous, err := q.GetActiveIndexVersion(ctx)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return model.IndexVersion{}, fmt.Errorf("get active index version failed: %w", err)
+	}
+
+	// Deactivate the current version and activate the requested one.
+	if err := q.DeactivateIndexVersions(ctx); err != nil {
+		return model.IndexVersion{}, fmt.Errorf("deactivate index versions failed: %w", err)
+	}
+
+	v, err := q.ActivateIndexVersion(ctx, version)
+	if err != nil {
+		return model.IndexVersion{}, fmt.Errorf("activate index version failed: %w", err)
+	}
+
+	// Record the switch in the audit log.
+	if err := ctl.audit(ctx, q, model.AuditIndexVersionActivated, 0, map[string]any{
+		"index_version": version,
+		"previous":      previous,
+	}); err != nil {
+		return model.IndexVersion{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return model.IndexVersion{}, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	ctl.logger(ctx).Info().Str("index_version", version).Msg("active index version switched")
+
+	return indexVersionToModel(v), nil
+}
+
+// CompareTask re-checks the media of an existing task against another index version with the given priority.
+// The new task references the original one so both verdicts can be compared.
+func (ctl *TaskController) CompareTask(ctx context.Context, taskID int64, version string, priority model.Priority) (int64, error) {
+	// Retrieve the original task.
+	orig, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrTaskNotFound
+		}
+		return 0, fmt.Errorf("get task failed: %w", err)
+	}
+
+	// Reserve the ID of the comparison task.
+	newID, err := ctl.pgConn.ReserveTaskID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reserve task id failed: %w", err)
+	}
+
+	// Create a comparison task for the same media files together with its requests to the ML services.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	task, err := q.CreateTask(ctx, pgsql.CreateTaskParams{
+		TaskID:       newID,
+		VideoFile:    orig.VideoFile,
+		AudioFile:    orig.AudioFile,
+		PreviewID:    orig.PreviewID,
+		Status:       pgsql.NullTaskStatus{TaskStatus: pgsql.TaskStatusInProgress, Valid: true},
+		VideoName:    orig.VideoName,
+		IndexVersion: pgtype.Text{String: version, Valid: true},
+		ParentTaskID: pgtype.Int8{Int64: orig.TaskID, Valid: true},
+		SourceIp:     orig.SourceIp,
+		UserAgent:    orig.UserAgent,
+		ApiKeyID:     orig.ApiKeyID,
+		DeadlineAt:   ctl.deadline(),
+		Stage:        pgsql.TaskStageQueued,
+		Priority:     priorityToPG(priority),
+		// The comparison task checks the same video.
+		DurationSeconds: orig.DurationSeconds,
+		Width:           orig.Width,
+		Height:          orig.Height,
+		FileSize:        orig.FileSize,
+		ContentHash:     orig.ContentHash,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create task failed: %w", err)
+	}
+
+	// The comparison task refers to the media of the original one, so it takes references of its own
+	// and purging either task keeps them for the other. Objects stored before references were counted
+	// stay uncounted.
+	for _, o := range ctl.sharedObjects(orig) {
+		if err := q.ShareObjectRef(ctx, pgsql.ShareObjectRefParams{
+			Bucket:    o.bucket,
+			ObjectKey: o.name,
+		}); err != nil {
+			return 0, fmt.Errorf("share object reference failed: %w", err)
+		}
+	}
+
+	if err := enqueueCopyrightCheck(ctx, q, task.TaskID, task.Priority); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Send the task to the ML services in the background.
+	ctl.wakeRelay()
+
+	// Record the comparison in the audit log.
+	ctl.recordAudit(ctx, model.AuditTaskCompared, task.TaskID, map[string]any{
+		"parent_task_id": orig.TaskID,
+		"index_version":  version,
+		"priority":       priority,
+	})
+
+	return task.TaskID, nil
+}
+
+// GetTaskComparisons returns a task followed by all comparison tasks created from it.
+func (ctl *TaskController) GetTaskComparisons(ctx context.Context, taskID int64) ([]model.Task, error) {
+	// Retrieve the original task.
+	orig, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("get task failed: %w", err)
+	}
+
+	// Retrieve the comparison tasks.
+	children, err := ctl.pgConn.GetTasksByParent(ctx, pgtype.Int8{Int64: taskID, Valid: true})
+	if err != nil {
+		return nil, fmt.Errorf("get comparison tasks failed: %w", err)
+	}
+
+	return taskSliceToModel(append([]pgsql.Task{orig}, children...))
+}
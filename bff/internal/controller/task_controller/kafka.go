@@ -0,0 +1,91 @@
+package taskcontroller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanisms supported for Kafka.
+const (
+	saslPlain       = "plain"
+	saslSCRAMSHA256 = "scram-sha-256"
+	saslSCRAMSHA512 = "scram-sha-512"
+)
+
+// kafkaDialer creates the dialer used by the readers and the admin connection, and the transport
+// used by the producer, both authenticated and encrypted as configured.
+func kafkaDialer(cfg config.KafkaConfig) (*kafka.Dialer, *kafka.Transport, error) {
+	// Build the SASL mechanism.
+	mechanism, err := kafkaSASL(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Build the TLS configuration.
+	tlsConfig, err := kafkaTLS(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsConfig,
+	}
+	transport := &kafka.Transport{
+		SASL: mechanism,
+		TLS:  tlsConfig,
+	}
+
+	return dialer, transport, nil
+}
+
+// kafkaSASL returns the configured SASL mechanism, nil when SASL is disabled.
+func kafkaSASL(cfg config.KafkaConfig) (sasl.Mechanism, error) {
+	switch strings.ToLower(cfg.SASLMechanism) {
+	case "":
+		return nil, nil
+	case saslPlain:
+		return plain.Mechanism{Username: cfg.SASLUser, Password: cfg.SASLPassword}, nil
+	case saslSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.SASLUser, cfg.SASLPassword)
+	case saslSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.SASLUser, cfg.SASLPassword)
+	default:
+		return nil, fmt.Errorf("unknown kafka sasl mechanism %q, want plain, scram-sha-256 or scram-sha-512", cfg.SASLMechanism)
+	}
+}
+
+// kafkaTLS returns the TLS configuration, nil when TLS is disabled. A CA file replaces the system roots.
+func kafkaTLS(cfg config.KafkaConfig) (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in kafka ca file %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
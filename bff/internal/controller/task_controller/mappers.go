@@ -2,8 +2,11 @@ package taskcontroller
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5/pgtype"
 
 	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
 )
@@ -22,6 +25,18 @@ func statusToModel(s pgsql.TaskStatus) model.TaskStatus {
 	}
 }
 
+// statusFromModel converts a model task status to a PostgreSQL task status.
+func statusFromModel(s model.TaskStatus) pgsql.TaskStatus {
+	switch s {
+	case model.TaskStatusDone:
+		return pgsql.TaskStatusDone
+	case model.TaskStatusInProgress:
+		return pgsql.TaskStatusInProgress
+	default:
+		return pgsql.TaskStatusFail
+	}
+}
+
 // taskSliceToModel converts a slice of PostgreSQL tasks to a slice of model tasks.
 func taskSliceToModel(t []pgsql.Task) ([]model.Task, error) {
 	// Create a slice of model tasks with the same length as the input slice.
@@ -56,11 +71,155 @@ func taskToModel(t pgsql.Task) (model.Task, error) {
 		vid = model.KafkaResponse{} // Initialize to an empty struct if unmarshaling fails.
 	}
 
+	// Collect the modalities an in-progress task has not received results for yet.
+	status := statusToModel(t.Status.TaskStatus)
+	var pending []model.Modality
+	if status == model.TaskStatusInProgress {
+		if t.AudioCopyright == nil {
+			pending = append(pending, model.ModalityAudio)
+		}
+		if t.VideoCopyright == nil {
+			pending = append(pending, model.ModalityVideo)
+		}
+	}
+
 	// Return the converted model task.
 	return model.Task{
 		TaskID:         t.TaskID,
-		Status:         statusToModel(t.Status.TaskStatus),
-		VideoCopyright: vid.Copy,
-		AudioCopyright: aud.Copy,
+		Status:         status,
+		Stage:          string(t.Stage),
+		VideoCopyright: model.GroupCandidates(vid.Copy),
+		AudioCopyright: model.GroupCandidates(aud.Copy),
+		IndexVersion:   t.IndexVersion.String,
+		ParentTaskID:   t.ParentTaskID.Int64,
+		Source: model.Source{
+			IP:        t.SourceIp.String,
+			UserAgent: t.UserAgent.String,
+			APIKeyID:  t.ApiKeyID.String,
+			Priority:  model.Priority(t.Priority),
+		},
+		DownloadVerification: t.DownloadVerification.String,
+		TraceID:              t.TraceID.String,
+		Pending:              pending,
+		FailureReason:        t.FailureReason.String,
+		CreatedAt:            t.CreatedAt.Time,
+		UpdatedAt:            t.UpdatedAt.Time,
+		Deadline:             t.DeadlineAt.Time,
+		DeletedAt:            t.DeletedAt.Time,
+		Video: model.VideoProperties{
+			Duration:    time.Duration(t.DurationSeconds.Float64 * float64(time.Second)),
+			Width:       int(t.Width.Int32),
+			Height:      int(t.Height.Int32),
+			Size:        t.FileSize.Int64,
+			ContentHash: t.ContentHash.String,
+		},
 	}, nil
 }
+
+// registrationToModel converts a PostgreSQL reference registration row to a model registration.
+func registrationToModel(r pgsql.ReferenceRegistration) model.Registration {
+	return model.Registration{
+		Modality:  model.Modality(r.Modality),
+		Status:    r.Status,
+		Attempts:  r.Attempts,
+		Error:     r.LastError.String,
+		UpdatedAt: r.UpdatedAt.Time,
+	}
+}
+
+// priorityToPG converts a model priority to its PostgreSQL enum; empty is interactive.
+func priorityToPG(p model.Priority) pgsql.TaskPriority {
+	if p == model.PriorityBatch {
+		return pgsql.TaskPriorityBatch
+	}
+
+	return pgsql.TaskPriorityInteractive
+}
+
+// optionalText converts an empty string to SQL NULL.
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// optionalTimestamp converts the zero time to SQL NULL.
+func optionalTimestamp(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: !t.IsZero()}
+}
+
+// optionalInt4 converts zero to SQL NULL.
+func optionalInt4(n int) pgtype.Int4 {
+	return pgtype.Int4{Int32: int32(n), Valid: n != 0}
+}
+
+// optionalInt8 converts zero to SQL NULL.
+func optionalInt8(n int64) pgtype.Int8 {
+	return pgtype.Int8{Int64: n, Valid: n != 0}
+}
+
+// optionalSeconds converts a duration to seconds, and zero to SQL NULL.
+func optionalSeconds(d time.Duration) pgtype.Float8 {
+	return pgtype.Float8{Float64: d.Seconds(), Valid: d != 0}
+}
+
+// origVideoToModel converts a PostgreSQL original video row to a model original video.
+func origVideoToModel(v pgsql.Origvideo) model.OrigVideo {
+	return model.OrigVideo{
+		ID:           v.ID,
+		Name:         v.VideoID.String,
+		Hash:         v.VideoHash.String,
+		RegisteredAt: v.RegisteredAt.Time,
+	}
+}
+
+// indexVersionToModel converts a PostgreSQL reference index row to a model index version.
+func indexVersionToModel(v pgsql.ReferenceIndex) model.IndexVersion {
+	return model.IndexVersion{
+		Version:   v.Version,
+		Active:    v.Active,
+		CreatedAt: v.CreatedAt.Time,
+	}
+}
+
+// auditEventToModel converts a PostgreSQL audit log row to a model audit event.
+func auditEventToModel(e pgsql.AuditLog) model.AuditEvent {
+	return model.AuditEvent{
+		ID:         e.ID,
+		OccurredAt: e.OccurredAt.Time,
+		Actor:      e.Actor,
+		Action:     e.Action,
+		TaskID:     e.TaskID.Int64,
+		Details:    e.Details,
+	}
+}
+
+func verdictEventToModel(e pgsql.ClaimVerdictEventsRow, seq int64) model.VerdictEvent {
+	return model.VerdictEvent{
+		Schema:     model.VerdictSchema,
+		Seq:        seq,
+		AuditID:    e.AuditID,
+		Kind:       model.VerdictKind(e.Action),
+		Action:     e.Action,
+		TaskID:     e.TaskID.Int64,
+		OccurredAt: e.OccurredAt.Time,
+		Actor:      e.Actor,
+		Details:    e.Details,
+	}
+}
+
+func batchToModel(b pgsql.Batch) (model.BatchSummary, error) {
+	s := model.BatchSummary{
+		ID:         b.BatchID,
+		StartedAt:  b.StartedAt.Time,
+		FinishedAt: b.FinishedAt.Time,
+		Total:      b.TotalRows,
+		Rows:       b.RowsProcessed,
+		Duplicates: b.Duplicates,
+		Failures:   b.Failures,
+		AvgLatency: time.Duration(b.AvgLatencyMs * float64(time.Millisecond)),
+	}
+	if err := json.Unmarshal(b.ScoreHistogram, &s.Scores); err != nil {
+		return model.BatchSummary{}, fmt.Errorf("failed to unmarshal score histogram: %w", err)
+	}
+
+	return s, nil
+}
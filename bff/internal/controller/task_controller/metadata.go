@@ -0,0 +1,88 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ErrMetadataNotFound is returned for a task created before its video was probed at ingest.
+var ErrMetadataNotFound = errors.New("metadata not found")
+
+// probeVideo reads the properties of a local video.
+func (ctl *TaskController) probeVideo(ctx context.Context, filename string) (model.VideoMetadata, error) {
+	md, err := ctl.ffmpegFor(ctx).Probe(filename)
+	if err != nil {
+		return model.VideoMetadata{}, fmt.Errorf("failed to probe video: %w", err)
+	}
+
+	return model.VideoMetadata{
+		Format:     md.Format,
+		VideoCodec: md.VideoCodec,
+		AudioCodec: md.AudioCodec,
+		Width:      md.Width,
+		Height:     md.Height,
+		FrameRate:  md.FrameRate,
+		BitRate:    md.BitRate,
+		Duration:   md.Duration,
+	}, nil
+}
+
+// recordMetadata stores the properties of the video of a task; a failure is only logged, as the task
+// is checked without them. Nothing is stored when the probe failed.
+func (ctl *TaskController) recordMetadata(ctx context.Context, taskID int64, md model.VideoMetadata) {
+	if md == (model.VideoMetadata{}) {
+		return
+	}
+
+	if err := ctl.pgConn.InsertTaskMetadata(ctx, pgsql.InsertTaskMetadataParams{
+		TaskID:          taskID,
+		Format:          md.Format,
+		VideoCodec:      md.VideoCodec,
+		AudioCodec:      md.AudioCodec,
+		Width:           int32(md.Width),
+		Height:          int32(md.Height),
+		FrameRate:       md.FrameRate,
+		BitRate:         md.BitRate,
+		DurationSeconds: md.Duration.Seconds(),
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to record task metadata")
+	}
+}
+
+// GetTaskMetadata returns the properties of the video of a task probed at ingest.
+func (ctl *TaskController) GetTaskMetadata(ctx context.Context, taskID int64) (model.VideoMetadata, error) {
+	md, err := ctl.pgConn.GetTaskMetadata(ctx, taskID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return model.VideoMetadata{}, fmt.Errorf("get task metadata failed: %w", err)
+		}
+
+		// Without metadata, check whether the task exists at all.
+		if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return model.VideoMetadata{}, ErrTaskNotFound
+			}
+			return model.VideoMetadata{}, fmt.Errorf("get task failed: %w", err)
+		}
+
+		return model.VideoMetadata{}, ErrMetadataNotFound
+	}
+
+	return model.VideoMetadata{
+		Format:     md.Format,
+		VideoCodec: md.VideoCodec,
+		AudioCodec: md.AudioCodec,
+		Width:      int(md.Width),
+		Height:     int(md.Height),
+		FrameRate:  md.FrameRate,
+		BitRate:    md.BitRate,
+		Duration:   time.Duration(md.DurationSeconds * float64(time.Second)),
+	}, nil
+}
@@ -0,0 +1,188 @@
+package taskcontroller
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+	"github.com/segmentio/kafka-go"
+)
+
+// notificationSource is the user agent recorded for tasks created from bucket notifications.
+const notificationSource = "bucket-notification"
+
+// objectEvent is the part of a MinIO bucket notification the watcher uses; it follows the S3 event format.
+type objectEvent struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				// Key is URL encoded.
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// watchBucket consumes the notifications of the watched bucket and creates a task for every new object.
+func (ctl *TaskController) watchBucket(ctx context.Context) {
+	if ctl.cfg.Minio.WatchBucket == "" {
+		return
+	}
+
+	// Consume the object events topic.
+	ctl.superviseReader(ctx, ctl.readerConfig(ctl.cfg.Kafka.ObjectEventsTopic, "bff-object-events-reader"), ctl.readObjectEvents)
+}
+
+// readObjectEvents processes the notifications read by r until ctx is done or reading fails.
+// A failed notification is only logged and a malformed one is dead-lettered; either is committed then,
+// while one whose dead-lettering is interrupted by ctx is left uncommitted and delivered again.
+func (ctl *TaskController) readObjectEvents(ctx context.Context, r *kafka.Reader) error {
+	for {
+		// Read a notification from the object events topic without committing it.
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read object event failed: %w", err)
+		}
+
+		if err := ctl.processObjectEvent(ctx, msg); err != nil {
+			if !errors.Is(err, ErrMalformedMessage) {
+				ctl.log.Error().Err(err).Int64("offset", msg.Offset).Msg("process object event failed")
+			} else if ctl.deadLetter(ctx, msg, err, 1) != nil {
+				return nil
+			}
+		}
+
+		if err := r.CommitMessages(ctx, msg); err != nil {
+			ctl.log.Error().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("commit message failed")
+		}
+	}
+}
+
+// processObjectEvent creates tasks for the objects a notification announces. The message is recorded
+// in the processed-message ledger first, so a redelivered notification does not create the tasks again.
+func (ctl *TaskController) processObjectEvent(ctx context.Context, msg kafka.Message) error {
+	// Decode the notification.
+	var ev objectEvent
+	if err := json.Unmarshal(msg.Value, &ev); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	// Skip notifications that were already processed.
+	n, err := ctl.pgConn.MarkMessageProcessed(ctx, pgsql.MarkMessageProcessedParams{
+		Topic:        msg.Topic,
+		MsgPartition: int32(msg.Partition),
+		MsgOffset:    msg.Offset,
+	})
+	if err != nil {
+		return fmt.Errorf("mark message processed failed: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	for _, r := range ev.Records {
+		// Only new objects of the watched bucket get a task; MinIO may publish events of other buckets to the same topic.
+		if !strings.HasPrefix(r.EventName, "s3:ObjectCreated:") || r.S3.Bucket.Name != ctl.cfg.Minio.WatchBucket {
+			continue
+		}
+
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			ctl.log.Error().Err(err).Str("key", r.S3.Object.Key).Msg("invalid object key in event")
+			continue
+		}
+
+		id, err := ctl.CreateTaskFromBucketObject(ctx, r.S3.Bucket.Name, key)
+		if err != nil {
+			ctl.log.Error().Err(err).Str("bucket", r.S3.Bucket.Name).Str("key", key).Msg("create task for object failed")
+			continue
+		}
+		ctl.log.Info().Int64("task_id", id).Str("bucket", r.S3.Bucket.Name).Str("key", key).Msg("task created for object")
+	}
+
+	return nil
+}
+
+// CreateTaskFromBucketObject creates a task for an object stored in any bucket. The video is copied
+// into the video bucket under the task, so the source object may be removed afterwards.
+func (ctl *TaskController) CreateTaskFromBucketObject(ctx context.Context, bucket, key string) (_ int64, err error) {
+	// Reserve the task ID so its artifacts can be stored under it.
+	taskID, err := ctl.pgConn.ReserveTaskID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve task id: %w", err)
+	}
+
+	// Record the task, so its progress is visible while the object is copied and prepared.
+	src := model.Source{UserAgent: notificationSource, Priority: model.PriorityBatch}
+	if err := ctl.startTask(ctx, taskID, path.Base(key), src); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.abandonTask(ctx, taskID, err)
+		}
+	}()
+
+	// Count the CPU time of the media processing towards the task.
+	ctx, recordCPU := ctl.meterTask(ctx, taskID)
+	defer recordCPU()
+
+	// Create a temporary file in the workspace to copy the object to.
+	tmpFile, err := ctl.tempFS.CreateTemp("object", "*.mp4")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	// Ensure the temporary file is closed and removed after processing.
+	defer func() {
+		_ = tmpFile.Close()
+		if errDef := ctl.tempFS.Remove(tmpFile.Name()); errDef != nil {
+			ctl.logger(ctx).Error().Err(errDef).Str("path", tmpFile.Name()).Msg("failed to remove tmp file")
+		}
+	}()
+
+	// Open the object.
+	obj, size, err := ctl.storage.GetFileReaderAt(ctx, key, bucket)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer obj.Close()
+
+	// Copy the object to the temporary file, hashing it on the way.
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, h), io.NewSectionReader(obj, 0, size)); err != nil {
+		return 0, fmt.Errorf("failed to copy object: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	// Upload the video and extract the audio and the previews.
+	videoFile, derived, err := ctl.uploadVideo(ctx, taskID, tmpFile, hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload video: %w", err)
+	}
+
+	return ctl.createTaskForVideo(ctx, taskInput{
+		derivedMedia: derived,
+		TaskID:       taskID,
+		VideoFile:    videoFile,
+		Filename:     path.Base(key),
+		Hash:         hash,
+		Source:       src,
+	})
+}
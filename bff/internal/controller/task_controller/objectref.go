@@ -0,0 +1,148 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// storeObject takes a reference to a content-addressed object and stores it with store unless identical
+// content is stored already. It reports whether store ran; a caller storing from a staging object
+// removes that object otherwise. On failure the reference is released.
+//
+// The reference is taken before the object is looked for: a reference released concurrently keeps its row
+// locked until the object is deleted, so this either waits for the deletion and stores the object again or
+// finds the object referenced and kept.
+func (ctl *TaskController) storeObject(ctx context.Context, objectName, bucketName string, store func() error) (bool, error) {
+	if err := ctl.pgConn.AcquireObjectRef(ctx, pgsql.AcquireObjectRefParams{
+		Bucket:    bucketName,
+		ObjectKey: objectName,
+	}); err != nil {
+		return false, fmt.Errorf("acquire object reference failed: %w", err)
+	}
+
+	exist, err := ctl.storage.IsFileExist(ctx, objectName, bucketName)
+	if err != nil {
+		ctl.releaseObject(ctx, objectName, bucketName)
+		return false, fmt.Errorf("failed to check object: %w", err)
+	}
+	if exist {
+		return false, nil
+	}
+
+	if err := store(); err != nil {
+		ctl.releaseObject(ctx, objectName, bucketName)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// releaseObject drops a reference to a content-addressed object and deletes the object once no task
+// refers to it; a failure is only logged. Objects stored before references were counted are never deleted.
+func (ctl *TaskController) releaseObject(ctx context.Context, objectName, bucketName string) {
+	if err := ctl.releaseObjectRef(context.WithoutCancel(ctx), objectName, bucketName); err != nil {
+		ctl.logger(ctx).Error().Err(err).Str("object", objectName).Str("bucket", bucketName).Msg("failed to release object")
+	}
+}
+
+func (ctl *TaskController) releaseObjectRef(ctx context.Context, objectName, bucketName string) error {
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err := ctl.dropObjectRef(ctx, ctl.pgConn.WithTx(tx), objectName, bucketName); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+// dropObjectRef drops a reference to an object through q, which must be in a transaction, and deletes
+// the object with its last reference before the transaction ends.
+func (ctl *TaskController) dropObjectRef(ctx context.Context, q *pgsql.Queries, objectName, bucketName string) error {
+	// Drop the reference; the row stays locked until the transaction ends.
+	refs, err := q.ReleaseObjectRef(ctx, pgsql.ReleaseObjectRefParams{
+		Bucket:    bucketName,
+		ObjectKey: objectName,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("release object reference failed: %w", err)
+	}
+
+	// Delete the last reference together with the object.
+	if refs == 0 {
+		if err := q.DeleteObjectRef(ctx, pgsql.DeleteObjectRefParams{
+			Bucket:    bucketName,
+			ObjectKey: objectName,
+		}); err != nil {
+			return fmt.Errorf("delete object reference failed: %w", err)
+		}
+		if err := ctl.storage.RemoveFile(ctx, objectName, bucketName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taskObject is a content-addressed object a task refers to.
+type taskObject struct {
+	name   string
+	bucket string
+}
+
+// sharedObjects returns the media of a task a comparison task shares: its video, audio and preview.
+func (ctl *TaskController) sharedObjects(task pgsql.Task) []taskObject {
+	var objects []taskObject
+	for _, o := range []taskObject{
+		{name: task.VideoFile.String, bucket: ctl.storage.GetVideoBucketName()},
+		{name: task.AudioFile.String, bucket: ctl.storage.GetAudioBucketName()},
+		{name: task.PreviewID.String, bucket: ctl.storage.GetPreviewBucketName()},
+	} {
+		if o.name != "" {
+			objects = append(objects, o)
+		}
+	}
+
+	return objects
+}
+
+// taskObjects returns every object a task holds a reference to: its shared media, its sprite sheet and
+// the keyframes of its scenes, each once per reference.
+func (ctl *TaskController) taskObjects(ctx context.Context, q *pgsql.Queries, task pgsql.Task) ([]taskObject, error) {
+	objects := ctl.sharedObjects(task)
+
+	sprite, err := q.GetTaskSprite(ctx, task.TaskID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get task sprite failed: %w", err)
+	}
+	if err == nil {
+		objects = append(objects, taskObject{name: sprite.ObjectKey, bucket: ctl.storage.GetPreviewBucketName()})
+	}
+
+	scenes, err := q.GetTaskScenes(ctx, task.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task scenes failed: %w", err)
+	}
+	for _, s := range scenes {
+		objects = append(objects, taskObject{name: s.KeyframeKey, bucket: ctl.storage.GetPreviewBucketName()})
+	}
+
+	return objects, nil
+}
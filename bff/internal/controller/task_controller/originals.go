@@ -0,0 +1,253 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/multihash"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/tracing"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+var (
+	// ErrOriginalExists is returned when a video with the same name or content is registered as an original already.
+	ErrOriginalExists = errors.New("original already registered")
+	// ErrOriginalNotFound is returned when no original video has the given ID.
+	ErrOriginalNotFound = errors.New("original not found")
+)
+
+// SearchOrigVideos retrieves a page of the original videos matching the filter in the order they were
+// registered, using keyset pagination.
+func (ctl *TaskController) SearchOrigVideos(ctx context.Context, filter model.OrigVideoFilter, limit uint64, cursor string) ([]model.OrigVideo, string, error) {
+	// Decode the cursor into the last video ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Retrieve one video more than requested to find out whether there is a next page.
+	rows, err := ctl.pgConn.SearchOrigVideos(ctx, pgsql.SearchOrigVideosParams{
+		ID:               after,
+		Name:             optionalText(filter.Name),
+		VideoHash:        optionalText(filter.Hash),
+		RegisteredAfter:  optionalTimestamp(filter.RegisteredAfter),
+		RegisteredBefore: optionalTimestamp(filter.RegisteredBefore),
+		MaxRows:          int32(limit + 1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("search original videos failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more videos.
+	var next string
+	if uint64(len(rows)) > limit {
+		rows = rows[:limit]
+		next = encodeCursor(rows[len(rows)-1].ID)
+	}
+
+	videos := make([]model.OrigVideo, len(rows))
+	for i, r := range rows {
+		videos[i] = origVideoToModel(r)
+	}
+
+	return videos, next, nil
+}
+
+// RegisterOriginalFromObject registers an uploaded video as an original without checking it for duplicates:
+// its media are prepared as for a task, it is added to the reference catalog and pushed to the indexes of
+// the ML services. It returns the original and the ID of the task holding its media; a failed push is
+// recorded with the registrations of the task and does not fail the registration.
+func (ctl *TaskController) RegisterOriginalFromObject(ctx context.Context, objectKey, name string, src model.Source) (_ model.OrigVideo, _ int64, err error) {
+	// Only staging keys handed out by GetUploadURL are accepted.
+	key, err := objectkey.Parse(objectKey)
+	if err != nil || key.Kind != objectkey.KindUpload {
+		return model.OrigVideo{}, 0, ErrObjectNotFound
+	}
+
+	// Make sure the object was actually uploaded.
+	bucket := ctl.storage.GetVideoBucketName()
+	exist, err := ctl.storage.IsFileExist(ctx, objectKey, bucket)
+	if err != nil {
+		return model.OrigVideo{}, 0, fmt.Errorf("failed to check object: %w", err)
+	}
+	if !exist {
+		return model.OrigVideo{}, 0, ErrObjectNotFound
+	}
+
+	if name == "" {
+		name = objectKey
+	}
+
+	// Originals are identified by their name, which must not be taken yet.
+	if _, err := ctl.pgConn.GetOrigVideo(ctx, pgtype.Text{String: name, Valid: true}); err == nil {
+		return model.OrigVideo{}, 0, fmt.Errorf("%w: %s", ErrOriginalExists, name)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return model.OrigVideo{}, 0, fmt.Errorf("get original video failed: %w", err)
+	}
+
+	// Record the task, so its progress is visible while the video is prepared.
+	if err := ctl.startTask(ctx, key.TaskID, name, src); err != nil {
+		return model.OrigVideo{}, 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.abandonTask(ctx, key.TaskID, err)
+		}
+	}()
+
+	// Count the CPU time of the media processing towards the task.
+	ctx, recordCPU := ctl.meterTask(ctx, key.TaskID)
+	defer recordCPU()
+
+	// Calculate the hash for the uploaded video.
+	hash, full, err := ctl.hashStoredVideo(ctx, objectKey, bucket)
+	if err != nil {
+		return model.OrigVideo{}, 0, fmt.Errorf("failed to calculate hash for video: %w", err)
+	}
+
+	// Refuse an exact copy of a registered original; a sampled hash may be shared by different videos.
+	if full {
+		videos, err := ctl.pgConn.GetOrigVideosByHash(ctx, pgtype.Text{String: hash, Valid: true})
+		if err != nil {
+			return model.OrigVideo{}, 0, fmt.Errorf("failed to compare hash with original videos: %w", err)
+		}
+		if len(videos) != 0 {
+			ctl.removeObject(ctx, objectKey, bucket)
+			return model.OrigVideo{}, 0, fmt.Errorf("%w: %s", ErrOriginalExists, videos[0].VideoID.String)
+		}
+	}
+
+	// Move the upload to the key of its content.
+	videoFile, err := ctl.storeUpload(ctx, key, objectKey, hash, full)
+	if err != nil {
+		return model.OrigVideo{}, 0, err
+	}
+
+	// Generate an audio file and the previews from the uploaded video, remuxed to MP4 when needed.
+	videoFile, derived, err := ctl.generateAudio(ctx, key.TaskID, videoFile)
+	if err != nil {
+		return model.OrigVideo{}, 0, fmt.Errorf("failed to generate audio from video: %w", err)
+	}
+
+	if err := ctl.createOriginalTask(ctx, taskInput{
+		derivedMedia: derived,
+		TaskID:       key.TaskID,
+		VideoFile:    videoFile,
+		Filename:     name,
+		Source:       src,
+	}); err != nil {
+		return model.OrigVideo{}, 0, err
+	}
+
+	// Push the video to the indexes of the ML services; the outcomes are recorded with the task.
+	if err := ctl.UploadToDatabaseAudio(ctx, key.TaskID); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", key.TaskID).Msg("update database audio failed")
+	}
+	if err := ctl.UploadToDatabaseVideo(ctx, key.TaskID); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", key.TaskID).Msg("update database video failed")
+	}
+
+	// Add the video to the reference catalog.
+	if err := ctl.RegisterOriginal(ctx, key.TaskID); err != nil {
+		return model.OrigVideo{}, 0, err
+	}
+	orig, err := ctl.pgConn.GetOrigVideo(ctx, pgtype.Text{String: name, Valid: true})
+	if err != nil {
+		return model.OrigVideo{}, 0, fmt.Errorf("get original video failed: %w", err)
+	}
+
+	ctl.recordAudit(ctx, model.AuditOriginalRegistered, key.TaskID, map[string]any{
+		"original_id": orig.ID,
+		"video_name":  name,
+		"video_hash":  orig.VideoHash.String,
+	})
+
+	return origVideoToModel(orig), key.TaskID, nil
+}
+
+// createOriginalTask creates the reserved task holding the media of an original as done, without
+// copyright results, and stores the digests and the metadata of the video.
+func (ctl *TaskController) createOriginalTask(ctx context.Context, in taskInput) error {
+	// Register the original in the currently active index version.
+	indexVersion, err := ctl.activeIndexVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Record the task under the trace of the request, or start a trace for its processing.
+	traceID := tracing.TraceID(ctx)
+	if traceID == "" {
+		traceID = tracing.NewTraceID()
+	}
+
+	task, err := ctl.pgConn.CreateTask(ctx, pgsql.CreateTaskParams{
+		TaskID:          in.TaskID,
+		VideoFile:       pgtype.Text{String: in.VideoFile, Valid: true},
+		AudioFile:       pgtype.Text{String: in.AudioFile, Valid: true},
+		PreviewID:       optionalText(in.Preview.Image),
+		Status:          pgsql.NullTaskStatus{TaskStatus: pgsql.TaskStatusDone, Valid: true},
+		VideoName:       pgtype.Text{String: in.Filename, Valid: true},
+		IndexVersion:    pgtype.Text{String: indexVersion, Valid: true},
+		SourceIp:        optionalText(in.Source.IP),
+		UserAgent:       optionalText(in.Source.UserAgent),
+		ApiKeyID:        optionalText(in.Source.APIKeyID),
+		TraceID:         optionalText(traceID),
+		Stage:           pgsql.TaskStageDone,
+		Priority:        priorityToPG(in.Source.Priority),
+		DurationSeconds: optionalSeconds(in.Video.Duration),
+		Width:           optionalInt4(in.Video.Width),
+		Height:          optionalInt4(in.Video.Height),
+		FileSize:        optionalInt8(in.Video.Size),
+		ContentHash:     optionalText(in.Video.ContentHash),
+	})
+	if err != nil {
+		return fmt.Errorf("create task failed: %w", err)
+	}
+
+	// The task is done without the ML services once its media are prepared.
+	ctl.advanceStage(ctx, task.TaskID, model.StageAudioExtraction, "")
+
+	// Store the digests and the metadata of the video for lookups and link its sprite sheet.
+	ctl.recordHashes(ctx, task.TaskID, in.Hashes)
+	ctl.recordAudioFingerprint(ctx, task.TaskID, in.AudioFingerprint)
+	ctl.recordMetadata(ctx, task.TaskID, in.Metadata)
+	ctl.recordSprite(ctx, task.TaskID, in.Preview)
+
+	// Store the scenes of the video; a failure is only logged, as the task is done already.
+	if err := insertScenes(ctx, ctl.pgConn, task.TaskID, in.Scenes); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", task.TaskID).Msg("failed to record scenes")
+	}
+
+	details := taskAuditDetails(in, indexVersion)
+	details["video_hash"] = in.Hashes[multihash.MD5]
+	ctl.recordAudit(ctx, model.AuditTaskCreated, task.TaskID, details)
+
+	return nil
+}
+
+// DelistOrigVideo removes an original video from the reference catalog, so exact copies and copies
+// matched by their perceptual hash or audio fingerprint no longer match it. The ML services offer no
+// removal, so their indexes keep the video until they are rebuilt.
+func (ctl *TaskController) DelistOrigVideo(ctx context.Context, id int64) (model.OrigVideo, error) {
+	orig, err := ctl.pgConn.DeleteOrigVideo(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.OrigVideo{}, fmt.Errorf("%w: %d", ErrOriginalNotFound, id)
+		}
+		return model.OrigVideo{}, fmt.Errorf("delete original video failed: %w", err)
+	}
+
+	ctl.recordAudit(ctx, model.AuditOriginalDelisted, 0, map[string]any{
+		"original_id": orig.ID,
+		"video_name":  orig.VideoID.String,
+		"video_hash":  orig.VideoHash.String,
+	})
+
+	return origVideoToModel(orig), nil
+}
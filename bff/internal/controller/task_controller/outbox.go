@@ -0,0 +1,290 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// maxOutboxBackoff caps the doubling wait of a failed request of the outbox.
+const maxOutboxBackoff = 5 * time.Minute
+
+var (
+	outboxPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bff_outbox_published_total",
+		Help: "Requests to the ML services published from the outbox by modality and priority.",
+	}, []string{"modality", "priority"})
+	outboxFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bff_outbox_failed_total",
+		Help: "Failed publications of requests of the outbox by modality and outcome: retried or parked.",
+	}, []string{"modality", "outcome"})
+)
+
+// outboxRelay publishes the requests recorded in the outbox.
+type outboxRelay struct {
+	// wake shortens the wait for requests recorded by this process.
+	wake chan struct{}
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// enqueueCopyrightCheck records the requests of a task to the ML service of each modality through q,
+// so they are committed together with the task. The relay publishes them after the commit, those of
+// interactive tasks first.
+func enqueueCopyrightCheck(ctx context.Context, q *pgsql.Queries, taskID int64, priority pgsql.TaskPriority) error {
+	for _, modality := range []model.Modality{model.ModalityAudio, model.ModalityVideo} {
+		if err := enqueueModality(ctx, q, taskID, priority, modality); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueModality records the request of a task to the ML service of one modality through q,
+// and times the check of the modality from now.
+func enqueueModality(ctx context.Context, q *pgsql.Queries, taskID int64, priority pgsql.TaskPriority, modality model.Modality) error {
+	if err := q.EnqueueOutbox(ctx, pgsql.EnqueueOutboxParams{
+		TaskID:   taskID,
+		Modality: string(modality),
+		Priority: priority,
+	}); err != nil {
+		return fmt.Errorf("enqueue %s request failed: %w", modality, err)
+	}
+
+	return startStage(ctx, q, taskID, model.CheckStage(modality))
+}
+
+// wakeRelay makes the relay publish without waiting for the next poll.
+func (ctl *TaskController) wakeRelay() {
+	select {
+	case ctl.relay.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutboxRelay starts publishing the outbox and the verdict stream until Drain is called. Rows are claimed with
+// SKIP LOCKED, so the relays of several processes share the outbox without sending a request twice.
+// Every batch is claimed interactive requests first, so they overtake a backlog of batch requests.
+func (ctl *TaskController) StartOutboxRelay(ctx context.Context) {
+	ctx, ctl.relay.stop = context.WithCancel(ctx)
+
+	ctl.relay.wg.Add(1)
+	go func() {
+		defer ctl.relay.wg.Done()
+
+		ticker := time.NewTicker(ctl.cfg.Outbox.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			// Publish until the outbox is empty, then wait for new requests.
+			for {
+				n, err := ctl.relayOutbox(ctx)
+				if err != nil {
+					ctl.log.Error().Err(err).Msg("relay outbox failed")
+				}
+				if err != nil || n < ctl.cfg.Outbox.BatchSize {
+					break
+				}
+			}
+
+			// Publish the verdict stream the same way.
+			for {
+				n, err := ctl.relayVerdicts(ctx)
+				if err != nil {
+					ctl.log.Error().Err(err).Msg("relay verdict stream failed")
+				}
+				if err != nil || n < ctl.cfg.Outbox.BatchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ctl.relay.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// relayOutbox publishes a batch of unsent requests and marks them sent. The batch is finished
+// even when ctx is done, so a stopping relay does not leave published requests unmarked.
+// It returns the number of requests claimed.
+func (ctl *TaskController) relayOutbox(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, nil
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	// Claim a batch; the rows stay locked until the transaction ends.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	rows, err := q.ClaimOutbox(ctx, int32(ctl.cfg.Outbox.BatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("claim outbox failed: %w", err)
+	}
+
+	// Publish the requests in order; a failed one is recorded and skipped, so it does not hold up the rest.
+	var errs []error
+	for _, r := range rows {
+		if err := ctl.publishClaimed(ctx, tx, q, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	return len(rows), errors.Join(errs...)
+}
+
+// publishClaimed publishes a claimed request within a savepoint of tx, so the writes of a failed one are
+// rolled back alone and its failure is recorded through q.
+func (ctl *TaskController) publishClaimed(ctx context.Context, tx pgx.Tx, q *pgsql.Queries, r pgsql.Outbox) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin savepoint failed: %w", err)
+	}
+
+	publishErr := ctl.publishRequest(ctx, ctl.pgConn.WithTx(sp), r)
+	if publishErr == nil {
+		if err := sp.Commit(ctx); err != nil {
+			return fmt.Errorf("release savepoint failed: %w", err)
+		}
+		return nil
+	}
+	_ = sp.Rollback(ctx)
+
+	if err := ctl.recordOutboxFailure(ctx, q, r, publishErr); err != nil {
+		return errors.Join(publishErr, err)
+	}
+
+	return publishErr
+}
+
+// recordOutboxFailure records a failed publication of a request and holds the request back with exponential
+// backoff, or parks it once it is out of attempts. A parked request is never claimed again; its task fails
+// at its deadline.
+func (ctl *TaskController) recordOutboxFailure(ctx context.Context, q *pgsql.Queries, r pgsql.Outbox, cause error) error {
+	attempts := int(r.Attempts) + 1
+	park := attempts >= ctl.cfg.Outbox.MaxAttempts
+
+	backoff := ctl.cfg.Outbox.RetryBackoff
+	for i := 1; i < attempts && backoff < maxOutboxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxOutboxBackoff)
+
+	if err := q.RecordOutboxFailure(ctx, pgsql.RecordOutboxFailureParams{
+		LastError: pgtype.Text{String: cause.Error(), Valid: true},
+		RetryAt:   pgtype.Timestamptz{Time: time.Now().Add(backoff), Valid: true},
+		Park:      park,
+		ID:        r.ID,
+	}); err != nil {
+		return fmt.Errorf("record failure of outbox %d failed: %w", r.ID, err)
+	}
+
+	if park {
+		outboxFailed.WithLabelValues(r.Modality, "parked").Inc()
+		ctl.log.Error().Err(cause).Int64("outbox_id", r.ID).Int64("task_id", r.TaskID).Str("modality", r.Modality).
+			Int("attempts", attempts).Msg("request out of attempts, parked")
+		return nil
+	}
+
+	outboxFailed.WithLabelValues(r.Modality, "retried").Inc()
+
+	return nil
+}
+
+// publishRequest sends a request of the outbox to the ML service of its modality and marks it sent.
+func (ctl *TaskController) publishRequest(ctx context.Context, q *pgsql.Queries, r pgsql.Outbox) error {
+	task, err := q.GetTask(ctx, r.TaskID)
+	if err != nil {
+		return fmt.Errorf("get task %d failed: %w", r.TaskID, err)
+	}
+
+	// The link is presigned now rather than when the request was recorded, so a backlog does not expire it.
+	if err := ctl.sendModality(ctx, task, model.Modality(r.Modality)); err != nil {
+		return fmt.Errorf("send %s request of task %d failed: %w", r.Modality, r.TaskID, err)
+	}
+
+	// Record when the request was sent, so the reaper can tell overdue requests.
+	if err := q.RecordModalityRequest(ctx, pgsql.RecordModalityRequestParams{
+		TaskID:   r.TaskID,
+		Modality: r.Modality,
+	}); err != nil {
+		return fmt.Errorf("record %s request of task %d failed: %w", r.Modality, r.TaskID, err)
+	}
+
+	if err := q.MarkOutboxSent(ctx, r.ID); err != nil {
+		return fmt.Errorf("mark outbox %d sent failed: %w", r.ID, err)
+	}
+
+	outboxPublished.WithLabelValues(r.Modality, string(r.Priority)).Inc()
+
+	return nil
+}
+
+// pruneOutbox deletes the requests sent longer ago than the retention of the outbox.
+func (ctl *TaskController) pruneOutbox(ctx context.Context) error {
+	if ctl.cfg.Outbox.SentRetention <= 0 {
+		return nil
+	}
+
+	sentBefore := pgtype.Timestamptz{Time: time.Now().Add(-ctl.cfg.Outbox.SentRetention), Valid: true}
+	for {
+		n, err := ctl.pgConn.PruneSentOutbox(ctx, pgsql.PruneSentOutboxParams{
+			SentBefore: sentBefore,
+			MaxRows:    pruneBatchSize,
+		})
+		if err != nil {
+			return fmt.Errorf("prune outbox failed: %w", err)
+		}
+		if n < pruneBatchSize {
+			return nil
+		}
+	}
+}
+
+// Drain stops the outbox relay and waits until ctx is done for the batch it is publishing.
+// Requests left unsent stay in the outbox for the next start.
+func (ctl *TaskController) Drain(ctx context.Context) error {
+	if ctl.relay.stop == nil {
+		return nil
+	}
+	ctl.relay.stop()
+
+	done := make(chan struct{})
+	go func() {
+		ctl.relay.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("outbox relay did not stop: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,114 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ffmpeg"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ErrPlaybackNotFound is returned for a task without an HLS rendition, or a file the rendition lacks.
+var ErrPlaybackNotFound = errors.New("playback not found")
+
+// hlsSegment matches the names of the segments written by ffmpeg.TranscodeHLS.
+var hlsSegment = regexp.MustCompile(`^segment[0-9]+\.ts$`)
+
+// transcodeHLS transcodes a local video to HLS and stores the playlist and its segments under the task
+// in the video bucket. The playlist is stored last, so a stored playlist has all its segments.
+func (ctl *TaskController) transcodeHLS(ctx context.Context, taskID int64, filename string) error {
+	cfg := ctl.cfg.Playback
+
+	// Transcode the video and ensure the rendition is removed after processing, also a partial one.
+	dir, err := ctl.ffmpegFor(ctx).TranscodeHLS(filename, cfg.SegmentDuration, cfg.MaxHeight)
+	var entries []os.DirEntry
+	if dir != "" {
+		ctl.tempFS.Track("hls", dir)
+		defer ctl.tempFS.Remove(dir)
+
+		var errDir error
+		entries, errDir = os.ReadDir(dir)
+		if errDir != nil && err == nil {
+			err = fmt.Errorf("failed to read hls directory: %w", errDir)
+		}
+		for _, e := range entries {
+			name := filepath.Join(dir, e.Name())
+			ctl.tempFS.Track("hls", name)
+			defer ctl.tempFS.Remove(name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to transcode video: %w", err)
+	}
+
+	// Upload the segments, then the playlist listing them.
+	var names []string
+	for _, e := range entries {
+		if hlsSegment.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	names = append(names, ffmpeg.HLSPlaylist)
+
+	bucket := ctl.storage.GetVideoBucketName()
+	var size int64
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		stat, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to get file metainfo: %w", err)
+		}
+
+		objectName := objectkey.Key{TaskID: taskID, Kind: objectkey.KindHLS, Name: name}.String()
+		if err := ctl.storage.UploadFileFromOs(ctx, path, objectName, bucket); err != nil {
+			return fmt.Errorf("failed to upload hls file to minio: %w", err)
+		}
+		size += stat.Size()
+	}
+
+	// Count the stored rendition towards the task.
+	ctl.addResourceUsage(ctx, pgsql.AddTaskResourceUsageParams{
+		TaskID:      taskID,
+		BytesStored: size,
+	})
+
+	return nil
+}
+
+// GetTaskPlayback opens a file of the HLS rendition of a task, its playlist or a segment the playlist
+// lists, and returns its size. Tasks created while playback was disabled have no rendition.
+func (ctl *TaskController) GetTaskPlayback(ctx context.Context, taskID int64, name string) (objectstorage.ReadAtCloser, int64, error) {
+	// Only the files written by the transcode are served.
+	if name != ffmpeg.HLSPlaylist && !hlsSegment.MatchString(name) {
+		return nil, 0, ErrPlaybackNotFound
+	}
+
+	// Check that the task exists.
+	if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, 0, ErrTaskNotFound
+		}
+		return nil, 0, fmt.Errorf("get task failed: %w", err)
+	}
+
+	// Check that the file is stored.
+	objectName := objectkey.Key{TaskID: taskID, Kind: objectkey.KindHLS, Name: name}.String()
+	bucket := ctl.storage.GetVideoBucketName()
+	exist, err := ctl.storage.IsFileExist(ctx, objectName, bucket)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exist {
+		return nil, 0, ErrPlaybackNotFound
+	}
+
+	return ctl.storage.GetFileReaderAt(ctx, objectName, bucket)
+}
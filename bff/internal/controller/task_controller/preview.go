@@ -0,0 +1,248 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+var (
+	// ErrPreviewNotFound is returned for a task without a stored preview image.
+	ErrPreviewNotFound = errors.New("preview not found")
+	// ErrSpriteNotFound is returned for a task without a stored sprite sheet.
+	ErrSpriteNotFound = errors.New("sprite sheet not found")
+)
+
+// taskPreview holds the preview images of a video stored in the preview bucket.
+type taskPreview struct {
+	// Image is the key of the screenshot from the middle of the video.
+	Image string
+	// Sprite is the key of the sprite sheet laid out as SpriteSheet describes.
+	Sprite      string
+	SpriteSheet model.Sprite
+}
+
+// generatePreviews stores the preview images of a local video. A preview that cannot be generated is
+// left out and only logged, as the task is checked without it.
+func (ctl *TaskController) generatePreviews(ctx context.Context, taskID int64, filename string) taskPreview {
+	var (
+		preview taskPreview
+		err     error
+	)
+
+	preview.Image, err = ctl.generatePreview(ctx, filename)
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to generate preview")
+	}
+
+	preview.Sprite, preview.SpriteSheet, err = ctl.generateSprite(ctx, filename)
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to generate sprite sheet")
+	}
+
+	return preview
+}
+
+// generatePreview takes a screenshot from the middle of a local video and stores it in the preview bucket
+// under its content key, returning the key.
+func (ctl *TaskController) generatePreview(ctx context.Context, filename string) (string, error) {
+	// Take the screenshot and ensure it is removed after processing.
+	shot, err := ctl.ffmpegFor(ctx).GetScreenshotFromVideo(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to take screenshot: %w", err)
+	}
+	ctl.tempFS.Track("preview", shot)
+	defer ctl.tempFS.Remove(shot)
+
+	return ctl.storeImage(ctx, objectkey.KindPreview, shot, ".png")
+}
+
+// generateSprite tiles frames evenly spaced over a local video into a sprite sheet as configured and
+// stores it in the preview bucket under its content key, returning the key and the layout.
+// It returns an empty key when sprite sheets are disabled.
+func (ctl *TaskController) generateSprite(ctx context.Context, filename string) (string, model.Sprite, error) {
+	cfg := ctl.cfg.Preview
+	if cfg.SpriteColumns <= 0 || cfg.SpriteRows <= 0 {
+		return "", model.Sprite{}, nil
+	}
+
+	// Tile the frames and ensure the sprite sheet is removed after processing.
+	sheet, timestamps, err := ctl.ffmpegFor(ctx).GetSpriteSheetFromVideo(filename, cfg.SpriteColumns, cfg.SpriteRows, cfg.SpriteFrameWidth)
+	if err != nil {
+		return "", model.Sprite{}, fmt.Errorf("failed to tile frames: %w", err)
+	}
+	ctl.tempFS.Track("preview", sheet)
+	defer ctl.tempFS.Remove(sheet)
+
+	// Derive the height of the frames, scaled to keep the aspect of the video, from the sheet.
+	f, err := os.Open(sheet)
+	if err != nil {
+		return "", model.Sprite{}, fmt.Errorf("failed to open sprite sheet: %w", err)
+	}
+	defer f.Close()
+
+	img, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return "", model.Sprite{}, fmt.Errorf("failed to decode sprite sheet: %w", err)
+	}
+
+	objectName, err := ctl.storeImage(ctx, objectkey.KindPreview, sheet, ".jpg")
+	if err != nil {
+		return "", model.Sprite{}, err
+	}
+
+	return objectName, model.Sprite{
+		Columns:     cfg.SpriteColumns,
+		Rows:        cfg.SpriteRows,
+		FrameWidth:  img.Width / cfg.SpriteColumns,
+		FrameHeight: img.Height / cfg.SpriteRows,
+		Timestamps:  timestamps,
+	}, nil
+}
+
+// storeImage stores a local image of a kind in the preview bucket under its content key, unless an
+// identical image is stored already, and returns the key.
+func (ctl *TaskController) storeImage(ctx context.Context, kind objectkey.Kind, filename, ext string) (string, error) {
+	// Hash the image to build its content key.
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", kind, err)
+	}
+	defer f.Close()
+
+	hash, err := md5Hex(f)
+	if err != nil {
+		return "", err
+	}
+
+	// Upload the image, unless an identical one is stored already.
+	objectName := objectkey.Content(kind, hash, ext)
+	bucket := ctl.storage.GetPreviewBucketName()
+	if _, err := ctl.storeObject(ctx, objectName, bucket, func() error {
+		return ctl.storage.UploadFileFromOs(ctx, filename, objectName, bucket)
+	}); err != nil {
+		return "", fmt.Errorf("failed to upload %s to minio: %w", kind, err)
+	}
+
+	return objectName, nil
+}
+
+// recordSprite links the sprite sheet of a video to its task; a failure is only logged, as the task
+// is checked without it.
+func (ctl *TaskController) recordSprite(ctx context.Context, taskID int64, preview taskPreview) {
+	if preview.Sprite == "" {
+		return
+	}
+
+	sheet := preview.SpriteSheet
+	timestamps := make([]float64, len(sheet.Timestamps))
+	for i, t := range sheet.Timestamps {
+		timestamps[i] = t.Seconds()
+	}
+
+	if err := ctl.pgConn.InsertTaskSprite(ctx, pgsql.InsertTaskSpriteParams{
+		TaskID:      taskID,
+		ObjectKey:   preview.Sprite,
+		GridColumns: int32(sheet.Columns),
+		GridRows:    int32(sheet.Rows),
+		FrameWidth:  int32(sheet.FrameWidth),
+		FrameHeight: int32(sheet.FrameHeight),
+		Timestamps:  timestamps,
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to record sprite sheet")
+	}
+}
+
+// GetTaskPreview opens the preview image of a task and returns its size.
+// Tasks created before previews were generated, or whose preview expired, have none.
+func (ctl *TaskController) GetTaskPreview(ctx context.Context, taskID int64) (objectstorage.ReadAtCloser, int64, error) {
+	// Retrieve the task to find the key of its preview.
+	task, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, 0, ErrTaskNotFound
+		}
+		return nil, 0, fmt.Errorf("get task failed: %w", err)
+	}
+	if task.PreviewID.String == "" {
+		return nil, 0, ErrPreviewNotFound
+	}
+
+	return ctl.openPreview(ctx, task.PreviewID.String, ErrPreviewNotFound)
+}
+
+// GetTaskSprite returns the layout of the sprite sheet of a task.
+func (ctl *TaskController) GetTaskSprite(ctx context.Context, taskID int64) (model.Sprite, error) {
+	sprite, err := ctl.getTaskSprite(ctx, taskID)
+	if err != nil {
+		return model.Sprite{}, err
+	}
+
+	timestamps := make([]time.Duration, len(sprite.Timestamps))
+	for i, t := range sprite.Timestamps {
+		timestamps[i] = time.Duration(t * float64(time.Second))
+	}
+
+	return model.Sprite{
+		Columns:     int(sprite.GridColumns),
+		Rows:        int(sprite.GridRows),
+		FrameWidth:  int(sprite.FrameWidth),
+		FrameHeight: int(sprite.FrameHeight),
+		Timestamps:  timestamps,
+	}, nil
+}
+
+// GetTaskSpriteImage opens the sprite sheet of a task and returns its size.
+func (ctl *TaskController) GetTaskSpriteImage(ctx context.Context, taskID int64) (objectstorage.ReadAtCloser, int64, error) {
+	sprite, err := ctl.getTaskSprite(ctx, taskID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ctl.openPreview(ctx, sprite.ObjectKey, ErrSpriteNotFound)
+}
+
+// getTaskSprite retrieves the sprite sheet of a task, telling a missing task from a task without one.
+func (ctl *TaskController) getTaskSprite(ctx context.Context, taskID int64) (pgsql.TaskSprite, error) {
+	sprite, err := ctl.pgConn.GetTaskSprite(ctx, taskID)
+	if err == nil {
+		return sprite, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return pgsql.TaskSprite{}, fmt.Errorf("get task sprite failed: %w", err)
+	}
+
+	// Without a sprite sheet, check whether the task exists at all.
+	if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgsql.TaskSprite{}, ErrTaskNotFound
+		}
+		return pgsql.TaskSprite{}, fmt.Errorf("get task failed: %w", err)
+	}
+
+	return pgsql.TaskSprite{}, ErrSpriteNotFound
+}
+
+// openPreview opens an image in the preview bucket, returning notFound once it is no longer stored.
+func (ctl *TaskController) openPreview(ctx context.Context, objectName string, notFound error) (objectstorage.ReadAtCloser, int64, error) {
+	bucket := ctl.storage.GetPreviewBucketName()
+	exist, err := ctl.storage.IsFileExist(ctx, objectName, bucket)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exist {
+		return nil, 0, notFound
+	}
+
+	return ctl.storage.GetFileReaderAt(ctx, objectName, bucket)
+}
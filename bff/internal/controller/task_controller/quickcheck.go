@@ -0,0 +1,80 @@
+package taskcontroller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// QuickCheck answers whether the video of a link duplicates an original from local evidence only.
+// The link is served from the download cache and never downloaded, and no task is created, so the
+// ML services are not involved. Links missing from the cache get the low tier.
+func (ctl *TaskController) QuickCheck(ctx context.Context, link string) (model.QuickCheck, error) {
+	unknown := model.QuickCheck{Tier: model.QuickCheckLow, Evidence: "none", FullCheckRecommended: true}
+
+	// Create a temporary file in the workspace to copy the cached video to.
+	tmpFile, err := ctl.tempFS.CreateTemp("quick-check", "*.mp4")
+	if err != nil {
+		return model.QuickCheck{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	// Ensure the temporary file is closed and removed after checking.
+	defer func() {
+		_ = tmpFile.Close()
+		if errDef := ctl.tempFS.Remove(tmpFile.Name()); errDef != nil {
+			ctl.logger(ctx).Error().Err(errDef).Str("path", tmpFile.Name()).Msg("failed to remove tmp file")
+		}
+	}()
+
+	// Copy the current version of the link from the download cache.
+	res, ok := ctl.downloader.Cached(ctx, link, tmpFile)
+	if !ok {
+		return unknown, nil
+	}
+	unknown.Cached = true
+
+	// An exact copy of an original settles the verdict.
+	videos, err := ctl.pgConn.GetOrigVideosByHash(ctx, pgtype.Text{String: res.MD5, Valid: true})
+	if err != nil {
+		return model.QuickCheck{}, fmt.Errorf("failed to compare hash with original videos: %w", err)
+	}
+	if len(videos) != 0 {
+		return model.QuickCheck{
+			Tier:         model.QuickCheckHigh,
+			Evidence:     "exact_match",
+			DuplicateFor: videos[0].VideoID.String,
+			Probability:  1,
+			Cached:       true,
+		}, nil
+	}
+
+	// A perceptual match only prefilters; the ML services decide re-encoded copies.
+	// Unreadable videos are left to the full check, which rejects them.
+	digest, err := ctl.perceptualHash(ctx, tmpFile.Name())
+	if err != nil {
+		ctl.logger(ctx).Warn().Err(err).Str("link", link).Msg("quick check failed to compute perceptual hash")
+		return unknown, nil
+	}
+	if digest == "" {
+		return unknown, nil
+	}
+
+	match, matched, err := ctl.findPerceptualMatch(ctx, digest)
+	if err != nil {
+		return model.QuickCheck{}, fmt.Errorf("failed to compare perceptual hash with original videos: %w", err)
+	}
+	if !matched {
+		return unknown, nil
+	}
+
+	return model.QuickCheck{
+		Tier:                 model.QuickCheckMedium,
+		Evidence:             match.Kind,
+		DuplicateFor:         match.VideoID,
+		Probability:          match.Probability,
+		Cached:               true,
+		FullCheckRecommended: true,
+	}, nil
+}
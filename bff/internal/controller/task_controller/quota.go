@@ -0,0 +1,116 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ErrQuotaExceeded is returned when the account of a request has used up its quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota returns the configured per account limits.
+func (ctl *TaskController) Quota() model.Usage {
+	return model.Usage{
+		Tasks:        ctl.cfg.Quota.MaxTasks,
+		VideoSeconds: ctl.cfg.Quota.MaxVideoMinutes * 60,
+		Bytes:        ctl.cfg.Quota.MaxBytes,
+	}
+}
+
+// GetUsage returns what the account has consumed so far.
+func (ctl *TaskController) GetUsage(ctx context.Context, account string) (model.Usage, error) {
+	// Retrieve the usage counters; an account without tasks has no row yet.
+	u, err := ctl.pgConn.GetApiKeyUsage(ctx, account)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Usage{}, nil
+		}
+		return model.Usage{}, fmt.Errorf("get api key usage failed: %w", err)
+	}
+
+	// Return the converted usage.
+	return model.Usage{
+		Tasks:        u.Tasks,
+		VideoSeconds: u.VideoSeconds,
+		Bytes:        u.BytesStored,
+	}, nil
+}
+
+// reserveQuota counts a new task against the quota of the account and rejects it with ErrQuotaExceeded
+// once any limit is reached. The check and the count are one statement, so concurrent requests cannot
+// overrun a limit. The task that crosses the video or storage limit still completes, as its media are
+// counted after processing. Tasks without an account are not counted.
+func (ctl *TaskController) reserveQuota(ctx context.Context, account string) error {
+	if account == "" {
+		return nil
+	}
+
+	quota := ctl.Quota()
+	if _, err := ctl.pgConn.ReserveApiKeyTask(ctx, pgsql.ReserveApiKeyTaskParams{
+		ApiKeyID:        account,
+		MaxTasks:        quota.Tasks,
+		MaxVideoSeconds: quota.VideoSeconds,
+		MaxBytes:        quota.Bytes,
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ctl.quotaExceeded(ctx, account, quota)
+		}
+		return fmt.Errorf("reserve quota failed: %w", err)
+	}
+
+	return nil
+}
+
+// quotaExceeded returns the error naming the limit of quota the account reached.
+func (ctl *TaskController) quotaExceeded(ctx context.Context, account string, quota model.Usage) error {
+	// Retrieve the usage of the account.
+	usage, err := ctl.GetUsage(ctx, account)
+	if err != nil {
+		return errors.Join(ErrQuotaExceeded, err)
+	}
+
+	// Compare every limited counter with its limit.
+	switch {
+	case quota.Tasks > 0 && usage.Tasks >= quota.Tasks:
+		return fmt.Errorf("%w: %d of %d tasks", ErrQuotaExceeded, usage.Tasks, quota.Tasks)
+	case quota.VideoSeconds > 0 && usage.VideoSeconds >= quota.VideoSeconds:
+		return fmt.Errorf("%w: %.0f of %.0f video minutes", ErrQuotaExceeded, usage.VideoSeconds/60, quota.VideoSeconds/60)
+	case quota.Bytes > 0 && usage.Bytes >= quota.Bytes:
+		return fmt.Errorf("%w: %d of %d bytes stored", ErrQuotaExceeded, usage.Bytes, quota.Bytes)
+	}
+
+	return ErrQuotaExceeded
+}
+
+// releaseQuota returns the task reserved by reserveQuota when the task is not created.
+func (ctl *TaskController) releaseQuota(ctx context.Context, account string) {
+	if account == "" {
+		return
+	}
+
+	if err := ctl.pgConn.ReleaseApiKeyTask(context.WithoutCancel(ctx), account); err != nil {
+		ctl.logger(ctx).Error().Err(err).Str("account", account).Msg("failed to release quota")
+	}
+}
+
+// recordUsage adds the media of a created task to the usage of the account; the task itself was
+// counted by reserveQuota.
+func (ctl *TaskController) recordUsage(ctx context.Context, account string, media model.Usage) {
+	if account == "" {
+		return
+	}
+
+	if err := ctl.pgConn.AddApiKeyUsage(ctx, pgsql.AddApiKeyUsageParams{
+		ApiKeyID:     account,
+		VideoSeconds: media.VideoSeconds,
+		BytesStored:  media.Bytes,
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Str("account", account).Msg("failed to record usage")
+	}
+}
@@ -0,0 +1,289 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+const (
+	// reapBatchSize bounds the overdue requests handled per reaper run; the rest wait for the next run.
+	reapBatchSize = 100
+	// pruneBatchSize bounds the rows deleted per statement when pruning, so no statement runs long.
+	pruneBatchSize = 1000
+)
+
+var (
+	resultLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bff_modality_result_seconds",
+		Help:    "Time from the first request to the result of a modality.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"modality"})
+	slaBreaches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bff_modality_sla_breaches_total",
+		Help: "Results that arrived later than the SLA of their modality.",
+	}, []string{"modality"})
+	resultTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bff_modality_timeouts_total",
+		Help: "Requests without a result within the timeout of their modality by outcome: retried or failed.",
+	}, []string{"modality", "outcome"})
+)
+
+// limits returns the timeout, retry and SLA settings of a modality.
+func (ctl *TaskController) limits(modality model.Modality) config.ModalityLimits {
+	if modality == model.ModalityAudio {
+		return ctl.cfg.Modality.Audio()
+	}
+
+	return ctl.cfg.Modality.Video()
+}
+
+// observeResult records how long the result of a modality took and whether it missed the SLA.
+func (ctl *TaskController) observeResult(modality model.Modality, latency time.Duration) {
+	resultLatency.WithLabelValues(string(modality)).Observe(latency.Seconds())
+	if sla := ctl.limits(modality).SLA; sla > 0 && latency > sla {
+		slaBreaches.WithLabelValues(string(modality)).Inc()
+	}
+}
+
+// overdueModalities returns the pending modalities of a task whose results are later than their SLA,
+// or all of them once the task is past its deadline.
+func (ctl *TaskController) overdueModalities(ctx context.Context, task model.Task) ([]model.Modality, error) {
+	if len(task.Pending) == 0 {
+		return nil, nil
+	}
+	if !task.Deadline.IsZero() && time.Now().After(task.Deadline) {
+		return task.Pending, nil
+	}
+
+	requests, err := ctl.pgConn.GetModalityRequests(ctx, task.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("get modality requests failed: %w", err)
+	}
+
+	var overdue []model.Modality
+	for _, r := range requests {
+		modality := model.Modality(r.Modality)
+		sla := ctl.limits(modality).SLA
+		if r.ReceivedAt.Valid || sla <= 0 || time.Since(r.RequestedAt.Time) <= sla {
+			continue
+		}
+		overdue = append(overdue, modality)
+	}
+
+	return overdue, nil
+}
+
+// runReaper periodically handles the requests without a result within the timeout of their modality
+// and the tasks past their deadline, and prunes the sent requests of the outbox and the old entries of
+// the ledgers, until ctx is done.
+func (ctl *TaskController) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(ctl.cfg.Modality.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := ctl.reap(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("reap overdue requests failed")
+		}
+		if err := ctl.failStuckTasks(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("fail stuck tasks failed")
+		}
+		if err := ctl.pruneOutbox(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("prune outbox failed")
+		}
+		if err := ctl.pruneLedgers(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("prune ledgers failed")
+		}
+	}
+}
+
+// reap sends the overdue requests again while they have retries left and fails their tasks after that.
+// A retry is claimed on the attempt count it was read with, so concurrent reapers send it once.
+func (ctl *TaskController) reap(ctx context.Context) error {
+	// Find the requests sent longer ago than the timeout of their modality.
+	now := time.Now()
+	overdue, err := ctl.pgConn.GetOverdueModalityRequests(ctx, pgsql.GetOverdueModalityRequestsParams{
+		AudioSentBefore: pgtype.Timestamptz{Time: now.Add(-ctl.cfg.Modality.AudioTimeout), Valid: true},
+		VideoSentBefore: pgtype.Timestamptz{Time: now.Add(-ctl.cfg.Modality.VideoTimeout), Valid: true},
+		MaxRows:         reapBatchSize,
+	})
+	if err != nil {
+		return fmt.Errorf("get overdue requests failed: %w", err)
+	}
+
+	var errs []error
+	for _, r := range overdue {
+		modality := model.Modality(r.Modality)
+
+		// Give up on the task once the modality is out of retries; an audio check is answered from
+		// the audio fingerprints instead where the task has one.
+		if int(r.Attempts) > ctl.limits(modality).Retries {
+			if modality == model.ModalityAudio {
+				answered, err := ctl.answerAudioFromFingerprint(ctx, r.TaskID)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if answered {
+					continue
+				}
+			}
+
+			if err := ctl.failTimedOut(ctx, r); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := ctl.retryModality(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// retryModality sends an overdue request again unless another reaper claimed it first.
+func (ctl *TaskController) retryModality(ctx context.Context, r pgsql.ModalityRequest) error {
+	// Claim the retry.
+	n, err := ctl.pgConn.ClaimModalityRetry(ctx, pgsql.ClaimModalityRetryParams{
+		TaskID:   r.TaskID,
+		Modality: r.Modality,
+		Attempts: r.Attempts,
+	})
+	if err != nil {
+		return fmt.Errorf("claim %s retry of task %d failed: %w", r.Modality, r.TaskID, err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// Send the request as stored.
+	task, err := ctl.pgConn.GetTask(ctx, r.TaskID)
+	if err != nil {
+		return fmt.Errorf("get task %d failed: %w", r.TaskID, err)
+	}
+	if err := ctl.sendModality(ctx, task, model.Modality(r.Modality)); err != nil {
+		return fmt.Errorf("retry %s of task %d failed: %w", r.Modality, r.TaskID, err)
+	}
+
+	resultTimeouts.WithLabelValues(r.Modality, "retried").Inc()
+	ctl.log.Warn().Int64("task_id", r.TaskID).Str("modality", r.Modality).Int32("attempt", r.Attempts+1).
+		Msg("result overdue, request sent again")
+
+	return nil
+}
+
+// failTimedOut fails the task of a request that is out of retries and records why in the audit log.
+func (ctl *TaskController) failTimedOut(ctx context.Context, r pgsql.ModalityRequest) error {
+	// Fail the task and audit it in one transaction.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Another reaper or a late result may have finished the task already.
+	n, err := q.MarkTaskFailed(ctx, pgsql.MarkTaskFailedParams{
+		TaskID:        r.TaskID,
+		FailureReason: pgtype.Text{String: fmt.Sprintf("no %s result after %d attempts", r.Modality, r.Attempts), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("fail task %d failed: %w", r.TaskID, err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if err := ctl.audit(ctx, q, model.AuditTaskTimedOut, r.TaskID, map[string]any{
+		"modality": r.Modality,
+		"attempts": r.Attempts,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Wake the callers waiting for the task.
+	ctl.completions.notify(r.TaskID)
+
+	resultTimeouts.WithLabelValues(r.Modality, "failed").Inc()
+	ctl.log.Error().Int64("task_id", r.TaskID).Str("modality", r.Modality).Int32("attempts", r.Attempts).
+		Msg("result overdue and out of retries, task failed")
+
+	return nil
+}
+
+// deadline returns the deadline of a task processed from now on, invalid when tasks have none.
+func (ctl *TaskController) deadline() pgtype.Timestamptz {
+	d := ctl.cfg.Modality.TaskDeadline
+	if d <= 0 {
+		return pgtype.Timestamptz{}
+	}
+
+	return pgtype.Timestamptz{Time: time.Now().Add(d), Valid: true}
+}
+
+// failStuckTasks fails the tasks still in progress past their deadline, such as those whose
+// requests were never sent, and records why in the audit log.
+func (ctl *TaskController) failStuckTasks(ctx context.Context) error {
+	// Fail the tasks and audit them in one transaction.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	failed, err := q.FailStuckTasks(ctx, pgsql.FailStuckTasksParams{
+		FailureReason: pgtype.Text{String: "not finished by its deadline", Valid: true},
+		MaxRows:       reapBatchSize,
+	})
+	if err != nil {
+		return fmt.Errorf("fail stuck tasks failed: %w", err)
+	}
+
+	for _, t := range failed {
+		if err := ctl.audit(ctx, q, model.AuditTaskTimedOut, t.TaskID, map[string]any{
+			"deadline": t.DeadlineAt.Time,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Wake the callers waiting for the tasks.
+	for _, t := range failed {
+		ctl.completions.notify(t.TaskID)
+		ctl.log.Error().Int64("task_id", t.TaskID).Time("deadline", t.DeadlineAt.Time).Msg("task past its deadline, task failed")
+	}
+
+	return nil
+}
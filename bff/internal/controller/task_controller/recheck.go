@@ -0,0 +1,129 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// runRecheckSweep periodically checks the library videos due for a re-check until ctx is done.
+func (ctl *TaskController) runRecheckSweep(ctx context.Context) {
+	if ctl.cfg.Recheck.SweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ctl.cfg.Recheck.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := ctl.sweepLibrary(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("sweep library failed")
+		}
+	}
+}
+
+// sweepLibrary creates a batch comparison task against the active index version for each library video
+// with references registered after its last check, and records it as a re-check of the video. Content
+// that was clean at upload may match originals registered since. References are ordered by task ID,
+// so a reference registered late under an older task than the last check is not noticed.
+func (ctl *TaskController) sweepLibrary(ctx context.Context) error {
+	// Find the latest reference and the videos not checked against it.
+	through, err := ctl.pgConn.GetLatestReferenceTaskID(ctx)
+	if err != nil {
+		return fmt.Errorf("get latest reference failed: %w", err)
+	}
+
+	due, err := ctl.pgConn.GetTasksDueForRecheck(ctx, pgsql.GetTasksDueForRecheckParams{
+		CheckedAfter:      pgtype.Timestamptz{Time: time.Now().Add(-ctl.cfg.Recheck.MinInterval), Valid: true},
+		ReferencesThrough: through,
+		MaxRows:           int32(ctl.cfg.Recheck.BatchSize),
+	})
+	if err != nil {
+		return fmt.Errorf("get videos due for recheck failed: %w", err)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	version, err := ctl.activeIndexVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Check every video.
+	var errs []error
+	for _, taskID := range due {
+		checkID, err := ctl.CompareTask(ctx, taskID, version, model.PriorityBatch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recheck video %d failed: %w", taskID, err))
+			continue
+		}
+
+		if err := ctl.pgConn.InsertTaskRecheck(ctx, pgsql.InsertTaskRecheckParams{
+			TaskID:            taskID,
+			RecheckTaskID:     checkID,
+			ReferencesThrough: through,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("record recheck of video %d failed: %w", taskID, err))
+		}
+	}
+
+	ctl.log.Info().Int("videos", len(due)).Int64("references_through", through).Int("failed", len(errs)).
+		Msg("library videos sent for a recheck")
+
+	return errors.Join(errs...)
+}
+
+// GetTaskRechecks returns the re-checks of a library video, oldest first.
+func (ctl *TaskController) GetTaskRechecks(ctx context.Context, taskID int64) ([]model.Recheck, error) {
+	// Retrieve the rechecked task.
+	task, err := ctl.pgConn.GetTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("get task failed: %w", err)
+	}
+
+	rows, err := ctl.pgConn.GetTaskRechecks(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get rechecks failed: %w", err)
+	}
+
+	rechecks := make([]model.Recheck, len(rows))
+	for i, r := range rows {
+		// Convert the re-check task as any other task.
+		check, err := taskToModel(pgsql.Task{
+			TaskID:         r.RecheckTaskID,
+			Status:         r.Status,
+			AudioCopyright: r.AudioCopyright,
+			VideoCopyright: r.VideoCopyright,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rechecks[i] = model.Recheck{
+			TaskID:            taskID,
+			VideoName:         task.VideoName.String,
+			ReferencesThrough: r.ReferencesThrough,
+			CreatedAt:         r.CreatedAt.Time,
+			Check:             check,
+		}
+	}
+
+	return rechecks, nil
+}
@@ -0,0 +1,178 @@
+package taskcontroller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// maxErrorBodySize bounds how much of an ML service error body is read and stored.
+const maxErrorBodySize = 4 << 10
+
+// ErrRegistrationFailed is returned when an ML service rejects or fails a reference registration.
+var ErrRegistrationFailed = errors.New("reference registration failed")
+
+// registerReference posts a reference registration to an ML service, retrying transient failures,
+// and records the outcome so that references missing from the index can be found and retried.
+func (ctl *TaskController) registerReference(ctx context.Context, taskID int64, modality model.Modality, url string, body any) error {
+	// Marshal the registration request.
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("json marshal failed: %w", err)
+	}
+
+	// Post the registration until it succeeds, fails permanently or runs out of attempts.
+	var attempts int
+	for attempts < max(ctl.cfg.Registration.Attempts, 1) {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(time.Duration(attempts) * ctl.cfg.Registration.Backoff):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		attempts++
+
+		var retry bool
+		retry, err = ctl.postRegistration(ctx, url, b)
+		if err == nil || !retry {
+			break
+		}
+		ctl.logger(ctx).Warn().Err(err).Int64("task_id", taskID).Str("modality", string(modality)).
+			Int("attempt", attempts).Msg("reference registration failed, retrying")
+	}
+
+	// Record the outcome of the registration.
+	status := model.RegistrationRegistered
+	var lastError pgtype.Text
+	if err != nil {
+		status = model.RegistrationFailed
+		lastError = pgtype.Text{String: err.Error(), Valid: true}
+	}
+	if errUpsert := ctl.pgConn.UpsertReferenceRegistration(ctx, pgsql.UpsertReferenceRegistrationParams{
+		TaskID:    taskID,
+		Modality:  string(modality),
+		Status:    status,
+		Attempts:  int32(attempts),
+		LastError: lastError,
+	}); errUpsert != nil {
+		ctl.logger(ctx).Error().Err(errUpsert).Int64("task_id", taskID).Msg("failed to record reference registration")
+	}
+
+	// Record the registration in the audit log.
+	action := model.AuditReferenceRegistered
+	if err != nil {
+		action = model.AuditReferenceRegistrationFailed
+	}
+	ctl.recordAudit(ctx, action, taskID, map[string]any{
+		"modality": modality,
+		"attempts": attempts,
+		"error":    lastError.String,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ctl.logger(ctx).Info().Int64("task_id", taskID).Str("modality", string(modality)).Int("attempts", attempts).
+		Msg("reference registered")
+
+	return nil
+}
+
+// postRegistration makes a single registration request and reports whether a failure is worth retrying.
+func (ctl *TaskController) postRegistration(ctx context.Context, url string, body []byte) (bool, error) {
+	// Create the HTTP POST request.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("create new request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the request; network errors are transient unless the context is done.
+	resp, err := ctl.registrationClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("make request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused and keep it for the error message.
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return true, fmt.Errorf("io read all failed: %w", err)
+	}
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	// Server errors and throttling are transient, other statuses mean the request is rejected.
+	retry := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+
+	return retry, fmt.Errorf("%w: status %d: %s", ErrRegistrationFailed, resp.StatusCode, errorDetail(respBody))
+}
+
+// errorDetail extracts the message of an ML service error body. The services are FastAPI apps
+// that put it into detail, either as a string or as a list of validation errors.
+func errorDetail(body []byte) string {
+	var e struct {
+		Detail  json.RawMessage `json:"detail"`
+		Message string          `json:"message"`
+		Error   string          `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil {
+		var s string
+		switch {
+		case len(e.Detail) != 0 && json.Unmarshal(e.Detail, &s) == nil:
+			return s
+		case len(e.Detail) != 0:
+			return string(e.Detail)
+		case e.Message != "":
+			return e.Message
+		case e.Error != "":
+			return e.Error
+		}
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// GetRegistrations returns the reference registrations of a task.
+func (ctl *TaskController) GetRegistrations(ctx context.Context, taskID int64) ([]model.Registration, error) {
+	// Make sure the task exists.
+	if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("get task failed: %w", err)
+	}
+
+	// Retrieve the registrations of the task.
+	regs, err := ctl.pgConn.GetReferenceRegistrations(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get reference registrations failed: %w", err)
+	}
+
+	// Convert the registrations to the application model.
+	out := make([]model.Registration, len(regs))
+	for i, r := range regs {
+		out[i] = registrationToModel(r)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package taskcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ResetModality clears the result of one modality of a task and requests it again from its ML service,
+// for results known to be wrong. The task is back in progress with a new deadline until the fresh
+// result arrives, which decides it again with the result of the other modality. The previous result
+// and status are kept in the audit log.
+func (ctl *TaskController) ResetModality(ctx context.Context, taskID int64, modality model.Modality) error {
+	// Pick the column the result is stored in.
+	var clearResult func(q *pgsql.Queries, version int64) (int64, error)
+	switch modality {
+	case model.ModalityAudio:
+		clearResult = func(q *pgsql.Queries, version int64) (int64, error) {
+			return q.ClearTaskAudioCopyright(ctx, pgsql.ClearTaskAudioCopyrightParams{
+				TaskID:     taskID,
+				DeadlineAt: ctl.deadline(),
+				Version:    version,
+			})
+		}
+	case model.ModalityVideo:
+		clearResult = func(q *pgsql.Queries, version int64) (int64, error) {
+			return q.ClearTaskVideoCopyright(ctx, pgsql.ClearTaskVideoCopyrightParams{
+				TaskID:     taskID,
+				DeadlineAt: ctl.deadline(),
+				Version:    version,
+			})
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownModality, modality)
+	}
+
+	// Clear the result, reset the request and enqueue it again in one transaction.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Lock the task, so a result arriving meanwhile is stored after the reset and the audit
+	// log records the result actually cleared.
+	task, err := q.GetTaskForUpdate(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+		}
+		return fmt.Errorf("get task failed: %w", err)
+	}
+
+	// A result still awaited is retried by the reaper instead.
+	n, err := clearResult(q, task.Version)
+	if err != nil {
+		return fmt.Errorf("clear %s result failed: %w", modality, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s of task %d", ErrModalityPending, modality, taskID)
+	}
+
+	// Restart the attempts of the request from now; the task got a new deadline above.
+	if err := q.ResetModalityRequest(ctx, pgsql.ResetModalityRequestParams{
+		TaskID:   taskID,
+		Modality: string(modality),
+	}); err != nil {
+		return fmt.Errorf("reset %s request failed: %w", modality, err)
+	}
+	if err := enqueueModality(ctx, q, taskID, task.Priority, modality); err != nil {
+		return err
+	}
+
+	previous := task.AudioCopyright
+	if modality == model.ModalityVideo {
+		previous = task.VideoCopyright
+	}
+	if err := ctl.audit(ctx, q, model.AuditTaskModalityReset, taskID, map[string]any{
+		"modality":        modality,
+		"previous_status": task.Status,
+		"previous_result": json.RawMessage(previous),
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Publish the request without waiting for the next poll.
+	ctl.wakeRelay()
+
+	ctl.logger(ctx).Info().Int64("task_id", taskID).Str("modality", string(modality)).
+		Msg("modality result cleared, request enqueued again")
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package taskcontroller
+
+import (
+	"context"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ffmpeg"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// cpuMeterKey is the context key of the meter of the ffmpeg processes run for a task.
+type cpuMeterKey struct{}
+
+// meterTask makes the ffmpeg processes run with the returned context count towards the resources used
+// by the task. The returned function records their CPU time and is called once the task is prepared.
+func (ctl *TaskController) meterTask(ctx context.Context, taskID int64) (context.Context, func()) {
+	m := &ffmpeg.CPUMeter{}
+
+	return context.WithValue(ctx, cpuMeterKey{}, m), func() {
+		if cpu := m.Total(); cpu > 0 {
+			ctl.addResourceUsage(ctx, pgsql.AddTaskResourceUsageParams{
+				TaskID:           taskID,
+				FfmpegCpuSeconds: cpu.Seconds(),
+			})
+		}
+	}
+}
+
+// ffmpegFor returns the executor of the ffmpeg processes run for ctx, metered when ctx is.
+func (ctl *TaskController) ffmpegFor(ctx context.Context) *ffmpeg.FfmpegExecutor {
+	if m, ok := ctx.Value(cpuMeterKey{}).(*ffmpeg.CPUMeter); ok {
+		return ctl.ffmpegExec.WithMeter(m)
+	}
+
+	return ctl.ffmpegExec
+}
+
+// addResourceUsage adds to the resources used by a task, also when the request that used them was
+// cancelled. A failure is only logged, as the usage is an estimate of cost.
+func (ctl *TaskController) addResourceUsage(ctx context.Context, usage pgsql.AddTaskResourceUsageParams) {
+	if err := ctl.pgConn.AddTaskResourceUsage(context.WithoutCancel(ctx), usage); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", usage.TaskID).Msg("add task resource usage failed")
+	}
+}
@@ -0,0 +1,122 @@
+package taskcontroller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+)
+
+// configuredRetention returns the retention of each bucket set by the config.
+func (ctl *TaskController) configuredRetention() []model.BucketRetention {
+	cfg := ctl.cfg.Retention
+
+	return []model.BucketRetention{
+		{Bucket: ctl.storage.GetAudioBucketName(), ExpireDays: cfg.ExpireAudioDays},
+		{
+			Bucket:          ctl.storage.GetVideoBucketName(),
+			ExpireDays:      cfg.ExpireVideoDays,
+			TransitionDays:  cfg.VideoTransitionDays,
+			TransitionClass: cfg.VideoTransitionClass,
+		},
+		{Bucket: ctl.storage.GetPreviewBucketName(), ExpireDays: cfg.ExpirePreviewDays},
+		// The reference videos are kept for as long as they are registered with the ML services.
+		{Bucket: ctl.storage.GetOrigVideoBucket()},
+	}
+}
+
+// applyRetention sets the configured lifecycle rules on the buckets; failures are logged, so an
+// unreachable storage does not keep the service from starting.
+func (ctl *TaskController) applyRetention(ctx context.Context) {
+	rm, ok := ctl.storage.(objectstorage.RetentionManager)
+	if !ok {
+		ctl.log.Info().Str("backend", ctl.cfg.Storage.Backend).Msg("storage backend does not manage retention, objects are kept")
+		return
+	}
+
+	for _, r := range ctl.configuredRetention() {
+		if err := rm.SetRetention(ctx, r.Bucket, retentionToStorage(r)); err != nil {
+			ctl.log.Error().Err(err).Str("bucket", r.Bucket).Msg("failed to apply bucket retention")
+		}
+	}
+}
+
+// GetRetention returns the lifecycle the storage applies to each bucket.
+func (ctl *TaskController) GetRetention(ctx context.Context) ([]model.BucketRetention, error) {
+	rm, ok := ctl.storage.(objectstorage.RetentionManager)
+	if !ok {
+		return nil, ErrRetentionUnsupported
+	}
+
+	buckets := ctl.configuredRetention()
+	for i := range buckets {
+		r, err := rm.GetRetention(ctx, buckets[i].Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("get retention of bucket %s failed: %w", buckets[i].Bucket, err)
+		}
+		buckets[i] = retentionFromStorage(buckets[i].Bucket, r)
+	}
+
+	return buckets, nil
+}
+
+// SetRetention replaces the lifecycle of a bucket until the next startup and records the change in the
+// audit log.
+func (ctl *TaskController) SetRetention(ctx context.Context, r model.BucketRetention) error {
+	rm, ok := ctl.storage.(objectstorage.RetentionManager)
+	if !ok {
+		return ErrRetentionUnsupported
+	}
+
+	known := false
+	for _, b := range ctl.configuredRetention() {
+		known = known || b.Bucket == r.Bucket
+	}
+	if !known {
+		return fmt.Errorf("%w: %s", ErrUnknownBucket, r.Bucket)
+	}
+
+	previous, err := rm.GetRetention(ctx, r.Bucket)
+	if err != nil {
+		return fmt.Errorf("get retention of bucket %s failed: %w", r.Bucket, err)
+	}
+
+	if err := rm.SetRetention(ctx, r.Bucket, retentionToStorage(r)); err != nil {
+		return fmt.Errorf("set retention of bucket %s failed: %w", r.Bucket, err)
+	}
+
+	ctl.recordAudit(ctx, model.AuditRetentionChanged, 0, map[string]any{
+		"bucket":   r.Bucket,
+		"previous": retentionDetails(previous),
+		"current":  retentionDetails(retentionToStorage(r)),
+	})
+
+	return nil
+}
+
+// retentionDetails describes a retention in the audit log.
+func retentionDetails(r objectstorage.Retention) map[string]any {
+	return map[string]any{
+		"expire_days":      r.ExpireDays,
+		"transition_days":  r.TransitionDays,
+		"transition_class": r.TransitionClass,
+	}
+}
+
+func retentionToStorage(r model.BucketRetention) objectstorage.Retention {
+	return objectstorage.Retention{
+		ExpireDays:      r.ExpireDays,
+		TransitionDays:  r.TransitionDays,
+		TransitionClass: r.TransitionClass,
+	}
+}
+
+func retentionFromStorage(bucket string, r objectstorage.Retention) model.BucketRetention {
+	return model.BucketRetention{
+		Bucket:          bucket,
+		ExpireDays:      r.ExpireDays,
+		TransitionDays:  r.TransitionDays,
+		TransitionClass: r.TransitionClass,
+	}
+}
@@ -0,0 +1,183 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// ErrSceneNotFound is returned for a scene the video of a task does not have.
+var ErrSceneNotFound = errors.New("scene not found")
+
+// taskScene is a scene of a video with the key of its keyframe in the preview bucket.
+type taskScene struct {
+	model.Scene
+	Keyframe string
+}
+
+// detectScenes cuts a local video of length into scenes as configured and stores a keyframe from the
+// middle of each. It returns no scenes when the detection is disabled.
+func (ctl *TaskController) detectScenes(ctx context.Context, filename string, length time.Duration) ([]taskScene, error) {
+	cfg := ctl.cfg.Scenes
+	if cfg.MaxScenes <= 0 {
+		return nil, nil
+	}
+
+	// Find the cuts, keeping evenly spaced ones of a video with too many.
+	cuts, err := ctl.ffmpegFor(ctx).DetectScenes(filename, cfg.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect scenes: %w", err)
+	}
+	if len(cuts) > cfg.MaxScenes-1 {
+		kept := make([]time.Duration, cfg.MaxScenes-1)
+		for i := range kept {
+			kept[i] = cuts[i*len(cuts)/len(kept)]
+		}
+		cuts = kept
+	}
+
+	// Lay out the scenes between the cuts, from the start to the end of the video.
+	scenes := make([]taskScene, len(cuts)+1)
+	at := make([]time.Duration, len(scenes))
+	for i := range scenes {
+		s := &scenes[i].Scene
+		s.Index = i
+		if i > 0 {
+			s.Start = cuts[i-1]
+		}
+		s.End = length
+		if i < len(cuts) {
+			s.End = cuts[i]
+		}
+		s.KeyframeAt = s.Start + (s.End-s.Start)/2
+		at[i] = s.KeyframeAt
+	}
+
+	// Take the keyframes and ensure they are removed after processing.
+	shots, err := ctl.ffmpegFor(ctx).GetScreenshotsAt(filename, at, cfg.FrameWidth)
+	for _, shot := range shots {
+		ctl.tempFS.Track("scenes", shot)
+		defer ctl.tempFS.Remove(shot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take keyframes: %w", err)
+	}
+
+	// Store the keyframes; the ones stored already are released should one fail.
+	for i, shot := range shots {
+		key, err := ctl.storeImage(ctx, objectkey.KindKeyframe, shot, ".jpg")
+		if err != nil {
+			for _, stored := range scenes[:i] {
+				ctl.releaseObject(ctx, stored.Keyframe, ctl.storage.GetPreviewBucketName())
+			}
+			return nil, err
+		}
+		scenes[i].Keyframe = key
+	}
+
+	return scenes, nil
+}
+
+// insertScenes records the scenes of the video of a task.
+func insertScenes(ctx context.Context, q *pgsql.Queries, taskID int64, scenes []taskScene) error {
+	for _, s := range scenes {
+		if err := q.InsertTaskScene(ctx, pgsql.InsertTaskSceneParams{
+			TaskID:          taskID,
+			Scene:           int32(s.Index),
+			StartSeconds:    s.Start.Seconds(),
+			EndSeconds:      s.End.Seconds(),
+			KeyframeSeconds: s.KeyframeAt.Seconds(),
+			KeyframeKey:     s.Keyframe,
+		}); err != nil {
+			return fmt.Errorf("insert task scene failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// keyframeLinks presigns the keyframes of the scenes of a task for the video-copy service.
+func (ctl *TaskController) keyframeLinks(ctx context.Context, taskID int64) ([]model.KafkaKeyframe, error) {
+	scenes, err := ctl.pgConn.GetTaskScenes(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task scenes failed: %w", err)
+	}
+
+	links := make([]model.KafkaKeyframe, 0, len(scenes))
+	for _, s := range scenes {
+		url, err := ctl.storage.GetFileURL(ctx, s.KeyframeKey, ctl.storage.GetPreviewBucketName())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get keyframe url: %w", err)
+		}
+		links = append(links, model.KafkaKeyframe{
+			Start: s.StartSeconds,
+			End:   s.EndSeconds,
+			At:    s.KeyframeSeconds,
+			Link:  url,
+		})
+	}
+
+	return links, nil
+}
+
+// GetTaskScenes returns the scenes of the video of a task in order; tasks created while the detection
+// was disabled have none.
+func (ctl *TaskController) GetTaskScenes(ctx context.Context, taskID int64) ([]model.Scene, error) {
+	// Check that the task exists.
+	if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("get task failed: %w", err)
+	}
+
+	rows, err := ctl.pgConn.GetTaskScenes(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task scenes failed: %w", err)
+	}
+
+	scenes := make([]model.Scene, len(rows))
+	for i, r := range rows {
+		scenes[i] = model.Scene{
+			Index:      int(r.Scene),
+			Start:      time.Duration(r.StartSeconds * float64(time.Second)),
+			End:        time.Duration(r.EndSeconds * float64(time.Second)),
+			KeyframeAt: time.Duration(r.KeyframeSeconds * float64(time.Second)),
+		}
+	}
+
+	return scenes, nil
+}
+
+// GetTaskKeyframe opens the keyframe of a scene of a task and returns its size.
+func (ctl *TaskController) GetTaskKeyframe(ctx context.Context, taskID int64, scene int) (objectstorage.ReadAtCloser, int64, error) {
+	s, err := ctl.pgConn.GetTaskScene(ctx, pgsql.GetTaskSceneParams{
+		TaskID: taskID,
+		Scene:  int32(scene),
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, 0, fmt.Errorf("get task scene failed: %w", err)
+		}
+
+		// Without the scene, check whether the task exists at all.
+		if _, err := ctl.pgConn.GetTask(ctx, taskID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, 0, ErrTaskNotFound
+			}
+			return nil, 0, fmt.Errorf("get task failed: %w", err)
+		}
+
+		return nil, 0, ErrSceneNotFound
+	}
+
+	return ctl.openPreview(ctx, s.KeyframeKey, ErrSceneNotFound)
+}
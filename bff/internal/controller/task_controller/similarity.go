@@ -0,0 +1,110 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// runSimilaritySweep periodically checks the references due for a check against the reference index
+// until ctx is done.
+func (ctl *TaskController) runSimilaritySweep(ctx context.Context) {
+	if ctl.cfg.Similarity.RecheckAfter <= 0 || ctl.cfg.Similarity.SweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ctl.cfg.Similarity.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := ctl.sweepReferences(ctx); err != nil && ctx.Err() == nil {
+			ctl.log.Error().Err(err).Msg("sweep references failed")
+		}
+	}
+}
+
+// sweepReferences creates a comparison task against the active index version for each registered
+// reference whose last check is older than the recheck age, and links the reference to it.
+// Workers sweeping at the same time may check a reference twice; the later check wins.
+func (ctl *TaskController) sweepReferences(ctx context.Context) error {
+	// Find the references due for a check.
+	due, err := ctl.pgConn.GetReferencesDueForCheck(ctx, pgsql.GetReferencesDueForCheckParams{
+		CheckedBefore: pgtype.Timestamptz{Time: time.Now().Add(-ctl.cfg.Similarity.RecheckAfter), Valid: true},
+		MaxRows:       int32(ctl.cfg.Similarity.BatchSize),
+	})
+	if err != nil {
+		return fmt.Errorf("get references due for check failed: %w", err)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	version, err := ctl.activeIndexVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Check every reference.
+	var errs []error
+	for _, taskID := range due {
+		checkID, err := ctl.CompareTask(ctx, taskID, version, model.PriorityBatch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check reference %d failed: %w", taskID, err))
+			continue
+		}
+
+		if err := ctl.pgConn.UpsertReferenceCheck(ctx, pgsql.UpsertReferenceCheckParams{
+			TaskID:      taskID,
+			CheckTaskID: checkID,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("record check of reference %d failed: %w", taskID, err))
+		}
+	}
+
+	ctl.log.Info().Int("references", len(due)).Int("failed", len(errs)).Msg("references sent for a similarity check")
+
+	return errors.Join(errs...)
+}
+
+// GetReferenceChecks returns the latest check of every checked reference, by reference task.
+func (ctl *TaskController) GetReferenceChecks(ctx context.Context) ([]model.ReferenceCheck, error) {
+	rows, err := ctl.pgConn.GetReferenceChecks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get reference checks failed: %w", err)
+	}
+
+	checks := make([]model.ReferenceCheck, len(rows))
+	for i, r := range rows {
+		// Convert the check task as any other task.
+		check, err := taskToModel(pgsql.Task{
+			TaskID:         r.CheckTaskID,
+			Status:         r.Status,
+			AudioCopyright: r.AudioCopyright,
+			VideoCopyright: r.VideoCopyright,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		checks[i] = model.ReferenceCheck{
+			TaskID:    r.TaskID,
+			VideoName: r.VideoName.String,
+			CheckedAt: r.CheckedAt.Time,
+			Check:     check,
+		}
+	}
+
+	return checks, nil
+}
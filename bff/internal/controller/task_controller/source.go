@@ -0,0 +1,137 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// Dimensions a source report can be grouped by.
+const (
+	SourceByIP        = "ip"
+	SourceByUserAgent = "user_agent"
+	SourceByAPIKey    = "api_key"
+)
+
+// ErrInvalidDimension is returned for an unknown source report dimension.
+var ErrInvalidDimension = errors.New("invalid report dimension")
+
+// SearchTasks retrieves a page of tasks matching the source filter using keyset pagination.
+func (ctl *TaskController) SearchTasks(ctx context.Context, filter model.TaskFilter, limit uint64, cursor string) ([]model.Task, string, error) {
+	// Decode the cursor into the last task ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Retrieve one task more than requested to find out whether there is a next page.
+	pgtasks, err := ctl.pgConn.SearchTasks(ctx, pgsql.SearchTasksParams{
+		TaskID:    after,
+		SourceIp:  optionalText(filter.SourceIP),
+		UserAgent: optionalText(filter.UserAgent),
+		ApiKeyID:  optionalText(filter.APIKeyID),
+		MaxRows:   int32(limit + 1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("search tasks failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more tasks.
+	var next string
+	if uint64(len(pgtasks)) > limit {
+		pgtasks = pgtasks[:limit]
+		next = encodeCursor(pgtasks[len(pgtasks)-1].TaskID)
+	}
+
+	// Convert the retrieved tasks from the database model to the application model.
+	tasks, err := taskSliceToModel(pgtasks)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tasks, next, nil
+}
+
+// GetSourceReport returns the sources with the most tasks grouped by the given dimension.
+func (ctl *TaskController) GetSourceReport(ctx context.Context, dimension string, limit uint64) ([]model.SourceStat, error) {
+	// Make sure the dimension is one the query knows.
+	switch dimension {
+	case SourceByIP, SourceByUserAgent, SourceByAPIKey:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDimension, dimension)
+	}
+
+	// Aggregate the tasks by source.
+	rows, err := ctl.pgConn.GetTaskSourceReport(ctx, pgsql.GetTaskSourceReportParams{
+		Dimension: dimension,
+		MaxRows:   int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get source report failed: %w", err)
+	}
+
+	// Convert the rows to the application model.
+	stats := make([]model.SourceStat, len(rows))
+	for i, r := range rows {
+		stats[i] = model.SourceStat{
+			Source:     r.Source,
+			Tasks:      r.Tasks,
+			InProgress: r.InProgress,
+			Failed:     r.Failed,
+		}
+	}
+
+	return stats, nil
+}
+
+// GetTasksByStatus retrieves a page of the tasks of a status created before the given time, oldest
+// first, using keyset pagination, and the number of all such tasks.
+func (ctl *TaskController) GetTasksByStatus(ctx context.Context, status model.TaskStatus, createdBefore time.Time, limit uint64, cursor string) ([]model.Task, int64, string, error) {
+	// Decode the cursor into the last task ID of the previous page.
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	before := pgtype.Timestamptz{Time: createdBefore, Valid: true}
+
+	// Retrieve one task more than requested to find out whether there is a next page.
+	pgtasks, err := ctl.pgConn.GetTasksByStatus(ctx, pgsql.GetTasksByStatusParams{
+		Status:        statusFromModel(status),
+		CreatedBefore: before,
+		TaskID:        after,
+		MaxRows:       int32(limit + 1),
+	})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("get tasks by status failed: %w", err)
+	}
+
+	total, err := ctl.pgConn.CountTasksByStatus(ctx, pgsql.CountTasksByStatusParams{
+		Status:        statusFromModel(status),
+		CreatedBefore: before,
+	})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("count tasks by status failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more tasks.
+	var next string
+	if uint64(len(pgtasks)) > limit {
+		pgtasks = pgtasks[:limit]
+		next = encodeCursor(pgtasks[len(pgtasks)-1].TaskID)
+	}
+
+	// Convert the retrieved tasks from the database model to the application model.
+	tasks, err := taskSliceToModel(pgtasks)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	return tasks, total, next, nil
+}
@@ -1,109 +1,386 @@
 package taskcontroller
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/diskcache"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/download"
 	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ffmpeg"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/msgformat"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/multihash"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/phash"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/tempfs"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/tracing"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/urlpolicy"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/localfs"
 	"github.com/gulldan/cp2024yappy/bff/internal/repository/minio"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
 	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/xid"
 	"github.com/rs/zerolog"
 	"github.com/segmentio/kafka-go"
 
 	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/postgres/migrations"
+)
+
+// uploadURLExpiry is how long a presigned upload URL stays valid.
+const uploadURLExpiry = time.Hour
+
+var (
+	// ErrTaskNotFound is returned when the requested task does not exist.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrObjectNotFound is returned when the referenced object is not in storage.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrUnknownModality is returned for a result of a modality other than audio or video.
+	ErrUnknownModality = errors.New("unknown modality")
+	// ErrMalformedMessage is returned for a result that cannot be decoded.
+	ErrMalformedMessage = errors.New("malformed message")
+	// ErrModalityPending is returned when clearing a modality whose result is still awaited.
+	ErrModalityPending = errors.New("modality result is pending")
+	// ErrConcurrentUpdate is returned when a task changed since it was read, so writing it would
+	// overwrite the newer state.
+	ErrConcurrentUpdate = errors.New("task was changed concurrently")
+	// ErrUnknownBucket is returned for a bucket the service does not keep media in.
+	ErrUnknownBucket = errors.New("unknown bucket")
+	// ErrRetentionUnsupported is returned when the storage backend cannot expire objects itself.
+	ErrRetentionUnsupported = errors.New("storage backend does not manage retention")
 )
 
 type TaskController struct {
 	cfg         *config.Config
 	ffmpegExec  *ffmpeg.FfmpegExecutor
-	minioClient *minio.MinioClient
+	tempFS      *tempfs.Workspace
+	storage     objectstorage.ObjectStorage
 	log         *zerolog.Logger
 	pgConn      *pgsql.Queries
-	audioReader *kafka.Reader
-	videoReader *kafka.Reader
+	pgPool      *pgxpool.Pool
 	producer    *kafka.Writer
+	kafkaDialer *kafka.Dialer
+	downloader  *download.Downloader
+	// codec serializes the requests to and the results of the ML services.
+	codec msgformat.Codec
+	// registrationClient calls the ML services to register references.
+	registrationClient *http.Client
+	// consumers supervises the readers of the consumer groups this process joined.
+	consumers consumerSupervisor
+	// relay publishes the requests to the ML services recorded in the outbox.
+	relay outboxRelay
+	// completions wakes the callers of WaitTask when this process finishes their task.
+	completions completionRegistry
+	// health holds the result of the last health check of the storage.
+	health healthState
+}
+
+// derivedMedia holds what is derived from a stored video before its task is created.
+type derivedMedia struct {
+	AudioFile string
+	// Preview holds the preview images; their keys are empty when they could not be generated.
+	Preview taskPreview
+	// Scenes are the scenes of the video with their stored keyframes; none when the detection failed.
+	Scenes []taskScene
+	// Metadata are the properties of the video probed at ingest; zero when the probe failed.
+	Metadata model.VideoMetadata
+	// Video holds the length, resolution, size and content hash of the video stored with the task.
+	Video model.VideoProperties
+	// Hashes are the digests of the video by the configured algorithms, stored for lookups.
+	Hashes map[string]string
+	// AudioFingerprint is the encoded chromaprint fingerprint of the audio; empty when it is disabled or failed.
+	AudioFingerprint []byte
+	// Media is the processing time and storage the task adds to the usage of its API key.
+	Media model.Usage
+}
+
+// taskInput holds the stored media and the metadata of a task being created.
+type taskInput struct {
+	derivedMedia
+	TaskID    int64
+	VideoFile string
+	Filename  string
+	// Hash is the MD5 of the video, used to find exact duplicates; empty when duplicates are already ruled out.
+	Hash   string
+	Source model.Source
+	// Verification tells how a downloaded video was checked against its source; empty for uploads.
+	Verification string
 }
 
 // New initializes and returns a new TaskController instance.
+// Result consumption is not started; processes running the worker role call StartConsumers.
 func New(cfg *config.Config, log *zerolog.Logger) (*TaskController, error) {
-	// Create a Kafka reader for the audio copyright topic.
-	audioReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{cfg.Kafka.Address},
-		Topic:    cfg.Kafka.AudioCopyrightTopic,
-		GroupID:  "bff-audio-copyright-reader",
-		MaxBytes: 10e6, // 10MB
-	})
-
-	// Create a Kafka reader for the video copyright topic.
-	videoReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{cfg.Kafka.Address},
-		Topic:    cfg.Kafka.VideoCopyrightTopic,
-		GroupID:  "bff-video-copyright-reader",
-		MaxBytes: 10e6, // 10MB
-	})
+	// Set up authentication and encryption for the Kafka connections.
+	dialer, transport, err := kafkaDialer(cfg.Kafka)
+	if err != nil {
+		return nil, fmt.Errorf("kafka config failed: %w", err)
+	}
 
-	// Create a Kafka producer.
+	// Create a Kafka producer. Messages are keyed by task ID and hashed to partitions so that all
+	// messages of a task keep their order; CRC32 matches the default partitioner of librdkafka clients.
 	producer := &kafka.Writer{
-		Addr:     kafka.TCP(cfg.Kafka.Address),
-		Balancer: &kafka.LeastBytes{},
+		Addr:      kafka.TCP(cfg.Kafka.BrokerAddrs()...),
+		Balancer:  &kafka.CRC32Balancer{},
+		Transport: transport,
+	}
+
+	// Pick the serialization of the messages exchanged with the ML services.
+	codec, err := msgformat.Open(cfg.Kafka.MessageFormat, cfg.Kafka.SchemaRegistryURL, cfg.Kafka.SchemaRegistryUser, cfg.Kafka.SchemaRegistryPassword)
+	if err != nil {
+		return nil, fmt.Errorf("kafka config failed: %w", err)
 	}
 
 	// Set up the HTTP client with a timeout.
 	httpCl := http.DefaultClient
 	httpCl.Timeout = time.Hour
 
-	pg, err := pgxpool.New(context.Background(), cfg.Postgres.Addr)
+	pg, err := pgsql.NewPool(context.Background(), cfg.Postgres)
 	if err != nil {
 		return nil, fmt.Errorf("postgres connect failed: %w", err)
 	}
 
-	// Create a new Minio client.
-	m, err := minio.NewMinioClient(&cfg.Minio)
+	// Bring the schema up to date when enabled, before any query relies on it.
+	if cfg.Postgres.Migrate {
+		migrator, err := migrations.New(pg, log)
+		if err != nil {
+			return nil, err
+		}
+		applied, err := migrator.Up(context.Background())
+		if errC := migrator.Close(); errC != nil {
+			log.Error().Err(errC).Msg("failed to close migrator")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("postgres migrate failed: %w", err)
+		}
+		log.Info().Int("applied", applied).Int64("version", migrator.Latest()).Msg("postgres schema up to date")
+	}
+
+	// Create the client of the configured object storage.
+	m, err := newObjectStorage(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage client: %w", err)
+	}
+
+	// Create the managed workspace for temporary files and expose its usage metrics.
+	ws, err := tempfs.New(cfg.Temp.Dir, cfg.Temp.StaleAfter, log)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create minio client: %w", err)
+		return nil, fmt.Errorf("failed to create temp workspace: %w", err)
+	}
+	prometheus.MustRegister(ws)
+
+	// Create the cache for downloads that are requested repeatedly, e.g. by batch runs.
+	cache, err := diskcache.New(cfg.Download.CacheDir, cfg.Download.CacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download cache: %w", err)
+	}
+	if cache != nil {
+		prometheus.MustRegister(cache)
+	}
+
+	// Fail fast on a misconfigured digest algorithm instead of on every task.
+	if err := multihash.Validate(cfg.Hash.Algorithms); err != nil {
+		return nil, fmt.Errorf("hash config failed: %w", err)
+	}
+
+	// Restrict the links users may make the service fetch, so it cannot be used to reach internal services.
+	policy := urlpolicy.New(urlpolicy.Options{
+		Schemes:      cfg.Download.Schemes,
+		AllowHosts:   cfg.Download.AllowHosts,
+		DenyHosts:    cfg.Download.DenyHosts,
+		AllowPrivate: cfg.Download.AllowPrivate,
+		MaxRedirects: cfg.Download.MaxRedirects,
+		MaxSize:      cfg.Download.MaxSize,
+	})
+
+	// Resolve the format of the extracted audio from its profile and overrides.
+	audioFormat, err := ffmpeg.AudioProfile(cfg.Audio.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("audio config failed: %w", err)
+	}
+	if cfg.Audio.SampleRate > 0 {
+		audioFormat.SampleRate = cfg.Audio.SampleRate
+	}
+	if cfg.Audio.Channels > 0 {
+		audioFormat.Channels = cfg.Audio.Channels
+	}
+	if cfg.Audio.Codec != "" {
+		audioFormat.Codec = cfg.Audio.Codec
+	}
+	if cfg.Audio.DownmixMono {
+		audioFormat.Channels = 1
+	}
+
+	// Create the executor of the media processing, decoding on the GPU when configured and supported.
+	ffmpegExec, err := ffmpeg.New(log, ws.Dir(), ffmpeg.Options{
+		MaxProcesses:  cfg.FFmpeg.MaxProcesses,
+		HWAccel:       cfg.FFmpeg.HWAccel,
+		HWAccelDevice: cfg.FFmpeg.HWAccelDevice,
+		ExtraFlags:    cfg.FFmpeg.ExtraFlags,
+		Audio:         audioFormat,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg config failed: %w", err)
 	}
 
 	// Initialize the TaskController instance.
 	controller := &TaskController{
-		cfg:         cfg,
-		ffmpegExec:  ffmpeg.New(log),
-		minioClient: m,
-		log:         log,
-		pgConn:      pgsql.New(pg),
-		audioReader: audioReader,
-		videoReader: videoReader,
-		producer:    producer,
+		cfg:                cfg,
+		ffmpegExec:         ffmpegExec,
+		tempFS:             ws,
+		storage:            m,
+		log:                log,
+		pgConn:             pgsql.New(pg),
+		pgPool:             pg,
+		producer:           producer,
+		kafkaDialer:        dialer,
+		codec:              codec,
+		registrationClient: &http.Client{Timeout: cfg.Registration.Timeout},
+		downloader:         download.New(policy.Client(cfg.Download.Timeout), cfg.Download.Attempts, cfg.Download.Backoff, cache, log),
+		relay:              outboxRelay{wake: make(chan struct{}, 1)},
+		completions:        completionRegistry{maxPerTask: cfg.Server.MaxTaskWaiters},
 	}
 
 	// Create necessary Kafka topics.
 	controller.createTopics()
 
-	// Start handling Kafka input messages.
-	controller.handleKafkaInput(context.Background())
+	// Apply the configured lifecycle rules to the buckets.
+	controller.applyRetention(context.Background())
 
 	// Return the initialized TaskController.
 	return controller, nil
 }
 
+// newObjectStorage creates the client of the configured object storage backend.
+func newObjectStorage(cfg *config.Config) (objectstorage.ObjectStorage, error) {
+	if cfg.Storage.Backend == objectstorage.BackendFS {
+		return localfs.New(&cfg.Minio, cfg.Storage)
+	}
+
+	return minio.New(&cfg.Minio, cfg.Storage)
+}
+
+// StorageHandler serves the presigned URLs of a storage kept in a local directory, nil for the other backends.
+func (ctl *TaskController) StorageHandler() http.Handler {
+	if s, ok := ctl.storage.(interface{ Handler() http.Handler }); ok {
+		return s.Handler()
+	}
+
+	return nil
+}
+
+// Close releases resources held by the controller, removing in-flight temporary files.
+func (ctl *TaskController) Close() {
+	// Close the readers still open, leaving the consumer groups so partitions are rebalanced right away.
+	if ctl.consumers.stop != nil {
+		ctl.consumers.stop()
+	}
+
+	// Flush the messages the producer still holds.
+	if err := ctl.producer.Close(); err != nil {
+		ctl.log.Error().Err(err).Msg("close kafka producer failed")
+	}
+
+	ctl.tempFS.Close()
+}
+
+// KillJobsAfter kills the running ffmpeg jobs once the grace period has passed, so the requests
+// waiting on them end before the shutdown deadline.
+func (ctl *TaskController) KillJobsAfter(grace time.Duration) {
+	time.AfterFunc(grace, ctl.ffmpegExec.Kill)
+}
+
+// StartConsumers joins the copyright result consumer groups and starts applying results.
+// Readers are only created here so processes that do not consume never join the groups;
+// StopConsumers closes them.
+func (ctl *TaskController) StartConsumers(ctx context.Context) {
+	ctx, ctl.consumers.stop = context.WithCancel(ctx)
+
+	// Start handling Kafka input messages.
+	ctl.handleKafkaInput(ctx)
+
+	// Send again or fail the requests whose results are overdue.
+	go ctl.runReaper(ctx)
+
+	// Check the references against the index for their similarity graph.
+	go ctl.runSimilaritySweep(ctx)
+
+	// Check the library again as new references are registered.
+	go ctl.runRecheckSweep(ctx)
+
+	// Create tasks for objects dropped into the watched bucket.
+	ctl.watchBucket(ctx)
+}
+
+// logger returns the request-scoped logger carried by ctx, falling back to the controller logger.
+func (ctl *TaskController) logger(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+
+	return ctl.log
+}
+
+// readerConfig returns the configuration of a consumer group reader of a topic.
+func (ctl *TaskController) readerConfig(topic, groupID string) kafka.ReaderConfig {
+	return kafka.ReaderConfig{
+		Brokers:  ctl.cfg.Kafka.BrokerAddrs(),
+		Topic:    topic,
+		GroupID:  groupID,
+		MinBytes: ctl.cfg.Kafka.ReaderMinBytes,
+		MaxBytes: ctl.cfg.Kafka.ReaderMaxBytes,
+		Dialer:   ctl.kafkaDialer,
+	}
+}
+
+// topicConfig returns the configuration a topic is created with, its partition count defaulting to partitions.
+func (ctl *TaskController) topicConfig(topic string, partitions int) kafka.TopicConfig {
+	cfg := kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: ctl.cfg.Kafka.ReplicationFactor,
+	}
+	if n, ok := ctl.cfg.Kafka.TopicPartitions[topic]; ok {
+		cfg.NumPartitions = n
+	}
+	if n, ok := ctl.cfg.Kafka.TopicReplicationFactors[topic]; ok {
+		cfg.ReplicationFactor = n
+	}
+
+	return cfg
+}
+
 // createTopics creates the necessary Kafka topics as defined in the configuration.
 func (ctl *TaskController) createTopics() {
-	// Dial the Kafka broker to establish a connection.
-	conn, err := kafka.Dial("tcp", ctl.cfg.Kafka.Address)
+	// Dial the first reachable broker to establish a connection.
+	var conn *kafka.Conn
+	var err error
+	for _, addr := range ctl.cfg.Kafka.BrokerAddrs() {
+		conn, err = ctl.kafkaDialer.Dial("tcp", addr)
+		if err == nil {
+			break
+		}
+		ctl.log.Warn().Err(err).Str("broker", addr).Msg("failed to dial kafka broker")
+	}
 	if err != nil {
 		ctl.log.Error().Err(err).Msg("failed to dial kafka")
 		return
@@ -119,7 +396,7 @@ func (ctl *TaskController) createTopics() {
 
 	// Dial the Kafka controller to establish a connection.
 	var controllerConn *kafka.Conn
-	controllerConn, err = kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	controllerConn, err = ctl.kafkaDialer.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
 	if err != nil {
 		ctl.log.Error().Err(err).Msg("failed to kafka dial")
 		return
@@ -127,452 +404,1288 @@ func (ctl *TaskController) createTopics() {
 	defer controllerConn.Close()
 
 	// Define the topic configurations for the necessary Kafka topics.
+	partitions := ctl.cfg.Kafka.Partitions
 	topicConfigs := []kafka.TopicConfig{
-		{
-			Topic:             ctl.cfg.Kafka.AudioInputTopic,
-			NumPartitions:     1,
-			ReplicationFactor: 1,
-		},
-		{
-			Topic:             ctl.cfg.Kafka.AudioCopyrightTopic,
-			NumPartitions:     1,
-			ReplicationFactor: 1,
-		},
-		{
-			Topic:             ctl.cfg.Kafka.VideoCopyrightTopic,
-			NumPartitions:     1,
-			ReplicationFactor: 1,
-		},
-		{
-			Topic:             ctl.cfg.Kafka.VideoInputTopic,
-			NumPartitions:     1,
-			ReplicationFactor: 1,
-		},
+		ctl.topicConfig(ctl.cfg.Kafka.AudioInputTopic, partitions),
+		ctl.topicConfig(ctl.cfg.Kafka.AudioCopyrightTopic, partitions),
+		ctl.topicConfig(ctl.cfg.Kafka.VideoCopyrightTopic, partitions),
+		ctl.topicConfig(ctl.cfg.Kafka.VideoInputTopic, partitions),
+	}
+	if ctl.cfg.Kafka.DeadLetterTopic != "" {
+		topicConfigs = append(topicConfigs, ctl.topicConfig(ctl.cfg.Kafka.DeadLetterTopic, partitions))
+	}
+	if ctl.cfg.Minio.WatchBucket != "" {
+		topicConfigs = append(topicConfigs, ctl.topicConfig(ctl.cfg.Kafka.ObjectEventsTopic, partitions))
+	}
+	// The verdict stream keeps a single partition, so its events are consumed in order.
+	if ctl.cfg.Kafka.VerdictTopic != "" {
+		cfg := ctl.topicConfig(ctl.cfg.Kafka.VerdictTopic, 1)
+		cfg.NumPartitions = 1
+		topicConfigs = append(topicConfigs, cfg)
 	}
 
 	// Create the Kafka topics using the defined configurations.
 	_ = controllerConn.CreateTopics(topicConfigs...)
 }
 
+// taskKey returns the Kafka message key of a task.
+func taskKey(taskID int64) []byte {
+	return strconv.AppendInt(nil, taskID, 10)
+}
+
+// priorityHeader tells the ML services the priority of a request, so they can serve interactive ones first.
+const priorityHeader = "x-priority"
+
+// traceHeaders propagates the trace of a task to the ML services in the W3C traceparent header.
+func traceHeaders(task pgsql.Task) []kafka.Header {
+	if !task.TraceID.Valid {
+		return nil
+	}
+
+	return []kafka.Header{{Key: tracing.Header, Value: []byte(tracing.Traceparent(task.TraceID.String))}}
+}
+
 // handleKafkaInput handles incoming Kafka messages for audio and video copyright topics.
 func (ctl *TaskController) handleKafkaInput(ctx context.Context) {
-	// checkTaskDone checks if a task is done by verifying if both audio and video copyrights are set.
-	checkTaskDone := func(taskID int64) {
-		// Retrieve the task from the database.
-		task, err := ctl.pgConn.GetTask(ctx, taskID)
-		if err != nil {
-			ctl.log.Error().Err(err).Msg("get task failed")
-			return
-		}
-
-		// Check if both audio and video copyrights are set.
-		if len(task.AudioCopyright) != 0 && len(task.VideoCopyright) != 0 {
-			// Update the task status to done.
-			if err := ctl.pgConn.UpdateTaskStatus(ctx, pgsql.UpdateTaskStatusParams{
-				TaskID: taskID,
-				Status: pgsql.NullTaskStatus{
-					TaskStatus: pgsql.TaskStatusDone,
-					Valid:      true,
-				},
-			}); err != nil {
-				ctl.log.Error().Err(err).Msg("update task status to done")
-			}
+	// Handle the video copyright Kafka messages.
+	ctl.superviseConsumer(ctx, ctl.readerConfig(ctl.cfg.Kafka.VideoCopyrightTopic, "bff-video-copyright-reader"), func(ctx context.Context, msg kafka.Message) error {
+		// Store the video copyright for the task unless the message was already processed.
+		return ctl.processCopyrightMessage(ctx, msg, model.ModalityVideo, func(q *pgsql.Queries, k model.KafkaResponse, value []byte, version int64) (int64, error) {
+			return q.ApplyTaskVideoCopyright(ctx, pgsql.ApplyTaskVideoCopyrightParams{
+				TaskID:         k.TaskID,
+				VideoCopyright: value,
+				Version:        version,
+			})
+		})
+	})
+
+	// Handle the audio copyright Kafka messages.
+	ctl.superviseConsumer(ctx, ctl.readerConfig(ctl.cfg.Kafka.AudioCopyrightTopic, "bff-audio-copyright-reader"), func(ctx context.Context, msg kafka.Message) error {
+		// Store the audio copyright for the task unless the message was already processed.
+		return ctl.processCopyrightMessage(ctx, msg, model.ModalityAudio, func(q *pgsql.Queries, k model.KafkaResponse, value []byte, version int64) (int64, error) {
+			return q.ApplyTaskAudioCopyright(ctx, pgsql.ApplyTaskAudioCopyrightParams{
+				TaskID:         k.TaskID,
+				AudioCopyright: value,
+				Version:        version,
+			})
+		})
+	})
+}
+
+// processCopyrightMessage applies a copyright result message exactly once.
+// The message position is recorded in the processed-message ledger in the same
+// transaction as the copyright update, so a redelivered message is a no-op; so is
+// a result the ML service published again, which finds the modality already set. A result in the
+// configured message format is stored as the JSON the ML services send by default; update is passed it.
+// Ledger entries are pruned after config.KafkaConfig.LedgerRetention, and those of a recreated topic must
+// be deleted, as its offsets start over.
+func (ctl *TaskController) processCopyrightMessage(ctx context.Context, msg kafka.Message, modality model.Modality, update func(q *pgsql.Queries, k model.KafkaResponse, value []byte, version int64) (int64, error)) error {
+	value, err := msgformat.ResponseJSON(ctx, ctl.codec, msg.Value)
+	if err != nil {
+		if errors.Is(err, msgformat.ErrMalformed) {
+			return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
 		}
+		return fmt.Errorf("decode result failed: %w", err)
 	}
 
-	// Goroutine to handle video copyright Kafka messages.
-	go func() {
-		for {
-			// Read a message from the video copyright Kafka topic.
-			msg, err := ctl.videoReader.ReadMessage(ctx)
-			if err != nil {
-				ctl.log.Error().Err(err).Msg("read message video failed")
-				continue
-			}
+	applied, err := ctl.applyCopyrightResult(ctx, modality, value, func(q *pgsql.Queries) (int64, error) {
+		return q.MarkMessageProcessed(ctx, pgsql.MarkMessageProcessedParams{
+			Topic:        msg.Topic,
+			MsgPartition: int32(msg.Partition),
+			MsgOffset:    msg.Offset,
+		})
+	}, func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error) {
+		return update(q, k, value, version)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		ctl.log.Debug().Str("topic", msg.Topic).Int("partition", msg.Partition).Int64("offset", msg.Offset).
+			Msg("duplicate message skipped")
+	}
 
-			// Unmarshal the message value into a KafkaResponse struct.
-			var k model.KafkaResponse
-			if err := json.Unmarshal(msg.Value, &k); err != nil {
-				ctl.log.Error().Err(err).Msg("unmarshal message video failed")
-				continue
-			}
+	return nil
+}
 
-			// Update the video copyright for the task in the database.
-			if err := ctl.pgConn.UpdateTaskVideoCopyright(ctx, pgsql.UpdateTaskVideoCopyrightParams{
+// PushResult applies a copyright result pushed over HTTP by a worker exactly once per result ID.
+// It returns false when the result was already applied or the modality of the task already has one.
+func (ctl *TaskController) PushResult(ctx context.Context, modality model.Modality, resultID string, payload []byte) (bool, error) {
+	// Pick the column the result is stored in.
+	var update func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error)
+	switch modality {
+	case model.ModalityAudio:
+		update = func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error) {
+			return q.ApplyTaskAudioCopyright(ctx, pgsql.ApplyTaskAudioCopyrightParams{
 				TaskID:         k.TaskID,
-				VideoCopyright: msg.Value,
-			}); err != nil {
-				ctl.log.Error().Err(err).Msg("update video copyright failed")
-				continue
-			}
-
-			// Check if the task is done.
-			checkTaskDone(k.TaskID)
+				AudioCopyright: payload,
+				Version:        version,
+			})
 		}
+	case model.ModalityVideo:
+		update = func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error) {
+			return q.ApplyTaskVideoCopyright(ctx, pgsql.ApplyTaskVideoCopyrightParams{
+				TaskID:         k.TaskID,
+				VideoCopyright: payload,
+				Version:        version,
+			})
+		}
+	default:
+		return false, fmt.Errorf("%w: %s", ErrUnknownModality, modality)
+	}
+
+	// Record the result ID in the push ledger and store the result in one transaction.
+	return ctl.applyCopyrightResult(ctx, modality, payload, func(q *pgsql.Queries) (int64, error) {
+		return q.MarkResultPushed(ctx, pgsql.MarkResultPushedParams{
+			Modality: string(modality),
+			ResultID: resultID,
+		})
+	}, update)
+}
+
+// applyCopyrightResult stores a copyright result unless record reports it as already seen or the task
+// already has a result for the modality. The ledger entry, the copyright update and the done transition
+// share one transaction. It returns false when the result was not applied.
+func (ctl *TaskController) applyCopyrightResult(ctx context.Context, modality model.Modality, value []byte, record func(q *pgsql.Queries) (int64, error), update func(q *pgsql.Queries, k model.KafkaResponse, version int64) (int64, error)) (bool, error) {
+	// Unmarshal the result into a KafkaResponse struct.
+	var k model.KafkaResponse
+	if err := json.Unmarshal(value, &k); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	// Start a transaction covering the ledger entry and the copyright update.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
 	}()
 
-	// Goroutine to handle audio copyright Kafka messages.
-	go func() {
-		for {
-			// Read a message from the audio copyright Kafka topic.
-			msg, err := ctl.audioReader.ReadMessage(ctx)
-			if err != nil {
-				ctl.log.Error().Err(err).Msg("read message audio failed")
-				continue
-			}
+	q := ctl.pgConn.WithTx(tx)
 
-			// Unmarshal the message value into a KafkaResponse struct.
-			var k model.KafkaResponse
-			if err := json.Unmarshal(msg.Value, &k); err != nil {
-				ctl.log.Error().Err(err).Msg("unmarshal message audio failed")
-				continue
-			}
+	// Lock the task before recording anything for it, so the results of both modalities, a reset
+	// and the reaper decide it one after another and the decision is written exactly once.
+	task, err := q.GetTaskForUpdate(ctx, k.TaskID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%w: %d", ErrTaskNotFound, k.TaskID)
+		}
+		return false, fmt.Errorf("get task failed: %w", err)
+	}
 
-			// Update the audio copyright for the task in the database.
-			if err := ctl.pgConn.UpdateTaskAudioCopyright(ctx, pgsql.UpdateTaskAudioCopyrightParams{
-				TaskID:         k.TaskID,
-				AudioCopyright: msg.Value,
-			}); err != nil {
-				ctl.log.Error().Err(err).Msg("update audio copyright failed")
-				continue
-			}
+	// Record the result in the ledger; zero affected rows means it was seen before.
+	n, err := record(q)
+	if err != nil {
+		return false, fmt.Errorf("mark message processed failed: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	// Store the result only while the task is in progress, has none for the modality yet and is
+	// still at the version locked above, so a result the ML service delivers again under another
+	// position or ID, or one arriving after the task was failed or deleted, cannot overwrite it.
+	stored, err := update(q, k, task.Version)
+	if err != nil {
+		return false, fmt.Errorf("update copyright failed: %w", err)
+	}
+	if stored == 0 {
+		return false, nil
+	}
+	if err := finishStage(ctx, q, k.TaskID, model.CheckStage(modality)); err != nil {
+		return false, err
+	}
+
+	// Record when the result arrived; tasks sent before requests were tracked have no request.
+	requestedAt, err := q.MarkModalityReceived(ctx, pgsql.MarkModalityReceivedParams{
+		TaskID:   k.TaskID,
+		Modality: string(modality),
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, fmt.Errorf("mark %s received failed: %w", modality, err)
+	}
 
-			// Check if the task is done.
-			checkTaskDone(k.TaskID)
+	// Mark the task as done once both modalities are set; the lock above makes the consumer
+	// that stores the second result the only one to see both.
+	done, err := q.MarkTaskDone(ctx, k.TaskID)
+	if err != nil {
+		return false, fmt.Errorf("update task status to done failed: %w", err)
+	}
+
+	// Record the decision in the audit log together with the results it is based on.
+	if done != 0 {
+		task, err := q.GetTask(ctx, k.TaskID)
+		if err != nil {
+			return false, fmt.Errorf("get task failed: %w", err)
 		}
-	}()
+		if err := ctl.audit(ctx, q, model.AuditTaskDecided, k.TaskID, map[string]any{
+			"audio_copyright": json.RawMessage(task.AudioCopyright),
+			"video_copyright": json.RawMessage(task.VideoCopyright),
+		}); err != nil {
+			return false, err
+		}
+	}
+
+	// Commit the transaction.
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Measure the result against the SLA of its modality.
+	if requestedAt.Valid {
+		ctl.observeResult(modality, time.Since(requestedAt.Time))
+	}
+
+	// Wake the callers waiting for the task.
+	if done != 0 {
+		ctl.completions.notify(k.TaskID)
+	}
+
+	return true, nil
 }
 
 // CreateTask creates a new task for a given video file and filename.
-func (ctl *TaskController) CreateTask(_ context.Context, file io.Reader, filename string) (int64, error) {
-	// Upload the video and extract video and audio files, and generate a preview ID.
-	videoFile, audioFile, err := ctl.makePreviewUploadVideo(context.Background(), file)
+func (ctl *TaskController) CreateTask(ctx context.Context, file io.Reader, filename string, src model.Source) (_ int64, err error) {
+	// Reject the task if the account has used up its quota, and give the task back if it is not created.
+	if err := ctl.reserveQuota(ctx, src.Account); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.releaseQuota(ctx, src.Account)
+		}
+	}()
+
+	// Reserve the task ID so its artifacts can be stored under it.
+	taskID, err := ctl.pgConn.ReserveTaskID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve task id: %w", err)
+	}
+
+	// Record the task, so its progress is visible while the video is prepared.
+	if err := ctl.startTask(ctx, taskID, filename, src); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.abandonTask(ctx, taskID, err)
+		}
+	}()
+
+	// Count the CPU time of the media processing towards the task.
+	ctx, recordCPU := ctl.meterTask(ctx, taskID)
+	defer recordCPU()
+
+	// Upload the video and extract video and audio files, and generate the previews.
+	videoFile, hash, derived, err := ctl.makePreviewUploadVideo(ctx, taskID, file)
 	if err != nil {
 		return 0, fmt.Errorf("failed to upload video: %w", err)
 	}
 
+	return ctl.createTaskForVideo(ctx, taskInput{
+		derivedMedia: derived,
+		TaskID:       taskID,
+		VideoFile:    videoFile,
+		Filename:     filename,
+		Hash:         hash,
+		Source:       src,
+	})
+}
+
+// CreateTaskFromLink downloads a video by link and creates a task for it.
+// The download is verified against the checksum the source announces and repeated on mismatch,
+// so detection never runs on a corrupt transfer.
+func (ctl *TaskController) CreateTaskFromLink(ctx context.Context, link, filename string, src model.Source) (_ int64, err error) {
+	// Reject the task if the account has used up its quota, and give the task back if it is not created.
+	if err := ctl.reserveQuota(ctx, src.Account); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.releaseQuota(ctx, src.Account)
+		}
+	}()
+
+	// Reserve the task ID so its artifacts can be stored under it.
+	taskID, err := ctl.pgConn.ReserveTaskID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve task id: %w", err)
+	}
+
+	// Record the task, so its progress is visible while the video is downloaded and prepared.
+	if err := ctl.startTask(ctx, taskID, filename, src); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.abandonTask(ctx, taskID, err)
+		}
+	}()
+
+	// Create a temporary file in the workspace to download the video to.
+	tmpFile, err := ctl.tempFS.CreateTemp("download", "*.mp4")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	// Ensure the temporary file is closed and removed after processing.
+	defer func() {
+		_ = tmpFile.Close()
+		if errDef := ctl.tempFS.Remove(tmpFile.Name()); errDef != nil {
+			ctl.logger(ctx).Error().Err(errDef).Str("path", tmpFile.Name()).Msg("failed to remove tmp file")
+		}
+	}()
+
+	// Download and verify the video.
+	res, err := ctl.downloader.Fetch(ctx, link, tmpFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download video: %w", err)
+	}
+	ctl.logger(ctx).Debug().Str("link", link).Str("verification", res.Verification).Int("attempts", res.Attempts).
+		Int64("size", res.Size).Bool("cached", res.Cached).Msg("video downloaded")
+
+	// Count the transfer towards the task; videos served from the cache were not downloaded again.
+	if !res.Cached {
+		ctl.addResourceUsage(ctx, pgsql.AddTaskResourceUsageParams{
+			TaskID:          taskID,
+			BytesDownloaded: res.Size,
+		})
+	}
+
+	// Count the CPU time of the media processing towards the task.
+	ctx, recordCPU := ctl.meterTask(ctx, taskID)
+	defer recordCPU()
+
+	// Upload the video and extract the audio and the previews.
+	videoFile, derived, err := ctl.uploadVideo(ctx, taskID, tmpFile, res.MD5)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload video: %w", err)
+	}
+
+	return ctl.createTaskForVideo(ctx, taskInput{
+		derivedMedia: derived,
+		TaskID:       taskID,
+		VideoFile:    videoFile,
+		Filename:     filename,
+		Hash:         res.MD5,
+		Source:       src,
+		Verification: res.Verification,
+	})
+}
+
+// GetUploadURL reserves an object key in the video bucket and returns a presigned URL
+// the client can PUT the video to directly, bypassing the BFF.
+func (ctl *TaskController) GetUploadURL(ctx context.Context) (objectKey, url string, err error) {
+	// Reserve the task the upload will belong to.
+	taskID, err := ctl.pgConn.ReserveTaskID(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to reserve task id: %w", err)
+	}
+
+	// Generate a staging key under the task; the object is moved to its content key on task creation.
+	objectKey = objectkey.New(taskID, objectkey.KindUpload, xid.New().String(), ".mp4")
+
+	// Presign a PUT request for the key in the video bucket.
+	url, err = ctl.storage.GetUploadURL(ctx, objectKey, ctl.storage.GetVideoBucketName(), uploadURLExpiry)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to presign upload url: %w", err)
+	}
+
+	// Return the object key and the presigned URL.
+	return objectKey, url, nil
+}
+
+// CreateTaskFromObject creates a new task for a video that was already uploaded to the video bucket.
+func (ctl *TaskController) CreateTaskFromObject(ctx context.Context, objectKey, filename string, src model.Source) (_ int64, err error) {
+	// Only staging keys handed out by GetUploadURL are accepted.
+	key, err := objectkey.Parse(objectKey)
+	if err != nil || key.Kind != objectkey.KindUpload {
+		return 0, ErrObjectNotFound
+	}
+
+	// Reject the task if the account has used up its quota, and give the task back if it is not created.
+	if err := ctl.reserveQuota(ctx, src.Account); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.releaseQuota(ctx, src.Account)
+		}
+	}()
+
+	// Make sure the object was actually uploaded.
+	exist, err := ctl.storage.IsFileExist(ctx, objectKey, ctl.storage.GetVideoBucketName())
+	if err != nil {
+		return 0, fmt.Errorf("failed to check object: %w", err)
+	}
+	if !exist {
+		return 0, ErrObjectNotFound
+	}
+
+	if filename == "" {
+		filename = objectKey
+	}
+
+	// Record the task, so its progress is visible while the video is prepared.
+	if err := ctl.startTask(ctx, key.TaskID, filename, src); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			ctl.abandonTask(ctx, key.TaskID, err)
+		}
+	}()
+
+	// Count the CPU time of the media processing towards the task.
+	ctx, recordCPU := ctl.meterTask(ctx, key.TaskID)
+	defer recordCPU()
+
 	// Calculate the hash for the uploaded video.
-	hash, err := ctl.getHashFromVideo(context.Background(), videoFile, ctl.minioClient.GetVideoBucketName())
+	hash, full, err := ctl.hashStoredVideo(ctx, objectKey, ctl.storage.GetVideoBucketName())
 	if err != nil {
 		return 0, fmt.Errorf("failed to calculate hash for video: %w", err)
 	}
 
-	// Retrieve original videos with the same hash from the database.
-	videos, err := ctl.pgConn.GetOrigVideosByHash(context.Background(), pgtype.Text{
-		String: hash,
-		Valid:  true,
+	// A sampled hash that matches no original rules out exact duplicates, so the lookup is skipped.
+	dedupHash := hash
+	if !full {
+		dedupHash = ""
+	}
+
+	// Move the upload to the key of its content.
+	videoFile, err := ctl.storeUpload(ctx, key, objectKey, hash, full)
+	if err != nil {
+		return 0, err
+	}
+
+	// Generate an audio file and the previews from the uploaded video, remuxed to MP4 when needed.
+	videoFile, derived, err := ctl.generateAudio(ctx, key.TaskID, videoFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate audio from video: %w", err)
+	}
+
+	return ctl.createTaskForVideo(ctx, taskInput{
+		derivedMedia: derived,
+		TaskID:       key.TaskID,
+		VideoFile:    videoFile,
+		Filename:     filename,
+		Hash:         dedupHash,
+		Source:       src,
+	})
+}
+
+// storeUpload moves an uploaded video to its content key, or drops it when identical content is stored
+// already, and returns the key the video is stored under. A sampled hash may be shared by different
+// videos, so such an upload is kept under its task instead.
+func (ctl *TaskController) storeUpload(ctx context.Context, key objectkey.Key, objectKey, hash string, full bool) (string, error) {
+	bucket := ctl.storage.GetVideoBucketName()
+	if !full {
+		videoFile := objectkey.New(key.TaskID, objectkey.KindVideo, hash, path.Ext(key.Name))
+		if err := ctl.storage.MoveFile(ctx, objectKey, videoFile, bucket); err != nil {
+			return "", fmt.Errorf("failed to move uploaded video: %w", err)
+		}
+
+		return videoFile, nil
+	}
+
+	videoFile := objectkey.Content(objectkey.KindVideo, hash, path.Ext(key.Name))
+	moved, err := ctl.storeObject(ctx, videoFile, bucket, func() error {
+		return ctl.storage.MoveFile(ctx, objectKey, videoFile, bucket)
 	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to compare hash with original videos: %w", err)
+		return "", fmt.Errorf("failed to move uploaded video: %w", err)
+	}
+	if !moved {
+		ctl.removeObject(ctx, objectKey, bucket)
 	}
 
-	// If there are existing videos with the same hash, create a new task with status done.
-	if len(videos) != 0 {
-		// Create a new task with the status set to done.
-		task, errC := ctl.pgConn.CreateTask(context.Background(), pgsql.CreateTaskParams{
-			VideoFile: pgtype.Text{String: videoFile, Valid: true},
-			AudioFile: pgtype.Text{String: audioFile, Valid: true},
-			PreviewID: pgtype.Text{String: "aaa", Valid: true},
-			Status:    pgsql.NullTaskStatus{TaskStatus: pgsql.TaskStatusDone, Valid: true},
-			VideoName: pgtype.Text{String: filename, Valid: true},
+	return videoFile, nil
+}
+
+// startTask records a reserved task as uploading; createTaskForVideo completes it once its media is stored.
+func (ctl *TaskController) startTask(ctx context.Context, taskID int64, filename string, src model.Source) error {
+	if err := ctl.pgConn.StartTask(ctx, pgsql.StartTaskParams{
+		TaskID:     taskID,
+		VideoName:  pgtype.Text{String: filename, Valid: true},
+		SourceIp:   optionalText(src.IP),
+		UserAgent:  optionalText(src.UserAgent),
+		ApiKeyID:   optionalText(src.APIKeyID),
+		DeadlineAt: ctl.deadline(),
+		Priority:   priorityToPG(src.Priority),
+	}); err != nil {
+		return fmt.Errorf("failed to start task: %w", err)
+	}
+
+	// Time the upload of the video from now.
+	ctl.advanceStage(ctx, taskID, "", model.StageUpload)
+
+	return nil
+}
+
+// setStage records the pipeline stage a task reached; a failure is only logged, as the stage is informative.
+func (ctl *TaskController) setStage(ctx context.Context, taskID int64, stage pgsql.TaskStage) {
+	if err := ctl.pgConn.SetTaskStage(ctx, pgsql.SetTaskStageParams{
+		TaskID: taskID,
+		Stage:  stage,
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Str("stage", string(stage)).Msg("set task stage failed")
+	}
+}
+
+// abandonTask fails a started task whose media could not be prepared, keeping the stage it failed in.
+func (ctl *TaskController) abandonTask(ctx context.Context, taskID int64, cause error) {
+	// Record the failure even when the request that created the task was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	if _, err := ctl.pgConn.MarkTaskFailed(ctx, pgsql.MarkTaskFailedParams{
+		TaskID:        taskID,
+		FailureReason: pgtype.Text{String: cause.Error(), Valid: true},
+	}); err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("fail abandoned task failed")
+		return
+	}
+
+	ctl.completions.notify(taskID)
+}
+
+// createTaskForVideo creates the reserved task for a video and audio file already stored in Minio.
+func (ctl *TaskController) createTaskForVideo(ctx context.Context, in taskInput) (int64, error) {
+	// Retrieve original videos with the same hash from the database.
+	var videos []pgsql.Origvideo
+	if in.Hash != "" {
+		var err error
+		videos, err = ctl.pgConn.GetOrigVideosByHash(ctx, pgtype.Text{
+			String: in.Hash,
+			Valid:  true,
 		})
-		if errC != nil {
-			return 0, fmt.Errorf("create task failed: %w", err)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compare hash with original videos: %w", err)
 		}
+	}
 
-		// Prepare the copyright information for the existing video.
+	// Pick the original an exact copy duplicates, or else the one a re-encoded copy looks like.
+	var match originalMatch
+	var matched bool
+	if len(videos) != 0 {
+		match = originalMatch{VideoID: videos[0].VideoID.String, Probability: 1, Kind: "exact_match"}
+		matched = true
+	} else if digest := in.Hashes[phash.Algorithm]; digest != "" {
+		var err error
+		match, matched, err = ctl.findPerceptualMatch(ctx, digest)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compare perceptual hash with original videos: %w", err)
+		}
+	}
+
+	// Determine the reference index version the task is checked against.
+	indexVersion, err := ctl.activeIndexVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Record the task under the trace of the request, or start a trace for its processing.
+	traceID := tracing.TraceID(ctx)
+	if traceID == "" {
+		traceID = tracing.NewTraceID()
+	}
+
+	// If the video duplicates an original, create a new task with status done.
+	if matched {
+		// Prepare the copyright information for the original video.
 		c := model.Copyright{
-			Name:        videos[0].VideoID.String,
-			Probability: 1,
+			Name:        match.VideoID,
+			Probability: match.Probability,
 		}
 
 		// Marshal the copyright information to JSON.
 		copyright, errC := json.Marshal(c)
 		if errC != nil {
-			return 0, fmt.Errorf("failed to marshal copyright to json: %w", err)
+			return 0, fmt.Errorf("failed to marshal copyright to json: %w", errC)
 		}
 
-		// Update the video and audio copyright for the task.
-		if errC = ctl.pgConn.UpdateTaskVideoCopyright(context.Background(), pgsql.UpdateTaskVideoCopyrightParams{
+		// Create the task and store its copyright in one transaction, so it is never left done without it.
+		tx, errC := ctl.pgPool.Begin(ctx)
+		if errC != nil {
+			return 0, fmt.Errorf("begin transaction failed: %w", errC)
+		}
+		defer func() {
+			_ = tx.Rollback(ctx)
+		}()
+
+		q := ctl.pgConn.WithTx(tx)
+
+		// Attribute the task to its creator in the task history.
+		if errC := attribute(ctx, q, model.AuditTaskCreated); errC != nil {
+			return 0, errC
+		}
+
+		// Create a new task with the status set to done.
+		task, errC := q.CreateTask(ctx, pgsql.CreateTaskParams{
+			TaskID:               in.TaskID,
+			VideoFile:            pgtype.Text{String: in.VideoFile, Valid: true},
+			AudioFile:            pgtype.Text{String: in.AudioFile, Valid: true},
+			PreviewID:            optionalText(in.Preview.Image),
+			Status:               pgsql.NullTaskStatus{TaskStatus: pgsql.TaskStatusDone, Valid: true},
+			VideoName:            pgtype.Text{String: in.Filename, Valid: true},
+			IndexVersion:         pgtype.Text{String: indexVersion, Valid: true},
+			SourceIp:             optionalText(in.Source.IP),
+			UserAgent:            optionalText(in.Source.UserAgent),
+			ApiKeyID:             optionalText(in.Source.APIKeyID),
+			DownloadVerification: optionalText(in.Verification),
+			TraceID:              optionalText(traceID),
+			Stage:                pgsql.TaskStageDone,
+			Priority:             priorityToPG(in.Source.Priority),
+			DurationSeconds:      optionalSeconds(in.Video.Duration),
+			Width:                optionalInt4(in.Video.Width),
+			Height:               optionalInt4(in.Video.Height),
+			FileSize:             optionalInt8(in.Video.Size),
+			ContentHash:          optionalText(in.Video.ContentHash),
+		})
+		if errC != nil {
+			return 0, fmt.Errorf("create task failed: %w", errC)
+		}
+
+		// Update the video and audio copyright for the task, unless an admin action changed it meanwhile.
+		n, errC := q.UpdateTaskVideoCopyright(ctx, pgsql.UpdateTaskVideoCopyrightParams{
 			TaskID:         task.TaskID,
 			VideoCopyright: copyright,
-		}); errC != nil {
-			return 0, fmt.Errorf("failed to update task copyright: %w", err)
+			Version:        task.Version,
+		})
+		if errC != nil {
+			return 0, fmt.Errorf("failed to update task copyright: %w", errC)
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("%w: %d", ErrConcurrentUpdate, task.TaskID)
 		}
 
-		if errC = ctl.pgConn.UpdateTaskAudioCopyright(context.Background(), pgsql.UpdateTaskAudioCopyrightParams{
+		n, errC = q.UpdateTaskAudioCopyright(ctx, pgsql.UpdateTaskAudioCopyrightParams{
 			TaskID:         task.TaskID,
 			AudioCopyright: copyright,
-		}); errC != nil {
-			return 0, fmt.Errorf("failed to update task copyright: %w", err)
+			Version:        task.Version + 1,
+		})
+		if errC != nil {
+			return 0, fmt.Errorf("failed to update task copyright: %w", errC)
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("%w: %d", ErrConcurrentUpdate, task.TaskID)
+		}
+
+		if errC := tx.Commit(ctx); errC != nil {
+			return 0, fmt.Errorf("commit transaction failed: %w", errC)
+		}
+
+		// The task is decided without the ML services once its media are prepared.
+		ctl.advanceStage(ctx, task.TaskID, model.StageAudioExtraction, "")
+
+		// Count the media of the task against the quota of its account.
+		ctl.recordUsage(ctx, in.Source.Account, in.Media)
+
+		// Store the digests and the metadata of the video for lookups and link its sprite sheet.
+		ctl.recordHashes(ctx, task.TaskID, in.Hashes)
+		ctl.recordAudioFingerprint(ctx, task.TaskID, in.AudioFingerprint)
+		ctl.recordMetadata(ctx, task.TaskID, in.Metadata)
+		ctl.recordSprite(ctx, task.TaskID, in.Preview)
+
+		// Store the scenes of the video; a failure is only logged, as the task is decided already.
+		if err := insertScenes(ctx, ctl.pgConn, task.TaskID, in.Scenes); err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", task.TaskID).Msg("failed to record scenes")
 		}
 
+		// Record the task and its decision by the match in the audit log.
+		ctl.recordAudit(ctx, model.AuditTaskCreated, task.TaskID, taskAuditDetails(in, indexVersion))
+		ctl.recordAudit(ctx, model.AuditTaskDecided, task.TaskID, map[string]any{
+			match.Kind: match.VideoID,
+		})
+
 		// Return the task ID.
 		return task.TaskID, nil
 	}
 
-	// If no existing videos with the same hash are found, create a new task with status in progress.
-	task, err := ctl.pgConn.CreateTask(context.Background(), pgsql.CreateTaskParams{
+	// Answer the audio check right away when the audio copies an original; the audio ML service
+	// checks it otherwise, also when the lookup fails.
+	var audioMatch originalMatch
+	var audioMatched bool
+	if len(in.AudioFingerprint) != 0 {
+		var err error
+		audioMatch, audioMatched, err = ctl.findAudioMatch(ctx, in.AudioFingerprint)
+		if err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", in.TaskID).Msg("failed to compare audio fingerprint with original videos")
+		}
+	}
+
+	// If the video duplicates no original, create a new task with status in progress
+	// together with its requests to the ML services, so it cannot be left without them.
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Attribute the task to its creator in the task history.
+	if err := attribute(ctx, q, model.AuditTaskCreated); err != nil {
+		return 0, err
+	}
+
+	task, err := q.CreateTask(ctx, pgsql.CreateTaskParams{
+		TaskID: in.TaskID,
 		VideoFile: pgtype.Text{
-			String: videoFile,
-			Valid:  true,
-		},
-		AudioFile: pgtype.Text{String: audioFile, Valid: true},
-		PreviewID: pgtype.Text{
-			String: "aaa",
+			String: in.VideoFile,
 			Valid:  true,
 		},
+		AudioFile: pgtype.Text{String: in.AudioFile, Valid: true},
+		PreviewID: optionalText(in.Preview.Image),
 		Status: pgsql.NullTaskStatus{
 			TaskStatus: pgsql.TaskStatusInProgress,
 			Valid:      true,
 		},
 		VideoName: pgtype.Text{
-			String: filename,
+			String: in.Filename,
+			Valid:  true,
+		},
+		IndexVersion: pgtype.Text{
+			String: indexVersion,
 			Valid:  true,
 		},
+		SourceIp:             optionalText(in.Source.IP),
+		UserAgent:            optionalText(in.Source.UserAgent),
+		ApiKeyID:             optionalText(in.Source.APIKeyID),
+		DownloadVerification: optionalText(in.Verification),
+		TraceID:              optionalText(traceID),
+		DeadlineAt:           ctl.deadline(),
+		Stage:                pgsql.TaskStageQueued,
+		Priority:             priorityToPG(in.Source.Priority),
+		DurationSeconds:      optionalSeconds(in.Video.Duration),
+		Width:                optionalInt4(in.Video.Width),
+		Height:               optionalInt4(in.Video.Height),
+		FileSize:             optionalInt8(in.Video.Size),
+		ContentHash:          optionalText(in.Video.ContentHash),
 	})
 	if err != nil {
 		return 0, fmt.Errorf("create task failed: %w", err)
 	}
 
-	// Start a goroutine to check for copyright infringement.
-	go func() {
-		if err := ctl.checkForCopyright(context.Background(), task); err != nil {
-			ctl.log.Error().Err(err).Any("task", task).Msg("check for copyright failed")
+	// The media of the task are prepared; its checks are timed from their requests on.
+	if err := finishStage(ctx, q, task.TaskID, model.StageAudioExtraction); err != nil {
+		return 0, err
+	}
+
+	// Store the scenes of the video with the task, so their keyframes are sent along to the ML service.
+	if err := insertScenes(ctx, q, task.TaskID, in.Scenes); err != nil {
+		return 0, err
+	}
+
+	// Store the audio result of a matched audio with the task and request the video check only.
+	if audioMatched {
+		result, err := audioResult(task.TaskID, audioMatch, true)
+		if err != nil {
+			return 0, err
 		}
-	}()
+		if _, err := q.ApplyTaskAudioCopyright(ctx, pgsql.ApplyTaskAudioCopyrightParams{
+			TaskID:         task.TaskID,
+			AudioCopyright: result,
+			Version:        task.Version,
+		}); err != nil {
+			return 0, fmt.Errorf("update task audio copyright failed: %w", err)
+		}
+		if err := enqueueModality(ctx, q, task.TaskID, task.Priority, model.ModalityVideo); err != nil {
+			return 0, err
+		}
+	} else if err := enqueueCopyrightCheck(ctx, q, task.TaskID, task.Priority); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// Send the task to the ML services in the background.
+	ctl.wakeRelay()
+	if audioMatched {
+		audioFingerprintAnswers.WithLabelValues("match").Inc()
+	}
+
+	// Count the media of the task against the quota of its account.
+	ctl.recordUsage(ctx, in.Source.Account, in.Media)
+
+	// Store the digests and the metadata of the video for lookups and link its sprite sheet.
+	ctl.recordHashes(ctx, task.TaskID, in.Hashes)
+	ctl.recordAudioFingerprint(ctx, task.TaskID, in.AudioFingerprint)
+	ctl.recordMetadata(ctx, task.TaskID, in.Metadata)
+	ctl.recordSprite(ctx, task.TaskID, in.Preview)
+
+	// Record the task in the audit log, with the original its audio copies.
+	details := taskAuditDetails(in, indexVersion)
+	if audioMatched {
+		details[audioMatch.Kind] = audioMatch.VideoID
+	}
+	ctl.recordAudit(ctx, model.AuditTaskCreated, task.TaskID, details)
 
 	// Return the task ID.
 	return task.TaskID, nil
 }
 
-// checkForCopyright checks for copyright infringement for a given task.
-func (ctl *TaskController) checkForCopyright(ctx context.Context, task pgsql.Task) error {
-	// Update the task status to "in progress" in the database.
-	if err := ctl.pgConn.UpdateTaskStatus(ctx, pgsql.UpdateTaskStatusParams{
-		TaskID: task.TaskID,
-		Status: pgsql.NullTaskStatus{
-			TaskStatus: pgsql.TaskStatusInProgress,
-			Valid:      true,
-		},
-	}); err != nil {
-		return fmt.Errorf("failed to update task status: %w", err)
-	}
-
-	// Get the URL for the audio file from Minio.
-	audioUrl, err := ctl.minioClient.GetFileURL(ctx, task.AudioFile.String, ctl.minioClient.GetAudioBucketName())
-	if err != nil {
-		return fmt.Errorf("failed to get audio url: %w", err)
+// taskAuditDetails describes a created task in the audit log.
+func taskAuditDetails(in taskInput, indexVersion string) map[string]any {
+	return map[string]any{
+		"video_name":    in.Filename,
+		"video_hash":    in.Hash,
+		"index_version": indexVersion,
+		"source_ip":     in.Source.IP,
+		"user_agent":    in.Source.UserAgent,
+		"api_key_id":    in.Source.APIKeyID,
+		"priority":      priorityToPG(in.Source.Priority),
 	}
+}
 
-	// Get the URL for the video file from Minio.
-	videoUrl, err := ctl.minioClient.GetFileURL(ctx, task.VideoFile.String, ctl.minioClient.GetVideoBucketName())
-	if err != nil {
-		return fmt.Errorf("failed to get video url: %w", err)
+// sendModality writes the link to the media of one modality to the input topic of its ML service.
+func (ctl *TaskController) sendModality(ctx context.Context, task pgsql.Task, modality model.Modality) error {
+	// Pick the stored file and the topic of the modality.
+	var object, bucket, topic string
+	switch modality {
+	case model.ModalityAudio:
+		object, bucket, topic = task.AudioFile.String, ctl.storage.GetAudioBucketName(), ctl.cfg.Kafka.AudioInputTopic
+	case model.ModalityVideo:
+		object, bucket, topic = task.VideoFile.String, ctl.storage.GetVideoBucketName(), ctl.cfg.Kafka.VideoInputTopic
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownModality, modality)
 	}
 
-	// Marshal the audio URL into a JSON message for Kafka.
-	bodyAudio, err := json.Marshal(model.KafkaLink{
-		Link:   audioUrl,
-		TaskID: task.TaskID,
-	})
+	// Get the URL for the file from Minio.
+	url, err := ctl.storage.GetFileURL(ctx, object, bucket)
 	if err != nil {
-		return fmt.Errorf("failed to marshal kafka link: %w", err)
+		return fmt.Errorf("failed to get %s url: %w", modality, err)
 	}
 
-	// Write the audio URL message to the audio input Kafka topic.
-	if err := ctl.producer.WriteMessages(ctx, kafka.Message{
-		Topic: ctl.cfg.Kafka.AudioInputTopic,
-		Value: bodyAudio,
-	}); err != nil {
-		return fmt.Errorf("failed to write message to audio topic: %w", err)
+	// Attach the keyframes of the scenes for the video-copy service.
+	var keyframes []model.KafkaKeyframe
+	if modality == model.ModalityVideo {
+		if keyframes, err = ctl.keyframeLinks(ctx, task.TaskID); err != nil {
+			return err
+		}
 	}
 
-	// Marshal the video URL into a JSON message for Kafka.
-	bodyVideo, err := json.Marshal(model.KafkaLink{
-		Link:   videoUrl,
-		TaskID: task.TaskID,
+	// Encode the URL into a message for Kafka in the configured format.
+	body, err := ctl.codec.EncodeLink(ctx, topic, model.KafkaLink{
+		Link:         url,
+		TaskID:       task.TaskID,
+		IndexVersion: task.IndexVersion.String,
+		Keyframes:    keyframes,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal kafka link: %w", err)
+		return fmt.Errorf("failed to encode kafka link: %w", err)
 	}
 
-	// Write the video URL message to the video input Kafka topic.
+	// Write the URL message to the input topic.
 	if err := ctl.producer.WriteMessages(ctx, kafka.Message{
-		Topic: ctl.cfg.Kafka.VideoInputTopic,
-		Value: bodyVideo,
+		Topic:   topic,
+		Key:     taskKey(task.TaskID),
+		Value:   body,
+		Headers: append(traceHeaders(task), kafka.Header{Key: priorityHeader, Value: []byte(task.Priority)}),
 	}); err != nil {
-		return fmt.Errorf("failed to write message to video topic: %w", err)
+		return fmt.Errorf("failed to write message to %s topic: %w", modality, err)
 	}
 
-	// Return nil if all steps are successful.
+	// Count the request towards the task, retries included.
+	ctl.addResourceUsage(ctx, pgsql.AddTaskResourceUsageParams{
+		TaskID:     task.TaskID,
+		MlRequests: 1,
+	})
+
 	return nil
 }
 
 // GetTask retrieves a task by its ID.
-func (ctl *TaskController) GetTask(_ context.Context, id int64) (model.Task, error) {
+func (ctl *TaskController) GetTask(ctx context.Context, id int64) (model.Task, error) {
 	// Retrieve the task from the database using the provided ID.
-	pgtask, err := ctl.pgConn.GetTask(context.Background(), id)
+	pgtask, err := ctl.pgConn.GetTask(ctx, id)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Task{}, ErrTaskNotFound
+		}
 		return model.Task{}, fmt.Errorf("get task failed: %w", err)
 	}
 
+	// A deleted task is only seen through the admin API until it is restored.
+	if pgtask.DeletedAt.Valid {
+		return model.Task{}, ErrTaskNotFound
+	}
+
 	// Convert the retrieved task from the database model to the application model.
 	task, err := taskToModel(pgtask)
 	if err != nil {
 		return model.Task{}, err
 	}
 
+	// Estimate how many pending tasks are ahead of this one, itself included.
+	if task.Status == model.TaskStatusInProgress {
+		task.QueuePosition, err = ctl.pgConn.GetTaskQueuePosition(ctx, id)
+		if err != nil {
+			return model.Task{}, fmt.Errorf("get queue position failed: %w", err)
+		}
+	}
+
+	// Flag the pending modalities that missed their SLA.
+	task.Overdue, err = ctl.overdueModalities(ctx, task)
+	if err != nil {
+		return model.Task{}, err
+	}
+
 	// Return the converted task.
 	return task, nil
 }
 
-// GetTasks retrieves a list of tasks with pagination.
-func (ctl *TaskController) GetTasks(_ context.Context, limit, offset uint64) ([]model.Task, int64, error) {
-	// Retrieve the tasks from the database with the specified limit and offset for pagination.
-	pgtasks, err := ctl.pgConn.GetTasks(context.Background(), pgsql.GetTasksParams{
-		Limit:  int32(limit),
-		Offset: int32(offset),
-	})
+// GetTasks retrieves a page of tasks using keyset pagination on the task ID, newest first when asked.
+// An empty cursor starts from the first task; the returned cursor is empty on the last page.
+func (ctl *TaskController) GetTasks(ctx context.Context, limit uint64, cursor string, newestFirst bool) ([]model.Task, string, error) {
+	// Decode the cursor into the last task ID of the previous page.
+	after, err := decodeCursor(cursor)
 	if err != nil {
-		return nil, 0, fmt.Errorf("get tasks failed: %w", err)
+		return nil, "", err
 	}
 
-	// Convert the retrieved tasks from the database model to the application model.
-	tasks, err := taskSliceToModel(pgtasks)
+	// Retrieve one task more than requested to find out whether there is a next page.
+	var pgtasks []pgsql.Task
+	if newestFirst {
+		if cursor == "" {
+			after = math.MaxInt64
+		}
+		pgtasks, err = ctl.pgConn.GetTasksDesc(ctx, pgsql.GetTasksDescParams{
+			TaskID: after,
+			Limit:  int32(limit + 1),
+		})
+	} else {
+		pgtasks, err = ctl.pgConn.GetTasks(ctx, pgsql.GetTasksParams{
+			TaskID: after,
+			Limit:  int32(limit + 1),
+		})
+	}
 	if err != nil {
-		return nil, 0, err
+		return nil, "", fmt.Errorf("get tasks failed: %w", err)
+	}
+
+	// Build the cursor for the next page if there are more tasks.
+	var next string
+	if uint64(len(pgtasks)) > limit {
+		pgtasks = pgtasks[:limit]
+		next = encodeCursor(pgtasks[len(pgtasks)-1].TaskID)
 	}
 
-	// Get the total count of tasks in the database.
-	total, err := ctl.pgConn.GetTasksCount(context.Background())
+	// Convert the retrieved tasks from the database model to the application model.
+	tasks, err := taskSliceToModel(pgtasks)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get tasks count: %w", err)
+		return nil, "", err
 	}
 
-	// Return the list of tasks and the total count.
-	return tasks, total, nil
+	// Return the page of tasks and the next cursor.
+	return tasks, next, nil
 }
 
-// makePreviewUploadVideo uploads a video file, generates an audio file, and creates a preview image.
-func (ctl *TaskController) makePreviewUploadVideo(ctx context.Context, file io.Reader) (videoID, audioID string, err error) {
-	// Generate a unique ID for the video file.
-	id := xid.New().String() + ".mp4"
+// makePreviewUploadVideo streams an uploaded video to storage and generates its audio file and previews.
+// It returns the object key and the MD5 hash of the video, and what is derived from it. The upload is
+// never spooled to disk: it is stored under a staging key while it is hashed, then moved to its content key.
+func (ctl *TaskController) makePreviewUploadVideo(ctx context.Context, taskID int64, file io.Reader) (videoID, hash string, derived derivedMedia, err error) {
+	// Reject obvious non-videos by their head before anything is stored.
+	br := bufio.NewReaderSize(file, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", "", derivedMedia{}, fmt.Errorf("failed to read upload head: %w", err)
+	}
+	if err := sniffVideo(head); err != nil {
+		return "", "", derivedMedia{}, err
+	}
 
-	// Create a temporary file to store the uploaded video.
-	tmpFile, err := os.CreateTemp("", "")
+	// Stream the upload to a staging key, hashing it and enforcing the size limit on the way.
+	bucket := ctl.storage.GetVideoBucketName()
+	staging := objectkey.New(taskID, objectkey.KindUpload, xid.New().String(), ".mp4")
+	h := md5.New()
+	body := io.TeeReader(&limitReader{r: br, limit: ctl.cfg.Upload.MaxSize}, h)
+	if err := ctl.storage.UploadFile(ctx, body, -1, staging, bucket); err != nil {
+		ctl.removeObject(ctx, staging, bucket)
+		return "", "", derivedMedia{}, fmt.Errorf("failed to upload video to minio: %w", err)
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+
+	// Move the upload to its content key, or drop it when identical content is stored already.
+	videoID = objectkey.Content(objectkey.KindVideo, hash, ".mp4")
+	moved, err := ctl.storeObject(ctx, videoID, bucket, func() error {
+		return ctl.storage.MoveFile(ctx, staging, videoID, bucket)
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create temporary file: %w", err)
+		ctl.removeObject(ctx, staging, bucket)
+		return "", "", derivedMedia{}, fmt.Errorf("failed to move uploaded video: %w", err)
+	}
+	if !moved {
+		ctl.removeObject(ctx, staging, bucket)
 	}
 
-	// Copy the uploaded file to the temporary file.
-	if _, err = io.Copy(tmpFile, file); err != nil {
-		return "", "", fmt.Errorf("io.Copy failed: %w", err)
+	// Validate the stored video and extract the audio; a rejected video is not kept.
+	processed, derived, err := ctl.generateAudio(ctx, taskID, videoID)
+	if err != nil {
+		ctl.releaseObject(ctx, videoID, bucket)
+		return "", "", derivedMedia{}, fmt.Errorf("failed to generate audio from video: %w", err)
 	}
 
-	// Ensure the temporary file is removed after processing.
-	defer func() {
-		if errDef := os.Remove(tmpFile.Name()); errDef != nil {
-			ctl.log.Error().Err(errDef).Str("path", tmpFile.Name()).Msg("failed to remove tmp file")
-		}
-	}()
+	// Return the key of the processed video, the hash of the upload and what was derived from the video.
+	return processed, hash, derived, nil
+}
+
+// removeObject deletes a staging object of a task; a failure is only logged.
+func (ctl *TaskController) removeObject(ctx context.Context, objectName, bucketName string) {
+	if err := ctl.storage.RemoveFile(context.WithoutCancel(ctx), objectName, bucketName); err != nil {
+		ctl.logger(ctx).Error().Err(err).Str("object", objectName).Str("bucket", bucketName).Msg("failed to remove object")
+	}
+}
+
+// uploadVideo uploads a spooled video under its content key and generates its audio and previews.
+// It returns the object key of the video and what is derived from it.
+func (ctl *TaskController) uploadVideo(ctx context.Context, taskID int64, tmpFile *os.File, hash string) (videoID string, derived derivedMedia, err error) {
+	// Reject payloads that are not videos or exceed the limits before anything is stored.
+	if _, _, err = ctl.validateVideo(ctx, tmpFile); err != nil {
+		return "", derivedMedia{}, err
+	}
 
 	// Get the metadata of the temporary file.
 	stat, err := tmpFile.Stat()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get file metainfo: %w", err)
+		return "", derivedMedia{}, fmt.Errorf("failed to get file metainfo: %w", err)
 	}
 
 	// Reset the file pointer to the beginning of the file.
 	if _, err = tmpFile.Seek(0, 0); err != nil {
-		return "", "", fmt.Errorf("failed to reset reader tmpfile: %w", err)
+		return "", derivedMedia{}, fmt.Errorf("failed to reset reader tmpfile: %w", err)
 	}
 
-	// Upload the video file to Minio.
-	if err = ctl.minioClient.UploadFile(context.Background(), tmpFile, stat.Size(), id, ctl.minioClient.GetVideoBucketName()); err != nil {
-		return "", "", fmt.Errorf("failed to upload video to minio: %w", err)
+	// Upload the video file to Minio under its content key, unless identical content is stored already.
+	id := objectkey.Content(objectkey.KindVideo, hash, ".mp4")
+	bucket := ctl.storage.GetVideoBucketName()
+	if _, err = ctl.storeObject(ctx, id, bucket, func() error {
+		return ctl.storage.UploadFile(ctx, tmpFile, stat.Size(), id, bucket)
+	}); err != nil {
+		return "", derivedMedia{}, fmt.Errorf("failed to upload video to minio: %w", err)
 	}
 
-	// Generate an audio file from the video.
-	audioFile, err := ctl.generateAudio(ctx, id)
+	// Generate an audio file and the previews from the video, remuxed to MP4 when needed.
+	id, derived, err = ctl.generateAudio(ctx, taskID, id)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate audio from video: %w", err)
+		return "", derivedMedia{}, fmt.Errorf("failed to generate audio from video: %w", err)
 	}
 
-	// Return the video ID, audio file ID, preview image ID, and video length.
-	return id, audioFile, nil
+	return id, derived, nil
 }
 
 // getHashFromVideo calculates the MD5 hash of a video file stored in Minio.
 func (ctl *TaskController) getHashFromVideo(ctx context.Context, id, bucket string) (string, error) {
 	// Get a reader for the video file from Minio.
-	rdr, err := ctl.minioClient.GetFileReader(ctx, id, bucket)
+	rdr, err := ctl.storage.GetFileReader(ctx, id, bucket)
 	if err != nil {
 		return "", fmt.Errorf("failed to get reader from minio: %w", err)
 	}
+	defer rdr.Close()
 
+	return md5Hex(rdr)
+}
+
+// md5Hex returns the hexadecimal MD5 hash of the reader content.
+func md5Hex(r io.Reader) (string, error) {
 	// Create a new MD5 hash instance.
 	h := md5.New()
 
-	// Copy the video file content to the hash instance.
-	if _, err := io.Copy(h, rdr); err != nil {
-		log.Fatal(err)
+	// Copy the content to the hash instance.
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
 	}
 
 	// Return the hexadecimal representation of the hash.
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// generateAudio generates an audio file from a video file stored in Minio.
-func (ctl *TaskController) generateAudio(ctx context.Context, id string) (string, error) {
+// generateAudio generates an audio file and the preview images from a video file stored in Minio and uploads them.
+// It also returns the metadata of the video, its length, the bytes stored for the video and the audio,
+// and the digests of the video by the configured algorithms. A video in another supported container
+// is remuxed to MP4, which replaces it in storage; the key of the video processed further is returned.
+func (ctl *TaskController) generateAudio(ctx context.Context, taskID int64, id string) (videoID string, derived derivedMedia, err error) {
+	ctl.setStage(ctx, taskID, pgsql.TaskStageAudioExtraction)
+	ctl.advanceStage(ctx, taskID, model.StageUpload, model.StageAudioExtraction)
+
 	// Get a reader for the video file from Minio.
-	videoReader, err := ctl.minioClient.GetFileReader(context.Background(), id, ctl.minioClient.GetVideoBucketName())
+	videoReader, err := ctl.storage.GetFileReader(ctx, id, ctl.storage.GetVideoBucketName())
 	if err != nil {
-		return "", err
+		return "", derivedMedia{}, err
 	}
+	defer videoReader.Close()
 
-	// Create a temporary file to store the audio extracted from the video.
-	tmpfile, err := os.CreateTemp("", "*.wav")
+	// Create a temporary file in the workspace to store the video for audio extraction.
+	tmpfile, err := ctl.tempFS.CreateTemp("audio-extraction", "*.mp4")
 	if err != nil {
-		return "", err
+		return "", derivedMedia{}, err
 	}
 	// Ensure the temporary file is removed and closed after processing.
-	defer os.Remove(tmpfile.Name())
+	defer ctl.tempFS.Remove(tmpfile.Name())
 	defer tmpfile.Close()
 
-	// Copy the video file content to the temporary file.
-	if _, err = io.Copy(tmpfile, videoReader); err != nil {
-		return "", err
+	// Copy the video file content to the temporary file, computing all configured digests on the way.
+	digests, err := multihash.New(ctl.cfg.Hash.Algorithms)
+	if err != nil {
+		return "", derivedMedia{}, err
+	}
+	videoSize, err := io.Copy(io.MultiWriter(tmpfile, digests), videoReader)
+	if err != nil {
+		return "", derivedMedia{}, err
+	}
+
+	// Validate and measure the video; objects uploaded directly to storage are checked here first.
+	length, remux, err := ctl.validateVideo(ctx, tmpfile)
+	if err != nil {
+		return "", derivedMedia{}, err
+	}
+
+	// Remux a video in another container to MP4, the format the ML services expect; the remuxed video
+	// is released again should the rest fail, and replaces the stored one once all succeeded.
+	videoID = id
+	storedSize := videoSize
+	if remux {
+		videoID, storedSize, err = ctl.remuxVideo(ctx, tmpfile.Name())
+		if err != nil {
+			return "", derivedMedia{}, err
+		}
+		defer func() {
+			if err != nil {
+				ctl.releaseObject(ctx, videoID, ctl.storage.GetVideoBucketName())
+			} else {
+				ctl.releaseObject(ctx, id, ctl.storage.GetVideoBucketName())
+			}
+		}()
+	}
+
+	// Probe the properties of the video; the task goes on without them on failure.
+	metadata, err := ctl.probeVideo(ctx, tmpfile.Name())
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to probe video metadata")
+	}
+
+	// Hash the frames of the video to find re-encoded copies; the task goes on without the hash on failure.
+	sums := digests.Sums()
+	perceptual, err := ctl.perceptualHash(ctx, tmpfile.Name())
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to calculate perceptual hash")
+	} else if perceptual != "" {
+		sums[phash.Algorithm] = perceptual
 	}
 
 	// Extract the audio from the video file and get the audio file name.
-	audioFileName, err := ctl.ffmpegExec.GetAudioFromVideo(tmpfile.Name())
+	audioFileName, err := ctl.ffmpegFor(ctx).GetAudioFromVideo(tmpfile.Name())
 	if err != nil {
-		return "", err
+		return "", derivedMedia{}, err
+	}
+
+	// Track the extracted audio file and ensure it is removed after processing.
+	ctl.tempFS.Track("audio-extraction", audioFileName)
+	defer ctl.tempFS.Remove(audioFileName)
+
+	// Normalize the loudness of the audio when enabled; the audio is sent as extracted on failure.
+	if cfg := ctl.cfg.Loudness; cfg.Normalize {
+		normalized, err := ctl.ffmpegFor(ctx).NormalizeLoudness(audioFileName, ffmpeg.Loudness{
+			Integrated: cfg.Integrated,
+			TruePeak:   cfg.TruePeak,
+			Range:      cfg.Range,
+		})
+		if err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to normalize audio loudness")
+		} else {
+			ctl.tempFS.Track("audio-extraction", normalized)
+			defer ctl.tempFS.Remove(normalized)
+			audioFileName = normalized
+		}
 	}
 
 	// Open the extracted audio file.
 	audioFile, err := os.Open(audioFileName)
 	if err != nil {
-		return "", err
+		return "", derivedMedia{}, err
 	}
-	// Ensure the audio file is removed and closed after processing.
-	defer os.Remove(audioFileName)
 	defer audioFile.Close()
 
-	// Upload the audio file to Minio.
-	if err = ctl.minioClient.UploadFileFromOs(context.Background(), audioFileName, audioFileName, ctl.minioClient.GetAudioBucketName()); err != nil {
-		return "", fmt.Errorf("failed to upload audio to minio: %w", err)
+	// Fingerprint the audio to find copies without the audio ML service; the task goes on without it on failure.
+	fingerprint, err := ctl.audioFingerprint(ctx, audioFileName)
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to fingerprint audio")
+	}
+
+	// Hash the audio to build its content key.
+	hash, err := md5Hex(audioFile)
+	if err != nil {
+		return "", derivedMedia{}, err
 	}
 
-	// Return the audio file name.
-	return audioFileName, nil
+	// Get the size of the audio file.
+	stat, err := audioFile.Stat()
+	if err != nil {
+		return "", derivedMedia{}, err
+	}
+
+	// Upload the audio file to Minio under its content key, unless identical audio is stored already.
+	objectName := objectkey.Content(objectkey.KindAudio, hash, filepath.Ext(audioFileName))
+	bucket := ctl.storage.GetAudioBucketName()
+	if _, err = ctl.storeObject(ctx, objectName, bucket, func() error {
+		return ctl.storage.UploadFileFromOs(ctx, audioFileName, objectName, bucket)
+	}); err != nil {
+		return "", derivedMedia{}, fmt.Errorf("failed to upload audio to minio: %w", err)
+	}
+
+	// Capture the previews of the video; the task goes on without them on failure.
+	preview := ctl.generatePreviews(ctx, taskID, tmpfile.Name())
+
+	// Cut the video into scenes and store their keyframes; the task goes on without them on failure.
+	scenes, err := ctl.detectScenes(ctx, tmpfile.Name(), length)
+	if err != nil {
+		ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to detect scenes")
+	}
+
+	// Transcode the video for playback when enabled; the task goes on without it on failure.
+	if ctl.cfg.Playback.HLS {
+		if err := ctl.transcodeHLS(ctx, taskID, tmpfile.Name()); err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to transcode video for playback")
+		}
+	}
+
+	// Count the stored video and audio towards the task.
+	ctl.addResourceUsage(ctx, pgsql.AddTaskResourceUsageParams{
+		TaskID:      taskID,
+		BytesStored: storedSize + stat.Size(),
+	})
+
+	// Return the audio object name, the previews and scenes, the video metadata and digests, and the stored media.
+	return videoID, derivedMedia{
+		AudioFile:        objectName,
+		Preview:          preview,
+		Scenes:           scenes,
+		Metadata:         metadata,
+		Hashes:           sums,
+		AudioFingerprint: fingerprint,
+		Video: model.VideoProperties{
+			Duration:    length,
+			Width:       metadata.Width,
+			Height:      metadata.Height,
+			Size:        storedSize,
+			ContentHash: sums[multihash.MD5],
+		},
+		Media: model.Usage{
+			VideoSeconds: length.Seconds(),
+			Bytes:        storedSize + stat.Size(),
+		},
+	}, nil
 }
 
 // updateAudioLinkReq represents the request structure for updating an audio link in the database.
 type updateAudioLinkReq struct {
-	Link     string `json:"link"`
-	Filename string `json:"filename"`
+	Link         string `json:"link"`
+	Filename     string `json:"filename"`
+	IndexVersion string `json:"index_version,omitempty"`
 }
 
 // UploadToDatabaseAudio uploads the audio file link to the database.
@@ -584,52 +1697,33 @@ func (ctl *TaskController) UploadToDatabaseAudio(ctx context.Context, taskID int
 	}
 
 	// Get the URL for the audio file from Minio.
-	url, err := ctl.minioClient.GetFileURL(ctx, task.AudioFile.String, ctl.minioClient.GetAudioBucketName())
+	url, err := ctl.storage.GetFileURL(ctx, task.AudioFile.String, ctl.storage.GetAudioBucketName())
 	if err != nil {
 		return fmt.Errorf("get url failed: %w", err)
 	}
 
-	// Create the update request structure with the audio file URL and the video filename.
-	upd := updateAudioLinkReq{
-		Link:     url,
-		Filename: task.VideoName.String,
-	}
-
-	// Marshal the update request structure to JSON.
-	b, err := json.Marshal(upd)
-	if err != nil {
-		return fmt.Errorf("json marshal failed: %w", err)
-	}
-
-	// Create a new HTTP POST request to update the audio link in the database.
-	req, err := http.NewRequest(http.MethodPost, ctl.cfg.Wav2VecAddr+"/update_database", bytes.NewBuffer(b))
+	// Register the original in the currently active index version.
+	indexVersion, err := ctl.activeIndexVersion(ctx)
 	if err != nil {
-		return fmt.Errorf("create new request failed: %w", err)
+		return err
 	}
 
-	// Send the HTTP request and get the response.
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("make request failed: %w", err)
-	}
-
-	// Read the response body.
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("io read all failed: %w", err)
+	// Create the update request structure with the audio file URL and the video filename.
+	upd := updateAudioLinkReq{
+		Link:         url,
+		Filename:     task.VideoName.String,
+		IndexVersion: indexVersion,
 	}
 
-	// Log the response body for debugging purposes.
-	ctl.log.Info().Str("resp_body", string(respBody)).Msg("audio add to database")
-
-	// Return nil if all steps are successful.
-	return nil
+	// Register the original with the ML service and record the outcome.
+	return ctl.registerReference(ctx, taskID, model.ModalityAudio, ctl.cfg.Wav2VecAddr+"/update_database", upd)
 }
 
 // updateVideoLinkReq represents the request structure for updating a video link in the database.
 type updateVideoLinkReq struct {
-	URL  string `json:"url"`
-	UUID string `json:"uuid"`
+	URL          string `json:"url"`
+	UUID         string `json:"uuid"`
+	IndexVersion string `json:"index_version,omitempty"`
 }
 
 // UploadToDatabaseVideo uploads the video file link to the database.
@@ -641,44 +1735,24 @@ func (ctl *TaskController) UploadToDatabaseVideo(ctx context.Context, taskID int
 	}
 
 	// Get the URL for the audio file from Minio.
-	url, err := ctl.minioClient.GetFileURL(ctx, task.AudioFile.String, ctl.minioClient.GetAudioBucketName())
+	url, err := ctl.storage.GetFileURL(ctx, task.AudioFile.String, ctl.storage.GetAudioBucketName())
 	if err != nil {
 		return fmt.Errorf("get url failed: %w", err)
 	}
 
-	// Create the update request structure with the audio file URL and the video UUID.
-	upd := updateVideoLinkReq{
-		URL:  url,
-		UUID: task.VideoName.String,
-	}
-
-	// Marshal the update request structure to JSON.
-	b, err := json.Marshal(upd)
+	// Register the original in the currently active index version.
+	indexVersion, err := ctl.activeIndexVersion(ctx)
 	if err != nil {
-		return fmt.Errorf("json marshal failed: %w", err)
+		return err
 	}
 
-	// Create a new HTTP POST request to update the video link in the database.
-	req, err := http.NewRequest(http.MethodPost, ctl.cfg.VideocopyAddr+"/upload_video", bytes.NewBuffer(b))
-	if err != nil {
-		return fmt.Errorf("create new request failed: %w", err)
-	}
-
-	// Send the HTTP request and get the response.
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("make request failed: %w", err)
-	}
-
-	// Read the response body.
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("io read all failed: %w", err)
+	// Create the update request structure with the audio file URL and the video UUID.
+	upd := updateVideoLinkReq{
+		URL:          url,
+		UUID:         task.VideoName.String,
+		IndexVersion: indexVersion,
 	}
 
-	// Log the response body for debugging purposes.
-	ctl.log.Info().Str("resp_body", string(respBody)).Msg("video add to database")
-
-	// Return nil if all steps are successful.
-	return nil
+	// Register the original with the ML service and record the outcome.
+	return ctl.registerReference(ctx, taskID, model.ModalityVideo, ctl.cfg.VideocopyAddr+"/upload_video", upd)
 }
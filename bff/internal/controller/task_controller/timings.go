@@ -0,0 +1,100 @@
+package taskcontroller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+// startStage records through q that a timed stage of a task started now; a stage started before
+// starts anew.
+func startStage(ctx context.Context, q *pgsql.Queries, taskID int64, stage model.TimedStage) error {
+	if err := q.StartTaskStage(ctx, pgsql.StartTaskStageParams{
+		TaskID: taskID,
+		Stage:  string(stage),
+	}); err != nil {
+		return fmt.Errorf("start %s stage failed: %w", stage, err)
+	}
+
+	return nil
+}
+
+// finishStage records through q that a running timed stage of a task finished now.
+func finishStage(ctx context.Context, q *pgsql.Queries, taskID int64, stage model.TimedStage) error {
+	if err := q.FinishTaskStage(ctx, pgsql.FinishTaskStageParams{
+		TaskID: taskID,
+		Stage:  string(stage),
+	}); err != nil {
+		return fmt.Errorf("finish %s stage failed: %w", stage, err)
+	}
+
+	return nil
+}
+
+// advanceStage finishes the timed stage finished and starts the stage started of a task; either may be
+// empty. A failure is only logged, as the timings are diagnostics.
+func (ctl *TaskController) advanceStage(ctx context.Context, taskID int64, finished, started model.TimedStage) {
+	// Record the timing even when the request processing the task was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	if finished != "" {
+		if err := finishStage(ctx, ctl.pgConn, taskID, finished); err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to record stage timing")
+		}
+	}
+	if started != "" {
+		if err := startStage(ctx, ctl.pgConn, taskID, started); err != nil {
+			ctl.logger(ctx).Error().Err(err).Int64("task_id", taskID).Msg("failed to record stage timing")
+		}
+	}
+}
+
+// GetTaskTimings returns when the timed stages of a task started and finished, in the order they started.
+func (ctl *TaskController) GetTaskTimings(ctx context.Context, taskID int64) ([]model.StageTiming, error) {
+	rows, err := ctl.pgConn.GetTaskStageTimings(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("get task stage timings failed: %w", err)
+	}
+
+	timings := make([]model.StageTiming, len(rows))
+	for i, r := range rows {
+		timings[i] = model.StageTiming{
+			Stage:      model.TimedStage(r.Stage),
+			StartedAt:  r.StartedAt.Time,
+			FinishedAt: r.FinishedAt.Time,
+		}
+	}
+
+	return timings, nil
+}
+
+// GetStageStats returns the percentiles of the durations of the timed stages finished since the given time.
+func (ctl *TaskController) GetStageStats(ctx context.Context, since time.Time) ([]model.StageStats, error) {
+	rows, err := ctl.pgConn.GetStageTimingPercentiles(ctx, pgtype.Timestamptz{Time: since, Valid: true})
+	if err != nil {
+		return nil, fmt.Errorf("get stage timing percentiles failed: %w", err)
+	}
+
+	stats := make([]model.StageStats, len(rows))
+	for i, r := range rows {
+		stats[i] = model.StageStats{
+			Stage: model.TimedStage(r.Stage),
+			Tasks: r.Tasks,
+			P50:   seconds(r.P50),
+			P90:   seconds(r.P90),
+			P99:   seconds(r.P99),
+		}
+	}
+
+	return stats, nil
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
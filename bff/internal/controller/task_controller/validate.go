@@ -0,0 +1,163 @@
+package taskcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/objectkey"
+)
+
+// sniffLen is the number of leading bytes the content type is detected from.
+const sniffLen = 512
+
+var (
+	// ErrNotVideo is returned when the payload is not a readable video.
+	ErrNotVideo = errors.New("not a video")
+	// ErrVideoTooLarge is returned when the video exceeds the configured size limit.
+	ErrVideoTooLarge = errors.New("video too large")
+	// ErrVideoTooLong is returned when the video exceeds the configured duration limit.
+	ErrVideoTooLong = errors.New("video too long")
+	// ErrUnsupportedContainer is returned for a video in a container other than mp4, mov, mkv, webm and avi.
+	ErrUnsupportedContainer = errors.New("unsupported video container")
+)
+
+// remuxedContainers are the containers, as ffprobe names them, that are accepted but remuxed to MP4
+// before processing. The mov family, mp4 included, is processed as is.
+var remuxedContainers = []string{"matroska,webm", "avi"}
+
+// mp4Container is the name ffprobe reports for the mov family of containers, mp4 included.
+const mp4Container = "mov,mp4,m4a,3gp,3g2,mj2"
+
+// validateVideo checks a spooled video against the upload limits and returns its length, and whether
+// its container has to be remuxed to MP4 before processing.
+// The content type is sniffed first so that documents, images and other obvious non-videos are
+// rejected without running ffprobe; formats the sniffer does not know are left to ffprobe.
+func (ctl *TaskController) validateVideo(ctx context.Context, f *os.File) (length time.Duration, remux bool, err error) {
+	// Get the metadata of the file.
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get file metainfo: %w", err)
+	}
+
+	// Enforce the size limit.
+	if limit := ctl.cfg.Upload.MaxSize; limit > 0 && stat.Size() > limit {
+		return 0, false, fmt.Errorf("%w: %d bytes, limit is %d", ErrVideoTooLarge, stat.Size(), limit)
+	}
+
+	// Sniff the content type from the head of the file.
+	head := make([]byte, sniffLen)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, false, fmt.Errorf("failed to read file head: %w", err)
+	}
+	if err := sniffVideo(head[:n]); err != nil {
+		return 0, false, err
+	}
+
+	// Probe the container; ffprobe fails on anything that is not media.
+	md, err := ctl.ffmpegFor(ctx).Probe(f.Name())
+	if err != nil {
+		// A missing ffprobe binary is a deployment problem, not a bad upload.
+		if errors.Is(err, exec.ErrNotFound) {
+			return 0, false, fmt.Errorf("failed to probe video: %w", err)
+		}
+		return 0, false, fmt.Errorf("%w: %w", ErrNotVideo, err)
+	}
+	if md.VideoCodec == "" {
+		return 0, false, fmt.Errorf("%w: no video stream", ErrNotVideo)
+	}
+	if md.Duration <= 0 {
+		return 0, false, fmt.Errorf("%w: unknown duration", ErrNotVideo)
+	}
+
+	// Accept the supported containers only, so others fail here rather than deep in the pipeline.
+	remux = slices.Contains(remuxedContainers, md.Format)
+	if md.Format != mp4Container && !remux {
+		return 0, false, fmt.Errorf("%w: %s", ErrUnsupportedContainer, md.Format)
+	}
+
+	// Enforce the duration limit.
+	if limit := ctl.cfg.Upload.MaxDuration; limit > 0 && md.Duration > limit {
+		return 0, false, fmt.Errorf("%w: %s, limit is %s", ErrVideoTooLong, md.Duration, limit)
+	}
+
+	return md.Duration, remux, nil
+}
+
+// remuxVideo remuxes a local video to MP4 and stores it in the video bucket under its content key,
+// unless identical content is stored already. It returns the key and the size of the MP4 file.
+func (ctl *TaskController) remuxVideo(ctx context.Context, filename string) (string, int64, error) {
+	// Remux the video and ensure the MP4 file is removed after processing.
+	remuxed, err := ctl.ffmpegFor(ctx).RemuxToMP4(filename)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to remux video: %w", err)
+	}
+	ctl.tempFS.Track("remux", remuxed)
+	defer ctl.tempFS.Remove(remuxed)
+
+	// Hash the MP4 file to build its content key.
+	f, err := os.Open(remuxed)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open remuxed video: %w", err)
+	}
+	defer f.Close()
+
+	hash, err := md5Hex(f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get file metainfo: %w", err)
+	}
+
+	// Upload the MP4 file, unless identical content is stored already.
+	objectName := objectkey.Content(objectkey.KindVideo, hash, ".mp4")
+	bucket := ctl.storage.GetVideoBucketName()
+	if _, err := ctl.storeObject(ctx, objectName, bucket, func() error {
+		return ctl.storage.UploadFileFromOs(ctx, remuxed, objectName, bucket)
+	}); err != nil {
+		return "", 0, fmt.Errorf("failed to upload remuxed video to minio: %w", err)
+	}
+
+	return objectName, stat.Size(), nil
+}
+
+// sniffVideo rejects a payload whose head is empty or sniffed as something other than a video.
+func sniffVideo(head []byte) error {
+	if len(head) == 0 {
+		return fmt.Errorf("%w: empty file", ErrNotVideo)
+	}
+	if ct := http.DetectContentType(head); !strings.HasPrefix(ct, "video/") && ct != "application/octet-stream" {
+		return fmt.Errorf("%w: detected %s", ErrNotVideo, ct)
+	}
+
+	return nil
+}
+
+// limitReader reads a streamed video and fails with ErrVideoTooLarge once it exceeds limit bytes;
+// a limit of zero is unlimited.
+type limitReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > l.limit {
+		return n, fmt.Errorf("%w: limit is %d bytes", ErrVideoTooLarge, l.limit)
+	}
+
+	return n, err
+}
@@ -0,0 +1,155 @@
+package taskcontroller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+)
+
+var verdictsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_verdict_events_published_total",
+	Help: "Events of the verdict stream published by kind.",
+}, []string{"kind"})
+
+// relayVerdicts publishes a batch of the verdict stream to the verdict topic and the file sink, numbering
+// the events in publish order. The stream is locked while publishing, so this returns without claiming
+// anything while another process publishes. Events are delivered at least once: a batch published but
+// not committed is published again with the same sequence numbers. It returns the number of events claimed.
+func (ctl *TaskController) relayVerdicts(ctx context.Context) (int, error) {
+	if ctx.Err() != nil || (ctl.cfg.Kafka.VerdictTopic == "" && ctl.cfg.Verdict.File == "") {
+		return 0, nil
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	tx, err := ctl.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q := ctl.pgConn.WithTx(tx)
+
+	// Lock the stream; when another process holds it, that process publishes the events.
+	lastSeq, err := q.LockVerdictStream(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock verdict stream failed: %w", err)
+	}
+
+	rows, err := q.ClaimVerdictEvents(ctx, int32(ctl.cfg.Outbox.BatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("claim verdict events failed: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	// Number the events following the last one published.
+	events := make([]model.VerdictEvent, len(rows))
+	for i, r := range rows {
+		events[i] = verdictEventToModel(r, lastSeq+int64(i)+1)
+	}
+
+	// Publish the batch to every sink before its numbers are committed.
+	if err := ctl.publishVerdicts(ctx, events); err != nil {
+		return 0, err
+	}
+
+	for i, r := range rows {
+		if err := q.MarkVerdictEventPublished(ctx, pgsql.MarkVerdictEventPublishedParams{
+			ID:  r.ID,
+			Seq: pgtype.Int8{Int64: events[i].Seq, Valid: true},
+		}); err != nil {
+			return 0, fmt.Errorf("mark verdict event %d published failed: %w", r.ID, err)
+		}
+	}
+	if err := q.AdvanceVerdictStream(ctx, events[len(events)-1].Seq); err != nil {
+		return 0, fmt.Errorf("advance verdict stream failed: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	for _, e := range events {
+		verdictsPublished.WithLabelValues(e.Kind).Inc()
+	}
+
+	return len(rows), nil
+}
+
+// publishVerdicts writes numbered verdict events to the verdict topic and appends them to the file sink.
+func (ctl *TaskController) publishVerdicts(ctx context.Context, events []model.VerdictEvent) error {
+	// Encode every event once for both sinks.
+	values := make([][]byte, len(events))
+	for i := range events {
+		b, err := json.Marshal(events[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal verdict event: %w", err)
+		}
+		values[i] = b
+	}
+
+	if topic := ctl.cfg.Kafka.VerdictTopic; topic != "" {
+		msgs := make([]kafka.Message, len(events))
+		for i := range events {
+			msgs[i] = kafka.Message{
+				Topic: topic,
+				Key:   taskKey(events[i].TaskID),
+				Value: values[i],
+			}
+		}
+		if err := ctl.producer.WriteMessages(ctx, msgs...); err != nil {
+			return fmt.Errorf("publish verdict events failed: %w", err)
+		}
+	}
+
+	if path := ctl.cfg.Verdict.File; path != "" {
+		if err := appendLines(path, values); err != nil {
+			return fmt.Errorf("append verdict events failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// appendLines appends a line per value to the file at path and syncs it, creating the file if needed.
+// The file is opened for every batch, so it can be rotated by renaming it.
+func appendLines(path string, values [][]byte) error {
+	var buf bytes.Buffer
+	for _, v := range values {
+		buf.Write(v)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
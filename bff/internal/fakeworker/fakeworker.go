@@ -0,0 +1,285 @@
+package fakeworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/msgformat"
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrInvalidOptions is returned for flags out of range.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Options holds the fake worker parameters.
+type Options struct {
+	// Modalities are the modalities to answer: audio, video or both.
+	Modalities string
+	Delay      time.Duration
+	// Jitter is the most a simulated delay exceeds Delay by, drawn uniformly.
+	Jitter time.Duration
+	// Results is a JSON file with the canned candidates by modality; without it every video is an original.
+	Results     string
+	Download    bool
+	Concurrency int
+	GroupID     string
+}
+
+// Results are the candidates the fake worker reports for every task, by modality.
+type Results struct {
+	Audio []model.Copyright `json:"audio"`
+	Video []model.Copyright `json:"video"`
+}
+
+// Worker answers the requests to the ML services with canned results, standing in for them in local
+// end-to-end runs and load tests. It speaks the Kafka protocol of the services: it consumes the
+// links of the input topics and produces KafkaResponse messages to the copyright topics.
+type Worker struct {
+	opts     Options
+	kafkaCfg config.KafkaConfig
+	results  Results
+	codec    msgformat.Codec
+	producer *kafka.Writer
+	client   *http.Client
+	log      *zerolog.Logger
+}
+
+// Run parses the command line arguments and answers requests until ctx is done. The Kafka address
+// and topics and the message format are read from the BFF configuration, so the worker pairs with a
+// BFF configured alike; SASL and TLS are not supported.
+func Run(ctx context.Context, args []string, log *zerolog.Logger) error {
+	fs := flag.NewFlagSet("fakeworker", flag.ContinueOnError)
+
+	var opts Options
+	fs.StringVar(&opts.Modalities, "modalities", "both", "modalities to answer: audio, video or both")
+	fs.DurationVar(&opts.Delay, "delay", 2*time.Second, "simulated processing time of a request")
+	fs.DurationVar(&opts.Jitter, "jitter", time.Second, "random extra processing time, up to this much")
+	fs.StringVar(&opts.Results, "results", "", `JSON file with the candidates to report, e.g. {"video": [{"Name": "ref", "Probability": 0.9}]}; empty reports none`)
+	fs.BoolVar(&opts.Download, "download", true, "download the linked file before answering, like the ML services")
+	fs.IntVar(&opts.Concurrency, "concurrency", 8, "requests processed at once per modality")
+	fs.StringVar(&opts.GroupID, "group", "fakeworker", "consumer group prefix, suffixed with the modality")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	modalities, err := parseModalities(opts.Modalities)
+	if err != nil {
+		return err
+	}
+	if opts.Delay < 0 || opts.Jitter < 0 || opts.Concurrency <= 0 {
+		return fmt.Errorf("%w: delay and jitter must not be negative, concurrency must be positive", ErrInvalidOptions)
+	}
+
+	cfg, _, err := config.InitConfig()
+	if err != nil {
+		return err
+	}
+
+	codec, err := msgformat.Open(cfg.Kafka.MessageFormat, cfg.Kafka.SchemaRegistryURL, cfg.Kafka.SchemaRegistryUser, cfg.Kafka.SchemaRegistryPassword)
+	if err != nil {
+		return err
+	}
+
+	w := &Worker{
+		opts:     opts,
+		kafkaCfg: cfg.Kafka,
+		codec:    codec,
+		producer: &kafka.Writer{
+			Addr:     kafka.TCP(cfg.Kafka.BrokerAddrs()...),
+			Balancer: &kafka.CRC32Balancer{},
+		},
+		client: &http.Client{Timeout: 10 * time.Minute},
+		log:    log,
+	}
+	defer w.producer.Close()
+
+	if opts.Results != "" {
+		if w.results, err = loadResults(opts.Results); err != nil {
+			return err
+		}
+	}
+
+	// Answer each modality from its own consumer group.
+	var wg sync.WaitGroup
+	errs := make([]error, len(modalities))
+	for i, modality := range modalities {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.serve(ctx, modality)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// parseModalities returns the modalities named by the modalities flag.
+func parseModalities(s string) ([]model.Modality, error) {
+	switch s {
+	case "both":
+		return []model.Modality{model.ModalityAudio, model.ModalityVideo}, nil
+	case string(model.ModalityAudio), string(model.ModalityVideo):
+		return []model.Modality{model.Modality(s)}, nil
+	default:
+		return nil, fmt.Errorf("%w: modalities must be audio, video or both", ErrInvalidOptions)
+	}
+}
+
+// loadResults reads the canned candidates.
+func loadResults(name string) (Results, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return Results{}, fmt.Errorf("failed to read results: %w", err)
+	}
+
+	var r Results
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Results{}, fmt.Errorf("failed to parse results: %w", err)
+	}
+
+	return r, nil
+}
+
+// topics returns the input topic of a modality and the copyright topic its results go to.
+func (w *Worker) topics(modality model.Modality) (input, output string) {
+	if modality == model.ModalityAudio {
+		return w.kafkaCfg.AudioInputTopic, w.kafkaCfg.AudioCopyrightTopic
+	}
+
+	return w.kafkaCfg.VideoInputTopic, w.kafkaCfg.VideoCopyrightTopic
+}
+
+// serve answers the requests of a modality until ctx is done. Offsets are committed when a request
+// is read, so requests in flight when the worker stops are lost; the BFF sends them again once
+// they time out.
+func (w *Worker) serve(ctx context.Context, modality model.Modality) error {
+	input, output := w.topics(modality)
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: w.kafkaCfg.BrokerAddrs(),
+		GroupID: w.opts.GroupID + "-" + string(modality),
+		Topic:   input,
+	})
+	defer r.Close()
+
+	candidates := w.results.Video
+	if modality == model.ModalityAudio {
+		candidates = w.results.Audio
+	}
+
+	w.log.Info().Str("modality", string(modality)).Str("input", input).Str("output", output).Msg("answering requests")
+
+	// Bound the requests in flight.
+	sem := make(chan struct{}, w.opts.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read %s request failed: %w", modality, err)
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := w.answer(ctx, msg, output, candidates); err != nil && ctx.Err() == nil {
+				w.log.Error().Err(err).Str("modality", string(modality)).Int64("offset", msg.Offset).Msg("answer request failed")
+			}
+		}()
+	}
+}
+
+// answer fetches the linked file of a request, waits the simulated processing time and produces
+// the canned result for its task, keyed and traced like the request.
+func (w *Worker) answer(ctx context.Context, msg kafka.Message, topic string, candidates []model.Copyright) error {
+	link, err := w.codec.DecodeLink(ctx, msg.Value)
+	if err != nil {
+		return fmt.Errorf("failed to parse request: %w", err)
+	}
+
+	start := time.Now()
+	if w.opts.Download {
+		if err := w.download(ctx, link.Link); err != nil {
+			return fmt.Errorf("download of task %d failed: %w", link.TaskID, err)
+		}
+	}
+
+	// Simulate the rest of the processing time.
+	delay := w.opts.Delay
+	if w.opts.Jitter > 0 {
+		delay += rand.N(w.opts.Jitter)
+	}
+	select {
+	case <-time.After(delay - time.Since(start)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	body, err := w.codec.EncodeResponse(ctx, topic, model.KafkaResponse{
+		TaskID: link.TaskID,
+		Copy:   append([]model.Copyright{}, candidates...),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+
+	if err := w.producer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     strconv.AppendInt(nil, link.TaskID, 10),
+		Value:   body,
+		Headers: msg.Headers,
+	}); err != nil {
+		return fmt.Errorf("failed to write result of task %d: %w", link.TaskID, err)
+	}
+
+	w.log.Debug().Int64("task_id", link.TaskID).Str("topic", topic).Dur("took", time.Since(start)).Msg("result sent")
+
+	return nil
+}
+
+// download reads the linked file to its end and discards it.
+func (w *Worker) download(ctx context.Context, link string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,356 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ffmpeg"
+	"github.com/rs/zerolog"
+)
+
+// ErrTaskFailed is returned when the pipeline reports a task as failed.
+var ErrTaskFailed = errors.New("task failed")
+
+// Options holds the load test parameters.
+type Options struct {
+	Target       string
+	Rate         float64
+	Duration     time.Duration
+	Timeout      time.Duration
+	Fixtures     string
+	Clips        int
+	ClipDuration time.Duration
+	PollInterval time.Duration
+	Token        string
+}
+
+// result is the outcome of a single synthetic submission.
+type result struct {
+	latency time.Duration
+	err     error
+}
+
+// Runner drives synthetic submissions against a running BFF.
+type Runner struct {
+	opts   Options
+	client *http.Client
+	log    *zerolog.Logger
+	links  []string
+	clips  []string
+}
+
+// Run parses the command line arguments and executes a load test, printing the report to stdout.
+func Run(ctx context.Context, args []string, log *zerolog.Logger) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+
+	var opts Options
+	fs.StringVar(&opts.Target, "target", "http://localhost:7083", "base URL of the BFF under test")
+	fs.Float64Var(&opts.Rate, "rate", 1, "submissions per second")
+	fs.DurationVar(&opts.Duration, "duration", time.Minute, "how long to generate load")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Minute, "end-to-end timeout of a single submission")
+	fs.StringVar(&opts.Fixtures, "fixtures", "", "file with one video link per line; when empty, clips are generated and uploaded")
+	fs.IntVar(&opts.Clips, "clips", 5, "number of distinct synthetic clips to generate")
+	fs.DurationVar(&opts.ClipDuration, "clip-duration", 5*time.Second, "length of a synthetic clip")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", 500*time.Millisecond, "task status polling interval")
+	fs.StringVar(&opts.Token, "token", os.Getenv("BFF_TOKEN"), "bearer token with the uploader role when authentication is enabled")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if opts.Rate <= 0 || opts.Duration <= 0 {
+		return errors.New("rate and duration must be positive")
+	}
+
+	r := &Runner{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		log:    log,
+	}
+
+	if err := r.prepare(); err != nil {
+		return err
+	}
+	defer r.cleanup()
+
+	results := r.generate(ctx)
+	printReport(os.Stdout, results, opts.Duration)
+
+	return nil
+}
+
+// prepare loads the link fixtures or renders the synthetic clips.
+func (r *Runner) prepare() error {
+	if r.opts.Fixtures != "" {
+		f, err := os.Open(r.opts.Fixtures)
+		if err != nil {
+			return fmt.Errorf("failed to open fixtures: %w", err)
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if l := strings.TrimSpace(sc.Text()); l != "" && !strings.HasPrefix(l, "#") {
+				r.links = append(r.links, l)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("failed to read fixtures: %w", err)
+		}
+		if len(r.links) == 0 {
+			return errors.New("fixtures file has no links")
+		}
+
+		return nil
+	}
+
+	ff, err := ffmpeg.New(r.log, os.TempDir(), ffmpeg.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to create ffmpeg executor: %w", err)
+	}
+	for i := 0; i < max(r.opts.Clips, 1); i++ {
+		clip, err := ff.GenerateTestClip(r.opts.ClipDuration, i)
+		if err != nil {
+			return fmt.Errorf("failed to generate clip: %w", err)
+		}
+		r.clips = append(r.clips, clip)
+	}
+	r.log.Info().Int("clips", len(r.clips)).Msg("synthetic clips generated")
+
+	return nil
+}
+
+// cleanup removes the generated clips.
+func (r *Runner) cleanup() {
+	for _, c := range r.clips {
+		_ = os.Remove(c)
+	}
+}
+
+// generate issues submissions at the configured rate and waits for all of them to finish.
+func (r *Runner) generate(ctx context.Context) []result {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Duration)
+	defer cancel()
+
+	t := time.NewTicker(time.Duration(float64(time.Second) / r.opts.Rate))
+	defer t.Stop()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []result
+	)
+
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return results
+		case <-t.C:
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+
+				subCtx, subCancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+				defer subCancel()
+
+				start := time.Now()
+				err := r.submit(subCtx, n)
+				res := result{latency: time.Since(start), err: err}
+				if err != nil {
+					r.log.Debug().Err(err).Int("n", n).Msg("submission failed")
+				}
+
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}(i)
+		}
+	}
+}
+
+// submit runs one submission through the whole pipeline.
+func (r *Runner) submit(ctx context.Context, n int) error {
+	if len(r.links) != 0 {
+		return r.postJSON(ctx, "/v1/check-video-duplicate", map[string]string{"link": r.links[n%len(r.links)]}, nil)
+	}
+
+	// Reserve an object key and upload the clip directly to storage.
+	var upload struct {
+		ObjectKey string `json:"object_key"`
+		UploadURL string `json:"upload_url"`
+	}
+	if err := r.postJSON(ctx, "/v1/task/upload-url", nil, &upload); err != nil {
+		return err
+	}
+	if err := r.putFile(ctx, upload.UploadURL, r.clips[n%len(r.clips)]); err != nil {
+		return err
+	}
+
+	// Create the task from the uploaded object and wait for it to finish.
+	var created struct {
+		TaskID int64 `json:"task_id"`
+	}
+	if err := r.postJSON(ctx, "/v1/task/from-object", map[string]string{
+		"object_key": upload.ObjectKey,
+		"name":       "loadtest-" + strconv.Itoa(n),
+	}, &created); err != nil {
+		return err
+	}
+
+	return r.waitTask(ctx, created.TaskID)
+}
+
+func (r *Runner) waitTask(ctx context.Context, id int64) error {
+	for {
+		var task struct {
+			Status string `json:"status"`
+		}
+		if err := r.do(ctx, http.MethodGet, "/v1/task/"+strconv.FormatInt(id, 10), nil, &task); err != nil {
+			return err
+		}
+
+		switch task.Status {
+		case "done":
+			return nil
+		case "failed":
+			return ErrTaskFailed
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.opts.PollInterval):
+		}
+	}
+}
+
+func (r *Runner) postJSON(ctx context.Context, path string, body, out any) error {
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rdr = bytes.NewReader(b)
+	}
+
+	return r.do(ctx, http.MethodPost, path, rdr, out)
+}
+
+func (r *Runner) do(ctx context.Context, method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(r.opts.Target, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.opts.Token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (r *Runner) putFile(ctx context.Context, url, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("upload: status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// printReport writes latency percentiles and error rates of the run.
+func printReport(w io.Writer, results []result, d time.Duration) {
+	var (
+		latencies []time.Duration
+		errs      = map[string]int{}
+	)
+	for _, r := range results {
+		if r.err != nil {
+			errs[r.err.Error()]++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	total := len(results)
+	failed := total - len(latencies)
+
+	fmt.Fprintf(w, "submissions: %d (%.2f/s)\n", total, float64(total)/d.Seconds())
+	fmt.Fprintf(w, "succeeded:   %d\n", len(latencies))
+	if total != 0 {
+		fmt.Fprintf(w, "failed:      %d (%.2f%%)\n", failed, 100*float64(failed)/float64(total))
+	}
+
+	if len(latencies) != 0 {
+		for _, p := range []float64{50, 90, 95, 99} {
+			fmt.Fprintf(w, "p%-3.0f        %s\n", p, percentile(latencies, p))
+		}
+		fmt.Fprintf(w, "max:         %s\n", latencies[len(latencies)-1])
+	}
+
+	for msg, n := range errs {
+		fmt.Fprintf(w, "error x%d: %s\n", n, msg)
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	idx = max(0, min(idx, len(sorted)-1))
+
+	return sorted[idx]
+}
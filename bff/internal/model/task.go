@@ -1,5 +1,12 @@
 package model
 
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
 type TaskStatus uint
 
 const (
@@ -8,9 +15,44 @@ const (
 	TaskStatusFailed
 )
 
+func (s TaskStatus) String() string {
+	switch s {
+	case TaskStatusDone:
+		return "done"
+	case TaskStatusInProgress:
+		return "in_progress"
+	case TaskStatusFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTaskStatus returns the status of the name String gives it.
+func ParseTaskStatus(name string) (TaskStatus, bool) {
+	for _, s := range []TaskStatus{TaskStatusDone, TaskStatusInProgress, TaskStatusFailed} {
+		if s.String() == name {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
 type KafkaLink struct {
-	TaskID int64  `json:"task_id"`
-	Link   string `json:"link"`
+	TaskID       int64  `json:"task_id"`
+	Link         string `json:"link"`
+	IndexVersion string `json:"index_version,omitempty"`
+	// Keyframes are the representative frames of the scenes of a video, sent to the video-copy service.
+	Keyframes []KafkaKeyframe `json:"keyframes,omitempty"`
+}
+
+// KafkaKeyframe links the keyframe of a scene; positions are in seconds.
+type KafkaKeyframe struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	At    float64 `json:"at"`
+	Link  string  `json:"link"`
 }
 
 type KafkaResponse struct {
@@ -18,14 +60,568 @@ type KafkaResponse struct {
 	Copy   []Copyright `json:"copyright"`
 }
 
+// Copyright is a reference the media matches. The ML services may report a reference several times,
+// once per matching segment, or group the segments themselves; GroupCandidates merges both forms.
 type Copyright struct {
-	Name        string
+	Name string
+	// Probability is that of the best matching segment.
 	Probability float64
+	Segments    []Segment `json:"segments,omitempty"`
+}
+
+// Segment is one match between a part of the media and a part of the reference, in seconds.
+type Segment struct {
+	Start          float64 `json:"start"`
+	End            float64 `json:"end"`
+	ReferenceStart float64 `json:"reference_start"`
+	ReferenceEnd   float64 `json:"reference_end"`
+	Probability    float64 `json:"probability"`
+}
+
+// GroupCandidates merges the candidates of the same reference, keeping all their segments in order
+// of appearance, and sets the probability of each reference to that of its best segment.
+// References keep the order of their first candidate.
+func GroupCandidates(candidates []Copyright) []Copyright {
+	var grouped []Copyright
+	index := map[string]int{}
+	for _, c := range candidates {
+		i, ok := index[c.Name]
+		if !ok {
+			i = len(grouped)
+			index[c.Name] = i
+			grouped = append(grouped, Copyright{Name: c.Name, Probability: c.Probability})
+		}
+
+		g := &grouped[i]
+		g.Probability = max(g.Probability, c.Probability)
+		for _, s := range c.Segments {
+			g.Probability = max(g.Probability, s.Probability)
+			g.Segments = append(g.Segments, s)
+		}
+	}
+
+	return grouped
+}
+
+// CopiedDuration is the length of the media covered by the matching segments in seconds,
+// counting the overlaps of segments once.
+func (c Copyright) CopiedDuration() float64 {
+	segments := slices.Clone(c.Segments)
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	var total, end float64
+	for _, s := range segments {
+		start := max(s.Start, end)
+		if s.End > start {
+			total += s.End - start
+			end = s.End
+		}
+	}
+
+	return total
 }
 
 type Task struct {
-	TaskID         int64
-	Status         TaskStatus
+	TaskID int64
+	Status TaskStatus
+	// Stage is the step of the pipeline the task reached: uploading, audio_extraction, queued,
+	// audio_checked, video_checked or done. A failed task keeps the stage it failed in.
+	Stage          string
 	VideoCopyright []Copyright
 	AudioCopyright []Copyright
+	IndexVersion   string
+	ParentTaskID   int64
+	// QueuePosition is the 1-based position among pending tasks, 0 when the task is not pending.
+	QueuePosition int64
+	Source        Source
+	// DownloadVerification tells how a video fetched by link was verified: content_md5, etag or none.
+	DownloadVerification string
+	// TraceID is the W3C trace ID the processing of the task is recorded under.
+	TraceID string
+	// Pending lists the modalities an in-progress task is still waiting for. While it is not
+	// empty, the copyright candidates of the other modality are a partial result.
+	Pending []Modality
+	// Overdue lists the pending modalities whose results are later than the SLA of their modality.
+	Overdue []Modality
+	// FailureReason tells why a failed task was given up on, empty when unknown.
+	FailureReason string
+	CreatedAt     time.Time
+	// UpdatedAt is when the status or a result of the task last changed.
+	UpdatedAt time.Time
+	// Deadline is when an in-progress task is failed, zero when the task has none.
+	Deadline time.Time
+	// DeletedAt is when the task was deleted, zero for a task that is not.
+	DeletedAt time.Time
+	// Video holds the properties of the video recorded when the task was created.
+	Video VideoProperties
+}
+
+// VideoProperties are the length, resolution, size and content hash of the video of a task.
+// Properties that were not determined are zero.
+type VideoProperties struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+	// Size is the number of bytes stored for the video.
+	Size int64
+	// ContentHash is the hexadecimal MD5 of the video as uploaded; empty unless md5 is a configured hash algorithm.
+	ContentHash string
+}
+
+// TaskEvent is a change of the status of a task.
+type TaskEvent struct {
+	ID     int64
+	Status TaskStatus
+	// PreviousStatus is the status before the change; HasPrevious is false for the status a task was
+	// created with.
+	PreviousStatus TaskStatus
+	HasPrevious    bool
+	// Stage is the stage of the task after the change.
+	Stage string
+	Actor string
+	// Reason is the failure reason of a failed task, else the audited action that changed the status.
+	Reason     string
+	OccurredAt time.Time
+}
+
+// TimedStage is a step of the processing of a task whose duration is recorded.
+type TimedStage string
+
+const (
+	// StageUpload stores the video of a task, from the request until the audio extraction starts.
+	StageUpload TimedStage = "upload"
+	// StageAudioExtraction extracts the audio and derives the previews, hashes and scenes.
+	StageAudioExtraction TimedStage = "audio_extraction"
+	// StageAudioCheck and StageVideoCheck wait for the result of an ML service, from the request.
+	StageAudioCheck TimedStage = "audio_check"
+	StageVideoCheck TimedStage = "video_check"
+)
+
+// CheckStage returns the timed stage of the check of a modality.
+func CheckStage(m Modality) TimedStage {
+	return TimedStage(string(m) + "_check")
+}
+
+// StageTiming is when a timed stage of a task started and finished; FinishedAt is zero while it runs.
+type StageTiming struct {
+	Stage      TimedStage
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// StageStats are the percentiles of the durations of a timed stage over the tasks that finished it.
+type StageStats struct {
+	Stage TimedStage
+	Tasks int64
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// VideoMetadata are the properties of the video of a task probed at ingest: its container and its first
+// video and audio streams. Properties the probe did not report are zero.
+type VideoMetadata struct {
+	Format     string
+	VideoCodec string
+	AudioCodec string
+	Width      int
+	Height     int
+	FrameRate  float64
+	// BitRate is the overall bit rate in bits per second.
+	BitRate  int64
+	Duration time.Duration
+}
+
+// Scene is a part of the video of a task between two cuts, where consecutive frames differ strongly.
+type Scene struct {
+	// Index numbers the scenes of a video from 0 in order.
+	Index int
+	Start time.Duration
+	End   time.Duration
+	// KeyframeAt is the position of the representative frame of the scene, its middle.
+	KeyframeAt time.Duration
+}
+
+// Sprite describes the sprite sheet of a task: frames evenly spaced over its video, tiled left to right
+// and top to bottom, each FrameWidth x FrameHeight pixels.
+type Sprite struct {
+	Columns     int
+	Rows        int
+	FrameWidth  int
+	FrameHeight int
+	// Timestamps are the positions of the frames in the video, in the order of the tiles.
+	Timestamps []time.Duration
+}
+
+// Source identifies the client that submitted a task and how urgently it wants the result.
+type Source struct {
+	IP        string
+	UserAgent string
+	// APIKeyID is a fingerprint of the API key, never the key itself.
+	APIKeyID string
+	// Account is the identity the quotas count the task against: the subject of the token or the
+	// fingerprint of a registered API key. Tasks without one, such as those of bucket notifications, are not counted.
+	Account string
+	// Priority orders the task among the pending ones; empty is interactive.
+	Priority Priority
+}
+
+// Priority orders the requests of pending tasks to the ML services.
+type Priority string
+
+const (
+	// PriorityInteractive is the priority of tasks a caller waits for; they go first.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBatch is the priority of bulk and background tasks, sent once no interactive task waits.
+	PriorityBatch Priority = "batch"
+)
+
+// TaskFilter selects tasks by their source; empty fields match everything.
+type TaskFilter struct {
+	SourceIP  string
+	UserAgent string
+	APIKeyID  string
+}
+
+// OrigVideo is a video registered in the reference catalog as an original.
+type OrigVideo struct {
+	ID   int64
+	Name string
+	// Hash is the hexadecimal MD5 of the video, empty when it was registered without one.
+	Hash         string
+	RegisteredAt time.Time
+}
+
+// OrigVideoFilter selects original videos; empty fields match everything.
+type OrigVideoFilter struct {
+	// Name matches a substring of the name, ignoring case.
+	Name             string
+	Hash             string
+	RegisteredAfter  time.Time
+	RegisteredBefore time.Time
+}
+
+// SourceStat aggregates the tasks submitted by one source.
+type SourceStat struct {
+	Source     string
+	Tasks      int64
+	InProgress int64
+	Failed     int64
+}
+
+// Usage is what an API key consumed; as a quota, zero fields are unlimited.
+type Usage struct {
+	Tasks        int64
+	VideoSeconds float64
+	Bytes        int64
+}
+
+// Decision is a verdict made before the service recorded tasks, imported from a submission file.
+type Decision struct {
+	Created time.Time
+	UUID    string
+	Link    string
+	// DuplicateFor is the UUID of the original the video duplicates, empty for an original.
+	DuplicateFor string
+	// File is the submission file the decision was read from.
+	File string
+}
+
+// Confidence tiers of a quick check.
+const (
+	// QuickCheckHigh means the video is byte for byte an original.
+	QuickCheckHigh = "high"
+	// QuickCheckMedium means the video looks like an original by its perceptual hash.
+	QuickCheckMedium = "medium"
+	// QuickCheckLow means no local evidence was found either way.
+	QuickCheckLow = "low"
+)
+
+// QuickCheck is a verdict on a video link from local evidence only: the download cache, the content
+// hash and the perceptual hash of the originals. It never involves the ML services.
+type QuickCheck struct {
+	Tier string
+	// Evidence is what the verdict rests on: exact_match, perceptual_match or none.
+	Evidence string
+	// DuplicateFor is the original the video matches, empty without a match.
+	DuplicateFor string
+	Probability  float64
+	// Cached reports that the link was found in the download cache; without it nothing could be compared.
+	Cached bool
+	// FullCheckRecommended is set unless the local evidence alone settles the verdict.
+	FullCheckRecommended bool
+}
+
+// Reference registration statuses.
+const (
+	RegistrationRegistered = "registered"
+	RegistrationFailed     = "failed"
+)
+
+// Registration is the outcome of registering the media of a task as a reference with an ML service.
+type Registration struct {
+	Modality Modality
+	Status   string
+	// Attempts counts the requests made over all registrations of the modality.
+	Attempts  int32
+	Error     string
+	UpdatedAt time.Time
+}
+
+// ReferenceCheck is the latest check of a reference video against the reference index. The candidates
+// of the check other than the reference itself are the references similar to it.
+type ReferenceCheck struct {
+	TaskID    int64
+	VideoName string
+	CheckedAt time.Time
+	Check     Task
+}
+
+// Recheck is a re-check of a library video against the references registered after its last check.
+// Its candidates other than the video itself are the originals the video may copy.
+type Recheck struct {
+	TaskID    int64
+	VideoName string
+	// ReferencesThrough is the latest reference task the re-check covers.
+	ReferencesThrough int64
+	CreatedAt         time.Time
+	Check             Task
+}
+
+// BucketRetention is the lifecycle of the objects in a bucket, in days after an object is written;
+// zero disables a rule.
+type BucketRetention struct {
+	Bucket          string
+	ExpireDays      int
+	TransitionDays  int
+	TransitionClass string
+}
+
+type IndexVersion struct {
+	Version   string
+	Active    bool
+	CreatedAt time.Time
+}
+
+// Modality is the kind of media a copyright result was computed for.
+type Modality string
+
+const (
+	ModalityAudio Modality = "audio"
+	ModalityVideo Modality = "video"
+)
+
+// Audited actions.
+const (
+	AuditTaskCreated                 = "task.created"
+	AuditTaskCompared                = "task.compared"
+	AuditTaskDecided                 = "task.decided"
+	AuditTaskTimedOut                = "task.timed_out"
+	AuditTaskModalityReset           = "task.modality_reset"
+	AuditTaskImported                = "task.imported"
+	AuditTaskDeleted                 = "task.deleted"
+	AuditTaskRestored                = "task.restored"
+	AuditTaskPurged                  = "task.purged"
+	AuditReferenceRegistered         = "reference.registered"
+	AuditReferenceRegistrationFailed = "reference.registration_failed"
+	AuditOriginalRegistered          = "original.registered"
+	AuditOriginalDelisted            = "original.delisted"
+	AuditDeadLetterReplayed          = "dead_letter.replayed"
+	AuditIndexVersionActivated       = "index_version.activated"
+	AuditBatchTokensRevoked          = "batch.tokens_revoked"
+	AuditRetentionChanged            = "retention.changed"
+)
+
+// AuditEvent records who did what; events are never changed once recorded.
+type AuditEvent struct {
+	ID         int64
+	OccurredAt time.Time
+	// Actor is the authenticated subject, API key fingerprint or client IP, prefixed with its kind,
+	// or "system" for actions of the service itself.
+	Actor  string
+	Action string
+	// TaskID is the task the action concerns, 0 when it concerns none.
+	TaskID  int64
+	Details json.RawMessage
+}
+
+// AuditFilter selects audit events; empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	TaskID int64
+}
+
+// DeadLetter is a Kafka message that could not be applied and was published to the dead-letter topic.
+type DeadLetter struct {
+	ID int64
+	// Topic, Partition and Offset locate the message in the topic it was read from.
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	// Headers are the headers of the original message.
+	Headers []Header
+	// Error is why the message could not be applied after Attempts tries.
+	Error          string
+	Attempts       int
+	DeadLetteredAt time.Time
+	// ReplayedAt is when the message was last published to its topic again, zero if never.
+	ReplayedAt time.Time
+}
+
+// Header is a header of a Kafka message.
+type Header struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// DeadLetterFilter selects dead-lettered messages; empty fields match everything.
+type DeadLetterFilter struct {
+	Topic string
+	// Pending selects the messages not replayed yet.
+	Pending bool
+}
+
+// VerdictSchema identifies the layout of VerdictEvent. Fields may be added under the same schema;
+// renaming or removing one starts a new schema.
+const VerdictSchema = "bff.verdict.v1"
+
+// Kinds of verdict events.
+const (
+	// VerdictKindDecision is a final verdict: a task decided, timed out or imported.
+	VerdictKindDecision = "decision"
+	// VerdictKindOverride is a verdict withdrawn, e.g. a modality reset to be checked again.
+	VerdictKindOverride = "override"
+)
+
+// VerdictKind returns the kind of verdict event an audited action emits, empty for actions that emit none.
+func VerdictKind(action string) string {
+	switch action {
+	case AuditTaskDecided, AuditTaskTimedOut, AuditTaskImported:
+		return VerdictKindDecision
+	case AuditTaskModalityReset:
+		return VerdictKindOverride
+	default:
+		return ""
+	}
+}
+
+// VerdictEvent is a message of the verdict stream, published as JSON to the verdict topic and the file sink.
+// Seq increases by one with every event published, so a consumer detects a gap or, after a failed
+// publish is retried, a redelivered event by it. AuditID refers to the audit event the verdict is recorded by.
+type VerdictEvent struct {
+	Schema     string    `json:"schema"`
+	Seq        int64     `json:"seq"`
+	AuditID    int64     `json:"audit_id"`
+	Kind       string    `json:"kind"`
+	Action     string    `json:"action"`
+	TaskID     int64     `json:"task_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Actor      string    `json:"actor"`
+	// Details are those of the audit event, e.g. the results a decision is based on.
+	Details json.RawMessage `json:"details"`
+}
+
+// scoreBuckets is the number of equal-width buckets the match score distribution is split into.
+const scoreBuckets = 10
+
+// ScoreBucket counts the match scores in [Min, Max); the last bucket also holds a score of 1.
+type ScoreBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int64   `json:"count"`
+}
+
+// BatchSummary describes a batch of checked videos, such as one submission CSV.
+type BatchSummary struct {
+	ID        int64
+	StartedAt time.Time
+	// FinishedAt is zero while the batch is running.
+	FinishedAt time.Time
+	// Total is the number of rows of the batch; Rows counts those checked so far.
+	Total      int64
+	Rows       int64
+	Duplicates int64
+	Failures   int64
+	AvgLatency time.Duration
+	// Scores is the distribution of the best match score of the videos checked successfully.
+	Scores []ScoreBucket
+	// Resources is what checking the rows used so far.
+	Resources ResourceUsage
+}
+
+// ResourceUsage estimates what checking videos used, to attribute the cost of the infrastructure.
+type ResourceUsage struct {
+	// BytesDownloaded counts the videos fetched from their links; videos served from the cache are free.
+	BytesDownloaded int64
+	// BytesStored counts the videos and the audio extracted from them.
+	BytesStored int64
+	FFmpegCPU   time.Duration
+	// MLRequests counts the requests sent to the ML services, retries included.
+	MLRequests int64
+}
+
+// BatchRow is a row of a batch, checked in the background.
+type BatchRow struct {
+	BatchID int64
+	RowNo   int32
+	Created time.Time
+	UUID    string
+	Link    string
+	// TaskID is the task checking the row, 0 until it is created.
+	TaskID int64
+	// Source is the client that submitted the batch.
+	Source Source
+}
+
+// BatchRowResult is the outcome of checking a row of a batch.
+type BatchRowResult struct {
+	Failed       bool
+	IsDuplicate  bool
+	DuplicateFor string
+	Score        float64
+	Latency      time.Duration
+}
+
+// BatchToken grants read access to one batch until it expires or is revoked.
+type BatchToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// NewBatchSummary returns an empty summary of a batch with empty score buckets covering [0, 1].
+func NewBatchSummary(id int64, startedAt time.Time) BatchSummary {
+	s := BatchSummary{
+		ID:        id,
+		StartedAt: startedAt,
+		Scores:    make([]ScoreBucket, scoreBuckets),
+	}
+	for i := range s.Scores {
+		s.Scores[i].Min = float64(i) / scoreBuckets
+		s.Scores[i].Max = float64(i+1) / scoreBuckets
+	}
+
+	return s
+}
+
+// Record counts a row checked in latency; the score of a failed row is ignored.
+func (s *BatchSummary) Record(latency time.Duration, score float64, duplicate, failed bool) {
+	s.Rows++
+	s.AvgLatency += (latency - s.AvgLatency) / time.Duration(s.Rows)
+
+	if failed {
+		s.Failures++
+		return
+	}
+	if duplicate {
+		s.Duplicates++
+	}
+
+	i := int(score * scoreBuckets)
+	i = max(0, min(i, len(s.Scores)-1))
+	s.Scores[i].Count++
 }
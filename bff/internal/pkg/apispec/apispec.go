@@ -0,0 +1,328 @@
+package apispec
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parameter locations.
+const (
+	InPath     = "path"
+	InQuery    = "query"
+	InHeader   = "header"
+	InFormData = "formData"
+)
+
+// Parameter and schema types.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeFile    = "file"
+)
+
+// Param describes a non-body request parameter.
+type Param struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// Response describes one response of an operation. Body is a prototype value of the payload type.
+type Response struct {
+	Description string
+	Body        any
+}
+
+// DefaultMaxBodySize bounds the request body of operations that do not set their own limit.
+const DefaultMaxBodySize = 1 << 20
+
+// Operation describes a route. Paths use gin syntax (/task/:id).
+// Body is a prototype value of the JSON request body; its `binding` tags drive validation.
+// MaxBodySize bounds the request body; zero applies DefaultMaxBodySize.
+type Operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Consumes    []string
+	Produces    []string
+	Params      []Param
+	Body        any
+	MaxBodySize int64
+	Responses   map[int]Response
+}
+
+// Spec accumulates operations into a Swagger 2.0 document.
+type Spec struct {
+	title       string
+	version     string
+	paths       map[string]map[string]any
+	definitions map[string]any
+}
+
+// New creates an empty spec.
+func New(title, version string) *Spec {
+	return &Spec{
+		title:       title,
+		version:     version,
+		paths:       map[string]map[string]any{},
+		definitions: map[string]any{},
+	}
+}
+
+// Add documents an operation. path is the full route path including group prefixes.
+func (s *Spec) Add(path string, op Operation) {
+	swPath, pathParams := convertPath(path)
+
+	o := map[string]any{
+		"summary":   op.Summary,
+		"responses": s.responses(op.Responses),
+	}
+	if op.Description != "" {
+		o["description"] = op.Description
+	}
+	if len(op.Tags) != 0 {
+		o["tags"] = op.Tags
+	}
+	if op.Deprecated {
+		o["deprecated"] = true
+	}
+	if len(op.Consumes) != 0 {
+		o["consumes"] = op.Consumes
+	}
+	if len(op.Produces) != 0 {
+		o["produces"] = op.Produces
+	}
+
+	var params []any
+	declared := map[string]bool{}
+	for _, p := range op.Params {
+		declared[p.In+":"+p.Name] = true
+		params = append(params, paramToSpec(p))
+	}
+	for _, name := range pathParams {
+		if !declared[InPath+":"+name] {
+			params = append(params, paramToSpec(Param{Name: name, In: InPath, Type: TypeString, Required: true}))
+		}
+	}
+	if op.Body != nil {
+		params = append(params, map[string]any{
+			"in":       "body",
+			"name":     "body",
+			"required": true,
+			"schema":   s.schema(reflect.TypeOf(op.Body)),
+		})
+	}
+	if len(params) != 0 {
+		o["parameters"] = params
+	}
+
+	if s.paths[swPath] == nil {
+		s.paths[swPath] = map[string]any{}
+	}
+	s.paths[swPath][strings.ToLower(op.Method)] = o
+}
+
+// MarshalJSON renders the Swagger 2.0 document.
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"swagger": "2.0",
+		"info": map[string]any{
+			"title":   s.title,
+			"version": s.version,
+		},
+		"consumes":    []string{"application/json"},
+		"produces":    []string{"application/json"},
+		"paths":       s.paths,
+		"definitions": s.definitions,
+	})
+}
+
+// Handler serves the rendered document.
+func (s *Spec) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+func (s *Spec) responses(rs map[int]Response) map[string]any {
+	out := map[string]any{}
+	for code, r := range rs {
+		resp := map[string]any{"description": r.Description}
+		if r.Body != nil {
+			resp["schema"] = s.schema(reflect.TypeOf(r.Body))
+		}
+		out[strconv.Itoa(code)] = resp
+	}
+	if len(out) == 0 {
+		out["default"] = map[string]any{"description": "Response"}
+	}
+
+	return out
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema builds a JSON schema for t, registering named structs as definitions.
+func (s *Spec) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": TypeString, "format": "date-time"}
+	case t == rawMessageType:
+		// Embedded JSON can be any value.
+		return map[string]any{}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := t.Name()
+		if _, ok := s.definitions[name]; !ok {
+			// Reserve the name first so recursive types terminate.
+			s.definitions[name] = map[string]any{}
+			s.definitions[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/definitions/" + name}
+	case t.Kind() == reflect.Struct:
+		return s.structSchema(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": TypeBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": TypeInteger, "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": TypeInteger, "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": TypeNumber}
+	case reflect.String:
+		return map[string]any{"type": TypeString}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": TypeString, "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (s *Spec) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	s.addFields(t, props, &required)
+
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) != 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+
+	return out
+}
+
+// addFields collects the properties of a struct, inlining untagged embedded structs like encoding/json.
+func (s *Spec) addFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			s.addFields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		name := jsonName(f)
+		if name == "-" {
+			continue
+		}
+
+		prop := s.schema(f.Type)
+		if d := f.Tag.Get("description"); d != "" {
+			prop = withAttr(prop, "description", d)
+		}
+		if e := f.Tag.Get("example"); e != "" {
+			prop = withAttr(prop, "example", e)
+		}
+		props[name] = prop
+
+		if strings.Contains(f.Tag.Get("binding"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// withAttr adds an attribute to a schema; $ref siblings are ignored by Swagger, so refs get wrapped.
+func withAttr(schema map[string]any, key, value string) map[string]any {
+	if _, ok := schema["$ref"]; ok {
+		schema = map[string]any{"allOf": []any{schema}}
+	}
+	schema[key] = value
+
+	return schema
+}
+
+// jsonName returns the JSON property name of a struct field.
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "-"
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+
+	return f.Name
+}
+
+func paramToSpec(p Param) map[string]any {
+	typ := p.Type
+	if typ == "" {
+		typ = TypeString
+	}
+	out := map[string]any{
+		"name":     p.Name,
+		"in":       p.In,
+		"type":     typ,
+		"required": p.Required || p.In == InPath,
+	}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+
+	return out
+}
+
+// convertPath turns a gin path into a Swagger path and returns its parameter names.
+func convertPath(path string) (string, []string) {
+	parts := strings.Split(path, "/")
+	var names []string
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			names = append(names, p[1:])
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/"), names
+}
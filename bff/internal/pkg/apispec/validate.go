@@ -0,0 +1,173 @@
+package apispec
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// bodyKey is the gin context key holding the validated request body.
+const bodyKey = "apispec_body"
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationErrorResponse is returned with 400 when a request does not match its operation.
+type ValidationErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func init() {
+	// Report JSON property names instead of Go field names in validation errors.
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			return jsonName(f)
+		})
+	}
+}
+
+// Validate returns a middleware that checks the request against the operation:
+// the body size, typed path/query/header parameters, a multipart body for form files and the JSON body
+// with its `binding` rules. The decoded body is stored in the context and available through Body.
+// Form files are not read, so handlers can stream them.
+func Validate(op Operation) gin.HandlerFunc {
+	var bodyType reflect.Type
+	if op.Body != nil {
+		bodyType = reflect.TypeOf(op.Body)
+	}
+
+	maxBodySize := op.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	return func(c *gin.Context) {
+		// Reject a declared oversized body up front and cut off a streamed one once it passes the limit.
+		if c.Request.ContentLength > maxBodySize {
+			AbortBodyTooLarge(c, maxBodySize)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+
+		var errs []FieldError
+
+		for _, p := range op.Params {
+			if err := validateParam(c, p); err != nil {
+				errs = append(errs, *err)
+			}
+		}
+
+		if bodyType != nil && len(errs) == 0 {
+			body := reflect.New(bodyType)
+			if err := c.ShouldBindJSON(body.Interface()); err != nil {
+				if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+					AbortBodyTooLarge(c, tooLarge.Limit)
+					return
+				}
+				errs = append(errs, bindingErrors(err)...)
+			} else {
+				c.Set(bodyKey, body.Elem().Interface())
+			}
+		}
+
+		if len(errs) != 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ValidationErrorResponse{
+				Message: "request validation failed",
+				Errors:  errs,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AbortBodyTooLarge responds with 413 for a request body over limit bytes.
+func AbortBodyTooLarge(c *gin.Context, limit int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"message": "request body exceeds " + strconv.FormatInt(limit, 10) + " bytes",
+	})
+}
+
+// Body returns the request body validated by the operation middleware.
+func Body[T any](c *gin.Context) T {
+	v, _ := c.Get(bodyKey)
+	body, _ := v.(T)
+
+	return body
+}
+
+func validateParam(c *gin.Context, p Param) *FieldError {
+	var (
+		value   string
+		present bool
+	)
+
+	switch p.In {
+	case InPath:
+		value = c.Param(p.Name)
+		present = value != ""
+	case InQuery:
+		value, present = c.GetQuery(p.Name)
+	case InHeader:
+		value = c.GetHeader(p.Name)
+		present = value != ""
+	case InFormData:
+		// The file part itself is left to the handler, which streams it.
+		if p.Type == TypeFile {
+			if c.ContentType() != binding.MIMEMultipartPOSTForm && p.Required {
+				return &FieldError{Field: p.Name, Reason: "file is required in a multipart/form-data body"}
+			}
+			return nil
+		}
+		value, present = c.GetPostForm(p.Name)
+	}
+
+	if !present {
+		if p.Required || p.In == InPath {
+			return &FieldError{Field: p.Name, Reason: "is required"}
+		}
+		return nil
+	}
+
+	var err error
+	switch p.Type {
+	case TypeInteger:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case TypeNumber:
+		_, err = strconv.ParseFloat(value, 64)
+	case TypeBoolean:
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return &FieldError{Field: p.Name, Reason: "must be of type " + p.Type}
+	}
+
+	return nil
+}
+
+func bindingErrors(err error) []FieldError {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		out := make([]FieldError, len(verrs))
+		for i, fe := range verrs {
+			reason := "failed on the '" + fe.Tag() + "' rule"
+			if fe.Param() != "" {
+				reason += " (" + fe.Param() + ")"
+			}
+			out[i] = FieldError{Field: fe.Field(), Reason: reason}
+		}
+		return out
+	}
+
+	return []FieldError{{Field: "body", Reason: err.Error()}}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+// Role is an access level; every role includes the permissions of the lower ones.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleUploader Role = "uploader"
+	// RoleWorker is granted to the ML workers, which push the results of tasks.
+	RoleWorker Role = "worker"
+	RoleAdmin  Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleUploader: 2,
+	RoleWorker:   3,
+	RoleAdmin:    4,
+}
+
+// privileged reports whether a role is refused while no authenticator is configured.
+func privileged(role Role) bool {
+	return roleRank[role] >= roleRank[RoleWorker]
+}
+
+const (
+	subjectKey = "auth_subject"
+	rolesKey   = "auth_roles"
+)
+
+var (
+	// ErrMissingToken is returned when the request carries no bearer token.
+	ErrMissingToken = errors.New("missing bearer token")
+	// ErrInvalidToken is returned for a token that fails validation.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Config configures token validation.
+type Config struct {
+	Issuer   string
+	Audience string
+	// RolesClaim is the dot separated path of the roles array in the claims, e.g. realm_access.roles.
+	RolesClaim string
+	// KeysMaxAge is how long signing keys are cached before they are refetched.
+	KeysMaxAge time.Duration
+}
+
+// Authenticator validates JWTs issued by an OIDC provider and enforces roles.
+// A nil Authenticator allows the requests up to the uploader role and refuses those requiring the worker
+// or admin role, so those are never served unauthenticated by accident; Disabled allows every request.
+type Authenticator struct {
+	cfg      Config
+	keys     *keySet
+	parser   *jwt.Parser
+	disabled bool
+}
+
+// Disabled returns an authenticator allowing every request, for deployments protected otherwise.
+func Disabled() *Authenticator {
+	return &Authenticator{disabled: true}
+}
+
+// New creates an authenticator for the issuer. Signing keys are discovered lazily.
+func New(cfg Config, client *http.Client) *Authenticator {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &Authenticator{
+		cfg:    cfg,
+		keys:   newKeySet(cfg.Issuer, client),
+		parser: jwt.NewParser(opts...),
+	}
+}
+
+// Require returns a middleware rejecting requests without a valid token granting at least the role.
+func (a *Authenticator) Require(role Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil && privileged(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "role " + string(role) + " required, but authentication is not configured",
+			})
+			return
+		}
+		if a == nil || a.disabled {
+			c.Next()
+			return
+		}
+
+		subject, roles, err := a.authenticate(c.Request.Context(), c.GetHeader("Authorization"))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		if !allows(roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "role " + string(role) + " required",
+			})
+			return
+		}
+
+		c.Set(subjectKey, subject)
+		c.Set(rolesKey, roles)
+
+		l := zerolog.Ctx(c.Request.Context()).With().Str("subject", subject).Logger()
+		c.Request = c.Request.WithContext(l.WithContext(c.Request.Context()))
+
+		c.Next()
+	}
+}
+
+// Subject returns the authenticated subject of the request, empty when authentication is off.
+func Subject(c *gin.Context) string {
+	return c.GetString(subjectKey)
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, header string) (string, []Role, error) {
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || raw == "" {
+		return "", nil, ErrMissingToken
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.key(ctx, kid, a.cfg.KeysMaxAge)
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	subject, _ := claims.GetSubject()
+
+	return subject, rolesFromClaims(claims, a.cfg.RolesClaim), nil
+}
+
+// rolesFromClaims reads the known roles at the dot separated claim path.
+func rolesFromClaims(claims jwt.MapClaims, path string) []Role {
+	var v any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+
+	var names []string
+	switch vv := v.(type) {
+	case []any:
+		for _, r := range vv {
+			if s, ok := r.(string); ok {
+				names = append(names, s)
+			}
+		}
+	case string:
+		names = strings.Fields(vv)
+	}
+
+	var roles []Role
+	for _, n := range names {
+		if _, ok := roleRank[Role(n)]; ok {
+			roles = append(roles, Role(n))
+		}
+	}
+
+	return roles
+}
+
+func allows(roles []Role, required Role) bool {
+	return slices.ContainsFunc(roles, func(r Role) bool {
+		return roleRank[r] >= roleRank[required]
+	})
+}
@@ -0,0 +1,74 @@
+package chromaprint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// MaxOffset is the largest shift, in sub-fingerprints, at which two fingerprints are aligned:
+	// about ten seconds, enough for a copy with a cut or added intro.
+	MaxOffset = 80
+	// MinOverlap is the fewest sub-fingerprints two aligned fingerprints share to be comparable,
+	// about five seconds of audio.
+	MinOverlap = 40
+)
+
+// ErrMalformedFingerprint is returned for bytes that are not an encoded fingerprint.
+var ErrMalformedFingerprint = errors.New("malformed audio fingerprint")
+
+// Fingerprint is the raw chromaprint fingerprint of audio: a 32-bit sub-fingerprint of each of its
+// overlapping frames in order. Similar audio has sub-fingerprints within a small Hamming distance.
+type Fingerprint []uint32
+
+// Bytes encodes the fingerprint as its sub-fingerprints in big-endian order.
+func (fp Fingerprint) Bytes() []byte {
+	b := make([]byte, 4*len(fp))
+	for i, item := range fp {
+		binary.BigEndian.PutUint32(b[4*i:], item)
+	}
+
+	return b
+}
+
+// Parse decodes a fingerprint encoded by Bytes.
+func Parse(b []byte) (Fingerprint, error) {
+	if len(b) == 0 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformedFingerprint, len(b))
+	}
+
+	fp := make(Fingerprint, len(b)/4)
+	for i := range fp {
+		fp[i] = binary.BigEndian.Uint32(b[4*i:])
+	}
+
+	return fp, nil
+}
+
+// Similarity returns the share of equal bits of two fingerprints at the alignment, within MaxOffset,
+// where they agree most. Unrelated audio scores about 0.5 and identical audio 1. It reports false for
+// fingerprints that overlap by fewer than MinOverlap sub-fingerprints at every alignment.
+func Similarity(a, b Fingerprint) (float64, bool) {
+	best, ok := 0.0, false
+	for offset := -MaxOffset; offset <= MaxOffset; offset++ {
+		// Align a[i] with b[i+offset].
+		start := max(0, -offset)
+		end := min(len(a), len(b)-offset)
+		if end-start < MinOverlap {
+			continue
+		}
+
+		var diff int
+		for i := start; i < end; i++ {
+			diff += bits.OnesCount32(a[i] ^ b[i+offset])
+		}
+
+		if s := 1 - float64(diff)/float64(32*(end-start)); s > best {
+			best, ok = s, true
+		}
+	}
+
+	return best, ok
+}
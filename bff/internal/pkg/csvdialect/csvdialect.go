@@ -0,0 +1,202 @@
+// Package csvdialect reads CSV files whose dialect drifts between producers: the delimiter is sniffed
+// from the start of the file, columns are found by header name and timestamps are parsed in any of the
+// common layouts.
+package csvdialect
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sniffSize is how much of the file the delimiter is sniffed from.
+const sniffSize = 64 << 10
+
+// sniffRecords is how many records of the sample are compared.
+const sniffRecords = 20
+
+// delimiters are the candidate delimiters, in order of preference on a tie.
+var delimiters = []rune{',', ';', '\t', '|'}
+
+// bom is the UTF-8 byte order mark spreadsheet programs prepend to exports.
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// timeLayouts are the accepted timestamp layouts. Slashed dates are year-first and dotted ones
+// day-first, so no date is read with day and month swapped.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+}
+
+// ErrInvalidTime is returned for a timestamp in none of the accepted layouts.
+var ErrInvalidTime = errors.New("invalid timestamp")
+
+// NewReader returns a reader of the records of r in the dialect sniffed from its start. Quotes inside
+// unquoted fields are kept and records may have any number of fields.
+func NewReader(r io.Reader) (*csv.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+
+	// Drop the byte order mark, which would otherwise be part of the first column name.
+	if b, err := br.Peek(len(bom)); err == nil && bytes.Equal(b, bom) {
+		_, _ = br.Discard(len(bom))
+	}
+
+	sample, err := br.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("read csv failed: %w", err)
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = Sniff(sample, len(sample) == sniffSize)
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	return reader, nil
+}
+
+// Sniff returns the delimiter splitting the records of sample into the most records with the same
+// number of fields, comma when none splits them. A truncated sample has its last line ignored.
+func Sniff(sample []byte, truncated bool) rune {
+	if truncated {
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i+1]
+		}
+	}
+
+	best, bestScore := delimiters[0], 0
+	for _, d := range delimiters {
+		if score := consistency(sample, d); score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+
+	return best
+}
+
+// consistency counts the records of sample sharing the most frequent number of fields when split by
+// delimiter, zero when the records are not split or fail to parse.
+func consistency(sample []byte, delimiter rune) int {
+	r := csv.NewReader(bytes.NewReader(sample))
+	r.Comma = delimiter
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+
+	counts := map[int]int{}
+	for range sniffRecords {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0
+		}
+		counts[len(record)]++
+	}
+
+	score := 0
+	for fields, n := range counts {
+		if fields > 1 && n > score {
+			score = n
+		}
+	}
+
+	return score
+}
+
+// Columns maps column names to their positions in the records.
+type Columns map[string]int
+
+// MapHeader maps the names of a header to their positions. Names are compared trimmed and lowercased,
+// and a name found in aliases is renamed to the column it is an alias of.
+func MapHeader(header []string, aliases map[string]string) Columns {
+	normalized := make(map[string]string, len(aliases))
+	for alias, name := range aliases {
+		normalized[normalize(alias)] = normalize(name)
+	}
+
+	c := Columns{}
+	for i, name := range header {
+		name = normalize(name)
+		if canonical, ok := normalized[name]; ok {
+			name = canonical
+		}
+		if _, ok := c[name]; !ok {
+			c[name] = i
+		}
+	}
+
+	return c
+}
+
+// Positional maps names to the positions of the columns in a file without a header.
+func Positional(names ...string) Columns {
+	c := make(Columns, len(names))
+	for i, name := range names {
+		c[name] = i
+	}
+
+	return c
+}
+
+// Has reports whether all the named columns are mapped.
+func (c Columns) Has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Field returns the trimmed value of the named column in record, empty when the record lacks it.
+func (c Columns) Field(record []string, name string) string {
+	i, ok := c[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[i])
+}
+
+// ParseTime parses a timestamp in any of the accepted layouts or as Unix seconds or milliseconds.
+// Timestamps without a zone are UTC.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	// Unix timestamps of the millisecond exports have 13 digits until the year 2286.
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+		if len(s) >= 13 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, s)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,177 @@
+package diskcache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hitsDesc      = prometheus.NewDesc("bff_download_cache_hits_total", "Downloads served from the disk cache.", nil, nil)
+	missesDesc    = prometheus.NewDesc("bff_download_cache_misses_total", "Downloads not found in the disk cache.", nil, nil)
+	evictionsDesc = prometheus.NewDesc("bff_download_cache_evictions_total", "Entries evicted from the disk cache.", nil, nil)
+	entriesDesc   = prometheus.NewDesc("bff_download_cache_entries", "Entries in the disk cache.", nil, nil)
+	bytesDesc     = prometheus.NewDesc("bff_download_cache_bytes", "Disk space used by the disk cache.", nil, nil)
+)
+
+// Entry is the metadata stored next to a cached file.
+type Entry struct {
+	MD5          string
+	Size         int64
+	Verification string
+}
+
+type item struct {
+	key   string
+	entry Entry
+}
+
+// Cache is a size bounded directory of files evicted in least recently used order.
+// Metadata is kept in memory only, so the directory is emptied on start.
+// A nil Cache stores nothing, which keeps caching optional.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	size  int64
+
+	hits, misses, evictions uint64
+}
+
+// New creates an empty cache in dir holding up to maxBytes. It returns nil when maxBytes is not positive.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		return nil, nil
+	}
+
+	// Files of a previous process have no metadata and cannot be served.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear cache dir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	return &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+	}, nil
+}
+
+// Get copies the file cached under key to w.
+func (c *Cache) Get(key string, w io.Writer) (Entry, bool, error) {
+	if c == nil {
+		return Entry{}, false, nil
+	}
+
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return Entry{}, false, nil
+	}
+	c.lru.MoveToFront(el)
+	c.hits++
+	entry := el.Value.(*item).entry
+
+	// Open under the lock; an eviction that unlinks the file afterwards does not affect the open handle.
+	f, err := os.Open(c.path(key))
+	c.mu.Unlock()
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to open cached file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return Entry{}, false, fmt.Errorf("failed to copy cached file: %w", err)
+	}
+
+	return entry, true, nil
+}
+
+// Put stores a copy of the first entry.Size bytes of r under key and evicts the least recently
+// used entries until the cache fits. Files larger than the whole cache are not stored.
+func (c *Cache) Put(key string, entry Entry, r io.ReaderAt) error {
+	if c == nil || entry.Size > c.maxBytes {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	_, err = io.Copy(tmp, io.NewSectionReader(r, 0, entry.Size))
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*item).entry.Size
+		el.Value.(*item).entry = entry
+		c.lru.MoveToFront(el)
+	} else {
+		c.items[key] = c.lru.PushFront(&item{key: key, entry: entry})
+	}
+	c.size += entry.Size
+
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		it := el.Value.(*item)
+		c.lru.Remove(el)
+		delete(c.items, it.key)
+		c.size -= it.entry.Size
+		c.evictions++
+		_ = os.Remove(c.path(it.key))
+	}
+
+	return nil
+}
+
+// path returns the file of key. Keys are expected to be safe file names, e.g. hex digests.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Describe implements prometheus.Collector.
+func (c *Cache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hitsDesc
+	ch <- missesDesc
+	ch <- evictionsDesc
+	ch <- entriesDesc
+	ch <- bytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Cache) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(c.hits))
+	ch <- prometheus.MustNewConstMetric(missesDesc, prometheus.CounterValue, float64(c.misses))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(c.evictions))
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(len(c.items)))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(c.size))
+}
@@ -0,0 +1,288 @@
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/diskcache"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/urlpolicy"
+	"github.com/rs/zerolog"
+)
+
+// Verification methods recorded for a download.
+const (
+	// VerifiedContentMD5 means the bytes matched the Content-MD5 header.
+	VerifiedContentMD5 = "content_md5"
+	// VerifiedETag means the bytes matched an MD5 ETag, as served by S3 compatible storages.
+	VerifiedETag = "etag"
+	// Unverified means the source announced no usable checksum.
+	Unverified = "none"
+)
+
+var (
+	// ErrChecksumMismatch is returned when every attempt produced bytes that do not match the checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrStatus is returned for a non-successful HTTP response.
+	ErrStatus = errors.New("unexpected status")
+)
+
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Result describes a completed download.
+type Result struct {
+	// MD5 is the hex MD5 of the downloaded bytes.
+	MD5          string
+	Size         int64
+	Verification string
+	Attempts     int
+	// ETag is the strong entity tag of the downloaded version, empty when the source sent none.
+	ETag string
+	// Cached reports that the bytes were served from the disk cache.
+	Cached bool
+}
+
+// Downloader fetches links into files, retrying transient failures and corrupt transfers.
+type Downloader struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	log      *zerolog.Logger
+	cache    *diskcache.Cache
+}
+
+// New creates a downloader making up to attempts tries with a linear backoff between them.
+// Downloads with an ETag are kept in cache when it is not nil.
+func New(client *http.Client, attempts int, backoff time.Duration, cache *diskcache.Cache, log *zerolog.Logger) *Downloader {
+	return &Downloader{
+		client:   client,
+		attempts: max(attempts, 1),
+		backoff:  backoff,
+		log:      log,
+		cache:    cache,
+	}
+}
+
+// Fetch downloads url into f. When the source provides Content-MD5 or an MD5 ETag the bytes are
+// verified against it and downloaded again on mismatch. f is truncated before every attempt.
+// With a cache, the current ETag of url is looked up first and an unchanged object is copied from disk.
+func (d *Downloader) Fetch(ctx context.Context, url string, f *os.File) (Result, error) {
+	if res, ok := d.fromCache(ctx, url, f); ok {
+		return res, nil
+	}
+
+	res, err := d.download(ctx, url, f)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if d.cache != nil && res.ETag != "" {
+		entry := diskcache.Entry{MD5: res.MD5, Size: res.Size, Verification: res.Verification}
+		if err := d.cache.Put(cacheKey(url, res.ETag), entry, f); err != nil {
+			d.log.Warn().Err(err).Str("url", url).Msg("cache download failed")
+		}
+	}
+
+	return res, nil
+}
+
+// Cached copies the cached version of url into f if the source still serves it, without downloading
+// anything else. It reports false when the link has no current version in cache.
+func (d *Downloader) Cached(ctx context.Context, url string, f *os.File) (Result, bool) {
+	return d.fromCache(ctx, url, f)
+}
+
+// fromCache copies the cached version of url into f if the source still serves it.
+func (d *Downloader) fromCache(ctx context.Context, url string, f *os.File) (Result, bool) {
+	if d.cache == nil {
+		return Result{}, false
+	}
+
+	etag, err := d.currentETag(ctx, url)
+	if err != nil {
+		d.log.Debug().Err(err).Str("url", url).Msg("head failed, skipping cache")
+		return Result{}, false
+	}
+	if etag == "" {
+		return Result{}, false
+	}
+
+	if err := reset(f); err != nil {
+		return Result{}, false
+	}
+	entry, ok, err := d.cache.Get(cacheKey(url, etag), f)
+	if err != nil {
+		d.log.Warn().Err(err).Str("url", url).Msg("read cached download failed")
+		return Result{}, false
+	}
+	if !ok {
+		return Result{}, false
+	}
+
+	return Result{
+		MD5:          entry.MD5,
+		Size:         entry.Size,
+		Verification: entry.Verification,
+		ETag:         etag,
+		Cached:       true,
+	}, true
+}
+
+// currentETag asks the source for the strong entity tag of url.
+func (d *Downloader) currentETag(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%w: %d", ErrStatus, resp.StatusCode)
+	}
+
+	return strongETag(resp.Header), nil
+}
+
+// download fetches url with retries.
+func (d *Downloader) download(ctx context.Context, url string, f *os.File) (Result, error) {
+	var lastErr error
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return Result{}, ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * d.backoff):
+			}
+		}
+
+		res, retry, err := d.fetch(ctx, url, f)
+		if err == nil {
+			res.Attempts = attempt
+			return res, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+
+		d.log.Warn().Err(err).Str("url", url).Int("attempt", attempt).Msg("download failed, retrying")
+	}
+
+	return Result{}, lastErr
+}
+
+// fetch makes a single attempt and reports whether a failure is worth retrying.
+func (d *Downloader) fetch(ctx context.Context, url string, f *os.File) (Result, bool, error) {
+	if err := reset(f); err != nil {
+		return Result{}, false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Result{}, false, fmt.Errorf("create request failed: %w", err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Result{}, transient(ctx, err), fmt.Errorf("get failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return Result{}, retry, fmt.Errorf("%w: %d", ErrStatus, resp.StatusCode)
+	}
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		return Result{}, transient(ctx, err), fmt.Errorf("read body failed: %w", err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return Result{}, true, fmt.Errorf("short body: got %d of %d bytes", n, resp.ContentLength)
+	}
+
+	res := Result{
+		MD5:          hex.EncodeToString(h.Sum(nil)),
+		Size:         n,
+		Verification: Unverified,
+		ETag:         strongETag(resp.Header),
+	}
+
+	expected, method := expectedMD5(resp.Header)
+	if method == Unverified {
+		return res, false, nil
+	}
+	if expected != res.MD5 {
+		return Result{}, true, fmt.Errorf("%w: %s expected %s, got %s", ErrChecksumMismatch, method, expected, res.MD5)
+	}
+	res.Verification = method
+
+	return res, false, nil
+}
+
+// transient reports whether a transport error may go away on retry; links rejected by the policy never do.
+func transient(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, urlpolicy.ErrForbidden) && !errors.Is(err, urlpolicy.ErrTooLarge)
+}
+
+// expectedMD5 extracts the hex MD5 the response announces and how it was announced.
+func expectedMD5(h http.Header) (string, string) {
+	if v := h.Get("Content-MD5"); v != "" {
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil && len(b) == md5.Size {
+			return hex.EncodeToString(b), VerifiedContentMD5
+		}
+	}
+
+	// Weak ETags are not content hashes and multipart ETags (md5-N) are not the MD5 of the object.
+	if v := h.Get("ETag"); v != "" && !strings.HasPrefix(v, "W/") {
+		if tag := strings.ToLower(strings.Trim(v, `"`)); md5ETag.MatchString(tag) {
+			return tag, VerifiedETag
+		}
+	}
+
+	return "", Unverified
+}
+
+// strongETag returns the ETag header unless it is weak; weak tags do not identify the exact bytes.
+func strongETag(h http.Header) string {
+	v := h.Get("ETag")
+	if strings.HasPrefix(v, "W/") {
+		return ""
+	}
+
+	return v
+}
+
+// cacheKey identifies a version of a link; links are expected to be normalized by the caller.
+func cacheKey(url, etag string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + etag))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func reset(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("truncate failed: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek failed: %w", err)
+	}
+
+	return nil
+}
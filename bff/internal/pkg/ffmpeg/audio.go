@@ -0,0 +1,69 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Audio profiles of the extracted audio.
+const (
+	// AudioProfileStereo is 44.1 kHz stereo 16-bit PCM.
+	AudioProfileStereo = "stereo"
+	// AudioProfileSpeech is 16 kHz mono 16-bit PCM, the input of speech models.
+	AudioProfileSpeech = "speech"
+)
+
+// ErrUnknownAudioProfile is returned for an audio profile other than the built-in ones.
+var ErrUnknownAudioProfile = errors.New("unknown audio profile")
+
+// AudioFormat is the format the audio is extracted in, always into a .wav file.
+type AudioFormat struct {
+	// SampleRate is the sample rate in Hz.
+	SampleRate int
+	// Channels is the number of channels; a source with more is downmixed.
+	Channels int
+	// Codec is the PCM codec of the samples, e.g. pcm_s16le or pcm_f32le.
+	Codec string
+}
+
+// audioProfiles are the formats of the built-in audio profiles.
+var audioProfiles = map[string]AudioFormat{
+	AudioProfileStereo: {SampleRate: 44100, Channels: 2, Codec: "pcm_s16le"},
+	AudioProfileSpeech: {SampleRate: 16000, Channels: 1, Codec: "pcm_s16le"},
+}
+
+// AudioProfile returns the format of a built-in audio profile.
+func AudioProfile(name string) (AudioFormat, error) {
+	format, ok := audioProfiles[name]
+	if !ok {
+		return AudioFormat{}, fmt.Errorf("%w: %s", ErrUnknownAudioProfile, name)
+	}
+
+	return format, nil
+}
+
+// orDefault fills the fields of the format that are not set from the stereo profile.
+func (a AudioFormat) orDefault() AudioFormat {
+	def := audioProfiles[AudioProfileStereo]
+	if a.SampleRate <= 0 {
+		a.SampleRate = def.SampleRate
+	}
+	if a.Channels <= 0 {
+		a.Channels = def.Channels
+	}
+	if a.Codec == "" {
+		a.Codec = def.Codec
+	}
+
+	return a
+}
+
+// flags returns the ffmpeg output flags writing audio in the format.
+func (a AudioFormat) flags() []string {
+	return []string{
+		"-acodec", a.Codec,
+		"-ar", strconv.Itoa(a.SampleRate),
+		"-ac", strconv.Itoa(a.Channels),
+	}
+}
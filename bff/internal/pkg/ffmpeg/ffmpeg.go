@@ -1,51 +1,193 @@
 package ffmpeg
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/xid"
 	"github.com/rs/zerolog"
 )
 
-// ErrWrongTimeOutput is an error indicating invalid time output.
-var ErrWrongTimeOutput = errors.New("invalid time output")
+var (
+	// ErrWrongTimeOutput is an error indicating invalid time output.
+	ErrWrongTimeOutput = errors.New("invalid time output")
+	// ErrVideoTooShort is returned when a video is too short to sample frames over it.
+	ErrVideoTooShort = errors.New("video too short")
+)
+
+var (
+	processesRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bff_ffmpeg_processes_running",
+		Help: "Number of running ffmpeg and ffprobe processes.",
+	})
+	processesWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bff_ffmpeg_processes_waiting",
+		Help: "Number of ffmpeg and ffprobe processes waiting for a free slot.",
+	})
+)
 
 // FfmpegExecutor is a struct that handles FFmpeg operations.
 type FfmpegExecutor struct {
-	log *zerolog.Logger
+	log    *zerolog.Logger
+	outDir string
+	// killCtx is cancelled by Kill to kill the running processes.
+	killCtx context.Context
+	kill    context.CancelFunc
+	// slots bounds the processes running at once; a process holds a slot while it runs.
+	slots chan struct{}
+	// hwaccel and extraFlags precede the input of every ffmpeg run; hwaccel is dropped on retry.
+	hwaccel    []string
+	extraFlags []string
+	// audio is the format the audio is extracted in.
+	audio AudioFormat
+	// meter adds up the CPU time of the processes; nil does not measure them.
+	meter *CPUMeter
+}
+
+// CPUMeter adds up the CPU time of the processes run by the executors returned by WithMeter.
+// It is safe for concurrent use.
+type CPUMeter struct {
+	nanos atomic.Int64
+}
+
+// Total returns the user and system CPU time of the processes measured so far.
+func (m *CPUMeter) Total() time.Duration {
+	return time.Duration(m.nanos.Load())
+}
+
+// New initializes and returns a new FfmpegExecutor instance writing its output files to outDir.
+// At most opts.MaxProcesses processes run at once, further ones wait for a free slot. A hardware
+// acceleration method the ffmpeg binary does not support is logged and replaced by software decoding.
+func New(log *zerolog.Logger, outDir string, opts Options) (*FfmpegExecutor, error) {
+	killCtx, kill := context.WithCancel(context.Background())
+
+	maxProcesses := opts.MaxProcesses
+	if maxProcesses <= 0 {
+		maxProcesses = runtime.NumCPU()
+	}
+
+	f := &FfmpegExecutor{
+		log:        log,
+		outDir:     outDir,
+		killCtx:    killCtx,
+		kill:       kill,
+		slots:      make(chan struct{}, maxProcesses),
+		extraFlags: opts.ExtraFlags,
+		audio:      opts.Audio.orDefault(),
+	}
+
+	hwaccel, err := f.hwaccelFlags(opts)
+	if err != nil {
+		kill()
+		return nil, err
+	}
+	f.hwaccel = hwaccel
+
+	return f, nil
 }
 
-// New initializes and returns a new FfmpegExecutor instance.
-func New(log *zerolog.Logger) *FfmpegExecutor {
-	return &FfmpegExecutor{
-		log: log,
+// Kill kills the running processes; processes started afterwards are killed right away.
+func (f *FfmpegExecutor) Kill() {
+	f.kill()
+}
+
+// WithMeter returns an executor adding the CPU time of its processes to m. It shares the output
+// directory and the process slots of f and is killed with it.
+func (f *FfmpegExecutor) WithMeter(m *CPUMeter) *FfmpegExecutor {
+	metered := *f
+	metered.meter = m
+
+	return &metered
+}
+
+// command returns a command that is killed by Kill.
+func (f *FfmpegExecutor) command(name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(f.killCtx, name, args...)
+}
+
+// run runs a command once a slot is free and measures its CPU time, also when it fails.
+func (f *FfmpegExecutor) run(cmd *exec.Cmd) error {
+	if err := f.acquire(); err != nil {
+		return err
 	}
+	defer f.release()
+
+	err := cmd.Run()
+	f.measure(cmd)
+
+	return err
 }
 
-// timespan is a type alias for time.Duration.
-type timespan time.Duration
+// output runs a command once a slot is free, returns its standard output and measures its CPU time,
+// also when it fails.
+func (f *FfmpegExecutor) output(cmd *exec.Cmd) ([]byte, error) {
+	if err := f.acquire(); err != nil {
+		return nil, err
+	}
+	defer f.release()
+
+	out, err := cmd.Output()
+	f.measure(cmd)
+
+	return out, err
+}
+
+// acquire waits for a free process slot; waiting ends without one once the executor is killed.
+func (f *FfmpegExecutor) acquire() error {
+	processesWaiting.Inc()
+	defer processesWaiting.Dec()
+
+	select {
+	case f.slots <- struct{}{}:
+		processesRunning.Inc()
+		return nil
+	case <-f.killCtx.Done():
+		return fmt.Errorf("ffmpeg executor killed: %w", f.killCtx.Err())
+	}
+}
 
-// GetAudioFromVideo extracts the audio from a video file and saves it as a .wav file.
+// release frees the slot of an exited process.
+func (f *FfmpegExecutor) release() {
+	processesRunning.Dec()
+	<-f.slots
+}
+
+// measure adds the CPU time of an exited command to the meter.
+func (f *FfmpegExecutor) measure(cmd *exec.Cmd) {
+	if f.meter == nil || cmd.ProcessState == nil {
+		return
+	}
+
+	f.meter.nanos.Add(int64(cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()))
+}
+
+// GetAudioFromVideo extracts the audio from a video file and saves it as a .wav file in the
+// configured format.
 func (f *FfmpegExecutor) GetAudioFromVideo(filename string) (string, error) {
 	// Generate a unique name for the audio file.
-	audioName := xid.New().String() + ".wav"
+	audioName := filepath.Join(f.outDir, xid.New().String()+".wav")
 
 	// Define the FFmpeg command flags to extract audio from the video.
-	flags := []string{
-		"-i", filename,
-		"-vn",
-		"-acodec", "pcm_s16le",
-		"-ar", "44100",
-		"-ac", "2", audioName,
-	}
+	flags := []string{"-i", filename, "-vn"}
+	flags = append(flags, f.audio.flags()...)
+	flags = append(flags, audioName)
 
-	// Create and run the FFmpeg command.
-	cmd := exec.Command("ffmpeg", flags...)
-	if err := cmd.Run(); err != nil {
+	// Run the FFmpeg command.
+	if err := f.runFFmpeg(flags); err != nil {
 		return "", fmt.Errorf("failed to run ffmpeg: %w", err)
 	}
 
@@ -53,17 +195,10 @@ func (f *FfmpegExecutor) GetAudioFromVideo(filename string) (string, error) {
 	return audioName, nil
 }
 
-// Format formats the timespan as a string.
-func (t timespan) Format(format string) string {
-	// Create a zero time instance and add the timespan duration.
-	z := time.Unix(0, 0).UTC()
-	return z.Add(time.Duration(t)).Format(format)
-}
-
 // GetScreenshotFromVideo generates a screenshot from the middle of the video.
 func (f *FfmpegExecutor) GetScreenshotFromVideo(filename string) (string, error) {
 	// Generate a unique name for the screenshot file.
-	id := xid.New().String() + ".png"
+	id := filepath.Join(f.outDir, xid.New().String()+".png")
 
 	// Get the length of the video.
 	length, err := f.GetVideoLength(filename)
@@ -75,11 +210,10 @@ func (f *FfmpegExecutor) GetScreenshotFromVideo(filename string) (string, error)
 	length /= 2
 
 	// Define the FFmpeg command flags to generate a screenshot from the video.
-	flags := []string{"-ss", timespan(length).Format("15:04:05"), "-i", filename, "-frames:v", "1", id}
+	flags := []string{"-ss", fmt.Sprintf("%.3f", length.Seconds()), "-i", filename, "-frames:v", "1", id}
 
-	// Create and run the FFmpeg command.
-	cmd := exec.Command("ffmpeg", flags...)
-	if err := cmd.Run(); err != nil {
+	// Run the FFmpeg command.
+	if err := f.runFFmpeg(flags); err != nil {
 		return "", fmt.Errorf("ffmpeg run failed: %w", err)
 	}
 
@@ -87,39 +221,308 @@ func (f *FfmpegExecutor) GetScreenshotFromVideo(filename string) (string, error)
 	return id, nil
 }
 
+// GetScreenshotsFromVideo generates count screenshots of size x size pixels evenly spaced over the video,
+// each from the middle of its part. The files are returned in the order of the video; on failure the
+// files generated so far are returned too, so they can be removed.
+func (f *FfmpegExecutor) GetScreenshotsFromVideo(filename string, count, size int) ([]string, error) {
+	// Get the length of the video.
+	length, err := f.GetVideoLength(filename)
+	if err != nil {
+		return nil, fmt.Errorf("get video length failed: %w", err)
+	}
+
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		// Generate a unique name for the screenshot file.
+		id := filepath.Join(f.outDir, xid.New().String()+".png")
+
+		// Calculate the middle point of the part of the video.
+		at := time.Duration((float64(i) + 0.5) / float64(count) * float64(length))
+
+		// Define the FFmpeg command flags to generate a scaled screenshot from the video.
+		flags := []string{
+			"-ss", fmt.Sprintf("%.3f", at.Seconds()),
+			"-i", filename,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:%d", size, size),
+			id,
+		}
+
+		// Run the FFmpeg command.
+		if err := f.runFFmpeg(flags); err != nil {
+			return ids, fmt.Errorf("ffmpeg run failed: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	// Return the names of the generated screenshot files.
+	return ids, nil
+}
+
+// GetSpriteSheetFromVideo generates a JPEG sprite sheet of columns x rows frames evenly spaced over the
+// video, each scaled to width pixels and tiled left to right, top to bottom. It also returns the
+// position of each frame in the video, in the order of the tiles.
+func (f *FfmpegExecutor) GetSpriteSheetFromVideo(filename string, columns, rows, width int) (string, []time.Duration, error) {
+	// Generate a unique name for the sprite sheet file.
+	id := filepath.Join(f.outDir, xid.New().String()+".jpg")
+
+	// Get the length of the video.
+	length, err := f.GetVideoLength(filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("get video length failed: %w", err)
+	}
+	if length <= 0 {
+		return "", nil, ErrVideoTooShort
+	}
+
+	// Sample the frames at a rate spreading them over the whole video; the fps filter takes the
+	// first one at the start.
+	count := columns * rows
+	interval := length / time.Duration(count)
+	timestamps := make([]time.Duration, count)
+	for i := range timestamps {
+		timestamps[i] = time.Duration(i) * interval
+	}
+
+	// Define the FFmpeg command flags to tile the scaled frames into a single image.
+	flags := []string{
+		"-i", filename,
+		"-vf", fmt.Sprintf("fps=%d/%.3f,scale=%d:-2,tile=%dx%d", count, length.Seconds(), width, columns, rows),
+		"-frames:v", "1",
+		"-q:v", "5",
+		id,
+	}
+
+	// Run the FFmpeg command.
+	if err := f.runFFmpeg(flags); err != nil {
+		return "", nil, fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// Return the name of the generated sprite sheet and the positions of its frames.
+	return id, timestamps, nil
+}
+
+// HLSPlaylist is the name of the playlist TranscodeHLS writes; the segments it lists are named
+// segment000.ts, segment001.ts and so on.
+const HLSPlaylist = "index.m3u8"
+
+// TranscodeHLS transcodes the video to an HLS rendition of H.264 and AAC segments of about segment
+// length, scaled down to at most maxHeight pixels high. The playlist and the segments are written to a
+// new directory, which is returned; on failure it is returned too, so it can be removed.
+func (f *FfmpegExecutor) TranscodeHLS(filename string, segment time.Duration, maxHeight int) (string, error) {
+	// Create a unique directory for the playlist and its segments.
+	dir := filepath.Join(f.outDir, xid.New().String())
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create hls directory: %w", err)
+	}
+
+	// Define the FFmpeg command flags to transcode the video to a single rendition for playback.
+	flags := []string{
+		"-i", filename,
+		"-vf", fmt.Sprintf("scale=-2:'trunc(min(%d,ih)/2)*2'", maxHeight),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%.3f", segment.Seconds()),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "segment%03d.ts"),
+		filepath.Join(dir, HLSPlaylist),
+	}
+
+	// Run the FFmpeg command.
+	if err := f.runFFmpeg(flags); err != nil {
+		return dir, fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// Return the directory of the rendition.
+	return dir, nil
+}
+
 // GetVideoLength retrieves the length of the video using ffprobe.
 func (f *FfmpegExecutor) GetVideoLength(filename string) (time.Duration, error) {
-	// Define the ffprobe command flags to get the video duration.
-	flags := []string{"-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", "-sexagesimal", filename}
+	// Define the ffprobe command flags to report the video duration in seconds as JSON.
+	flags := []string{"-v", "error", "-show_entries", "format=duration", "-of", "json", filename}
 	f.log.Debug().Strs("flags", flags).Msg("starting ffprobe")
 
 	// Create and run the ffprobe command.
-	cmd := exec.Command("ffprobe", flags...)
+	cmd := f.command("ffprobe", flags...)
 
 	// Capture the output of the ffprobe command.
-	outputBytes, err := cmd.Output()
+	outputBytes, err := f.output(cmd)
 	if err != nil {
 		return 0, fmt.Errorf("ffprobe get output failed: %w", err)
 	}
 
+	var out probeOutput
+	if err := json.Unmarshal(outputBytes, &out); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
 	// Parse the output to get the video duration.
-	return parseTime(string(outputBytes))
+	return parseDuration(out.Format.Duration)
+}
+
+// Metadata describes a media file as probed by ffprobe: its container and its first video and audio
+// streams. Properties ffprobe does not report are zero.
+type Metadata struct {
+	Format   string
+	Duration time.Duration
+	// BitRate is the overall bit rate of the file in bits per second.
+	BitRate    int64
+	VideoCodec string
+	Width      int
+	Height     int
+	FrameRate  float64
+	AudioCodec string
 }
 
-// parseTime parses the time output from ffprobe and returns it as a time.Duration.
-func parseTime(t string) (time.Duration, error) {
-	// Split the time output into hours, minutes, and seconds.
-	sp := strings.Split(t, ".")
-	if len(sp) != 2 {
-		return 0, fmt.Errorf("%w: %s", ErrWrongTimeOutput, t)
+// probeOutput is the JSON output of ffprobe for the entries Probe and GetVideoLength ask for.
+type probeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+	} `json:"streams"`
+}
+
+// Probe retrieves the container format, the codecs, the resolution, the frame rate, the bit rate and
+// the duration of a media file using ffprobe.
+func (f *FfmpegExecutor) Probe(filename string) (Metadata, error) {
+	// Define the ffprobe command flags to report the format and the streams as JSON.
+	flags := []string{
+		"-v", "error",
+		"-show_entries", "format=format_name,duration,bit_rate:stream=codec_type,codec_name,width,height,avg_frame_rate",
+		"-of", "json",
+		filename,
 	}
+	f.log.Debug().Strs("flags", flags).Msg("starting ffprobe")
+
+	// Create and run the ffprobe command.
+	cmd := f.command("ffprobe", flags...)
 
-	// Parse the time string into a time.Time instance.
-	tt, err := time.Parse("15:04:05", sp[0])
+	// Capture the output of the ffprobe command.
+	outputBytes, err := f.output(cmd)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse time: %w", err)
+		return Metadata{}, fmt.Errorf("ffprobe get output failed: %w", err)
+	}
+
+	var out probeOutput
+	if err := json.Unmarshal(outputBytes, &out); err != nil {
+		return Metadata{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
 	}
 
-	// Calculate the duration by subtracting the zero time instance.
-	return tt.Sub(time.Time{}), nil
+	// Read the container; ffprobe reports N/A for what it cannot determine.
+	md := Metadata{Format: out.Format.FormatName}
+	if duration, err := parseDuration(out.Format.Duration); err == nil {
+		md.Duration = duration
+	}
+	if bitRate, err := strconv.ParseInt(out.Format.BitRate, 10, 64); err == nil {
+		md.BitRate = bitRate
+	}
+
+	// Read the first video and audio streams.
+	for _, s := range out.Streams {
+		switch {
+		case s.CodecType == "video" && md.VideoCodec == "":
+			md.VideoCodec = s.CodecName
+			md.Width = s.Width
+			md.Height = s.Height
+			md.FrameRate = parseRate(s.AvgFrameRate)
+		case s.CodecType == "audio" && md.AudioCodec == "":
+			md.AudioCodec = s.CodecName
+		}
+	}
+
+	return md, nil
+}
+
+// parseRate parses a frame rate reported by ffprobe as a fraction, e.g. 30000/1001. An unknown rate,
+// reported as 0/0, is zero.
+func parseRate(r string) float64 {
+	num, den, ok := strings.Cut(r, "/")
+	if !ok {
+		return 0
+	}
+
+	n, errNum := strconv.ParseFloat(num, 64)
+	d, errDen := strconv.ParseFloat(den, 64)
+	if errNum != nil || errDen != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
+
+// parseDuration parses a duration reported by ffprobe in seconds, e.g. 90061.250000. ffprobe formats
+// numbers independently of the locale; a duration it cannot determine is reported as N/A or left out.
+func parseDuration(t string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(t, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, fmt.Errorf("%w: %q", ErrWrongTimeOutput, t)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// GenerateTestClip renders a synthetic video with a tone track; the variant shifts the picture hue
+// and the tone frequency so that generated clips are distinguishable from each other.
+func (f *FfmpegExecutor) GenerateTestClip(duration time.Duration, variant int) (string, error) {
+	// Generate a unique name for the clip file.
+	id := filepath.Join(f.outDir, xid.New().String()+".mp4")
+	seconds := fmt.Sprintf("%.3f", duration.Seconds())
+
+	// Define the FFmpeg command flags to render the test pattern and the tone.
+	flags := []string{
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc=size=320x240:rate=25:duration=%s,hue=h=%d", seconds, (variant*37)%360),
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=%d:duration=%s", 220+(variant%20)*40, seconds),
+		"-shortest",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		id,
+	}
+
+	// Create and run the FFmpeg command.
+	cmd := f.command("ffmpeg", flags...)
+	if err := f.run(cmd); err != nil {
+		return "", fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// Return the name of the generated clip.
+	return id, nil
+}
+
+// RemuxToMP4 copies the first video and audio streams of a media file into a new MP4 file with its
+// index at the front. Streams MP4 cannot hold are transcoded to H.264 and AAC instead.
+func (f *FfmpegExecutor) RemuxToMP4(filename string) (string, error) {
+	// Generate a unique name for the MP4 file.
+	id := filepath.Join(f.outDir, xid.New().String()+".mp4")
+
+	// Define the FFmpeg command flags to copy the streams, and to transcode them should that fail.
+	streams := []string{"-i", filename, "-map", "0:v:0", "-map", "0:a:0?"}
+	remux := slices.Concat(streams, []string{"-c", "copy", "-movflags", "+faststart", id})
+	transcode := slices.Concat(streams, []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
+		"-c:a", "aac",
+		"-movflags", "+faststart", id,
+	})
+
+	// Run the FFmpeg command; the transcode overwrites the output of a failed copy.
+	if err := f.runFFmpeg(remux); err != nil {
+		f.log.Debug().Err(err).Str("file", filename).Msg("stream copy to mp4 failed, transcoding")
+		if err := f.runFFmpeg(transcode); err != nil {
+			_ = os.Remove(id)
+			return "", fmt.Errorf("ffmpeg run failed: %w", err)
+		}
+	}
+
+	// Return the name of the MP4 file.
+	return id, nil
 }
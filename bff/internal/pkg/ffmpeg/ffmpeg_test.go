@@ -0,0 +1,83 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "seconds", in: "12.500000", want: 12500 * time.Millisecond},
+		{name: "over a day", in: "90061.250000", want: 25*time.Hour + time.Minute + time.Second + 250*time.Millisecond},
+		{name: "sub-second", in: "0.040000", want: 40 * time.Millisecond},
+		{name: "zero", in: "0.000000", want: 0},
+		{name: "not available", in: "N/A", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+		{name: "negative", in: "-1.000000", wantErr: true},
+		{name: "infinite", in: "inf", wantErr: true},
+		{name: "not a number", in: "nan", wantErr: true},
+		{name: "comma decimal", in: "12,500000", wantErr: true},
+		{name: "clock format", in: "0:00:12.500000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDuration(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrWrongTimeOutput) {
+					t.Fatalf("parseDuration(%q) error = %v, want %v", tt.in, err, ErrWrongTimeOutput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDuration(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeOutputDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "duration", in: `{"format": {"duration": "90061.250000"}}`, want: 90061250 * time.Millisecond},
+		{name: "duration absent", in: `{"format": {}}`, wantErr: true},
+		{name: "format absent", in: `{}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out probeOutput
+			if err := json.Unmarshal([]byte(tt.in), &out); err != nil {
+				t.Fatalf("unmarshal %s: %v", tt.in, err)
+			}
+
+			got, err := parseDuration(out.Format.Duration)
+			if tt.wantErr {
+				if !errors.Is(err, ErrWrongTimeOutput) {
+					t.Fatalf("duration of %s error = %v, want %v", tt.in, err, ErrWrongTimeOutput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("duration of %s error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("duration of %s = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrNoFingerprint is returned when fpcalc reports no fingerprint, e.g. for silent or too short audio.
+var ErrNoFingerprint = errors.New("no audio fingerprint")
+
+// fpcalcOutput is the JSON output of fpcalc for a raw fingerprint.
+type fpcalcOutput struct {
+	Duration    float64 `json:"duration"`
+	Fingerprint []int64 `json:"fingerprint"`
+}
+
+// GetAudioFingerprint calculates the raw chromaprint fingerprint of the first length of an audio
+// file with fpcalc: one 32-bit sub-fingerprint for about every 0.124 seconds of audio.
+func (f *FfmpegExecutor) GetAudioFingerprint(filename string, length time.Duration) ([]uint32, error) {
+	// Define the fpcalc command flags to report the raw fingerprint as JSON.
+	flags := []string{
+		"-raw",
+		"-json",
+		"-length", strconv.Itoa(int(length.Seconds())),
+		filename,
+	}
+	f.log.Debug().Strs("flags", flags).Msg("starting fpcalc")
+
+	// Create and run the fpcalc command, capturing its output.
+	outputBytes, err := f.output(f.command("fpcalc", flags...))
+	if err != nil {
+		return nil, fmt.Errorf("fpcalc get output failed: %w", err)
+	}
+
+	var out fpcalcOutput
+	if err := json.Unmarshal(outputBytes, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse fpcalc output: %w", err)
+	}
+	if len(out.Fingerprint) == 0 {
+		return nil, ErrNoFingerprint
+	}
+
+	// Older fpcalc versions print the sub-fingerprints as signed integers.
+	fingerprint := make([]uint32, len(out.Fingerprint))
+	for i, v := range out.Fingerprint {
+		fingerprint[i] = uint32(v)
+	}
+
+	return fingerprint, nil
+}
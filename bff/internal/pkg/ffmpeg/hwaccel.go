@@ -0,0 +1,104 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Hardware acceleration methods selectable in Options.
+const (
+	HWAccelNone  = ""
+	HWAccelNVENC = "nvenc"
+	HWAccelVAAPI = "vaapi"
+	HWAccelQSV   = "qsv"
+)
+
+// ErrUnknownHWAccel is returned for a hardware acceleration method other than the supported ones.
+var ErrUnknownHWAccel = errors.New("unknown hardware acceleration")
+
+// Options configures an executor.
+type Options struct {
+	// MaxProcesses bounds the processes running at once; zero allows one per CPU.
+	MaxProcesses int
+	// HWAccel decodes videos on the GPU: nvenc, vaapi or qsv; empty decodes in software.
+	HWAccel string
+	// HWAccelDevice is the device decoding with vaapi, e.g. /dev/dri/renderD128; empty picks the default.
+	HWAccelDevice string
+	// ExtraFlags are added to every ffmpeg run before its input, e.g. -threads 2.
+	ExtraFlags []string
+	// Audio is the format the audio is extracted in; unset fields are those of the stereo profile.
+	Audio AudioFormat
+}
+
+// hwaccelNames maps the methods of Options to the names ffmpeg lists them by in -hwaccels.
+var hwaccelNames = map[string]string{
+	HWAccelNVENC: "cuda",
+	HWAccelVAAPI: "vaapi",
+	HWAccelQSV:   "qsv",
+}
+
+// hwaccelFlags returns the input flags decoding with the configured method, or nil when the method is
+// not set or not supported by the ffmpeg binary.
+func (f *FfmpegExecutor) hwaccelFlags(opts Options) ([]string, error) {
+	if opts.HWAccel == HWAccelNone {
+		return nil, nil
+	}
+
+	name, ok := hwaccelNames[opts.HWAccel]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHWAccel, opts.HWAccel)
+	}
+
+	// Check that the binary was built with the method; decoding falls back to software otherwise.
+	out, err := f.command("ffmpeg", "-hide_banner", "-hwaccels").Output()
+	if err != nil {
+		f.log.Warn().Err(err).Str("hwaccel", opts.HWAccel).Msg("failed to list ffmpeg hardware acceleration methods, decoding in software")
+		return nil, nil
+	}
+	if !slices.Contains(strings.Fields(string(out)), name) {
+		f.log.Warn().Str("hwaccel", opts.HWAccel).Msg("ffmpeg does not support the hardware acceleration method, decoding in software")
+		return nil, nil
+	}
+
+	flags := []string{"-hwaccel", name}
+	if opts.HWAccel == HWAccelVAAPI && opts.HWAccelDevice != "" {
+		flags = append(flags, "-hwaccel_device", opts.HWAccelDevice)
+	}
+
+	return flags, nil
+}
+
+// runFFmpeg runs ffmpeg with flags, preceded by the configured extra flags. With hardware acceleration
+// it decodes on the GPU first and, should that fail, e.g. for a codec the device does not support,
+// runs again in software.
+func (f *FfmpegExecutor) runFFmpeg(flags []string) error {
+	return f.runFFmpegLog(flags, nil)
+}
+
+// runFFmpegLog runs ffmpeg like runFFmpeg and captures the log of the last run in log, unless it is nil.
+func (f *FfmpegExecutor) runFFmpegLog(flags []string, log *bytes.Buffer) error {
+	// Outputs of a failed run are overwritten by the next one.
+	base := append([]string{"-y"}, f.extraFlags...)
+
+	attempt := func(args []string) error {
+		cmd := f.command("ffmpeg", args...)
+		if log != nil {
+			log.Reset()
+			cmd.Stderr = log
+		}
+		return f.run(cmd)
+	}
+
+	if len(f.hwaccel) != 0 {
+		err := attempt(slices.Concat(f.hwaccel, base, flags))
+		if err == nil || f.killCtx.Err() != nil {
+			return err
+		}
+		f.log.Warn().Err(err).Strs("hwaccel", f.hwaccel).Msg("hardware accelerated ffmpeg run failed, retrying in software")
+	}
+
+	return attempt(slices.Concat(base, flags))
+}
@@ -0,0 +1,79 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rs/xid"
+)
+
+// ErrNoLoudnessMeasurement is returned when the loudnorm filter logs no measurement of the audio.
+var ErrNoLoudnessMeasurement = errors.New("no loudness measurement")
+
+// Loudness is an EBU R128 loudness target: the integrated loudness in LUFS, the maximum true peak
+// in dBTP and the loudness range in LU.
+type Loudness struct {
+	Integrated float64
+	TruePeak   float64
+	Range      float64
+}
+
+// loudnormMeasurement is the measurement the loudnorm filter logs as JSON after its first pass.
+// The filter reports the numbers as strings.
+type loudnormMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// NormalizeLoudness normalizes the loudness of an audio file to the target in two passes of the
+// loudnorm filter: the first measures the audio, the second applies a linear gain from the measurement.
+// The result is saved as a new .wav file in the configured format, as by GetAudioFromVideo.
+func (f *FfmpegExecutor) NormalizeLoudness(filename string, target Loudness) (string, error) {
+	filter := fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f", target.Integrated, target.TruePeak, target.Range)
+
+	// Measure the audio, capturing the measurement the filter logs.
+	var log bytes.Buffer
+	if err := f.runFFmpegLog([]string{
+		"-loglevel", "info",
+		"-i", filename,
+		"-af", filter + ":print_format=json",
+		"-f", "null", "-",
+	}, &log); err != nil {
+		return "", fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// The measurement is the last JSON object of the log.
+	out := log.Bytes()
+	start, end := bytes.LastIndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if start < 0 || end < start {
+		return "", ErrNoLoudnessMeasurement
+	}
+
+	var m loudnormMeasurement
+	if err := json.Unmarshal(out[start:end+1], &m); err != nil {
+		return "", fmt.Errorf("failed to parse loudness measurement: %w", err)
+	}
+
+	// Generate a unique name for the normalized audio file.
+	audioName := filepath.Join(f.outDir, xid.New().String()+".wav")
+
+	// Apply the gain, resampling back from the rate the filter works at.
+	flags := []string{
+		"-i", filename,
+		"-af", fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+			filter, m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset),
+	}
+	flags = append(flags, f.audio.flags()...)
+	if err := f.runFFmpeg(append(flags, audioName)); err != nil {
+		return "", fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// Return the name of the normalized audio file.
+	return audioName, nil
+}
@@ -0,0 +1,92 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// showinfoTime matches the position of a frame in a line logged by the showinfo filter.
+var showinfoTime = regexp.MustCompile(`\bpts_time:\s*([0-9.]+)`)
+
+// DetectScenes finds the scene cuts of the video: the frames differing from the previous frame by more
+// than threshold, between 0 and 1. It returns their positions in the video in order; the first scene
+// starts at the beginning of the video and is not a cut.
+func (f *FfmpegExecutor) DetectScenes(filename string, threshold float64) ([]time.Duration, error) {
+	// Define the FFmpeg command flags to log the cut frames and discard the output.
+	flags := []string{
+		"-loglevel", "info",
+		"-i", filename,
+		"-an",
+		"-vf", fmt.Sprintf("select='gt(scene,%.3f)',showinfo", threshold),
+		"-f", "null", "-",
+	}
+
+	// Run the FFmpeg command, capturing the log of the showinfo filter.
+	var log bytes.Buffer
+	if err := f.runFFmpegLog(flags, &log); err != nil {
+		return nil, fmt.Errorf("ffmpeg run failed: %w", err)
+	}
+
+	// Parse the positions of the cut frames.
+	var cuts []time.Duration
+	sc := bufio.NewScanner(&log)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.Contains(line, "Parsed_showinfo") {
+			continue
+		}
+		m := showinfoTime.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		at, err := parseDuration(m[1])
+		if err != nil {
+			return nil, err
+		}
+		cuts = append(cuts, at)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ffmpeg log: %w", err)
+	}
+
+	return cuts, nil
+}
+
+// GetScreenshotsAt generates a JPEG screenshot at each of the positions in the video, scaled to width
+// pixels wide. The files are returned in the order of the positions; on failure the files generated
+// so far are returned too, so they can be removed.
+func (f *FfmpegExecutor) GetScreenshotsAt(filename string, at []time.Duration, width int) ([]string, error) {
+	ids := make([]string, 0, len(at))
+	for _, pos := range at {
+		// Generate a unique name for the screenshot file.
+		id := filepath.Join(f.outDir, xid.New().String()+".jpg")
+
+		// Define the FFmpeg command flags to generate a scaled screenshot from the video.
+		flags := []string{
+			"-ss", fmt.Sprintf("%.3f", pos.Seconds()),
+			"-i", filename,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", width),
+			"-q:v", "3",
+			id,
+		}
+
+		// Run the FFmpeg command.
+		if err := f.runFFmpeg(flags); err != nil {
+			return ids, fmt.Errorf("ffmpeg run failed: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	// Return the names of the generated screenshot files.
+	return ids, nil
+}
@@ -0,0 +1,515 @@
+package msgformat
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// avroCodec encodes the messages with the embedded .avsc schemas. Messages are decoded with the schema
+// they were written with, fetched from the registry, and their fields matched by name, so fields added by
+// a newer schema are skipped and missing ones are zero.
+type avroCodec struct {
+	registry *Registry
+	link     string
+	response string
+
+	mu      sync.Mutex
+	writers map[int]*avroSchema
+}
+
+func newAvro(registry *Registry) (*avroCodec, error) {
+	link, err := schema("kafka_link.avsc")
+	if err != nil {
+		return nil, err
+	}
+	response, err := schema("kafka_response.avsc")
+	if err != nil {
+		return nil, err
+	}
+
+	return &avroCodec{
+		registry: registry,
+		link:     link,
+		response: response,
+		writers:  map[int]*avroSchema{},
+	}, nil
+}
+
+func (c *avroCodec) EncodeLink(ctx context.Context, topic string, l model.KafkaLink) ([]byte, error) {
+	id, err := c.registry.Register(ctx, subject(topic), schemaTypeAvro, c.link)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = binary.AppendVarint(b, l.TaskID)
+	b = appendAvroString(b, l.Link)
+	b = appendAvroString(b, l.IndexVersion)
+	if len(l.Keyframes) > 0 {
+		b = binary.AppendVarint(b, int64(len(l.Keyframes)))
+		for _, k := range l.Keyframes {
+			b = appendAvroDouble(b, k.Start)
+			b = appendAvroDouble(b, k.End)
+			b = appendAvroDouble(b, k.At)
+			b = appendAvroString(b, k.Link)
+		}
+	}
+	b = binary.AppendVarint(b, 0)
+
+	return frame(id, b), nil
+}
+
+func (c *avroCodec) DecodeLink(ctx context.Context, b []byte) (model.KafkaLink, error) {
+	v, err := c.decode(ctx, b)
+	if err != nil {
+		return model.KafkaLink{}, err
+	}
+
+	l := model.KafkaLink{
+		TaskID:       avroLong(v, "task_id"),
+		Link:         avroString(v, "link"),
+		IndexVersion: avroString(v, "index_version"),
+	}
+	for _, k := range avroRecords(v, "keyframes") {
+		l.Keyframes = append(l.Keyframes, model.KafkaKeyframe{
+			Start: avroDouble(k, "start"),
+			End:   avroDouble(k, "end"),
+			At:    avroDouble(k, "at"),
+			Link:  avroString(k, "link"),
+		})
+	}
+
+	return l, nil
+}
+
+func (c *avroCodec) EncodeResponse(ctx context.Context, topic string, r model.KafkaResponse) ([]byte, error) {
+	id, err := c.registry.Register(ctx, subject(topic), schemaTypeAvro, c.response)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = binary.AppendVarint(b, r.TaskID)
+	if len(r.Copy) > 0 {
+		b = binary.AppendVarint(b, int64(len(r.Copy)))
+		for _, cr := range r.Copy {
+			b = appendAvroString(b, cr.Name)
+			b = appendAvroDouble(b, cr.Probability)
+			if len(cr.Segments) > 0 {
+				b = binary.AppendVarint(b, int64(len(cr.Segments)))
+				for _, s := range cr.Segments {
+					b = appendAvroDouble(b, s.Start)
+					b = appendAvroDouble(b, s.End)
+					b = appendAvroDouble(b, s.ReferenceStart)
+					b = appendAvroDouble(b, s.ReferenceEnd)
+					b = appendAvroDouble(b, s.Probability)
+				}
+			}
+			b = binary.AppendVarint(b, 0)
+		}
+	}
+	b = binary.AppendVarint(b, 0)
+
+	return frame(id, b), nil
+}
+
+func (c *avroCodec) DecodeResponse(ctx context.Context, b []byte) (model.KafkaResponse, error) {
+	v, err := c.decode(ctx, b)
+	if err != nil {
+		return model.KafkaResponse{}, err
+	}
+
+	r := model.KafkaResponse{TaskID: avroLong(v, "task_id"), Copy: []model.Copyright{}}
+	for _, cr := range avroRecords(v, "copyright") {
+		copyright := model.Copyright{
+			Name:        avroString(cr, "name"),
+			Probability: avroDouble(cr, "probability"),
+		}
+		for _, s := range avroRecords(cr, "segments") {
+			copyright.Segments = append(copyright.Segments, model.Segment{
+				Start:          avroDouble(s, "start"),
+				End:            avroDouble(s, "end"),
+				ReferenceStart: avroDouble(s, "reference_start"),
+				ReferenceEnd:   avroDouble(s, "reference_end"),
+				Probability:    avroDouble(s, "probability"),
+			})
+		}
+		r.Copy = append(r.Copy, copyright)
+	}
+
+	return r, nil
+}
+
+// decode decodes a message in the wire format with its writer schema into a record.
+func (c *avroCodec) decode(ctx context.Context, b []byte) (map[string]any, error) {
+	id, payload, err := unframe(b)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := c.writer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if writer.typ != "record" {
+		return nil, fmt.Errorf("%w: schema %d is not a record", ErrMalformed, id)
+	}
+
+	d := avroDecoder{b: payload}
+	v := d.decode(writer)
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, d.err)
+	}
+
+	return v.(map[string]any), nil
+}
+
+// writer returns the parsed schema with the given ID.
+func (c *avroCodec) writer(ctx context.Context, id int) (*avroSchema, error) {
+	c.mu.Lock()
+	s, ok := c.writers[id]
+	c.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	raw, err := c.registry.Schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s, err = parseAvroSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse schema %d failed: %w", id, err)
+	}
+
+	c.mu.Lock()
+	c.writers[id] = s
+	c.mu.Unlock()
+
+	return s, nil
+}
+
+func appendAvroString(b []byte, s string) []byte {
+	b = binary.AppendVarint(b, int64(len(s)))
+
+	return append(b, s...)
+}
+
+func appendAvroDouble(b []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// The avro helpers read a field of a decoded record, promoting numbers as Avro schema resolution does.
+
+func avroLong(r map[string]any, name string) int64 {
+	v, _ := r[name].(int64)
+
+	return v
+}
+
+func avroDouble(r map[string]any, name string) float64 {
+	switch v := r[name].(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+
+	return 0
+}
+
+func avroString(r map[string]any, name string) string {
+	switch v := r[name].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return ""
+}
+
+func avroRecords(r map[string]any, name string) []map[string]any {
+	items, _ := r[name].([]any)
+	records := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if record, ok := item.(map[string]any); ok {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// avroSchema is a parsed Avro schema.
+type avroSchema struct {
+	typ      string
+	name     string
+	fields   []avroField   // record
+	items    *avroSchema   // array, map
+	branches []*avroSchema // union
+	symbols  []string      // enum
+	size     int           // fixed
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+// parseAvroSchema parses a schema in its JSON form.
+func parseAvroSchema(raw string) (*avroSchema, error) {
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %w", err)
+	}
+
+	p := avroParser{named: map[string]*avroSchema{}}
+
+	return p.parse(v, "")
+}
+
+// avroParser resolves the references to the named types of a schema.
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(v any, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		switch v {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{typ: v}, nil
+		}
+		if s, ok := p.named[fullName(v, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %s", v)
+	case []any:
+		s := &avroSchema{typ: "union"}
+		for _, branch := range v {
+			b, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, b)
+		}
+		return s, nil
+	case map[string]any:
+		return p.parseComplex(v, namespace)
+	}
+
+	return nil, fmt.Errorf("invalid schema %v", v)
+}
+
+func (p *avroParser) parseComplex(v map[string]any, namespace string) (*avroSchema, error) {
+	typ, _ := v["type"].(string)
+	s := &avroSchema{typ: typ}
+
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := v["name"].(string)
+		if ns, ok := v["namespace"].(string); ok {
+			namespace = ns
+		}
+		s.name = fullName(name, namespace)
+		if i := strings.LastIndexByte(s.name, '.'); i >= 0 {
+			namespace = s.name[:i]
+		}
+		// Registered before the fields are parsed, as a record may refer to itself.
+		p.named[s.name] = s
+	}
+
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		fields, _ := v["fields"].([]any)
+		for _, f := range fields {
+			f, _ := f.(map[string]any)
+			name, _ := f["name"].(string)
+			fs, err := p.parse(f["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			s.fields = append(s.fields, avroField{name: name, schema: fs})
+		}
+	case "enum":
+		symbols, _ := v["symbols"].([]any)
+		for _, sym := range symbols {
+			name, _ := sym.(string)
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, _ := v["size"].(float64)
+		s.size = int(size)
+	case "array", "map":
+		key := "items"
+		if typ == "map" {
+			key = "values"
+		}
+		items, err := p.parse(v[key], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+	default:
+		// A primitive type, possibly with a logical type, which decodes as the primitive.
+		return p.parse(typ, namespace)
+	}
+
+	return s, nil
+}
+
+func fullName(name, namespace string) string {
+	if strings.LastIndexByte(name, '.') >= 0 || namespace == "" {
+		return name
+	}
+
+	return namespace + "." + name
+}
+
+// avroDecoder decodes the binary encoding of a schema into generic values: records into maps, arrays
+// into slices, numbers into int64 or float64. The first error stops decoding.
+type avroDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *avroDecoder) decode(s *avroSchema) any {
+	if d.err != nil {
+		return nil
+	}
+
+	switch s.typ {
+	case "null":
+		return nil
+	case "boolean":
+		b := d.read(1)
+		return len(b) == 1 && b[0] != 0
+	case "int", "long":
+		return d.long()
+	case "float":
+		b := d.read(4)
+		if b == nil {
+			return nil
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case "double":
+		b := d.read(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case "bytes":
+		return d.read(int(d.long()))
+	case "string":
+		return string(d.read(int(d.long())))
+	case "fixed":
+		return d.read(s.size)
+	case "enum":
+		i := d.long()
+		if i < 0 || i >= int64(len(s.symbols)) {
+			d.fail("enum index %d out of range", i)
+			return nil
+		}
+		return s.symbols[i]
+	case "union":
+		i := d.long()
+		if i < 0 || i >= int64(len(s.branches)) {
+			d.fail("union index %d out of range", i)
+			return nil
+		}
+		return d.decode(s.branches[i])
+	case "record":
+		r := make(map[string]any, len(s.fields))
+		for _, f := range s.fields {
+			r[f.name] = d.decode(f.schema)
+		}
+		return r
+	case "array":
+		var items []any
+		d.blocks(func() {
+			items = append(items, d.decode(s.items))
+		})
+		return items
+	case "map":
+		m := map[string]any{}
+		d.blocks(func() {
+			k := string(d.read(int(d.long())))
+			m[k] = d.decode(s.items)
+		})
+		return m
+	}
+
+	d.fail("unsupported type %s", s.typ)
+
+	return nil
+}
+
+// blocks reads the blocks of an array or a map, calling item for each item.
+func (d *avroDecoder) blocks(item func()) {
+	for d.err == nil {
+		n := d.long()
+		if n == 0 {
+			return
+		}
+		if n < 0 {
+			// A negative count is followed by the size of the block in bytes.
+			n = -n
+			d.long()
+		}
+		if n > int64(len(d.b)) {
+			d.fail("block of %d items exceeds the message", n)
+			return
+		}
+		for range n {
+			item()
+		}
+	}
+}
+
+func (d *avroDecoder) long() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+
+	return v
+}
+
+func (d *avroDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.fail("length %d exceeds the message", n)
+		return nil
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b
+}
+
+func (d *avroDecoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
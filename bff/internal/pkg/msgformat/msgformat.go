@@ -0,0 +1,166 @@
+package msgformat
+
+import (
+	"context"
+	"embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// Formats of the messages exchanged with the ML services.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+	FormatAvro     = "avro"
+)
+
+var (
+	// ErrUnknownFormat is returned for a message format other than json, protobuf or avro.
+	ErrUnknownFormat = errors.New("unknown message format")
+	// ErrNoRegistry is returned when Protobuf or Avro is selected without a schema registry.
+	ErrNoRegistry = errors.New("schema registry required")
+	// ErrMalformed is returned for a message that is not in the configured format.
+	ErrMalformed = errors.New("malformed message")
+)
+
+//go:embed schemas
+var schemasFS embed.FS
+
+// Codec serializes the requests to and the results of the ML services. Protobuf and Avro messages
+// use the Confluent wire format: a zero byte and the big-endian ID of the writer schema precede the
+// payload. Their schemas are registered under the <topic>-value subject of the topic they are written to.
+type Codec interface {
+	EncodeLink(ctx context.Context, topic string, l model.KafkaLink) ([]byte, error)
+	DecodeLink(ctx context.Context, b []byte) (model.KafkaLink, error)
+	EncodeResponse(ctx context.Context, topic string, r model.KafkaResponse) ([]byte, error)
+	DecodeResponse(ctx context.Context, b []byte) (model.KafkaResponse, error)
+}
+
+// New returns the codec of the format; registry is required for Protobuf and Avro.
+func New(format string, registry *Registry) (Codec, error) {
+	switch format {
+	case FormatJSON, "":
+		return JSON{}, nil
+	case FormatProtobuf, FormatAvro:
+		if registry == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoRegistry, format)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	if format == FormatProtobuf {
+		return newProtobuf(registry)
+	}
+
+	return newAvro(registry)
+}
+
+// Open returns the codec of the format with a client of the registry at registryURL, if set.
+func Open(format, registryURL, user, password string) (Codec, error) {
+	var registry *Registry
+	if registryURL != "" {
+		registry = NewRegistry(registryURL, user, password)
+	}
+
+	return New(format, registry)
+}
+
+// ResponseJSON converts a result in the format of c to the JSON the ML services send by default, which
+// results are stored as. JSON results are returned unchanged.
+func ResponseJSON(ctx context.Context, c Codec, b []byte) ([]byte, error) {
+	if _, ok := c.(JSON); ok {
+		return b, nil
+	}
+
+	r, err := c.DecodeResponse(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSON{}.EncodeResponse(ctx, "", r)
+}
+
+// JSON is the default codec, encoding the messages as JSON objects.
+type JSON struct{}
+
+func (JSON) EncodeLink(_ context.Context, _ string, l model.KafkaLink) ([]byte, error) {
+	return json.Marshal(l)
+}
+
+func (JSON) DecodeLink(_ context.Context, b []byte) (model.KafkaLink, error) {
+	var l model.KafkaLink
+	if err := json.Unmarshal(b, &l); err != nil {
+		return model.KafkaLink{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return l, nil
+}
+
+// jsonCopyright is a candidate as the ML services send it; model.Copyright carries no JSON names.
+type jsonCopyright struct {
+	Name        string          `json:"name"`
+	Probability float64         `json:"probability"`
+	Segments    []model.Segment `json:"segments,omitempty"`
+}
+
+func (JSON) EncodeResponse(_ context.Context, _ string, r model.KafkaResponse) ([]byte, error) {
+	candidates := make([]jsonCopyright, len(r.Copy))
+	for i, c := range r.Copy {
+		candidates[i] = jsonCopyright(c)
+	}
+
+	return json.Marshal(struct {
+		TaskID int64           `json:"task_id"`
+		Copy   []jsonCopyright `json:"copyright"`
+	}{r.TaskID, candidates})
+}
+
+func (JSON) DecodeResponse(_ context.Context, b []byte) (model.KafkaResponse, error) {
+	var r model.KafkaResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return model.KafkaResponse{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return r, nil
+}
+
+// magicByte starts a message in the Confluent wire format.
+const magicByte = 0
+
+// frame prepends the wire format header of the schema to a payload.
+func frame(schemaID int, payload []byte) []byte {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = magicByte
+	binary.BigEndian.PutUint32(b[1:], uint32(schemaID))
+
+	return append(b, payload...)
+}
+
+// unframe splits a message in the wire format into the ID of its writer schema and its payload.
+func unframe(b []byte) (int, []byte, error) {
+	if len(b) < 5 || b[0] != magicByte {
+		return 0, nil, fmt.Errorf("%w: no schema registry header", ErrMalformed)
+	}
+
+	return int(binary.BigEndian.Uint32(b[1:5])), b[5:], nil
+}
+
+// subject is the registry subject of the values of a topic.
+func subject(topic string) string {
+	return topic + "-value"
+}
+
+// schema returns an embedded schema.
+func schema(name string) (string, error) {
+	b, err := schemasFS.ReadFile("schemas/" + name)
+	if err != nil {
+		return "", fmt.Errorf("read schema %s failed: %w", name, err)
+	}
+
+	return string(b), nil
+}
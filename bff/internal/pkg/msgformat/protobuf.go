@@ -0,0 +1,306 @@
+package msgformat
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+)
+
+// protobufCodec encodes the messages as the first message of the embedded .proto schemas. Fields are
+// read by number, so fields added by a newer schema are skipped and missing ones are zero.
+type protobufCodec struct {
+	registry *Registry
+	link     string
+	response string
+}
+
+func newProtobuf(registry *Registry) (*protobufCodec, error) {
+	link, err := schema("kafka_link.proto")
+	if err != nil {
+		return nil, err
+	}
+	response, err := schema("kafka_response.proto")
+	if err != nil {
+		return nil, err
+	}
+
+	return &protobufCodec{registry: registry, link: link, response: response}, nil
+}
+
+func (c *protobufCodec) EncodeLink(ctx context.Context, topic string, l model.KafkaLink) ([]byte, error) {
+	id, err := c.registry.Register(ctx, subject(topic), schemaTypeProtobuf, c.link)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = appendInt64(b, 1, l.TaskID)
+	b = appendString(b, 2, l.Link)
+	b = appendString(b, 3, l.IndexVersion)
+	for _, k := range l.Keyframes {
+		var kb []byte
+		kb = appendDouble(kb, 1, k.Start)
+		kb = appendDouble(kb, 2, k.End)
+		kb = appendDouble(kb, 3, k.At)
+		kb = appendString(kb, 4, k.Link)
+		b = appendMessage(b, 4, kb)
+	}
+
+	return frameProtobuf(id, b), nil
+}
+
+func (c *protobufCodec) DecodeLink(_ context.Context, b []byte) (model.KafkaLink, error) {
+	payload, err := unframeProtobuf(b)
+	if err != nil {
+		return model.KafkaLink{}, err
+	}
+
+	var l model.KafkaLink
+	err = consumeFields(payload, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			x, n := protowire.ConsumeVarint(v)
+			l.TaskID = int64(x)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(v)
+			l.Link = s
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(v)
+			l.IndexVersion = s
+			return n, nil
+		case num == 4 && typ == protowire.BytesType:
+			kb, n := protowire.ConsumeBytes(v)
+			if n < 0 {
+				return n, nil
+			}
+			var k model.KafkaKeyframe
+			err := consumeFields(kb, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					return consumeDouble(v, &k.Start)
+				case num == 2 && typ == protowire.Fixed64Type:
+					return consumeDouble(v, &k.End)
+				case num == 3 && typ == protowire.Fixed64Type:
+					return consumeDouble(v, &k.At)
+				case num == 4 && typ == protowire.BytesType:
+					s, n := protowire.ConsumeString(v)
+					k.Link = s
+					return n, nil
+				}
+				return protowire.ConsumeFieldValue(num, typ, v), nil
+			})
+			l.Keyframes = append(l.Keyframes, k)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, v), nil
+	})
+	if err != nil {
+		return model.KafkaLink{}, err
+	}
+
+	return l, nil
+}
+
+func (c *protobufCodec) EncodeResponse(ctx context.Context, topic string, r model.KafkaResponse) ([]byte, error) {
+	id, err := c.registry.Register(ctx, subject(topic), schemaTypeProtobuf, c.response)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = appendInt64(b, 1, r.TaskID)
+	for _, cr := range r.Copy {
+		var cb []byte
+		cb = appendString(cb, 1, cr.Name)
+		cb = appendDouble(cb, 2, cr.Probability)
+		for _, s := range cr.Segments {
+			var sb []byte
+			sb = appendDouble(sb, 1, s.Start)
+			sb = appendDouble(sb, 2, s.End)
+			sb = appendDouble(sb, 3, s.ReferenceStart)
+			sb = appendDouble(sb, 4, s.ReferenceEnd)
+			sb = appendDouble(sb, 5, s.Probability)
+			cb = appendMessage(cb, 3, sb)
+		}
+		b = appendMessage(b, 2, cb)
+	}
+
+	return frameProtobuf(id, b), nil
+}
+
+func (c *protobufCodec) DecodeResponse(_ context.Context, b []byte) (model.KafkaResponse, error) {
+	payload, err := unframeProtobuf(b)
+	if err != nil {
+		return model.KafkaResponse{}, err
+	}
+
+	r := model.KafkaResponse{Copy: []model.Copyright{}}
+	err = consumeFields(payload, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			x, n := protowire.ConsumeVarint(v)
+			r.TaskID = int64(x)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			cb, n := protowire.ConsumeBytes(v)
+			if n < 0 {
+				return n, nil
+			}
+			cr, err := decodeCopyright(cb)
+			r.Copy = append(r.Copy, cr)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, v), nil
+	})
+	if err != nil {
+		return model.KafkaResponse{}, err
+	}
+
+	return r, nil
+}
+
+// decodeCopyright decodes a Copyright message.
+func decodeCopyright(b []byte) (model.Copyright, error) {
+	var c model.Copyright
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(v)
+			c.Name = s
+			return n, nil
+		case num == 2 && typ == protowire.Fixed64Type:
+			return consumeDouble(v, &c.Probability)
+		case num == 3 && typ == protowire.BytesType:
+			sb, n := protowire.ConsumeBytes(v)
+			if n < 0 {
+				return n, nil
+			}
+			var s model.Segment
+			err := consumeFields(sb, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+				if typ != protowire.Fixed64Type {
+					return protowire.ConsumeFieldValue(num, typ, v), nil
+				}
+				switch num {
+				case 1:
+					return consumeDouble(v, &s.Start)
+				case 2:
+					return consumeDouble(v, &s.End)
+				case 3:
+					return consumeDouble(v, &s.ReferenceStart)
+				case 4:
+					return consumeDouble(v, &s.ReferenceEnd)
+				case 5:
+					return consumeDouble(v, &s.Probability)
+				}
+				return protowire.ConsumeFieldValue(num, typ, v), nil
+			})
+			c.Segments = append(c.Segments, s)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, v), nil
+	})
+
+	return c, err
+}
+
+// consumeFields calls field for every field of a message with the bytes following its tag; field returns
+// the length of the value it consumed, negative for a malformed one.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, v []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("%w: %w", ErrMalformed, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: field %d: %w", ErrMalformed, num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+func consumeDouble(b []byte, v *float64) (int, error) {
+	x, n := protowire.ConsumeFixed64(b)
+	*v = math.Float64frombits(x)
+
+	return n, nil
+}
+
+// The append helpers leave out zero values, as proto3 does.
+
+func appendInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+// frameProtobuf frames a payload for the wire format, which lists the index path of the message in its
+// schema after the schema ID; the first message is written as the single index 0.
+func frameProtobuf(schemaID int, payload []byte) []byte {
+	return frame(schemaID, append([]byte{0}, payload...))
+}
+
+// unframeProtobuf returns the payload of a Protobuf message in the wire format. Only the first message of
+// a schema is expected, so the index path is skipped.
+func unframeProtobuf(b []byte) ([]byte, error) {
+	_, payload, err := unframe(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index path is a zigzag varint count followed by as many zigzag varint indexes.
+	count, n := protowire.ConsumeVarint(payload)
+	if n < 0 {
+		return nil, fmt.Errorf("%w: message indexes: %w", ErrMalformed, protowire.ParseError(n))
+	}
+	payload = payload[n:]
+	for range protowire.DecodeZigZag(count) {
+		_, n := protowire.ConsumeVarint(payload)
+		if n < 0 {
+			return nil, fmt.Errorf("%w: message indexes: %w", ErrMalformed, protowire.ParseError(n))
+		}
+		payload = payload[n:]
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,152 @@
+package msgformat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema types of the registry; Avro is the default and not sent.
+const (
+	schemaTypeAvro     = ""
+	schemaTypeProtobuf = "PROTOBUF"
+)
+
+// registryTimeout bounds a request to the schema registry.
+const registryTimeout = 10 * time.Second
+
+// registryContentType is the media type of the requests of the registry API.
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// ErrRegistry is returned when the schema registry rejects or fails a request.
+var ErrRegistry = errors.New("schema registry request failed")
+
+// Registry is a client of a Confluent-compatible schema registry. Registered schema IDs and fetched
+// schemas are cached, as neither changes once assigned.
+type Registry struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+
+	mu      sync.Mutex
+	ids     map[string]int
+	schemas map[int]string
+}
+
+// NewRegistry creates a client of the registry at baseURL; user and password enable basic authentication.
+func NewRegistry(baseURL, user, password string) *Registry {
+	return &Registry{
+		url:      strings.TrimSuffix(baseURL, "/"),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: registryTimeout},
+		ids:      map[string]int{},
+		schemas:  map[int]string{},
+	}
+}
+
+// Register registers a schema under a subject, unless it is registered already, and returns its ID.
+// The registry rejects a schema incompatible with the versions of the subject under its compatibility rules.
+func (r *Registry) Register(ctx context.Context, subject, schemaType, schema string) (int, error) {
+	key := subject + "\x00" + schema
+	r.mu.Lock()
+	id, ok := r.ids[key]
+	r.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType,omitempty"`
+	}{schema, schemaType}, &resp); err != nil {
+		return 0, fmt.Errorf("register schema of %s failed: %w", subject, err)
+	}
+
+	r.mu.Lock()
+	r.ids[key] = resp.ID
+	r.schemas[resp.ID] = schema
+	r.mu.Unlock()
+
+	return resp.ID, nil
+}
+
+// Schema returns the schema with the given ID.
+func (r *Registry) Schema(ctx context.Context, id int) (string, error) {
+	r.mu.Lock()
+	schema, ok := r.schemas[id]
+	r.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	var resp struct {
+		Schema string `json:"schema"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return "", fmt.Errorf("get schema %d failed: %w", id, err)
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = resp.Schema
+	r.mu.Unlock()
+
+	return resp.Schema, nil
+}
+
+// do sends a request to the registry API and decodes the response into out.
+func (r *Registry) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("json marshal failed: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, body)
+	if err != nil {
+		return fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Accept", registryContentType)
+	if in != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistry, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: read response: %w", ErrRegistry, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d: %s", ErrRegistry, resp.StatusCode, bytes.TrimSpace(b))
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%w: decode response: %w", ErrRegistry, err)
+	}
+
+	return nil
+}
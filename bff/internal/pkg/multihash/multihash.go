@@ -0,0 +1,99 @@
+package multihash
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Supported algorithms.
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+	XXH3   = "xxh3"
+)
+
+// ErrUnknownAlgorithm is returned for an algorithm other than the supported ones.
+var ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+
+// parallelMin is the write size from which the digests are computed concurrently;
+// smaller writes are not worth the goroutines.
+const parallelMin = 16 << 10
+
+var constructors = map[string]func() hash.Hash{
+	MD5:    md5.New,
+	SHA256: sha256.New,
+	XXH3:   func() hash.Hash { return xxh3.New() },
+}
+
+// Writer computes several digests of the data written to it in one pass.
+type Writer struct {
+	names  []string
+	hashes []hash.Hash
+}
+
+// Validate checks that all algorithms are supported.
+func Validate(algorithms []string) error {
+	_, err := New(algorithms)
+
+	return err
+}
+
+// New returns a Writer computing the digests of the algorithms; duplicates are ignored.
+func New(algorithms []string) (*Writer, error) {
+	w := &Writer{}
+	seen := map[string]bool{}
+	for _, name := range algorithms {
+		newHash, ok := constructors[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		w.names = append(w.names, name)
+		w.hashes = append(w.hashes, newHash())
+	}
+
+	return w, nil
+}
+
+// Write feeds p to every digest, concurrently for large writes. It never fails.
+func (w *Writer) Write(p []byte) (int, error) {
+	if len(w.hashes) < 2 || len(p) < parallelMin {
+		for _, h := range w.hashes {
+			h.Write(p)
+		}
+		return len(p), nil
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range w.hashes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Write(p)
+		}()
+	}
+	wg.Wait()
+
+	return len(p), nil
+}
+
+// Sums returns the hex digests of the data written so far by algorithm.
+func (w *Writer) Sums() map[string]string {
+	sums := make(map[string]string, len(w.hashes))
+	for i, h := range w.hashes {
+		sums[w.names[i]] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return sums
+}
@@ -0,0 +1,71 @@
+package objectkey
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Kind is the artifact type stored under a task.
+type Kind string
+
+const (
+	KindVideo   Kind = "video"
+	KindAudio   Kind = "audio"
+	KindPreview Kind = "preview"
+	// KindHLS holds the playlist and the segments of the HLS rendition of a video.
+	KindHLS Kind = "hls"
+	// KindKeyframe holds the representative frames of the scenes of a video.
+	KindKeyframe Kind = "keyframe"
+	// KindUpload holds client uploads until their content hash is known.
+	KindUpload Kind = "upload"
+)
+
+// ErrInvalidKey is returned when a key does not follow the task/kind/name scheme.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Key is the location of an artifact of a single task: <taskID>/<kind>/<name>.
+type Key struct {
+	TaskID int64
+	Kind   Kind
+	Name   string
+}
+
+// New returns the key of an artifact of a task, e.g. 42/upload/cs1c3p2l0mhs73d1c5n0.mp4.
+// ext includes the leading dot.
+func New(taskID int64, kind Kind, name, ext string) string {
+	return Key{TaskID: taskID, Kind: kind, Name: name + ext}.String()
+}
+
+// Content returns the key of an artifact addressed by its content hash, e.g. audio/9e107d9d.wav, shared
+// by every task with identical content. ext includes the leading dot.
+func Content(kind Kind, hash, ext string) string {
+	return path.Join(string(kind), hash+ext)
+}
+
+// String renders the key.
+func (k Key) String() string {
+	return path.Join(strconv.FormatInt(k.TaskID, 10), string(k.Kind), k.Name)
+}
+
+// Parse splits a key produced by New.
+func Parse(key string) (Key, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || parts[2] == "" || parts[2] == "." || parts[2] == ".." {
+		return Key{}, fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		return Key{}, fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+
+	switch kind := Kind(parts[1]); kind {
+	case KindVideo, KindAudio, KindPreview, KindHLS, KindKeyframe, KindUpload:
+		return Key{TaskID: id, Kind: kind, Name: parts[2]}, nil
+	default:
+		return Key{}, fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+}
@@ -0,0 +1,103 @@
+package phash
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Algorithm is the name the perceptual hash of a video is stored under next to its digests.
+const Algorithm = "dhash"
+
+// FrameBits is the number of bits of the hash of one frame.
+const FrameBits = 64
+
+// ErrMalformedHash is returned for a string that is not a perceptual hash.
+var ErrMalformedHash = errors.New("malformed perceptual hash")
+
+// Hash is the perceptual hash of a video: the dHash of each sampled frame in order.
+type Hash []uint64
+
+// DHash returns the difference hash of an image: it is shrunk to 9x8 gray cells and every bit tells
+// whether a cell is brighter than its right neighbour. Re-encoding, rescaling and mild color changes
+// flip few bits, so similar images have hashes within a small Hamming distance.
+func DHash(img image.Image) uint64 {
+	const w, h = 9, 8
+
+	// Average the luma of the pixels of each cell.
+	var sum [h][w]float64
+	var count [h][w]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * w / b.Dx()
+			sum[cy][cx] += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			count[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if sum[y][x]*float64(count[y][x+1]) > sum[y][x+1]*float64(count[y][x]) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// String encodes the hash as 16 hex digits per frame.
+func (h Hash) String() string {
+	var sb strings.Builder
+	for _, frame := range h {
+		fmt.Fprintf(&sb, "%016x", frame)
+	}
+
+	return sb.String()
+}
+
+// Parse decodes a hash encoded by String.
+func Parse(s string) (Hash, error) {
+	if s == "" || len(s)%16 != 0 {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedHash, s)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+
+	h := make(Hash, 0, len(s)/16)
+	for i := 0; i < len(s); i += 16 {
+		frame, err := strconv.ParseUint(s[i:i+16], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+		}
+		h = append(h, frame)
+	}
+
+	return h, nil
+}
+
+// Distance returns the mean number of differing bits per frame of two hashes. Frames are sampled
+// at the same relative positions of the videos, so only hashes of as many frames are comparable;
+// it reports false for others.
+func Distance(a, b Hash) (float64, bool) {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0, false
+	}
+
+	var total int
+	for i := range a {
+		total += bits.OnesCount64(a[i] ^ b[i])
+	}
+
+	return float64(total) / float64(len(a)), true
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter keeps a token bucket per key, e.g. per client IP.
+// A nil Limiter allows everything, which keeps rate limiting optional.
+type Limiter struct {
+	limit   rate.Limit
+	burst   int
+	idleTTL time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// New creates a limiter refilling perSecond tokens up to burst for every key.
+// Buckets unused for idleTTL are dropped. It returns nil when perSecond is not positive.
+func New(perSecond float64, burst int, idleTTL time.Duration) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		limit:     rate.Limit(perSecond),
+		burst:     max(burst, 1),
+		idleTTL:   idleTTL,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it reports
+// how long the client has to wait until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{lim: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.lim.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		// Give the token back, the request is rejected rather than delayed.
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep drops idle buckets at most once per idleTTL.
+func (l *Limiter) sweep(now time.Time) {
+	if l.idleTTL <= 0 || now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for k, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idleTTL {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package resultschema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Header carries the schema version of a pushed result and is echoed in the response.
+const Header = "X-Result-Schema-Version"
+
+// Latest is the version assumed when the client does not send the header.
+const Latest = "2"
+
+// ErrUnsupportedVersion is returned for a schema version this build does not know.
+var ErrUnsupportedVersion = errors.New("unsupported result schema version")
+
+//go:embed schemas/*.json
+var schemasFS embed.FS
+
+// FieldError describes a single schema violation; Field is a JSON pointer into the payload.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+// Registry holds the compiled result schemas by version.
+type Registry struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// New compiles the embedded schemas. Files are named result.v<version>.json.
+func New() (*Registry, error) {
+	entries, err := schemasFS.ReadDir("schemas")
+	if err != nil {
+		return nil, fmt.Errorf("read schemas failed: %w", err)
+	}
+
+	r := &Registry{schemas: map[string]*jsonschema.Schema{}}
+	c := jsonschema.NewCompiler()
+	for _, e := range entries {
+		version := strings.TrimSuffix(strings.TrimPrefix(e.Name(), "result.v"), ".json")
+
+		b, err := schemasFS.ReadFile("schemas/" + e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read schema %s failed: %w", e.Name(), err)
+		}
+		if err := c.AddResource(e.Name(), bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("add schema %s failed: %w", e.Name(), err)
+		}
+		s, err := c.Compile(e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("compile schema %s failed: %w", e.Name(), err)
+		}
+		r.schemas[version] = s
+	}
+
+	if _, ok := r.schemas[Latest]; !ok {
+		return nil, fmt.Errorf("schema for latest version %s is missing", Latest)
+	}
+
+	return r, nil
+}
+
+// Versions returns the supported schema versions in ascending order.
+func (r *Registry) Versions() []string {
+	out := make([]string, 0, len(r.schemas))
+	for v := range r.schemas {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// Negotiate picks the schema version for a request header value; empty means the latest one.
+func (r *Registry) Negotiate(header string) (string, error) {
+	version := strings.TrimPrefix(strings.TrimSpace(header), "v")
+	if version == "" {
+		return Latest, nil
+	}
+	if _, ok := r.schemas[version]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedVersion, header)
+	}
+
+	return version, nil
+}
+
+// Validate checks payload against the schema of the version.
+// Violations are returned as field errors; err is only set for unparsable JSON or an unknown version.
+func (r *Registry) Validate(version string, payload []byte) ([]FieldError, error) {
+	s, ok := r.schemas[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	var verr *jsonschema.ValidationError
+	if err := s.Validate(doc); err != nil {
+		if !errors.As(err, &verr) {
+			return nil, err
+		}
+		return leafErrors(verr), nil
+	}
+
+	return nil, nil
+}
+
+// leafErrors flattens the validation error tree into its most specific causes.
+func leafErrors(e *jsonschema.ValidationError) []FieldError {
+	if len(e.Causes) == 0 {
+		field := e.InstanceLocation
+		if field == "" {
+			field = "/"
+		}
+		return []FieldError{{Field: field, Reason: e.Message}}
+	}
+
+	var out []FieldError
+	for _, c := range e.Causes {
+		out = append(out, leafErrors(c)...)
+	}
+
+	return out
+}
@@ -0,0 +1,35 @@
+package samplehash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Sum hashes the size and the first and last n bytes of a file. It is a cheap fingerprint for
+// finding exact duplicate candidates: equal files always have equal sums, while equal sums
+// still have to be confirmed by a full hash. Files of up to 2n bytes are hashed whole.
+func Sum(r io.ReaderAt, size, n int64) (string, error) {
+	h := sha256.New()
+
+	var sz [8]byte
+	binary.BigEndian.PutUint64(sz[:], uint64(size))
+	h.Write(sz[:])
+
+	if size <= 2*n {
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+			return "", fmt.Errorf("failed to hash content: %w", err)
+		}
+	} else {
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, n)); err != nil {
+			return "", fmt.Errorf("failed to hash head: %w", err)
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(r, size-n, n)); err != nil {
+			return "", fmt.Errorf("failed to hash tail: %w", err)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
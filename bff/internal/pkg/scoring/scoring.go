@@ -0,0 +1,173 @@
+package scoring
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Built-in scoring strategies.
+const (
+	// Harmonic scores a reference by the weighted harmonic mean of the probabilities, so both
+	// modalities have to find it.
+	Harmonic = "harmonic"
+	// Weighted scores a reference by the weighted sum of the probabilities divided by the total weight.
+	Weighted = "weighted"
+	// Geometric scores a reference by the weighted geometric mean of the probabilities.
+	Geometric = "geometric"
+	// Min scores a reference by the lower of the probabilities.
+	Min = "min"
+	// Max scores a reference by the higher of the probabilities, so either modality is enough.
+	Max = "max"
+	// VideoOnly scores a reference by the video probability and ignores the audio.
+	VideoOnly = "video_only"
+)
+
+var (
+	// ErrUnknownStrategy is returned for a strategy other than the built-in ones.
+	ErrUnknownStrategy = errors.New("unknown scoring strategy")
+	// ErrInvalidConfig is returned for weights or a threshold out of range.
+	ErrInvalidConfig = errors.New("invalid scoring config")
+)
+
+// Candidate is a reference a modality found for the checked video.
+type Candidate struct {
+	Name        string
+	Probability float64
+}
+
+// Verdict is the decision of a scorer over the candidates of a checked video.
+type Verdict struct {
+	// Duplicate tells whether the checked video is a duplicate of Match.
+	Duplicate bool
+	// Match is the best scoring reference, empty when there are no candidates.
+	Match string
+	// Score is the score of Match.
+	Score float64
+}
+
+// Scorer decides from the candidates of both modalities whether a checked video is a duplicate.
+type Scorer interface {
+	Score(video, audio []Candidate) Verdict
+}
+
+// Config selects a built-in scorer and tunes it.
+type Config struct {
+	Strategy string
+	// VideoWeight and AudioWeight are the relative weights of the modalities in the means.
+	// A modality of weight zero is ignored, so references it did not find still score.
+	VideoWeight float64
+	AudioWeight float64
+	// Threshold is the lowest score of a duplicate.
+	Threshold float64
+}
+
+// New returns the built-in scorer of the configured strategy. The weights must not be negative and
+// not both zero, and the threshold must be a probability.
+func New(cfg Config) (Scorer, error) {
+	if cfg.VideoWeight < 0 || cfg.AudioWeight < 0 || cfg.VideoWeight+cfg.AudioWeight == 0 {
+		return nil, fmt.Errorf("%w: weights %v and %v", ErrInvalidConfig, cfg.VideoWeight, cfg.AudioWeight)
+	}
+	if cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return nil, fmt.Errorf("%w: threshold %v", ErrInvalidConfig, cfg.Threshold)
+	}
+
+	wv, wa := cfg.VideoWeight, cfg.AudioWeight
+	s := &fused{threshold: cfg.Threshold}
+	switch cfg.Strategy {
+	case Harmonic:
+		s.fuse = func(v, a float64) float64 {
+			if (wv > 0 && v == 0) || (wa > 0 && a == 0) {
+				return 0
+			}
+			var d float64
+			if wv > 0 {
+				d += wv / v
+			}
+			if wa > 0 {
+				d += wa / a
+			}
+			return (wv + wa) / d
+		}
+	case Weighted:
+		s.fuse = func(v, a float64) float64 {
+			return (wv*v + wa*a) / (wv + wa)
+		}
+	case Geometric:
+		s.fuse = func(v, a float64) float64 {
+			return math.Pow(v, wv/(wv+wa)) * math.Pow(a, wa/(wv+wa))
+		}
+	case Min:
+		s.fuse = func(v, a float64) float64 {
+			if wa == 0 {
+				return v
+			}
+			if wv == 0 {
+				return a
+			}
+			return min(v, a)
+		}
+	case Max:
+		s.fuse = func(v, a float64) float64 {
+			if wa == 0 {
+				return v
+			}
+			if wv == 0 {
+				return a
+			}
+			return max(v, a)
+		}
+	case VideoOnly:
+		s.fuse = func(v, _ float64) float64 {
+			return v
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, cfg.Strategy)
+	}
+
+	return s, nil
+}
+
+// fused scores every candidate by fusing its probabilities of both modalities, a modality that did not
+// find it counting as zero, and picks the best.
+type fused struct {
+	fuse      func(video, audio float64) float64
+	threshold float64
+}
+
+func (s *fused) Score(video, audio []Candidate) Verdict {
+	// Collect the probabilities by reference, keeping the highest one a modality reports.
+	v, a := probabilities(video), probabilities(audio)
+	names := make([]string, 0, len(v)+len(a))
+	for name := range v {
+		names = append(names, name)
+	}
+	for name := range a {
+		if _, ok := v[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Pick the highest score; ties go to the first name in order.
+	var best Verdict
+	for _, name := range names {
+		if score := s.fuse(v[name], a[name]); best.Match == "" || score > best.Score {
+			best.Match, best.Score = name, score
+		}
+	}
+	best.Duplicate = best.Match != "" && best.Score >= s.threshold
+
+	return best
+}
+
+// probabilities maps candidates to their probabilities by reference.
+func probabilities(c []Candidate) map[string]float64 {
+	m := make(map[string]float64, len(c))
+	for _, v := range c {
+		m[v.Name] = max(m[v.Name], v.Probability)
+	}
+
+	return m
+}
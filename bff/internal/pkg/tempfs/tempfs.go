@@ -0,0 +1,206 @@
+package tempfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+var (
+	filesDesc = prometheus.NewDesc("bff_temp_files", "Number of tracked temporary files by owner.", []string{"owner"}, nil)
+	bytesDesc = prometheus.NewDesc("bff_temp_bytes", "Disk space used by the temporary workspace.", nil, nil)
+)
+
+// trackedFile holds the ownership information of a temporary file.
+type trackedFile struct {
+	owner   string
+	created time.Time
+}
+
+// Workspace is a managed directory for temporary files.
+// Every file created through it is tracked with its owner until removed,
+// and untracked leftovers from crashed runs are swept away.
+type Workspace struct {
+	dir        string
+	staleAfter time.Duration
+	log        *zerolog.Logger
+
+	mu    sync.Mutex
+	files map[string]trackedFile
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New creates the workspace directory, removes stale leftovers and starts the periodic sweep.
+func New(dir string, staleAfter time.Duration, log *zerolog.Logger) (*Workspace, error) {
+	// Create the workspace directory if it does not exist yet.
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create temp workspace: %w", err)
+	}
+
+	w := &Workspace{
+		dir:        dir,
+		staleAfter: staleAfter,
+		log:        log,
+		files:      map[string]trackedFile{},
+		stop:       make(chan struct{}),
+	}
+
+	// Remove files left behind by a previous process.
+	removed, err := w.Sweep()
+	if err != nil {
+		return nil, err
+	}
+	if removed != 0 {
+		log.Info().Int("removed", removed).Str("dir", dir).Msg("stale temp files removed")
+	}
+
+	// Periodically remove untracked files that survived a panic.
+	go w.sweepLoop()
+
+	return w, nil
+}
+
+// Dir returns the workspace directory.
+func (w *Workspace) Dir() string {
+	return w.dir
+}
+
+// CreateTemp creates a new temporary file in the workspace owned by owner.
+func (w *Workspace) CreateTemp(owner, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(w.dir, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	w.Track(owner, f.Name())
+
+	return f, nil
+}
+
+// Track registers a file created in the workspace by other means (e.g. ffmpeg output).
+func (w *Workspace) Track(owner, path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.files[path] = trackedFile{
+		owner:   owner,
+		created: time.Now(),
+	}
+}
+
+// Remove deletes a tracked file and stops tracking it.
+func (w *Workspace) Remove(path string) error {
+	w.mu.Lock()
+	delete(w.files, path)
+	w.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove temp file: %w", err)
+	}
+
+	return nil
+}
+
+// Sweep removes untracked entries older than the stale threshold and returns how many were removed.
+func (w *Workspace) Sweep() (int, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read temp workspace: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	removed := 0
+	for _, e := range entries {
+		path := filepath.Join(w.dir, e.Name())
+		if _, ok := w.files[path]; ok {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < w.staleAfter {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			w.log.Error().Err(err).Str("path", path).Msg("failed to remove stale temp file")
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Close stops the periodic sweep and removes all tracked files.
+func (w *Workspace) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for path := range w.files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			w.log.Error().Err(err).Str("path", path).Msg("failed to remove temp file")
+		}
+		delete(w.files, path)
+	}
+}
+
+func (w *Workspace) sweepLoop() {
+	t := time.NewTicker(w.staleAfter)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			if _, err := w.Sweep(); err != nil {
+				w.log.Error().Err(err).Msg("temp workspace sweep failed")
+			}
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (w *Workspace) Describe(ch chan<- *prometheus.Desc) {
+	ch <- filesDesc
+	ch <- bytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (w *Workspace) Collect(ch chan<- prometheus.Metric) {
+	w.mu.Lock()
+	owners := map[string]int{}
+	for _, f := range w.files {
+		owners[f.owner]++
+	}
+	w.mu.Unlock()
+
+	for owner, n := range owners {
+		ch <- prometheus.MustNewConstMetric(filesDesc, prometheus.GaugeValue, float64(n), owner)
+	}
+
+	var size int64
+	_ = filepath.WalkDir(w.dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(size))
+}
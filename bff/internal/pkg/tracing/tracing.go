@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Header is the W3C Trace Context header carrying the trace of a request.
+const Header = "traceparent"
+
+type traceKey struct{}
+
+// WithTraceID returns a context carrying the trace ID.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceID)
+}
+
+// TraceID returns the trace ID carried by ctx, or an empty string.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+
+	return id
+}
+
+// NewTraceID returns a random 16-byte trace ID in hex.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// Parse extracts the trace ID from a traceparent header value (version-traceid-parentid-flags).
+func Parse(traceparent string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", false
+	}
+	if !validID(parts[1], 32) || !validID(parts[2], 16) || len(parts[3]) != 2 {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// Traceparent returns a header value continuing the trace with a new sampled span.
+func Traceparent(traceID string) string {
+	return "00-" + traceID + "-" + randomHex(8) + "-01"
+}
+
+// URL fills the {trace_id} placeholder of a trace viewer URL template; empty when either is empty.
+func URL(template, traceID string) string {
+	if template == "" || traceID == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(template, "{trace_id}", traceID)
+}
+
+// validID reports whether s is a non-zero lowercase hex ID of the given length.
+func validID(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
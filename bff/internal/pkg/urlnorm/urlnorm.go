@@ -0,0 +1,108 @@
+package urlnorm
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts maps schemes to the port that is implied when none is given.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// trackingParams are query parameters that identify the referrer, not the resource.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"yclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"ref_src": true,
+	"si":      true,
+	"feature": true,
+}
+
+// youtubeHosts are the hosts serving youtube watch pages.
+var youtubeHosts = map[string]bool{
+	"youtube.com":       true,
+	"www.youtube.com":   true,
+	"m.youtube.com":     true,
+	"music.youtube.com": true,
+}
+
+// Normalize returns the canonical form of a link, so equivalent links compare equal:
+// scheme and host are lowercased, default ports, fragments and tracking parameters are dropped,
+// the remaining query is sorted and known shorteners are expanded.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("parse url failed: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("url %q is not absolute", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// Drop the port when it is the scheme default.
+	if host, port, err := net.SplitHostPort(u.Host); err == nil && defaultPorts[u.Scheme] == port {
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	}
+	u.Host = strings.TrimSuffix(u.Host, ".")
+
+	// Strip tracking parameters; Encode sorts the rest by key.
+	q := u.Query()
+	for k := range q {
+		if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+			q.Del(k)
+		}
+	}
+
+	canonicalShortener(u, q)
+
+	u.RawQuery = q.Encode()
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), nil
+}
+
+// canonicalShortener rewrites short and alternative youtube links to the watch page.
+func canonicalShortener(u *url.URL, q url.Values) {
+	var id string
+	switch {
+	case u.Host == "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case youtubeHosts[u.Host] && strings.HasPrefix(u.Path, "/shorts/"):
+		id = strings.TrimPrefix(u.Path, "/shorts/")
+	case youtubeHosts[u.Host] && strings.HasPrefix(u.Path, "/embed/"):
+		id = strings.TrimPrefix(u.Path, "/embed/")
+	case youtubeHosts[u.Host] && u.Path == "/watch":
+		id = q.Get("v")
+	default:
+		return
+	}
+	if id == "" || strings.Contains(id, "/") {
+		return
+	}
+
+	u.Scheme = "https"
+	u.Host = "www.youtube.com"
+	u.Path = "/watch"
+	q.Set("v", id)
+}
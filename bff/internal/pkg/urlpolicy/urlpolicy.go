@@ -0,0 +1,200 @@
+package urlpolicy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbidden is returned for links the policy does not allow to be fetched.
+	ErrForbidden = errors.New("link forbidden")
+	// ErrTooLarge is returned when a response exceeds the download size limit.
+	ErrTooLarge = errors.New("download too large")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, internal to providers and not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Options configures a policy. Host entries match the host and all of its subdomains.
+type Options struct {
+	// Schemes are the allowed URL schemes.
+	Schemes []string
+	// AllowHosts restricts links to these hosts when not empty.
+	AllowHosts []string
+	// DenyHosts are never fetched, even when allowed.
+	DenyHosts []string
+	// AllowPrivate permits loopback, private and link-local addresses, e.g. for local development.
+	AllowPrivate bool
+	// MaxRedirects is the number of redirects followed; every hop is checked against the policy.
+	MaxRedirects int
+	// MaxSize bounds the response body in bytes; 0 disables the limit.
+	MaxSize int64
+}
+
+// Policy decides which links may be fetched on behalf of users.
+type Policy struct {
+	opts    Options
+	schemes map[string]bool
+}
+
+// New creates a policy.
+func New(opts Options) *Policy {
+	schemes := make(map[string]bool, len(opts.Schemes))
+	for _, s := range opts.Schemes {
+		schemes[strings.ToLower(s)] = true
+	}
+
+	return &Policy{
+		opts:    opts,
+		schemes: schemes,
+	}
+}
+
+// Check validates the scheme and host of a link. Addresses are checked when connecting,
+// after DNS resolution, so names resolving to internal addresses are caught as well.
+func (p *Policy) Check(u *url.URL) error {
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrForbidden, u.Scheme)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("%w: no host", ErrForbidden)
+	}
+	if matchHost(host, p.opts.DenyHosts) {
+		return fmt.Errorf("%w: host %q is denied", ErrForbidden, host)
+	}
+	if len(p.opts.AllowHosts) != 0 && !matchHost(host, p.opts.AllowHosts) {
+		return fmt.Errorf("%w: host %q is not allowed", ErrForbidden, host)
+	}
+
+	return nil
+}
+
+// Client returns an HTTP client that enforces the policy on every request, redirect and connection.
+// Proxies are not used, since they would hide the address that is actually connected to.
+func (p *Policy) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &roundTripper{policy: p, next: transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.opts.MaxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrForbidden, p.opts.MaxRedirects)
+			}
+			return p.Check(req.URL)
+		},
+	}
+}
+
+// control rejects connections to internal addresses; it runs with the resolved address of every dial.
+func (p *Policy) control(_, address string, _ syscall.RawConn) error {
+	if p.opts.AllowPrivate {
+		return nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unparsable address %q", ErrForbidden, address)
+	}
+	if addr := addrPort.Addr().Unmap(); internal(addr) {
+		return fmt.Errorf("%w: address %s is internal", ErrForbidden, addr)
+	}
+
+	return nil
+}
+
+// internal reports whether addr is not routable on the public internet.
+func internal(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// matchHost reports whether host equals one of the entries or is a subdomain of one.
+func matchHost(host string, entries []string) bool {
+	for _, e := range entries {
+		e = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(e)), ".")
+		if e == "" {
+			continue
+		}
+		if host == e || strings.HasSuffix(host, "."+e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// roundTripper checks every request against the policy and bounds the response size.
+type roundTripper struct {
+	policy *Policy
+	next   http.RoundTripper
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.policy.Check(req.URL); err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := rt.policy.opts.MaxSize
+	if limit <= 0 {
+		return resp, nil
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes announced, limit is %d", ErrTooLarge, resp.ContentLength, limit)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, left: limit}
+
+	return resp, nil
+}
+
+// limitedBody fails reads once more than the limit was read, instead of silently truncating.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		return n, ErrTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,349 @@
+// Package localfs keeps objects in a local directory, so the BFF runs without MinIO in development
+// and integration tests. Presigned URLs point to Handler, which checks their signature.
+package localfs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+)
+
+// Query parameters of a presigned URL.
+const (
+	expiresParam   = "expires"
+	signatureParam = "signature"
+)
+
+// getURLExpiry matches the lifetime of the GET URLs presigned by MinIO.
+const getURLExpiry = time.Hour
+
+var (
+	// ErrInvalidName is returned for a bucket or an object name that would leave the root directory.
+	ErrInvalidName = errors.New("invalid object name")
+	// ErrInvalidSignature is returned for a presigned URL that is expired or not signed by this storage.
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+)
+
+// Storage keeps every bucket in a directory under its root.
+type Storage struct {
+	root      string
+	publicURL *url.URL
+	key       []byte
+
+	videoBucket         string
+	audioBucket         string
+	previewBucket       string
+	originalVideoBucket string
+}
+
+var _ objectstorage.ObjectStorage = (*Storage)(nil)
+
+// New creates the storage under the configured root with the buckets of opts. Without a signing key
+// a random one is generated, so only this process can serve the URLs it presigns.
+func New(opts *config.MinioConfig, storage config.StorageConfig) (*Storage, error) {
+	publicURL, err := url.Parse(storage.PublicURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage public url: %w", err)
+	}
+
+	key := []byte(storage.SigningKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(storage.Root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage root: %w", err)
+	}
+
+	return &Storage{
+		root:                storage.Root,
+		publicURL:           publicURL,
+		key:                 key,
+		videoBucket:         opts.VideoBucket,
+		audioBucket:         opts.AudioBucket,
+		previewBucket:       opts.PreviewBucket,
+		originalVideoBucket: opts.OriginVideoBucket,
+	}, nil
+}
+
+// path returns the file of an object, refusing names that would leave the root.
+func (s *Storage) path(objectName, bucketName string) (string, error) {
+	if !filepath.IsLocal(bucketName) || strings.ContainsAny(bucketName, `/\`) || !filepath.IsLocal(objectName) {
+		return "", fmt.Errorf("%w: %s/%s", ErrInvalidName, bucketName, objectName)
+	}
+
+	return filepath.Join(s.root, bucketName, filepath.FromSlash(objectName)), nil
+}
+
+func (s *Storage) UploadFile(ctx context.Context, data io.Reader, dataSize int64, objectName, bucketName string) error {
+	name, err := s.path(objectName, bucketName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create bucket directory: %w", err)
+	}
+
+	// Write to a temporary file renamed into place, so readers never see a partial object.
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create object file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if dataSize >= 0 {
+		data = io.LimitReader(data, dataSize)
+	}
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to store object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UploadFileFromOs(ctx context.Context, filePath, objectName, bucketName string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	return s.UploadFile(ctx, f, -1, objectName, bucketName)
+}
+
+func (s *Storage) GetFileURL(ctx context.Context, objectName, bucketName string) (string, error) {
+	return s.presign(http.MethodGet, objectName, bucketName, getURLExpiry)
+}
+
+func (s *Storage) GetUploadURL(ctx context.Context, objectName, bucketName string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodPut, objectName, bucketName, expires)
+}
+
+func (s *Storage) IsFileExist(ctx context.Context, objectName, bucketName string) (bool, error) {
+	name, err := s.path(objectName, bucketName)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat object failed: %w", err)
+	}
+
+	return true, nil
+}
+
+func (s *Storage) MoveFile(ctx context.Context, srcObject, dstObject, bucketName string) error {
+	src, err := s.path(srcObject, bucketName)
+	if err != nil {
+		return err
+	}
+	dst, err := s.path(dstObject, bucketName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create bucket directory: %w", err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("move object failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RemoveFile(ctx context.Context, objectName, bucketName string) error {
+	name, err := s.path(objectName, bucketName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove object failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetFileReader(ctx context.Context, objectName, bucketName string) (io.ReadCloser, error) {
+	name, err := s.path(objectName, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open object failed: %w", err)
+	}
+
+	return f, nil
+}
+
+func (s *Storage) GetFileReaderAt(ctx context.Context, objectName, bucketName string) (objectstorage.ReadAtCloser, int64, error) {
+	name, err := s.path(objectName, bucketName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open object failed: %w", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat object failed: %w", err)
+	}
+
+	return f, stat.Size(), nil
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	if _, err := os.Stat(s.root); err != nil {
+		return fmt.Errorf("stat storage root failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetVideoBucketName() string {
+	return s.videoBucket
+}
+
+func (s *Storage) GetAudioBucketName() string {
+	return s.audioBucket
+}
+
+func (s *Storage) GetPreviewBucketName() string {
+	return s.previewBucket
+}
+
+func (s *Storage) GetOrigVideoBucket() string {
+	return s.originalVideoBucket
+}
+
+// presign returns a URL of Handler allowing method on the object until expires has passed.
+func (s *Storage) presign(method, objectName, bucketName string, expires time.Duration) (string, error) {
+	if _, err := s.path(objectName, bucketName); err != nil {
+		return "", err
+	}
+
+	deadline := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	u := s.publicURL.JoinPath(bucketName, objectName)
+	q := u.Query()
+	q.Set(expiresParam, deadline)
+	q.Set(signatureParam, s.sign(method, bucketName, objectName, deadline))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// sign returns the hex HMAC-SHA256 of a presigned request.
+func (s *Storage) sign(method, bucketName, objectName, deadline string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(method + "\n" + bucketName + "/" + objectName + "\n" + deadline))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns ErrInvalidSignature unless the query presigns method on the object and has not expired.
+func (s *Storage) verify(method, bucketName, objectName string, q url.Values) error {
+	deadline := q.Get(expiresParam)
+	unix, err := strconv.ParseInt(deadline, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return ErrInvalidSignature
+	}
+
+	want := s.sign(method, bucketName, objectName, deadline)
+	if !hmac.Equal([]byte(want), []byte(q.Get(signatureParam))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Handler serves the presigned URLs, relative to the public URL: GET and HEAD read an object with
+// a GET URL, PUT stores one with an upload URL.
+func (s *Storage) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucketName, objectName, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if method != http.MethodGet && method != http.MethodPut {
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.verify(method, bucketName, objectName, r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if method == http.MethodPut {
+			if err := s.UploadFile(r.Context(), r.Body, r.ContentLength, objectName, bucketName); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		name, err := s.path(objectName, bucketName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		stat, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, objectName, stat.ModTime(), f)
+	})
+}
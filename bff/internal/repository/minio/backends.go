@@ -0,0 +1,88 @@
+package minio
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// gcsEndpoint serves the S3 compatible XML API of Google Cloud Storage.
+const gcsEndpoint = "storage.googleapis.com"
+
+// New creates the client of the configured storage backend, uploading large objects and retrying
+// transient failures as configured.
+func New(opts *config.MinioConfig, storage config.StorageConfig) (objectstorage.ObjectStorage, error) {
+	multipart, err := newMultipartOptions(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *MinioClient
+	switch storage.Backend {
+	case objectstorage.BackendMinio:
+		client, err = NewMinioClient(opts)
+	case objectstorage.BackendS3:
+		client, err = NewS3Client(opts, storage.Region)
+	case objectstorage.BackendGCS:
+		client, err = NewGCSClient(opts)
+	default:
+		return nil, fmt.Errorf("%w: %q", objectstorage.ErrUnknownBackend, storage.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client.multipart = multipart
+	client.retries = retryOptions{attempts: storage.RetryAttempts, backoff: storage.RetryBackoff}
+
+	return client, nil
+}
+
+// NewS3Client creates a client of AWS S3 in region. Without configured keys the credentials are looked
+// up like the AWS SDKs do: the AWS_* environment, the shared credentials file, then the IAM role of the
+// instance or the pod. The endpoint defaults to the regional one of S3.
+func NewS3Client(opts *config.MinioConfig, region string) (*MinioClient, error) {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+		if region != "" {
+			endpoint = "s3." + region + ".amazonaws.com"
+		}
+	}
+
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretAccessKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	return newClient(opts, endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: true,
+		Region: region,
+	})
+}
+
+// NewGCSClient creates a client of Google Cloud Storage through its XML API, authenticated with the
+// HMAC keys of a service account as the access and secret keys.
+func NewGCSClient(opts *config.MinioConfig) (*MinioClient, error) {
+	if opts.AccessKey == "" || opts.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%w: gcs requires the HMAC keys of a service account", objectstorage.ErrMissingCredentials)
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+
+	return newClient(opts, endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretAccessKey, ""),
+		Secure: true,
+	})
+}
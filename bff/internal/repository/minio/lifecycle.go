@@ -0,0 +1,101 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// retentionRuleID names the lifecycle rule of a bucket managed by the BFF.
+const retentionRuleID = "bff-retention"
+
+var _ objectstorage.RetentionManager = (*MinioClient)(nil)
+
+func (m *MinioClient) GetRetention(ctx context.Context, bucketName string) (objectstorage.Retention, error) {
+	cfg, err := m.getLifecycle(ctx, bucketName)
+	if err != nil {
+		return objectstorage.Retention{}, err
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.ID != retentionRuleID {
+			continue
+		}
+
+		return objectstorage.Retention{
+			ExpireDays:      int(rule.Expiration.Days),
+			TransitionDays:  int(rule.Transition.Days),
+			TransitionClass: rule.Transition.StorageClass,
+		}, nil
+	}
+
+	return objectstorage.Retention{}, nil
+}
+
+func (m *MinioClient) SetRetention(ctx context.Context, bucketName string, r objectstorage.Retention) error {
+	if r.ExpireDays < 0 || r.TransitionDays < 0 || (r.TransitionDays > 0) != (r.TransitionClass != "") {
+		return fmt.Errorf("%w: a transition needs both days and a class", objectstorage.ErrInvalidRetention)
+	}
+
+	if exist := m.isBucketExist(ctx, bucketName); !exist {
+		if err := m.makeBucket(ctx, bucketName); err != nil {
+			return fmt.Errorf("failed to make bucket when set retention: %w", err)
+		}
+	}
+
+	cfg, err := m.getLifecycle(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+
+	// Replace the rule of the BFF only; an empty configuration removes the lifecycle of the bucket.
+	rules := cfg.Rules[:0]
+	for _, rule := range cfg.Rules {
+		if rule.ID != retentionRuleID {
+			rules = append(rules, rule)
+		}
+	}
+	if r.ExpireDays > 0 || r.TransitionDays > 0 {
+		rules = append(rules, lifecycle.Rule{
+			ID:         retentionRuleID,
+			Status:     "Enabled",
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(r.ExpireDays)},
+			Transition: lifecycle.Transition{
+				Days:         lifecycle.ExpirationDays(r.TransitionDays),
+				StorageClass: r.TransitionClass,
+			},
+		})
+	}
+	cfg.Rules = rules
+
+	err = m.retry(ctx, func() error {
+		return m.client.SetBucketLifecycle(ctx, bucketName, cfg)
+	})
+	if err != nil {
+		return fmt.Errorf("SetBucketLifecycle failed: %w", err)
+	}
+
+	return nil
+}
+
+// getLifecycle returns the lifecycle of a bucket, empty when it has none.
+func (m *MinioClient) getLifecycle(ctx context.Context, bucketName string) (*lifecycle.Configuration, error) {
+	var cfg *lifecycle.Configuration
+	err := m.retry(ctx, func() (err error) {
+		cfg, err = m.client.GetBucketLifecycle(ctx, bucketName)
+		return err
+	})
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchLifecycleConfiguration", "NoSuchBucket":
+			return lifecycle.NewConfiguration(), nil
+		}
+
+		return nil, fmt.Errorf("GetBucketLifecycle failed: %w", err)
+	}
+
+	return cfg, nil
+}
@@ -5,32 +5,52 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"time"
 
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/objectstorage"
 	"github.com/gulldan/cp2024yappy/bff/pkg/config"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+// streamPartSize is the size of the parts of uploads of unknown size; with at most 10000 parts
+// such objects are limited to about 160 GiB.
+const streamPartSize = 16 << 20
+
+// MinioClient stores objects through the S3 API, served by MinIO, AWS S3 or the XML API of GCS.
 type MinioClient struct {
-	client              *minio.Client
+	client *minio.Client
+	// core uploads the parts of large objects; multipart sets when and how.
+	core      *minio.Core
+	multipart multipartOptions
+	// retries bounds the tries of operations failing with transient errors.
+	retries             retryOptions
 	videoBucket         string
 	audioBucket         string
 	previewBucket       string
 	originalVideoBucket string
 }
 
+var _ objectstorage.ObjectStorage = (*MinioClient)(nil)
+
 func NewMinioClient(opts *config.MinioConfig) (*MinioClient, error) {
-	minioClient, err := minio.New(opts.Endpoint, &minio.Options{
+	return newClient(opts, opts.Endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretAccessKey, ""),
 		Secure: opts.IsUseSsl,
 	})
+}
+
+// newClient creates a client of the S3 API at endpoint for the buckets of opts.
+func newClient(opts *config.MinioConfig, endpoint string, clientOpts *minio.Options) (*MinioClient, error) {
+	minioClient, err := minio.New(endpoint, clientOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new minio client: %w", err)
 	}
 
 	return &MinioClient{
 		client:              minioClient,
+		core:                &minio.Core{Client: minioClient},
 		videoBucket:         opts.VideoBucket,
 		audioBucket:         opts.AudioBucket,
 		previewBucket:       opts.PreviewBucket,
@@ -45,7 +65,42 @@ func (m *MinioClient) UploadFile(ctx context.Context, data io.Reader, dataSize i
 		}
 	}
 
-	_, err := m.client.PutObject(ctx, bucketName, objectName, data, dataSize, minio.PutObjectOptions{})
+	// Large objects and streams are uploaded in parts concurrently.
+	if m.multipart.applies(dataSize) {
+		if err := m.putMultipart(ctx, data, dataSize, objectName, bucketName); err != nil {
+			return fmt.Errorf("failed to put object in s3: %w", err)
+		}
+		return nil
+	}
+
+	// Data of unknown size is sent in parts buffered in memory; bound them instead of the default
+	// sized for the largest possible object.
+	opts := minio.PutObjectOptions{}
+	if dataSize < 0 {
+		opts.PartSize = streamPartSize
+	}
+
+	put := func() error {
+		_, err := m.client.PutObject(ctx, bucketName, objectName, data, dataSize, opts)
+		return err
+	}
+
+	// Data that can be rewound is sent again after a transient failure; a stream is sent once.
+	var err error
+	if seeker, ok := data.(io.Seeker); ok {
+		start, seekErr := seeker.Seek(0, io.SeekCurrent)
+		if seekErr != nil {
+			return fmt.Errorf("failed to seek object data: %w", seekErr)
+		}
+		err = m.retry(ctx, func() error {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+			return put()
+		})
+	} else {
+		err = put()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to put object in s3: %w", err)
 	}
@@ -60,7 +115,28 @@ func (m *MinioClient) UploadFileFromOs(ctx context.Context, filePath, objectName
 		}
 	}
 
-	_, err := m.client.FPutObject(ctx, bucketName, objectName, filePath, minio.PutObjectOptions{})
+	// Large files are uploaded in parts concurrently.
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to get file metainfo: %w", err)
+	}
+	if m.multipart.applies(stat.Size()) {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+
+		if err := m.putMultipart(ctx, f, stat.Size(), objectName, bucketName); err != nil {
+			return fmt.Errorf("failed to put object in s3: %w", err)
+		}
+		return nil
+	}
+
+	err = m.retry(ctx, func() error {
+		_, err := m.client.FPutObject(ctx, bucketName, objectName, filePath, minio.PutObjectOptions{})
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to put object in s3: %w", err)
 	}
@@ -69,16 +145,92 @@ func (m *MinioClient) UploadFileFromOs(ctx context.Context, filePath, objectName
 }
 
 func (m *MinioClient) GetFileURL(ctx context.Context, objectName, bucketName string) (string, error) {
-	url, err := m.client.PresignedGetObject(ctx, bucketName, objectName, time.Hour, url.Values{})
+	var u *url.URL
+	err := m.retry(ctx, func() (err error) {
+		u, err = m.client.PresignedGetObject(ctx, bucketName, objectName, time.Hour, url.Values{})
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("PresignedGetObject failed: %w", err)
 	}
 
-	return url.String(), nil
+	return u.String(), nil
+}
+
+func (m *MinioClient) GetUploadURL(ctx context.Context, objectName, bucketName string, expires time.Duration) (string, error) {
+	if exist := m.isBucketExist(ctx, bucketName); !exist {
+		if err := m.makeBucket(ctx, bucketName); err != nil {
+			return "", fmt.Errorf("failed to make bucket when presign upload: %w", err)
+		}
+	}
+
+	var u *url.URL
+	err := m.retry(ctx, func() (err error) {
+		u, err = m.client.PresignedPutObject(ctx, bucketName, objectName, expires)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("PresignedPutObject failed: %w", err)
+	}
+
+	return u.String(), nil
+}
+
+func (m *MinioClient) IsFileExist(ctx context.Context, objectName, bucketName string) (bool, error) {
+	err := m.retry(ctx, func() error {
+		_, err := m.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("StatObject failed: %w", err)
+	}
+
+	return true, nil
+}
+
+func (m *MinioClient) MoveFile(ctx context.Context, srcObject, dstObject, bucketName string) error {
+	err := m.retry(ctx, func() error {
+		_, err := m.client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: bucketName, Object: dstObject},
+			minio.CopySrcOptions{Bucket: bucketName, Object: srcObject},
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CopyObject failed: %w", err)
+	}
+
+	if err := m.removeObject(ctx, srcObject, bucketName); err != nil {
+		return fmt.Errorf("RemoveObject failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MinioClient) RemoveFile(ctx context.Context, objectName, bucketName string) error {
+	if err := m.removeObject(ctx, objectName, bucketName); err != nil {
+		return fmt.Errorf("RemoveObject failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MinioClient) removeObject(ctx context.Context, objectName, bucketName string) error {
+	return m.retry(ctx, func() error {
+		return m.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+	})
 }
 
 func (m *MinioClient) isBucketExist(ctx context.Context, bucketName string) bool {
-	exists, errBucketExists := m.client.BucketExists(ctx, bucketName)
+	var exists bool
+	errBucketExists := m.retry(ctx, func() (err error) {
+		exists, err = m.client.BucketExists(ctx, bucketName)
+		return err
+	})
 	if errBucketExists == nil && exists {
 		return true
 	}
@@ -87,14 +239,17 @@ func (m *MinioClient) isBucketExist(ctx context.Context, bucketName string) bool
 }
 
 func (m *MinioClient) makeBucket(ctx context.Context, bucketName string) error {
-	if err := m.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{}); err != nil {
+	err := m.retry(ctx, func() error {
+		return m.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+	})
+	if err != nil {
 		return fmt.Errorf("failed to make bucket:%w", err)
 	}
 
 	return nil
 }
 
-func (m *MinioClient) GetFileReader(ctx context.Context, objectName, bucketName string) (io.Reader, error) {
+func (m *MinioClient) GetFileReader(ctx context.Context, objectName, bucketName string) (io.ReadCloser, error) {
 	url, err := m.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("GetObject failed: %w", err)
@@ -103,6 +258,38 @@ func (m *MinioClient) GetFileReader(ctx context.Context, objectName, bucketName
 	return url, nil
 }
 
+// GetFileReaderAt opens an object for random access and returns its size; reads are served by range requests.
+func (m *MinioClient) GetFileReaderAt(ctx context.Context, objectName, bucketName string) (objectstorage.ReadAtCloser, int64, error) {
+	// A failed Stat sticks to its object, so every attempt opens the object again.
+	var (
+		obj  *minio.Object
+		stat minio.ObjectInfo
+	)
+	err := m.retry(ctx, func() (err error) {
+		if obj, err = m.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{}); err != nil {
+			return err
+		}
+		if stat, err = obj.Stat(); err != nil {
+			obj.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("StatObject failed: %w", err)
+	}
+
+	return obj, stat.Size, nil
+}
+
+func (m *MinioClient) Ping(ctx context.Context) error {
+	if _, err := m.client.BucketExists(ctx, m.videoBucket); err != nil {
+		return fmt.Errorf("BucketExists failed: %w", err)
+	}
+
+	return nil
+}
+
 func (m *MinioClient) GetVideoBucketName() string {
 	return m.videoBucket
 }
@@ -0,0 +1,236 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/minio/minio-go/v7"
+)
+
+// Limits of multipart uploads of the S3 API.
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// partRetryBackoff is the delay before a failed part is uploaded again; it doubles with every attempt.
+const partRetryBackoff = time.Second
+
+// ErrInvalidMultipart is returned for multipart upload settings the S3 API cannot honour.
+var ErrInvalidMultipart = errors.New("invalid multipart upload config")
+
+// multipartOptions tunes the concurrent multipart uploads of large objects; a zero threshold disables them.
+type multipartOptions struct {
+	threshold   int64
+	partSize    int64
+	concurrency int
+	retries     int
+}
+
+// newMultipartOptions validates the multipart settings of the storage config.
+func newMultipartOptions(storage config.StorageConfig) (multipartOptions, error) {
+	o := multipartOptions{
+		threshold:   storage.MultipartThreshold,
+		partSize:    storage.MultipartPartSize,
+		concurrency: storage.MultipartConcurrency,
+		retries:     storage.MultipartRetries,
+	}
+	if o.threshold <= 0 {
+		return multipartOptions{}, nil
+	}
+
+	switch {
+	case o.partSize < minPartSize:
+		return multipartOptions{}, fmt.Errorf("%w: part size %d is below %d bytes", ErrInvalidMultipart, o.partSize, minPartSize)
+	case o.concurrency < 1:
+		return multipartOptions{}, fmt.Errorf("%w: concurrency %d is below 1", ErrInvalidMultipart, o.concurrency)
+	case o.retries < 0:
+		return multipartOptions{}, fmt.Errorf("%w: negative retries", ErrInvalidMultipart)
+	}
+
+	return o, nil
+}
+
+// applies reports whether an object of size bytes, -1 when unknown, is uploaded in concurrent parts.
+func (o multipartOptions) applies(size int64) bool {
+	return o.threshold > 0 && (size < 0 || size >= o.threshold)
+}
+
+// partSizeFor returns the part size for an object of size bytes, grown when the configured one would
+// need more parts than allowed.
+func (o multipartOptions) partSizeFor(size int64) int64 {
+	return max(o.partSize, (size+maxParts-1)/maxParts)
+}
+
+// putMultipart uploads data of dataSize bytes, -1 when unknown, in parts uploaded concurrently. A stream
+// that turns out to fit in a single part is put at once. A failed upload is aborted, so its parts are removed.
+func (m *MinioClient) putMultipart(ctx context.Context, data io.Reader, dataSize int64, objectName, bucketName string) error {
+	partSize := m.multipart.partSizeFor(dataSize)
+
+	if dataSize < 0 {
+		// Read the first part to tell a short stream from a large one.
+		first := make([]byte, partSize)
+		n, err := io.ReadFull(data, first)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err := m.retry(ctx, func() error {
+				_, err := m.client.PutObject(ctx, bucketName, objectName, bytes.NewReader(first[:n]), int64(n), minio.PutObjectOptions{})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("PutObject failed: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read object data: %w", err)
+		}
+		data = io.MultiReader(bytes.NewReader(first), data)
+	} else {
+		data = io.LimitReader(data, dataSize)
+	}
+
+	var uploadID string
+	err := m.retry(ctx, func() (err error) {
+		uploadID, err = m.core.NewMultipartUpload(ctx, bucketName, objectName, minio.PutObjectOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("NewMultipartUpload failed: %w", err)
+	}
+
+	parts, err := m.uploadParts(ctx, data, partSize, uploadID, objectName, bucketName)
+	if err != nil {
+		// Abort even when ctx is done, so the parts stored so far do not linger in the bucket.
+		abortCtx := context.WithoutCancel(ctx)
+		abortErr := m.retry(abortCtx, func() error {
+			return m.core.AbortMultipartUpload(abortCtx, bucketName, objectName, uploadID)
+		})
+		if abortErr != nil {
+			return errors.Join(err, fmt.Errorf("AbortMultipartUpload failed: %w", abortErr))
+		}
+		return err
+	}
+
+	err = m.retry(ctx, func() error {
+		_, err := m.core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts, minio.PutObjectOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CompleteMultipartUpload failed: %w", err)
+	}
+
+	return nil
+}
+
+// uploadParts reads data in parts of partSize bytes and uploads up to the configured number of them
+// at a time. Memory is bounded by reusing a buffer per concurrent part. The first failure stops reading
+// and cancels the parts in flight.
+func (m *MinioClient) uploadParts(ctx context.Context, data io.Reader, partSize int64, uploadID, objectName, bucketName string) ([]minio.CompletePart, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Hand out a buffer per concurrent part; they are allocated on first use.
+	buffers := make(chan []byte, m.multipart.concurrency)
+	for range m.multipart.concurrency {
+		buffers <- nil
+	}
+
+	var (
+		mu    sync.Mutex
+		parts []minio.CompletePart
+		wg    sync.WaitGroup
+	)
+
+	for number := 1; ; number++ {
+		// Wait for a free buffer.
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if number > maxParts {
+			cancel(fmt.Errorf("object exceeds %d parts of %d bytes", maxParts, partSize))
+			break
+		}
+		if buf == nil {
+			buf = make([]byte, partSize)
+		}
+
+		// Read the part; a short read is the last part, an empty one ends the object.
+		n, err := io.ReadFull(data, buf)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			cancel(fmt.Errorf("failed to read object data: %w", err))
+			break
+		}
+		if n == 0 && number > 1 {
+			break
+		}
+
+		wg.Add(1)
+		go func(number int, buf []byte, n int) {
+			defer wg.Done()
+
+			part, err := m.uploadPart(ctx, buf[:n], number, uploadID, objectName, bucketName)
+			buffers <- buf
+			if err != nil {
+				cancel(err)
+				return
+			}
+
+			mu.Lock()
+			parts = append(parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+			mu.Unlock()
+		}(number, buf, n)
+
+		if last {
+			break
+		}
+	}
+
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	// Parts finish out of order; the upload is completed with them in order.
+	slices.SortFunc(parts, func(a, b minio.CompletePart) int {
+		return a.PartNumber - b.PartNumber
+	})
+
+	return parts, nil
+}
+
+// uploadPart uploads a part, retrying a failure up to the configured number of times, so a transient
+// error resumes the upload at that part instead of restarting it.
+func (m *MinioClient) uploadPart(ctx context.Context, data []byte, number int, uploadID, objectName, bucketName string) (minio.ObjectPart, error) {
+	var err error
+	for attempt := 0; attempt <= m.multipart.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return minio.ObjectPart{}, context.Cause(ctx)
+			case <-time.After(partRetryBackoff << (attempt - 1)):
+			}
+		}
+
+		var part minio.ObjectPart
+		part, err = m.core.PutObjectPart(ctx, bucketName, objectName, uploadID, number,
+			bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+		if err == nil {
+			return part, nil
+		}
+	}
+
+	return minio.ObjectPart{}, fmt.Errorf("PutObjectPart %d failed after %d attempts: %w", number, m.multipart.retries+1, err)
+}
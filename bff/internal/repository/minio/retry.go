@@ -0,0 +1,62 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// maxRetryBackoff caps the doubling delay between the tries of an operation.
+const maxRetryBackoff = 10 * time.Second
+
+// retryOptions bounds the tries of an operation failing with a transient error; the delay between
+// them doubles from backoff.
+type retryOptions struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// retry runs op until it succeeds, fails with an error that is not transient or has made the configured
+// number of attempts. op returns the error of the S3 API unwrapped, so it can be classified.
+func (m *MinioClient) retry(ctx context.Context, op func() error) error {
+	backoff := m.retries.backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= m.retries.attempts || !transient(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// transient reports whether an error of the S3 API may go away on retry: a network failure, throttling
+// or an error of the server. Errors of the request itself, e.g. a missing object, never do.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return true
+	case resp.Code == "RequestTimeout" || resp.Code == "SlowDown":
+		return true
+	case resp.StatusCode != 0:
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,78 @@
+// Package objectstorage defines the storage the media of tasks are kept in, so the BFF runs against
+// MinIO, AWS S3 or GCS without code changes.
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Backends selected by the storage config.
+const (
+	BackendMinio = "minio"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+	// BackendFS keeps objects in a local directory, for development and tests.
+	BackendFS = "fs"
+)
+
+var (
+	// ErrUnknownBackend is returned for a backend other than the supported ones.
+	ErrUnknownBackend = errors.New("unknown object storage backend")
+	// ErrMissingCredentials is returned when a backend requires keys that are not configured.
+	ErrMissingCredentials = errors.New("object storage credentials are missing")
+	// ErrInvalidRetention is returned for a retention the storage cannot apply.
+	ErrInvalidRetention = errors.New("invalid retention")
+)
+
+// ReadAtCloser gives random access to an object.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// ObjectStorage stores objects in buckets and presigns URLs to them for the ML services and clients.
+// Buckets missing on upload are created.
+type ObjectStorage interface {
+	// UploadFile stores data of dataSize bytes, or streams it until EOF when dataSize is -1.
+	UploadFile(ctx context.Context, data io.Reader, dataSize int64, objectName, bucketName string) error
+	UploadFileFromOs(ctx context.Context, filePath, objectName, bucketName string) error
+	// GetFileURL presigns a GET of the object valid for an hour.
+	GetFileURL(ctx context.Context, objectName, bucketName string) (string, error)
+	// GetUploadURL presigns a PUT of the object valid for expires.
+	GetUploadURL(ctx context.Context, objectName, bucketName string, expires time.Duration) (string, error)
+	IsFileExist(ctx context.Context, objectName, bucketName string) (bool, error)
+	// MoveFile renames an object within its bucket.
+	MoveFile(ctx context.Context, srcObject, dstObject, bucketName string) error
+	// RemoveFile deletes an object; a missing object is not an error.
+	RemoveFile(ctx context.Context, objectName, bucketName string) error
+	GetFileReader(ctx context.Context, objectName, bucketName string) (io.ReadCloser, error)
+	// GetFileReaderAt opens an object for random access and returns its size.
+	GetFileReaderAt(ctx context.Context, objectName, bucketName string) (ReadAtCloser, int64, error)
+	// Ping checks that the storage is reachable and accepts the credentials, without retrying.
+	Ping(ctx context.Context) error
+
+	GetVideoBucketName() string
+	GetAudioBucketName() string
+	GetPreviewBucketName() string
+	GetOrigVideoBucket() string
+}
+
+// Retention is the lifecycle of the objects in a bucket, in days after an object is written; zero
+// disables a rule.
+type Retention struct {
+	ExpireDays     int
+	TransitionDays int
+	// TransitionClass is the storage class or the remote tier objects move to after TransitionDays.
+	TransitionClass string
+}
+
+// RetentionManager is implemented by storages that expire and transition objects themselves.
+type RetentionManager interface {
+	GetRetention(ctx context.Context, bucketName string) (Retention, error)
+	// SetRetention replaces the retention of the bucket, creating it if missing, and keeps the lifecycle
+	// rules configured by others.
+	SetRetention(ctx context.Context, bucketName string, r Retention) error
+}
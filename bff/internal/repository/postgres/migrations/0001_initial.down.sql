@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS origvideo, task;
+
+DROP TYPE IF EXISTS task_status;
@@ -0,0 +1,51 @@
+DROP TABLE IF EXISTS
+  task_audio_fingerprint,
+  task_scene,
+  task_metadata,
+  task_sprite,
+  object_ref,
+  task_recheck,
+  modality_request,
+  task_hash,
+  outbox,
+  batch_token,
+  batch_row,
+  batch,
+  verdict_stream,
+  verdict_event,
+  audit_log,
+  reference_check,
+  reference_registration,
+  task_resource_usage,
+  api_key_usage,
+  reference_index,
+  pushed_result,
+  kafka_processed_message;
+
+DROP FUNCTION IF EXISTS audit_log_immutable();
+
+DROP INDEX IF EXISTS origvideo_sample_hash_idx;
+
+ALTER TABLE origvideo
+  DROP COLUMN IF EXISTS audio_fingerprint,
+  DROP COLUMN IF EXISTS perceptual_hash,
+  DROP COLUMN IF EXISTS sample_hash;
+
+DROP INDEX IF EXISTS task_api_key_id_idx, task_source_ip_idx, task_in_progress_deadline_at_idx, task_in_progress_idx;
+
+ALTER TABLE task
+  DROP COLUMN IF EXISTS priority,
+  DROP COLUMN IF EXISTS stage,
+  DROP COLUMN IF EXISTS deadline_at,
+  DROP COLUMN IF EXISTS updated_at,
+  DROP COLUMN IF EXISTS failure_reason,
+  DROP COLUMN IF EXISTS created_at,
+  DROP COLUMN IF EXISTS trace_id,
+  DROP COLUMN IF EXISTS download_verification,
+  DROP COLUMN IF EXISTS api_key_id,
+  DROP COLUMN IF EXISTS user_agent,
+  DROP COLUMN IF EXISTS source_ip,
+  DROP COLUMN IF EXISTS parent_task_id,
+  DROP COLUMN IF EXISTS index_version;
+
+DROP TYPE IF EXISTS task_priority, task_stage;
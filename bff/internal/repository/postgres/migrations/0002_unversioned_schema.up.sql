@@ -0,0 +1,292 @@
+-- The schema changes made before the schema was migrated, applied to a database created with the initial
+-- schema.
+
+-- task_stage is the step of the pipeline a task reached; a failed task keeps the stage it failed in.
+CREATE TYPE task_stage AS ENUM ('uploading', 'audio_extraction', 'queued', 'audio_checked', 'video_checked', 'done');
+
+-- task_priority orders the requests to the ML services: interactive tasks, which a caller waits for,
+-- go before batch ones.
+CREATE TYPE task_priority AS ENUM ('interactive', 'batch');
+
+ALTER TABLE task
+  ADD COLUMN index_version TEXT,
+  ADD COLUMN parent_task_id BIGINT REFERENCES task (task_id),
+  ADD COLUMN source_ip TEXT,
+  ADD COLUMN user_agent TEXT,
+  ADD COLUMN api_key_id TEXT,
+  ADD COLUMN download_verification TEXT,
+  ADD COLUMN trace_id TEXT,
+  ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN failure_reason TEXT,
+  ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN deadline_at TIMESTAMPTZ,
+  ADD COLUMN stage task_stage NOT NULL DEFAULT 'queued',
+  ADD COLUMN priority task_priority NOT NULL DEFAULT 'interactive';
+
+-- Tasks finished before stages were tracked reached the last one.
+UPDATE task SET stage = 'done' WHERE status = 'done';
+
+CREATE INDEX task_in_progress_idx ON task (task_id) WHERE status = 'in_progress';
+CREATE INDEX task_in_progress_deadline_at_idx ON task (deadline_at) WHERE status = 'in_progress';
+CREATE INDEX task_source_ip_idx ON task (source_ip);
+CREATE INDEX task_api_key_id_idx ON task (api_key_id);
+
+ALTER TABLE origvideo
+  ADD COLUMN sample_hash TEXT,
+  ADD COLUMN perceptual_hash TEXT,
+  ADD COLUMN audio_fingerprint BYTEA;
+
+CREATE INDEX origvideo_sample_hash_idx ON origvideo (sample_hash);
+
+CREATE TABLE kafka_processed_message (
+  topic TEXT NOT NULL,
+  msg_partition INTEGER NOT NULL,
+  msg_offset BIGINT NOT NULL,
+  processed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (topic, msg_partition, msg_offset)
+);
+
+CREATE TABLE pushed_result (
+  modality TEXT NOT NULL,
+  result_id TEXT NOT NULL,
+  pushed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (modality, result_id)
+);
+
+CREATE TABLE reference_index (
+  version TEXT PRIMARY KEY,
+  active BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX reference_index_active_idx ON reference_index (active) WHERE active;
+
+CREATE TABLE api_key_usage (
+  api_key_id TEXT PRIMARY KEY,
+  tasks BIGINT NOT NULL DEFAULT 0,
+  video_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+  bytes_stored BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE task_resource_usage (
+  task_id BIGINT PRIMARY KEY REFERENCES task (task_id),
+  bytes_downloaded BIGINT NOT NULL DEFAULT 0,
+  bytes_stored BIGINT NOT NULL DEFAULT 0,
+  ffmpeg_cpu_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+  ml_requests INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE reference_registration (
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  modality TEXT NOT NULL,
+  status TEXT NOT NULL,
+  attempts INTEGER NOT NULL,
+  last_error TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (task_id, modality)
+);
+
+-- reference_check links a reference video to the latest task checking it against the reference index,
+-- so the similarity graph of the references is built from the candidates of those tasks.
+CREATE TABLE reference_check (
+  task_id BIGINT PRIMARY KEY REFERENCES task (task_id),
+  check_task_id BIGINT NOT NULL REFERENCES task (task_id),
+  checked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- audit_log is append-only: the trigger rejects changes to recorded events.
+CREATE TABLE audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  task_id BIGINT,
+  details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_log_task_id_idx ON audit_log (task_id);
+
+CREATE FUNCTION audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE FUNCTION audit_log_immutable();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_log_immutable();
+
+-- verdict_event queues the audit events of final verdicts and overrides for the verdict stream.
+-- seq is assigned when an event is published, so consumers see numbers without gaps in publish order.
+CREATE TABLE verdict_event (
+  id BIGSERIAL PRIMARY KEY,
+  audit_id BIGINT NOT NULL REFERENCES audit_log (id),
+  seq BIGINT UNIQUE,
+  published_at TIMESTAMPTZ
+);
+
+CREATE INDEX verdict_event_unpublished_idx ON verdict_event (id) WHERE seq IS NULL;
+
+-- verdict_stream holds the last sequence number published; its single row is locked while a relay
+-- publishes, so the relays of several processes never number events concurrently.
+CREATE TABLE verdict_stream (
+  id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+  last_seq BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO verdict_stream DEFAULT VALUES;
+
+CREATE TABLE batch (
+  batch_id BIGSERIAL PRIMARY KEY,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ,
+  rows_processed BIGINT NOT NULL DEFAULT 0,
+  duplicates BIGINT NOT NULL DEFAULT 0,
+  failures BIGINT NOT NULL DEFAULT 0,
+  avg_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+  score_histogram JSONB NOT NULL DEFAULT '[]',
+  result_csv BYTEA,
+  total_rows BIGINT NOT NULL DEFAULT 0,
+  source_ip TEXT,
+  user_agent TEXT,
+  api_key_id TEXT
+);
+
+-- batch_row holds the rows of a batch, checked in the background by the batch workers of the API.
+-- claimed_at leases a row to a worker, so the rows of a stopped worker are claimed again once the
+-- lease runs out; task_id lets the new worker wait for the task already created for the row.
+CREATE TABLE batch_row (
+  batch_id BIGINT NOT NULL REFERENCES batch (batch_id),
+  row_no INTEGER NOT NULL,
+  created TIMESTAMPTZ NOT NULL,
+  uuid TEXT NOT NULL,
+  link TEXT NOT NULL,
+  task_id BIGINT REFERENCES task (task_id),
+  claimed_at TIMESTAMPTZ,
+  processed_at TIMESTAMPTZ,
+  failed BOOLEAN NOT NULL DEFAULT false,
+  is_duplicate BOOLEAN NOT NULL DEFAULT false,
+  duplicate_for TEXT,
+  score DOUBLE PRECISION NOT NULL DEFAULT 0,
+  latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+  PRIMARY KEY (batch_id, row_no)
+);
+
+CREATE INDEX batch_row_pending_idx ON batch_row (batch_id, row_no) WHERE processed_at IS NULL;
+
+-- batch_token holds the tokens scoped to reading one batch, handed to the frontend instead of API
+-- credentials. Only the SHA-256 of a token is stored.
+CREATE TABLE batch_token (
+  token_hash TEXT PRIMARY KEY,
+  batch_id BIGINT NOT NULL REFERENCES batch (batch_id),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX batch_token_batch_id_idx ON batch_token (batch_id);
+
+-- outbox holds the requests to the ML services written together with their task; a relay publishes
+-- them and marks them sent, so a task is never left in progress without its requests.
+CREATE TABLE outbox (
+  id BIGSERIAL PRIMARY KEY,
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  modality TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  sent_at TIMESTAMPTZ,
+  priority task_priority NOT NULL DEFAULT 'interactive'
+);
+
+CREATE INDEX outbox_unsent_idx ON outbox (priority, id) WHERE sent_at IS NULL;
+
+CREATE TABLE task_hash (
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  algorithm TEXT NOT NULL,
+  digest TEXT NOT NULL,
+  PRIMARY KEY (task_id, algorithm)
+);
+
+CREATE INDEX task_hash_digest_idx ON task_hash (algorithm, digest);
+
+-- modality_request tracks the request sent to the ML service of each modality, so requests
+-- without a result in time are sent again or fail the task.
+CREATE TABLE modality_request (
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  modality TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  sent_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  received_at TIMESTAMPTZ,
+  PRIMARY KEY (task_id, modality)
+);
+
+CREATE INDEX modality_request_waiting_idx ON modality_request (sent_at) WHERE received_at IS NULL;
+
+-- task_recheck records the re-checks of a library video against the references registered after its
+-- last check. Each re-check is a comparison task, so its verdict is kept apart from the one at upload.
+-- references_through is the latest reference task the re-check covers.
+CREATE TABLE task_recheck (
+  id BIGSERIAL PRIMARY KEY,
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  recheck_task_id BIGINT NOT NULL REFERENCES task (task_id),
+  references_through BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX task_recheck_task_id_idx ON task_recheck (task_id);
+
+-- object_ref counts the references of tasks to a content-addressed object. Identical files share an
+-- object, so it is only deleted once its count drops to zero.
+CREATE TABLE object_ref (
+  bucket TEXT NOT NULL,
+  object_key TEXT NOT NULL,
+  refs INTEGER NOT NULL CHECK (refs >= 0),
+  PRIMARY KEY (bucket, object_key)
+);
+
+-- task_sprite describes the sprite sheet of a task: frames evenly spaced over its video, tiled left to
+-- right and top to bottom for hover previews. timestamps holds the position of each frame in seconds.
+CREATE TABLE task_sprite (
+  task_id BIGINT PRIMARY KEY REFERENCES task (task_id),
+  object_key TEXT NOT NULL,
+  grid_columns INTEGER NOT NULL,
+  grid_rows INTEGER NOT NULL,
+  frame_width INTEGER NOT NULL,
+  frame_height INTEGER NOT NULL,
+  timestamps DOUBLE PRECISION[] NOT NULL
+);
+
+-- task_metadata holds the properties of the video of a task probed at ingest: its container and its
+-- first video and audio streams. Properties the probe did not report are empty or zero.
+CREATE TABLE task_metadata (
+  task_id BIGINT PRIMARY KEY REFERENCES task (task_id),
+  format TEXT NOT NULL,
+  video_codec TEXT NOT NULL,
+  audio_codec TEXT NOT NULL,
+  width INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  frame_rate DOUBLE PRECISION NOT NULL,
+  bit_rate BIGINT NOT NULL,
+  duration_seconds DOUBLE PRECISION NOT NULL
+);
+
+-- task_scene holds the scenes of the video of a task, cut where consecutive frames differ strongly,
+-- with a keyframe from the middle of each stored in the preview bucket. Positions are in seconds.
+CREATE TABLE task_scene (
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  scene INTEGER NOT NULL,
+  start_seconds DOUBLE PRECISION NOT NULL,
+  end_seconds DOUBLE PRECISION NOT NULL,
+  keyframe_seconds DOUBLE PRECISION NOT NULL,
+  keyframe_key TEXT NOT NULL,
+  PRIMARY KEY (task_id, scene)
+);
+
+-- task_audio_fingerprint holds the chromaprint fingerprint of the audio of a task: its raw 32-bit
+-- sub-fingerprints in order, big-endian.
+CREATE TABLE task_audio_fingerprint (
+  task_id BIGINT PRIMARY KEY REFERENCES task (task_id),
+  fingerprint BYTEA NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS task_status_created_at_idx;
+DROP INDEX IF EXISTS task_status_task_id_idx;
@@ -0,0 +1,3 @@
+-- Tasks are listed by status in order and counted by status and age, without scanning all tasks.
+CREATE INDEX task_status_task_id_idx ON task (status, task_id);
+CREATE INDEX task_status_created_at_idx ON task (status, created_at);
@@ -0,0 +1,5 @@
+-- deleted_at marks a task deleted: it is hidden from the listings until restored or purged, and its
+-- audit events are kept.
+ALTER TABLE task ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX task_deleted_idx ON task (task_id) WHERE deleted_at IS NOT NULL;
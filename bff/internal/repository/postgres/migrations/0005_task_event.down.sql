@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS task_status_changed ON task;
+
+DROP FUNCTION IF EXISTS record_task_event();
+
+DROP TABLE IF EXISTS task_event;
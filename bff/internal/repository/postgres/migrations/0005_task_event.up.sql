@@ -0,0 +1,47 @@
+-- task_event records every change of the status of a task. The trigger runs when the transaction
+-- commits, so it sees the actor and reason set by the audited action of the transaction through the
+-- bff.actor and bff.reason settings; a failed task records its failure reason instead.
+CREATE TABLE task_event (
+  id BIGSERIAL PRIMARY KEY,
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  status task_status NOT NULL,
+  previous_status task_status,
+  stage task_stage NOT NULL,
+  actor TEXT NOT NULL,
+  reason TEXT,
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX task_event_task_id_idx ON task_event (task_id, id);
+
+CREATE FUNCTION record_task_event() RETURNS trigger AS $$
+BEGIN
+  IF NEW.status IS NULL OR (TG_OP = 'UPDATE' AND OLD.status IS NOT DISTINCT FROM NEW.status) THEN
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO task_event (task_id, status, previous_status, stage, actor, reason)
+  VALUES (
+    NEW.task_id,
+    NEW.status,
+    CASE WHEN TG_OP = 'UPDATE' THEN OLD.status END,
+    NEW.stage,
+    COALESCE(NULLIF(current_setting('bff.actor', true), ''), 'system'),
+    CASE WHEN NEW.status = 'fail' AND NEW.failure_reason IS NOT NULL THEN NEW.failure_reason
+      ELSE NULLIF(current_setting('bff.reason', true), '') END
+  );
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER task_status_changed AFTER INSERT OR UPDATE OF status ON task
+  DEFERRABLE INITIALLY DEFERRED
+  FOR EACH ROW EXECUTE FUNCTION record_task_event();
+
+-- Tasks created before the history start with their current status.
+INSERT INTO task_event (task_id, status, stage, actor, reason, occurred_at)
+SELECT task_id, status, stage, 'system', failure_reason, updated_at
+FROM task
+WHERE status IS NOT NULL
+ORDER BY task_id;
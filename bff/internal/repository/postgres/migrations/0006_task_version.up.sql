@@ -0,0 +1,4 @@
+-- version counts the changes of a task. Writes of its status and results that depend on what was read
+-- before are conditional on the version read, so a stale writer changes nothing instead of overwriting
+-- a newer state.
+ALTER TABLE task ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,6 @@
+ALTER TABLE task
+  DROP COLUMN IF EXISTS content_hash,
+  DROP COLUMN IF EXISTS file_size,
+  DROP COLUMN IF EXISTS height,
+  DROP COLUMN IF EXISTS width,
+  DROP COLUMN IF EXISTS duration_seconds;
@@ -0,0 +1,9 @@
+-- The properties of the video of a task, recorded when the task is created: its length in seconds, its
+-- resolution, the bytes stored for it and the MD5 of its content. They are NULL for tasks created before
+-- and for properties that could not be determined.
+ALTER TABLE task
+  ADD COLUMN duration_seconds DOUBLE PRECISION,
+  ADD COLUMN width INTEGER,
+  ADD COLUMN height INTEGER,
+  ADD COLUMN file_size BIGINT,
+  ADD COLUMN content_hash TEXT;
@@ -0,0 +1,12 @@
+-- task_stage_timing records when the timed stages of a task started and finished: the upload of its
+-- video, the audio extraction and the checks by the audio and video ML services. finished_at is NULL
+-- while a stage runs; a check requested again starts its stage anew.
+CREATE TABLE task_stage_timing (
+  task_id BIGINT NOT NULL REFERENCES task (task_id),
+  stage TEXT NOT NULL,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ,
+  PRIMARY KEY (task_id, stage)
+);
+
+CREATE INDEX task_stage_timing_finished_idx ON task_stage_timing (finished_at) WHERE finished_at IS NOT NULL;
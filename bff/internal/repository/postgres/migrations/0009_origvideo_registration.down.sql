@@ -0,0 +1,3 @@
+ALTER TABLE origvideo
+  DROP COLUMN IF EXISTS registered_at,
+  DROP COLUMN IF EXISTS id;
@@ -0,0 +1,7 @@
+-- id numbers the original videos in the order they were registered and registered_at tells when, so the
+-- catalog can be listed page by page. Videos registered earlier carry the time of this migration.
+ALTER TABLE origvideo
+  ADD COLUMN id BIGSERIAL PRIMARY KEY,
+  ADD COLUMN registered_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX origvideo_registered_at_idx ON origvideo (registered_at);
@@ -0,0 +1,16 @@
+-- kafka_dead_letter indexes the messages published to the dead-letter topic, so they can be inspected and
+-- replayed to the topic they were read from. A message dead-lettered again replaces its entry.
+CREATE TABLE kafka_dead_letter (
+  id BIGSERIAL PRIMARY KEY,
+  topic TEXT NOT NULL,
+  msg_partition INTEGER NOT NULL,
+  msg_offset BIGINT NOT NULL,
+  msg_key BYTEA,
+  msg_value BYTEA NOT NULL,
+  headers JSONB NOT NULL DEFAULT '[]',
+  error TEXT NOT NULL,
+  attempts INTEGER NOT NULL,
+  dead_lettered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  replayed_at TIMESTAMPTZ,
+  UNIQUE (topic, msg_partition, msg_offset)
+);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS outbox_sent_idx;
+
+DROP INDEX IF EXISTS outbox_unsent_idx;
+
+CREATE INDEX outbox_unsent_idx ON outbox (priority, id) WHERE sent_at IS NULL;
+
+ALTER TABLE outbox
+  DROP COLUMN IF EXISTS parked_at,
+  DROP COLUMN IF EXISTS retry_at,
+  DROP COLUMN IF EXISTS last_error,
+  DROP COLUMN IF EXISTS attempts;
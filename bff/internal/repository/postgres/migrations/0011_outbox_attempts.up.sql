@@ -0,0 +1,15 @@
+-- attempts and last_error record the failed publications of a request; a failed request waits until
+-- retry_at, so it does not hold up the requests behind it, and is parked after too many attempts.
+-- Parked requests are kept for inspection and never claimed again.
+ALTER TABLE outbox
+  ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN last_error TEXT,
+  ADD COLUMN retry_at TIMESTAMPTZ,
+  ADD COLUMN parked_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS outbox_unsent_idx;
+
+CREATE INDEX outbox_unsent_idx ON outbox (priority, id) WHERE sent_at IS NULL AND parked_at IS NULL;
+
+-- Sent requests are pruned after a retention period.
+CREATE INDEX outbox_sent_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
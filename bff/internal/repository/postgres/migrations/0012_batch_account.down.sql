@@ -0,0 +1 @@
+ALTER TABLE batch DROP COLUMN IF EXISTS account;
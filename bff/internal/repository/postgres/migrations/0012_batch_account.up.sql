@@ -0,0 +1,3 @@
+-- account is the identity the tasks of a batch are counted against by the quotas: the subject of the
+-- token or the fingerprint of the registered API key the batch was submitted with.
+ALTER TABLE batch ADD COLUMN account TEXT;
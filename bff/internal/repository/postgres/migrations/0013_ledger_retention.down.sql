@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS pushed_result_pushed_at_idx;
+
+DROP INDEX IF EXISTS kafka_processed_message_processed_at_idx;
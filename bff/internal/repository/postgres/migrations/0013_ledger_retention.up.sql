@@ -0,0 +1,5 @@
+-- The processed-message and pushed-result ledgers are pruned after a retention period; the indexes let
+-- the reaper find the old entries without a scan.
+CREATE INDEX kafka_processed_message_processed_at_idx ON kafka_processed_message (processed_at);
+
+CREATE INDEX pushed_result_pushed_at_idx ON pushed_result (pushed_at);
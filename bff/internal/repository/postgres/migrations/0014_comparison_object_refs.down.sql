@@ -0,0 +1,15 @@
+UPDATE object_ref r SET refs = greatest(r.refs - c.n, 1)
+FROM (
+  SELECT k.object_key, count(*) AS n
+  FROM (
+    SELECT video_file AS object_key FROM task WHERE parent_task_id IS NOT NULL
+    UNION ALL
+    SELECT audio_file FROM task WHERE parent_task_id IS NOT NULL
+    UNION ALL
+    SELECT preview_id FROM task WHERE parent_task_id IS NOT NULL
+  ) k
+  WHERE k.object_key IS NOT NULL
+  GROUP BY k.object_key
+) c
+WHERE r.object_key = c.object_key
+  AND r.refs > 0;
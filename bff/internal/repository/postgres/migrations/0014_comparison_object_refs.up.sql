@@ -0,0 +1,18 @@
+-- Comparison tasks share the media of their original task and now hold references of their own, so
+-- purging either task keeps the media for the other. The references of the existing comparison tasks
+-- are counted here; objects without references stay uncounted.
+UPDATE object_ref r SET refs = r.refs + c.n
+FROM (
+  SELECT k.object_key, count(*) AS n
+  FROM (
+    SELECT video_file AS object_key FROM task WHERE parent_task_id IS NOT NULL
+    UNION ALL
+    SELECT audio_file FROM task WHERE parent_task_id IS NOT NULL
+    UNION ALL
+    SELECT preview_id FROM task WHERE parent_task_id IS NOT NULL
+  ) k
+  WHERE k.object_key IS NOT NULL
+  GROUP BY k.object_key
+) c
+WHERE r.object_key = c.object_key
+  AND r.refs > 0;
@@ -0,0 +1,230 @@
+// Package migrations applies the schema of the database from the migrations embedded in the binary
+// with golang-migrate.
+//
+// A migration is a pair of files NNNN_name.up.sql and NNNN_name.down.sql, numbered from 1 without
+// gaps; sqlc reads the schema from the same files. A new schema change is always a new migration,
+// an applied one is never edited. The applied version is recorded in schema_migrations.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// ErrUnknownVersion is returned for a version of the database the binary has no migration of,
+// e.g. one applied by a newer binary.
+var ErrUnknownVersion = errors.New("unknown schema version")
+
+// baselineTable is a table of the initial migration, the schema the database was created with before
+// it was migrated; a database having it without a recorded version is at version 1.
+const baselineTable = "task"
+
+// Migrator applies and reverts the embedded migrations. Migrations run on a connection of their own
+// without the statement timeout of the pool, as they and the wait for the migration lock may outlast it.
+type Migrator struct {
+	pool    *pgxpool.Pool
+	log     *zerolog.Logger
+	migrate *migrate.Migrate
+	latest  int64
+}
+
+// New returns a migrator of the database of the pool. It must be closed after use.
+func New(pool *pgxpool.Pool, log *zerolog.Logger) (*Migrator, error) {
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	latest, err := latestVersion(src)
+	if err != nil {
+		return nil, err
+	}
+
+	connCfg := pool.Config().ConnConfig.Copy()
+	delete(connCfg.RuntimeParams, "statement_timeout")
+	driver, err := pgxmigrate.WithInstance(stdlib.OpenDB(*connCfg), &pgxmigrate.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration connection: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrator: %w", err)
+	}
+	m.Log = migrateLogger{log: log}
+
+	return &Migrator{pool: pool, log: log, migrate: m, latest: latest}, nil
+}
+
+// Close releases the connection of the migrator.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.migrate.Close()
+
+	return errors.Join(srcErr, dbErr)
+}
+
+// Latest returns the version of the last embedded migration.
+func (m *Migrator) Latest() int64 {
+	return m.latest
+}
+
+// Version returns the version of the database, zero for an empty one, and whether the last migration
+// failed halfway; such a database is fixed by hand and recorded with Force.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	if err := m.stampBaseline(ctx); err != nil {
+		return 0, false, err
+	}
+
+	return m.version()
+}
+
+// Up applies the migrations the database lacks and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.stampBaseline(ctx); err != nil {
+		return 0, err
+	}
+
+	before, _, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.migrate.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return 0, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	after, _, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(after - before), nil
+}
+
+// Down reverts the last steps migrations and returns how many were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if err := m.stampBaseline(ctx); err != nil {
+		return 0, err
+	}
+
+	version, _, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+
+	// Revert no further than the empty database.
+	steps = min(steps, int(version))
+	if steps == 0 {
+		return 0, nil
+	}
+	if err := m.migrate.Steps(-steps); err != nil {
+		return 0, fmt.Errorf("failed to revert migrations: %w", err)
+	}
+
+	return steps, nil
+}
+
+// Force records the database at version without applying anything and clears a failed migration,
+// e.g. after fixing it by hand.
+func (m *Migrator) Force(_ context.Context, version int64) error {
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	// golang-migrate records an empty database as the nil version.
+	v := int(version)
+	if version == 0 {
+		v = database.NilVersion
+	}
+	if err := m.migrate.Force(v); err != nil {
+		return fmt.Errorf("failed to force schema version: %w", err)
+	}
+
+	return nil
+}
+
+// version returns the recorded version of the database, zero for none.
+func (m *Migrator) version() (int64, bool, error) {
+	version, dirty, err := m.migrate.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	if int64(version) > m.Latest() {
+		return 0, false, fmt.Errorf("%w: %d, latest known is %d", ErrUnknownVersion, version, m.Latest())
+	}
+
+	return int64(version), dirty, nil
+}
+
+// stampBaseline records a database created before it was migrated, which has the tables of the
+// initial migration but no recorded version, at version 1, so the later migrations upgrade it.
+func (m *Migrator) stampBaseline(ctx context.Context) error {
+	if _, _, err := m.migrate.Version(); !errors.Is(err, migrate.ErrNilVersion) {
+		return nil
+	}
+
+	var baseline bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", baselineTable).Scan(&baseline); err != nil {
+		return fmt.Errorf("failed to check for the initial schema: %w", err)
+	}
+	if !baseline {
+		return nil
+	}
+
+	m.log.Warn().Msg("schema created without migrations, recording it at version 1")
+	if err := m.migrate.Force(1); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	return nil
+}
+
+// latestVersion returns the version of the last migration of src.
+func latestVersion(src source.Driver) (int64, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return int64(version), nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		version = next
+	}
+}
+
+// migrateLogger logs the progress of golang-migrate.
+type migrateLogger struct {
+	log *zerolog.Logger
+}
+
+func (l migrateLogger) Printf(format string, v ...any) {
+	l.log.Info().Msg(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (l migrateLogger) Verbose() bool {
+	return false
+}
@@ -54,18 +54,353 @@ func (ns NullTaskStatus) Value() (driver.Value, error) {
 	return string(ns.TaskStatus), nil
 }
 
+type TaskStage string
+
+const (
+	TaskStageUploading       TaskStage = "uploading"
+	TaskStageAudioExtraction TaskStage = "audio_extraction"
+	TaskStageQueued          TaskStage = "queued"
+	TaskStageAudioChecked    TaskStage = "audio_checked"
+	TaskStageVideoChecked    TaskStage = "video_checked"
+	TaskStageDone            TaskStage = "done"
+)
+
+func (e *TaskStage) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = TaskStage(s)
+	case string:
+		*e = TaskStage(s)
+	default:
+		return fmt.Errorf("unsupported scan type for TaskStage: %T", src)
+	}
+	return nil
+}
+
+type NullTaskStage struct {
+	TaskStage TaskStage
+	Valid     bool // Valid is true if TaskStage is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullTaskStage) Scan(value interface{}) error {
+	if value == nil {
+		ns.TaskStage, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.TaskStage.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullTaskStage) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.TaskStage), nil
+}
+
+type TaskPriority string
+
+const (
+	TaskPriorityInteractive TaskPriority = "interactive"
+	TaskPriorityBatch       TaskPriority = "batch"
+)
+
+func (e *TaskPriority) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = TaskPriority(s)
+	case string:
+		*e = TaskPriority(s)
+	default:
+		return fmt.Errorf("unsupported scan type for TaskPriority: %T", src)
+	}
+	return nil
+}
+
+type NullTaskPriority struct {
+	TaskPriority TaskPriority
+	Valid        bool // Valid is true if TaskPriority is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullTaskPriority) Scan(value interface{}) error {
+	if value == nil {
+		ns.TaskPriority, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.TaskPriority.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullTaskPriority) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.TaskPriority), nil
+}
+
+type ApiKeyUsage struct {
+	ApiKeyID     string
+	Tasks        int64
+	VideoSeconds float64
+	BytesStored  int64
+	UpdatedAt    pgtype.Timestamptz
+}
+
+type AuditLog struct {
+	ID         int64
+	OccurredAt pgtype.Timestamptz
+	Actor      string
+	Action     string
+	TaskID     pgtype.Int8
+	Details    []byte
+}
+
+type Batch struct {
+	BatchID        int64
+	StartedAt      pgtype.Timestamptz
+	FinishedAt     pgtype.Timestamptz
+	RowsProcessed  int64
+	Duplicates     int64
+	Failures       int64
+	AvgLatencyMs   float64
+	ScoreHistogram []byte
+	ResultCsv      []byte
+	TotalRows      int64
+	SourceIp       pgtype.Text
+	UserAgent      pgtype.Text
+	ApiKeyID       pgtype.Text
+	Account        pgtype.Text
+}
+
+type BatchRow struct {
+	BatchID      int64
+	RowNo        int32
+	Created      pgtype.Timestamptz
+	Uuid         string
+	Link         string
+	TaskID       pgtype.Int8
+	ClaimedAt    pgtype.Timestamptz
+	ProcessedAt  pgtype.Timestamptz
+	Failed       bool
+	IsDuplicate  bool
+	DuplicateFor pgtype.Text
+	Score        float64
+	LatencyMs    float64
+}
+
+type BatchToken struct {
+	TokenHash string
+	BatchID   int64
+	CreatedAt pgtype.Timestamptz
+	ExpiresAt pgtype.Timestamptz
+	RevokedAt pgtype.Timestamptz
+}
+
+type KafkaDeadLetter struct {
+	ID             int64
+	Topic          string
+	MsgPartition   int32
+	MsgOffset      int64
+	MsgKey         []byte
+	MsgValue       []byte
+	Headers        []byte
+	Error          string
+	Attempts       int32
+	DeadLetteredAt pgtype.Timestamptz
+	ReplayedAt     pgtype.Timestamptz
+}
+
+type KafkaProcessedMessage struct {
+	Topic        string
+	MsgPartition int32
+	MsgOffset    int64
+	ProcessedAt  pgtype.Timestamptz
+}
+
+type ModalityRequest struct {
+	TaskID      int64
+	Modality    string
+	Attempts    int32
+	RequestedAt pgtype.Timestamptz
+	SentAt      pgtype.Timestamptz
+	ReceivedAt  pgtype.Timestamptz
+}
+
+type ObjectRef struct {
+	Bucket    string
+	ObjectKey string
+	Refs      int32
+}
+
 type Origvideo struct {
-	VideoID   pgtype.Text
-	VideoHash pgtype.Text
+	VideoID          pgtype.Text
+	VideoHash        pgtype.Text
+	SampleHash       pgtype.Text
+	PerceptualHash   pgtype.Text
+	AudioFingerprint []byte
+	ID               int64
+	RegisteredAt     pgtype.Timestamptz
+}
+
+type Outbox struct {
+	ID        int64
+	TaskID    int64
+	Modality  string
+	CreatedAt pgtype.Timestamptz
+	SentAt    pgtype.Timestamptz
+	Priority  TaskPriority
+	Attempts  int32
+	LastError pgtype.Text
+	RetryAt   pgtype.Timestamptz
+	ParkedAt  pgtype.Timestamptz
+}
+
+type PushedResult struct {
+	Modality string
+	ResultID string
+	PushedAt pgtype.Timestamptz
+}
+
+type ReferenceCheck struct {
+	TaskID      int64
+	CheckTaskID int64
+	CheckedAt   pgtype.Timestamptz
+}
+
+type ReferenceIndex struct {
+	Version   string
+	Active    bool
+	CreatedAt pgtype.Timestamptz
+}
+
+type ReferenceRegistration struct {
+	TaskID    int64
+	Modality  string
+	Status    string
+	Attempts  int32
+	LastError pgtype.Text
+	UpdatedAt pgtype.Timestamptz
 }
 
 type Task struct {
+	TaskID               int64
+	VideoName            pgtype.Text
+	AudioFile            pgtype.Text
+	VideoFile            pgtype.Text
+	PreviewID            pgtype.Text
+	Status               NullTaskStatus
+	AudioCopyright       []byte
+	VideoCopyright       []byte
+	IndexVersion         pgtype.Text
+	ParentTaskID         pgtype.Int8
+	SourceIp             pgtype.Text
+	UserAgent            pgtype.Text
+	ApiKeyID             pgtype.Text
+	DownloadVerification pgtype.Text
+	TraceID              pgtype.Text
+	CreatedAt            pgtype.Timestamptz
+	FailureReason        pgtype.Text
+	UpdatedAt            pgtype.Timestamptz
+	DeadlineAt           pgtype.Timestamptz
+	Stage                TaskStage
+	Priority             TaskPriority
+	DeletedAt            pgtype.Timestamptz
+	Version              int64
+	DurationSeconds      pgtype.Float8
+	Width                pgtype.Int4
+	Height               pgtype.Int4
+	FileSize             pgtype.Int8
+	ContentHash          pgtype.Text
+}
+
+type TaskAudioFingerprint struct {
+	TaskID      int64
+	Fingerprint []byte
+}
+
+type TaskEvent struct {
+	ID             int64
 	TaskID         int64
-	VideoName      pgtype.Text
-	AudioFile      pgtype.Text
-	VideoFile      pgtype.Text
-	PreviewID      pgtype.Text
-	Status         NullTaskStatus
-	AudioCopyright []byte
-	VideoCopyright []byte
+	Status         TaskStatus
+	PreviousStatus NullTaskStatus
+	Stage          TaskStage
+	Actor          string
+	Reason         pgtype.Text
+	OccurredAt     pgtype.Timestamptz
+}
+
+type TaskHash struct {
+	TaskID    int64
+	Algorithm string
+	Digest    string
+}
+
+type TaskMetadatum struct {
+	TaskID          int64
+	Format          string
+	VideoCodec      string
+	AudioCodec      string
+	Width           int32
+	Height          int32
+	FrameRate       float64
+	BitRate         int64
+	DurationSeconds float64
+}
+
+type TaskRecheck struct {
+	ID                int64
+	TaskID            int64
+	RecheckTaskID     int64
+	ReferencesThrough int64
+	CreatedAt         pgtype.Timestamptz
+}
+
+type TaskResourceUsage struct {
+	TaskID           int64
+	BytesDownloaded  int64
+	BytesStored      int64
+	FfmpegCpuSeconds float64
+	MlRequests       int32
+}
+
+type TaskScene struct {
+	TaskID          int64
+	Scene           int32
+	StartSeconds    float64
+	EndSeconds      float64
+	KeyframeSeconds float64
+	KeyframeKey     string
+}
+
+type TaskSprite struct {
+	TaskID      int64
+	ObjectKey   string
+	GridColumns int32
+	GridRows    int32
+	FrameWidth  int32
+	FrameHeight int32
+	Timestamps  []float64
+}
+
+type TaskStageTiming struct {
+	TaskID     int64
+	Stage      string
+	StartedAt  pgtype.Timestamptz
+	FinishedAt pgtype.Timestamptz
+}
+
+type VerdictEvent struct {
+	ID          int64
+	AuditID     int64
+	Seq         pgtype.Int8
+	PublishedAt pgtype.Timestamptz
+}
+
+type VerdictStream struct {
+	ID      bool
+	LastSeq int64
 }
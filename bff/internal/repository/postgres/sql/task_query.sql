@@ -2,34 +2,380 @@
 SELECT * FROM task
 WHERE task_id = $1 LIMIT 1;
 
+-- name: GetTaskForUpdate :one
+SELECT * FROM task
+WHERE task_id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: GetTaskQueuePosition :one
+SELECT count(*) FROM task
+WHERE status = 'in_progress'
+  AND stage NOT IN ('uploading', 'audio_extraction')
+  AND (priority, task_id) <= (SELECT t.priority, t.task_id FROM task t WHERE t.task_id = $1);
+
 -- name: GetTasks :many
 SELECT * FROM task
+WHERE task_id > $1
+  AND deleted_at IS NULL
 ORDER BY task_id ASC
-LIMIT $1 OFFSET $2;
+LIMIT $2;
+
+-- name: GetTasksDesc :many
+SELECT * FROM task
+WHERE task_id < $1
+  AND deleted_at IS NULL
+ORDER BY task_id DESC
+LIMIT $2;
+
+-- name: SearchTasks :many
+SELECT * FROM task
+WHERE task_id > @task_id
+  AND (sqlc.narg(source_ip)::text IS NULL OR source_ip = sqlc.narg(source_ip))
+  AND (sqlc.narg(user_agent)::text IS NULL OR user_agent ILIKE '%' || sqlc.narg(user_agent) || '%')
+  AND (sqlc.narg(api_key_id)::text IS NULL OR api_key_id = sqlc.narg(api_key_id))
+  AND deleted_at IS NULL
+ORDER BY task_id ASC
+LIMIT @max_rows;
+
+-- name: GetTasksByStatus :many
+SELECT * FROM task
+WHERE status = @status::task_status
+  AND created_at < @created_before
+  AND task_id > @task_id
+  AND deleted_at IS NULL
+ORDER BY task_id ASC
+LIMIT @max_rows;
+
+-- name: CountTasksByStatus :one
+SELECT count(*) FROM task
+WHERE status = @status::task_status
+  AND created_at < @created_before
+  AND deleted_at IS NULL;
+
+-- name: GetTaskSourceReport :many
+SELECT
+  COALESCE(CASE @dimension::text
+    WHEN 'user_agent' THEN user_agent
+    WHEN 'api_key' THEN api_key_id
+    ELSE source_ip
+  END, '')::text AS source,
+  count(*) AS tasks,
+  count(*) FILTER (WHERE status = 'in_progress') AS in_progress,
+  count(*) FILTER (WHERE status = 'fail') AS failed
+FROM task
+GROUP BY 1
+ORDER BY tasks DESC
+LIMIT @max_rows;
 
 -- name: GetTasksCount :one
-SELECT count(*) FROM task;
+SELECT count(*) FROM task
+WHERE deleted_at IS NULL;
+
+-- name: SoftDeleteTask :execrows
+UPDATE task SET
+  deleted_at = now(),
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $2
+  AND deleted_at IS NULL;
+
+-- name: RestoreTask :execrows
+UPDATE task SET
+  deleted_at = NULL,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $2
+  AND deleted_at IS NOT NULL;
+
+-- name: GetDeletedTasks :many
+SELECT * FROM task
+WHERE task_id > $1
+  AND deleted_at IS NOT NULL
+ORDER BY task_id ASC
+LIMIT $2;
+
+-- name: PurgeTask :execrows
+WITH
+  resource_usage AS (DELETE FROM task_resource_usage WHERE task_resource_usage.task_id = @task_id),
+  registrations AS (DELETE FROM reference_registration WHERE reference_registration.task_id = @task_id),
+  checks AS (DELETE FROM reference_check WHERE reference_check.task_id = @task_id OR check_task_id = @task_id),
+  outbox_rows AS (DELETE FROM outbox WHERE outbox.task_id = @task_id),
+  hashes AS (DELETE FROM task_hash WHERE task_hash.task_id = @task_id),
+  requests AS (DELETE FROM modality_request WHERE modality_request.task_id = @task_id),
+  rechecks AS (DELETE FROM task_recheck WHERE task_recheck.task_id = @task_id OR recheck_task_id = @task_id),
+  sprites AS (DELETE FROM task_sprite WHERE task_sprite.task_id = @task_id),
+  metadata AS (DELETE FROM task_metadata WHERE task_metadata.task_id = @task_id),
+  scenes AS (DELETE FROM task_scene WHERE task_scene.task_id = @task_id),
+  fingerprints AS (DELETE FROM task_audio_fingerprint WHERE task_audio_fingerprint.task_id = @task_id),
+  events AS (DELETE FROM task_event WHERE task_event.task_id = @task_id),
+  timings AS (DELETE FROM task_stage_timing WHERE task_stage_timing.task_id = @task_id),
+  batch_rows AS (UPDATE batch_row SET task_id = NULL WHERE batch_row.task_id = @task_id),
+  children AS (UPDATE task SET parent_task_id = NULL WHERE parent_task_id = @task_id)
+DELETE FROM task
+WHERE task.task_id = @task_id
+  AND task.version = @version
+  AND deleted_at IS NOT NULL;
+
+-- name: HasTaskForVideoName :one
+SELECT EXISTS (
+  SELECT 1 FROM task
+  WHERE video_name = $1
+);
+
+-- name: GetTasksByParent :many
+SELECT * FROM task
+WHERE parent_task_id = $1
+ORDER BY task_id ASC;
+
+-- name: ReserveTaskID :one
+SELECT nextval(pg_get_serial_sequence('task', 'task_id'))::bigint AS task_id;
 
 -- name: CreateTask :one
 INSERT INTO task (
-  video_file, audio_file, preview_id, status, video_name
+  task_id, video_file, audio_file, preview_id, status, video_name, index_version, parent_task_id,
+  source_ip, user_agent, api_key_id, download_verification, trace_id, deadline_at, stage, priority,
+  duration_seconds, width, height, file_size, content_hash
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
 )
+ON CONFLICT (task_id) DO UPDATE SET
+  video_file = EXCLUDED.video_file,
+  audio_file = EXCLUDED.audio_file,
+  preview_id = EXCLUDED.preview_id,
+  status = EXCLUDED.status,
+  video_name = EXCLUDED.video_name,
+  index_version = EXCLUDED.index_version,
+  parent_task_id = EXCLUDED.parent_task_id,
+  source_ip = EXCLUDED.source_ip,
+  user_agent = EXCLUDED.user_agent,
+  api_key_id = EXCLUDED.api_key_id,
+  download_verification = EXCLUDED.download_verification,
+  trace_id = EXCLUDED.trace_id,
+  deadline_at = EXCLUDED.deadline_at,
+  stage = EXCLUDED.stage,
+  priority = EXCLUDED.priority,
+  duration_seconds = EXCLUDED.duration_seconds,
+  width = EXCLUDED.width,
+  height = EXCLUDED.height,
+  file_size = EXCLUDED.file_size,
+  content_hash = EXCLUDED.content_hash,
+  version = task.version + 1,
+  updated_at = now()
+WHERE task.status = 'in_progress'
 RETURNING *;
 
--- name: UpdateTaskAudioCopyright :exec
-UPDATE task SET audio_copyright = $2
-WHERE task_id = $1;
+-- name: StartTask :exec
+INSERT INTO task (
+  task_id, video_name, status, stage, source_ip, user_agent, api_key_id, deadline_at, priority
+) VALUES (
+  $1, $2, 'in_progress', 'uploading', $3, $4, $5, $6, $7
+);
 
--- name: UpdateTaskVideoCopyright :exec
-UPDATE task SET video_copyright = $2
-WHERE task_id = $1;
+-- name: SetTaskStage :exec
+UPDATE task SET
+  stage = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress';
+
+-- name: GetApiKeyUsage :one
+SELECT * FROM api_key_usage
+WHERE api_key_id = $1;
+
+-- name: ReserveApiKeyTask :one
+INSERT INTO api_key_usage (
+  api_key_id, tasks
+) VALUES (
+  @api_key_id, 1
+)
+ON CONFLICT (api_key_id) DO UPDATE SET
+  tasks = api_key_usage.tasks + 1,
+  updated_at = now()
+WHERE (@max_tasks::bigint = 0 OR api_key_usage.tasks < @max_tasks::bigint)
+  AND (@max_video_seconds::double precision = 0 OR api_key_usage.video_seconds < @max_video_seconds::double precision)
+  AND (@max_bytes::bigint = 0 OR api_key_usage.bytes_stored < @max_bytes::bigint)
+RETURNING tasks;
+
+-- name: ReleaseApiKeyTask :exec
+UPDATE api_key_usage SET
+  tasks = tasks - 1,
+  updated_at = now()
+WHERE api_key_id = $1
+  AND tasks > 0;
+
+-- name: AddApiKeyUsage :exec
+INSERT INTO api_key_usage (
+  api_key_id, tasks, video_seconds, bytes_stored
+) VALUES (
+  $1, $2, $3, $4
+)
+ON CONFLICT (api_key_id) DO UPDATE SET
+  tasks = api_key_usage.tasks + EXCLUDED.tasks,
+  video_seconds = api_key_usage.video_seconds + EXCLUDED.video_seconds,
+  bytes_stored = api_key_usage.bytes_stored + EXCLUDED.bytes_stored,
+  updated_at = now();
+
+-- name: AddTaskResourceUsage :exec
+INSERT INTO task_resource_usage (
+  task_id, bytes_downloaded, bytes_stored, ffmpeg_cpu_seconds, ml_requests
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (task_id) DO UPDATE SET
+  bytes_downloaded = task_resource_usage.bytes_downloaded + EXCLUDED.bytes_downloaded,
+  bytes_stored = task_resource_usage.bytes_stored + EXCLUDED.bytes_stored,
+  ffmpeg_cpu_seconds = task_resource_usage.ffmpeg_cpu_seconds + EXCLUDED.ffmpeg_cpu_seconds,
+  ml_requests = task_resource_usage.ml_requests + EXCLUDED.ml_requests;
+
+-- name: UpdateTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3;
+
+-- name: UpdateTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3;
+
+-- name: ApplyTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = $2,
+  stage = 'audio_checked',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND status = 'in_progress'
+  AND audio_copyright IS NULL;
+
+-- name: ApplyTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = $2,
+  stage = 'video_checked',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND status = 'in_progress'
+  AND video_copyright IS NULL;
+
+-- name: ClearTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = NULL,
+  status = 'in_progress',
+  stage = CASE WHEN video_copyright IS NULL THEN 'queued' ELSE 'video_checked' END::task_stage,
+  failure_reason = NULL,
+  deadline_at = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND (status <> 'in_progress' OR audio_copyright IS NOT NULL);
+
+-- name: ClearTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = NULL,
+  status = 'in_progress',
+  stage = CASE WHEN audio_copyright IS NULL THEN 'queued' ELSE 'audio_checked' END::task_stage,
+  failure_reason = NULL,
+  deadline_at = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND (status <> 'in_progress' OR video_copyright IS NOT NULL);
 
 -- name: UpdateTaskStatus :exec
-UPDATE task SET status = $2
+UPDATE task SET
+  status = $2,
+  version = version + 1,
+  updated_at = now()
 WHERE task_id = $1;
 
+-- name: MarkTaskDone :execrows
+UPDATE task SET
+  status = 'done',
+  stage = 'done',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress'
+  AND audio_copyright IS NOT NULL
+  AND video_copyright IS NOT NULL;
+
+-- name: MarkMessageProcessed :execrows
+INSERT INTO kafka_processed_message (
+  topic, msg_partition, msg_offset
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: PruneProcessedMessages :execrows
+DELETE FROM kafka_processed_message
+WHERE (topic, msg_partition, msg_offset) IN (
+  SELECT p.topic, p.msg_partition, p.msg_offset FROM kafka_processed_message p
+  WHERE p.processed_at < @processed_before
+  ORDER BY p.processed_at ASC
+  LIMIT @max_rows
+);
+
+-- name: InsertDeadLetter :exec
+INSERT INTO kafka_dead_letter (
+  topic, msg_partition, msg_offset, msg_key, msg_value, headers, error, attempts
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (topic, msg_partition, msg_offset) DO UPDATE
+SET msg_key = EXCLUDED.msg_key,
+    msg_value = EXCLUDED.msg_value,
+    headers = EXCLUDED.headers,
+    error = EXCLUDED.error,
+    attempts = EXCLUDED.attempts,
+    dead_lettered_at = now(),
+    replayed_at = NULL;
+
+-- name: ListDeadLetters :many
+SELECT * FROM kafka_dead_letter
+WHERE id > @id
+  AND (sqlc.narg(topic)::text IS NULL OR topic = sqlc.narg(topic))
+  AND (NOT @pending::boolean OR replayed_at IS NULL)
+ORDER BY id ASC
+LIMIT @max_rows;
+
+-- name: GetDeadLetter :one
+SELECT * FROM kafka_dead_letter
+WHERE id = $1;
+
+-- name: MarkDeadLetterReplayed :exec
+UPDATE kafka_dead_letter SET replayed_at = now()
+WHERE id = $1;
+
+-- name: MarkResultPushed :execrows
+INSERT INTO pushed_result (
+  modality, result_id
+) VALUES (
+  $1, $2
+)
+ON CONFLICT DO NOTHING;
+
+-- name: PrunePushedResults :execrows
+DELETE FROM pushed_result
+WHERE (modality, result_id) IN (
+  SELECT p.modality, p.result_id FROM pushed_result p
+  WHERE p.pushed_at < @pushed_before
+  ORDER BY p.pushed_at ASC
+  LIMIT @max_rows
+);
 
 -- name: GetOrigVideo :one
 SELECT * FROM origvideo
@@ -40,15 +386,551 @@ SELECT * FROM origvideo
 ORDER BY video_id DESC
 LIMIT $1 OFFSET $2;
 
+-- name: SearchOrigVideos :many
+SELECT * FROM origvideo
+WHERE id > @id
+  AND (sqlc.narg(name)::text IS NULL OR video_id ILIKE '%' || sqlc.narg(name) || '%')
+  AND (sqlc.narg(video_hash)::text IS NULL OR video_hash = sqlc.narg(video_hash))
+  AND (sqlc.narg(registered_after)::timestamptz IS NULL OR registered_at >= sqlc.narg(registered_after))
+  AND (sqlc.narg(registered_before)::timestamptz IS NULL OR registered_at < sqlc.narg(registered_before))
+ORDER BY id ASC
+LIMIT @max_rows;
+
 -- name: GetOrigVideosByHash :many
 SELECT * FROM origvideo
 WHERE video_hash = $1
 ORDER BY video_id DESC;
 
+-- name: GetOrigVideosWithAudioFingerprint :many
+SELECT * FROM origvideo
+WHERE audio_fingerprint IS NOT NULL
+ORDER BY video_id DESC;
+
+-- name: GetOrigVideosWithPerceptualHash :many
+SELECT * FROM origvideo
+WHERE perceptual_hash IS NOT NULL
+ORDER BY video_id DESC;
+
+-- name: HasOrigVideoCandidates :one
+SELECT EXISTS (
+  SELECT 1 FROM origvideo
+  WHERE sample_hash = $1 OR sample_hash IS NULL
+);
+
 -- name: CreateOrigVideo :one
 INSERT INTO origvideo (
-  video_id, video_hash
+  video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint
 ) VALUES (
-  $1, $2
+  $1, $2, $3, $4, $5
+)
+RETURNING *;
+
+-- name: DeleteOrigVideo :one
+DELETE FROM origvideo
+WHERE id = $1
+RETURNING *;
+
+-- name: GetActiveIndexVersion :one
+SELECT version FROM reference_index
+WHERE active LIMIT 1;
+
+-- name: ListIndexVersions :many
+SELECT * FROM reference_index
+ORDER BY created_at ASC;
+
+-- name: DeactivateIndexVersions :exec
+UPDATE reference_index SET active = false
+WHERE active;
+
+-- name: ActivateIndexVersion :one
+INSERT INTO reference_index (
+  version, active
+) VALUES (
+  $1, true
 )
+ON CONFLICT (version) DO UPDATE SET active = true
 RETURNING *;
+
+-- name: UpsertReferenceRegistration :exec
+INSERT INTO reference_registration (
+  task_id, modality, status, attempts, last_error
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  status = EXCLUDED.status,
+  attempts = reference_registration.attempts + EXCLUDED.attempts,
+  last_error = EXCLUDED.last_error,
+  updated_at = now();
+
+-- name: GetReferenceRegistrations :many
+SELECT * FROM reference_registration
+WHERE task_id = $1
+ORDER BY modality ASC;
+
+-- name: GetReferencesDueForCheck :many
+SELECT DISTINCT r.task_id FROM reference_registration r
+LEFT JOIN reference_check c ON c.task_id = r.task_id
+WHERE r.status = 'registered'
+  AND (c.checked_at IS NULL OR c.checked_at < @checked_before)
+ORDER BY r.task_id ASC
+LIMIT @max_rows;
+
+-- name: UpsertReferenceCheck :exec
+INSERT INTO reference_check (
+  task_id, check_task_id
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id) DO UPDATE SET
+  check_task_id = EXCLUDED.check_task_id,
+  checked_at = now();
+
+-- name: GetReferenceChecks :many
+SELECT c.task_id, r.video_name, c.check_task_id, c.checked_at, t.status, t.audio_copyright, t.video_copyright
+FROM reference_check c
+JOIN task r ON r.task_id = c.task_id
+JOIN task t ON t.task_id = c.check_task_id
+ORDER BY c.task_id ASC;
+
+-- name: GetLatestReferenceTaskID :one
+SELECT COALESCE(max(task_id), 0)::bigint AS task_id FROM reference_registration
+WHERE status = 'registered';
+
+-- name: GetTasksDueForRecheck :many
+SELECT DISTINCT r.task_id FROM reference_registration r
+WHERE r.status = 'registered'
+  AND NOT EXISTS (
+    SELECT 1 FROM task_recheck c
+    WHERE c.task_id = r.task_id AND c.created_at >= @checked_after
+  )
+  AND @references_through::bigint > COALESCE(
+    (SELECT max(c.references_through) FROM task_recheck c WHERE c.task_id = r.task_id),
+    r.task_id
+  )
+ORDER BY r.task_id ASC
+LIMIT @max_rows;
+
+-- name: InsertTaskRecheck :exec
+INSERT INTO task_recheck (
+  task_id, recheck_task_id, references_through
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: GetTaskRechecks :many
+SELECT c.recheck_task_id, c.references_through, c.created_at, t.status, t.audio_copyright, t.video_copyright
+FROM task_recheck c
+JOIN task t ON t.task_id = c.recheck_task_id
+WHERE c.task_id = $1
+ORDER BY c.id ASC;
+
+-- name: SetAuditContext :exec
+SELECT set_config('bff.actor', @actor::text, true), set_config('bff.reason', @reason::text, true);
+
+-- name: InsertAuditEvent :exec
+INSERT INTO audit_log (
+  actor, action, task_id, details
+) VALUES (
+  $1, $2, $3, $4
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_log
+WHERE id > @id
+  AND (sqlc.narg(actor)::text IS NULL OR actor = sqlc.narg(actor))
+  AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+  AND (sqlc.narg(task_id)::bigint IS NULL OR task_id = sqlc.narg(task_id))
+ORDER BY id ASC
+LIMIT @max_rows;
+
+-- name: InsertVerdictAuditEvent :exec
+WITH event AS (
+  INSERT INTO audit_log (
+    actor, action, task_id, details
+  ) VALUES (
+    $1, $2, $3, $4
+  )
+  RETURNING id
+)
+INSERT INTO verdict_event (audit_id)
+SELECT id FROM event;
+
+-- name: LockVerdictStream :one
+SELECT last_seq FROM verdict_stream
+FOR UPDATE SKIP LOCKED;
+
+-- name: ClaimVerdictEvents :many
+SELECT e.id, a.id AS audit_id, a.occurred_at, a.actor, a.action, a.task_id, a.details
+FROM verdict_event e
+JOIN audit_log a ON a.id = e.audit_id
+WHERE e.seq IS NULL
+ORDER BY e.id ASC
+LIMIT $1;
+
+-- name: MarkVerdictEventPublished :exec
+UPDATE verdict_event SET
+  seq = $2,
+  published_at = now()
+WHERE id = $1;
+
+-- name: AdvanceVerdictStream :exec
+UPDATE verdict_stream SET last_seq = $1;
+
+-- name: CreateBatch :one
+INSERT INTO batch (
+  total_rows, source_ip, user_agent, api_key_id, account
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+RETURNING batch_id;
+
+-- name: FinishBatch :execrows
+UPDATE batch SET
+  finished_at = now(),
+  rows_processed = $2,
+  duplicates = $3,
+  failures = $4,
+  avg_latency_ms = $5,
+  score_histogram = $6,
+  result_csv = $7
+WHERE batch_id = $1
+  AND finished_at IS NULL;
+
+-- name: GetBatch :one
+SELECT * FROM batch
+WHERE batch_id = $1;
+
+-- name: InsertBatchRow :exec
+INSERT INTO batch_row (
+  batch_id, row_no, created, uuid, link
+) VALUES (
+  $1, $2, $3, $4, $5
+);
+
+-- name: ClaimBatchRow :one
+UPDATE batch_row r SET claimed_at = now()
+FROM batch b
+WHERE b.batch_id = r.batch_id
+  AND (r.batch_id, r.row_no) = (
+    SELECT p.batch_id, p.row_no FROM batch_row p
+    WHERE p.processed_at IS NULL
+      AND (p.claimed_at IS NULL OR p.claimed_at < @claimed_before)
+    ORDER BY p.batch_id ASC, p.row_no ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING r.batch_id, r.row_no, r.created, r.uuid, r.link, r.task_id, b.source_ip, b.user_agent, b.api_key_id, b.account;
+
+-- name: SetBatchRowTask :exec
+UPDATE batch_row SET task_id = $3
+WHERE batch_id = $1
+  AND row_no = $2;
+
+-- name: CompleteBatchRow :exec
+UPDATE batch_row SET
+  processed_at = now(),
+  failed = $3,
+  is_duplicate = $4,
+  duplicate_for = $5,
+  score = $6,
+  latency_ms = $7
+WHERE batch_id = $1
+  AND row_no = $2
+  AND processed_at IS NULL;
+
+-- name: GetBatchRows :many
+SELECT * FROM batch_row
+WHERE batch_id = $1
+ORDER BY row_no ASC;
+
+-- name: GetBatchProgress :one
+SELECT
+  count(*) FILTER (WHERE processed_at IS NOT NULL) AS processed,
+  count(*) FILTER (WHERE is_duplicate) AS duplicates,
+  count(*) FILTER (WHERE failed) AS failures
+FROM batch_row
+WHERE batch_id = $1;
+
+-- name: GetBatchResourceUsage :one
+SELECT
+  COALESCE(sum(u.bytes_downloaded), 0)::bigint AS bytes_downloaded,
+  COALESCE(sum(u.bytes_stored), 0)::bigint AS bytes_stored,
+  COALESCE(sum(u.ffmpeg_cpu_seconds), 0)::double precision AS ffmpeg_cpu_seconds,
+  COALESCE(sum(u.ml_requests), 0)::bigint AS ml_requests
+FROM batch_row r
+JOIN task_resource_usage u ON u.task_id = r.task_id
+WHERE r.batch_id = $1;
+
+-- name: CreateBatchToken :exec
+INSERT INTO batch_token (
+  token_hash, batch_id, expires_at
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: HasValidBatchToken :one
+SELECT EXISTS (
+  SELECT 1 FROM batch_token
+  WHERE token_hash = $1
+    AND batch_id = $2
+    AND revoked_at IS NULL
+    AND expires_at > now()
+);
+
+-- name: RevokeBatchTokens :execrows
+UPDATE batch_token SET revoked_at = now()
+WHERE batch_id = $1
+  AND revoked_at IS NULL;
+
+-- name: EnqueueOutbox :exec
+INSERT INTO outbox (
+  task_id, modality, priority
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: ClaimOutbox :many
+SELECT * FROM outbox
+WHERE sent_at IS NULL
+  AND parked_at IS NULL
+  AND (retry_at IS NULL OR retry_at <= now())
+ORDER BY priority ASC, id ASC
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxSent :exec
+UPDATE outbox SET sent_at = now()
+WHERE id = $1;
+
+-- name: RecordOutboxFailure :exec
+UPDATE outbox SET
+  attempts = attempts + 1,
+  last_error = @last_error,
+  retry_at = @retry_at,
+  parked_at = CASE WHEN @park::boolean THEN now() END
+WHERE id = @id;
+
+-- name: PruneSentOutbox :execrows
+DELETE FROM outbox
+WHERE id IN (
+  SELECT id FROM outbox
+  WHERE sent_at < @sent_before
+  ORDER BY sent_at ASC
+  LIMIT @max_rows
+);
+
+-- name: InsertTaskHash :exec
+INSERT INTO task_hash (
+  task_id, algorithm, digest
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (task_id, algorithm) DO UPDATE SET digest = EXCLUDED.digest;
+
+-- name: GetTaskHashes :many
+SELECT * FROM task_hash
+WHERE task_id = $1
+ORDER BY algorithm ASC;
+
+-- name: InsertTaskSprite :exec
+INSERT INTO task_sprite (
+  task_id, object_key, grid_columns, grid_rows, frame_width, frame_height, timestamps
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+)
+ON CONFLICT (task_id) DO NOTHING;
+
+-- name: GetTaskSprite :one
+SELECT * FROM task_sprite
+WHERE task_id = $1;
+
+-- name: InsertTaskMetadata :exec
+INSERT INTO task_metadata (
+  task_id, format, video_codec, audio_codec, width, height, frame_rate, bit_rate, duration_seconds
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9
+)
+ON CONFLICT (task_id) DO NOTHING;
+
+-- name: GetTaskMetadata :one
+SELECT * FROM task_metadata
+WHERE task_id = $1;
+
+-- name: InsertTaskAudioFingerprint :exec
+INSERT INTO task_audio_fingerprint (
+  task_id, fingerprint
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id) DO NOTHING;
+
+-- name: GetTaskAudioFingerprint :one
+SELECT fingerprint FROM task_audio_fingerprint
+WHERE task_id = $1;
+
+-- name: InsertTaskScene :exec
+INSERT INTO task_scene (
+  task_id, scene, start_seconds, end_seconds, keyframe_seconds, keyframe_key
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (task_id, scene) DO NOTHING;
+
+-- name: GetTaskScenes :many
+SELECT * FROM task_scene
+WHERE task_id = $1
+ORDER BY scene ASC;
+
+-- name: GetTaskScene :one
+SELECT * FROM task_scene
+WHERE task_id = $1 AND scene = $2;
+
+-- name: GetTasksByHash :many
+SELECT * FROM task
+WHERE task_id IN (
+  SELECT task_id FROM task_hash
+  WHERE algorithm = $1 AND digest = $2
+)
+ORDER BY task_id ASC
+LIMIT $3;
+
+-- name: RecordModalityRequest :exec
+INSERT INTO modality_request (
+  task_id, modality
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  attempts = modality_request.attempts + 1,
+  sent_at = now();
+
+-- name: ClaimModalityRetry :execrows
+UPDATE modality_request SET
+  attempts = attempts + 1,
+  sent_at = now()
+WHERE task_id = $1
+  AND modality = $2
+  AND attempts = $3
+  AND received_at IS NULL;
+
+-- name: ResetModalityRequest :exec
+INSERT INTO modality_request (
+  task_id, modality, attempts
+) VALUES (
+  $1, $2, 0
+)
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  attempts = 0,
+  requested_at = now(),
+  sent_at = now(),
+  received_at = NULL;
+
+-- name: MarkModalityReceived :one
+UPDATE modality_request SET received_at = now()
+WHERE task_id = $1
+  AND modality = $2
+  AND received_at IS NULL
+RETURNING requested_at;
+
+-- name: GetModalityRequests :many
+SELECT * FROM modality_request
+WHERE task_id = $1
+ORDER BY modality ASC;
+
+-- name: GetOverdueModalityRequests :many
+SELECT r.* FROM modality_request r
+JOIN task t ON t.task_id = r.task_id
+WHERE t.status = 'in_progress'
+  AND r.received_at IS NULL
+  AND r.sent_at < CASE r.modality WHEN 'audio' THEN @audio_sent_before::timestamptz ELSE @video_sent_before::timestamptz END
+ORDER BY r.task_id ASC
+LIMIT @max_rows;
+
+-- name: MarkTaskFailed :execrows
+UPDATE task SET
+  status = 'fail',
+  failure_reason = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress';
+
+-- name: FailStuckTasks :many
+UPDATE task SET
+  status = 'fail',
+  failure_reason = @failure_reason,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id IN (
+  SELECT task_id FROM task
+  WHERE status = 'in_progress'
+    AND deadline_at < now()
+  ORDER BY task_id ASC
+  LIMIT @max_rows
+)
+  AND status = 'in_progress'
+RETURNING task_id, deadline_at;
+
+-- name: AcquireObjectRef :exec
+INSERT INTO object_ref (
+  bucket, object_key, refs
+) VALUES (
+  $1, $2, 1
+)
+ON CONFLICT (bucket, object_key) DO UPDATE SET refs = object_ref.refs + 1;
+
+-- name: ReleaseObjectRef :one
+UPDATE object_ref SET refs = refs - 1
+WHERE bucket = $1 AND object_key = $2 AND refs > 0
+RETURNING refs;
+
+-- name: DeleteObjectRef :exec
+DELETE FROM object_ref
+WHERE bucket = $1 AND object_key = $2 AND refs = 0;
+
+-- name: ShareObjectRef :exec
+UPDATE object_ref SET refs = refs + 1
+WHERE bucket = $1 AND object_key = $2 AND refs > 0;
+
+-- name: GetTaskEvents :many
+SELECT * FROM task_event
+WHERE task_id = $1
+ORDER BY id ASC;
+
+-- name: StartTaskStage :exec
+INSERT INTO task_stage_timing (
+  task_id, stage
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id, stage) DO UPDATE SET
+  started_at = now(),
+  finished_at = NULL;
+
+-- name: FinishTaskStage :exec
+UPDATE task_stage_timing SET
+  finished_at = now()
+WHERE task_id = $1
+  AND stage = $2
+  AND finished_at IS NULL;
+
+-- name: GetTaskStageTimings :many
+SELECT * FROM task_stage_timing
+WHERE task_id = $1
+ORDER BY started_at ASC, stage ASC;
+
+-- name: GetStageTimingPercentiles :many
+SELECT
+  stage,
+  count(*) AS tasks,
+  percentile_cont(0.5) WITHIN GROUP (ORDER BY seconds)::float8 AS p50,
+  percentile_cont(0.9) WITHIN GROUP (ORDER BY seconds)::float8 AS p90,
+  percentile_cont(0.99) WITHIN GROUP (ORDER BY seconds)::float8 AS p99
+FROM (
+  SELECT stage, extract(epoch FROM finished_at - started_at)::float8 AS seconds
+  FROM task_stage_timing
+  WHERE finished_at >= @finished_after
+) AS timing
+GROUP BY stage
+ORDER BY stage;
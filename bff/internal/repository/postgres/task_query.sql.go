@@ -13,115 +13,3149 @@ import (
 
 const createOrigVideo = `-- name: CreateOrigVideo :one
 INSERT INTO origvideo (
-  video_id, video_hash
+  video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+RETURNING video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at
+`
+
+type CreateOrigVideoParams struct {
+	VideoID          pgtype.Text
+	VideoHash        pgtype.Text
+	SampleHash       pgtype.Text
+	PerceptualHash   pgtype.Text
+	AudioFingerprint []byte
+}
+
+func (q *Queries) CreateOrigVideo(ctx context.Context, arg CreateOrigVideoParams) (Origvideo, error) {
+	row := q.db.QueryRow(ctx, createOrigVideo,
+		arg.VideoID,
+		arg.VideoHash,
+		arg.SampleHash,
+		arg.PerceptualHash,
+		arg.AudioFingerprint,
+	)
+	var i Origvideo
+	err := row.Scan(
+		&i.VideoID,
+		&i.VideoHash,
+		&i.SampleHash,
+		&i.PerceptualHash,
+		&i.AudioFingerprint,
+		&i.ID,
+		&i.RegisteredAt,
+	)
+	return i, err
+}
+
+const acquireObjectRef = `-- name: AcquireObjectRef :exec
+INSERT INTO object_ref (
+  bucket, object_key, refs
+) VALUES (
+  $1, $2, 1
+)
+ON CONFLICT (bucket, object_key) DO UPDATE SET refs = object_ref.refs + 1
+`
+
+type AcquireObjectRefParams struct {
+	Bucket    string
+	ObjectKey string
+}
+
+func (q *Queries) AcquireObjectRef(ctx context.Context, arg AcquireObjectRefParams) error {
+	_, err := q.db.Exec(ctx, acquireObjectRef, arg.Bucket, arg.ObjectKey)
+	return err
+}
+
+const activateIndexVersion = `-- name: ActivateIndexVersion :one
+INSERT INTO reference_index (
+  version, active
+) VALUES (
+  $1, true
+)
+ON CONFLICT (version) DO UPDATE SET active = true
+RETURNING version, active, created_at
+`
+
+func (q *Queries) ActivateIndexVersion(ctx context.Context, version string) (ReferenceIndex, error) {
+	row := q.db.QueryRow(ctx, activateIndexVersion, version)
+	var i ReferenceIndex
+	err := row.Scan(&i.Version, &i.Active, &i.CreatedAt)
+	return i, err
+}
+
+const addApiKeyUsage = `-- name: AddApiKeyUsage :exec
+INSERT INTO api_key_usage (
+  api_key_id, tasks, video_seconds, bytes_stored
+) VALUES (
+  $1, $2, $3, $4
+)
+ON CONFLICT (api_key_id) DO UPDATE SET
+  tasks = api_key_usage.tasks + EXCLUDED.tasks,
+  video_seconds = api_key_usage.video_seconds + EXCLUDED.video_seconds,
+  bytes_stored = api_key_usage.bytes_stored + EXCLUDED.bytes_stored,
+  updated_at = now()
+`
+
+type AddApiKeyUsageParams struct {
+	ApiKeyID     string
+	Tasks        int64
+	VideoSeconds float64
+	BytesStored  int64
+}
+
+func (q *Queries) AddApiKeyUsage(ctx context.Context, arg AddApiKeyUsageParams) error {
+	_, err := q.db.Exec(ctx, addApiKeyUsage,
+		arg.ApiKeyID,
+		arg.Tasks,
+		arg.VideoSeconds,
+		arg.BytesStored,
+	)
+	return err
+}
+
+const addTaskResourceUsage = `-- name: AddTaskResourceUsage :exec
+INSERT INTO task_resource_usage (
+  task_id, bytes_downloaded, bytes_stored, ffmpeg_cpu_seconds, ml_requests
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (task_id) DO UPDATE SET
+  bytes_downloaded = task_resource_usage.bytes_downloaded + EXCLUDED.bytes_downloaded,
+  bytes_stored = task_resource_usage.bytes_stored + EXCLUDED.bytes_stored,
+  ffmpeg_cpu_seconds = task_resource_usage.ffmpeg_cpu_seconds + EXCLUDED.ffmpeg_cpu_seconds,
+  ml_requests = task_resource_usage.ml_requests + EXCLUDED.ml_requests
+`
+
+type AddTaskResourceUsageParams struct {
+	TaskID           int64
+	BytesDownloaded  int64
+	BytesStored      int64
+	FfmpegCpuSeconds float64
+	MlRequests       int32
+}
+
+func (q *Queries) AddTaskResourceUsage(ctx context.Context, arg AddTaskResourceUsageParams) error {
+	_, err := q.db.Exec(ctx, addTaskResourceUsage,
+		arg.TaskID,
+		arg.BytesDownloaded,
+		arg.BytesStored,
+		arg.FfmpegCpuSeconds,
+		arg.MlRequests,
+	)
+	return err
+}
+
+const advanceVerdictStream = `-- name: AdvanceVerdictStream :exec
+UPDATE verdict_stream SET last_seq = $1
+`
+
+func (q *Queries) AdvanceVerdictStream(ctx context.Context, lastSeq int64) error {
+	_, err := q.db.Exec(ctx, advanceVerdictStream, lastSeq)
+	return err
+}
+
+const applyTaskAudioCopyright = `-- name: ApplyTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = $2,
+  stage = 'audio_checked',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND status = 'in_progress'
+  AND audio_copyright IS NULL
+`
+
+type ApplyTaskAudioCopyrightParams struct {
+	TaskID         int64
+	AudioCopyright []byte
+	Version        int64
+}
+
+func (q *Queries) ApplyTaskAudioCopyright(ctx context.Context, arg ApplyTaskAudioCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, applyTaskAudioCopyright, arg.TaskID, arg.AudioCopyright, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const applyTaskVideoCopyright = `-- name: ApplyTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = $2,
+  stage = 'video_checked',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND status = 'in_progress'
+  AND video_copyright IS NULL
+`
+
+type ApplyTaskVideoCopyrightParams struct {
+	TaskID         int64
+	VideoCopyright []byte
+	Version        int64
+}
+
+func (q *Queries) ApplyTaskVideoCopyright(ctx context.Context, arg ApplyTaskVideoCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, applyTaskVideoCopyright, arg.TaskID, arg.VideoCopyright, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimBatchRow = `-- name: ClaimBatchRow :one
+UPDATE batch_row r SET claimed_at = now()
+FROM batch b
+WHERE b.batch_id = r.batch_id
+  AND (r.batch_id, r.row_no) = (
+    SELECT p.batch_id, p.row_no FROM batch_row p
+    WHERE p.processed_at IS NULL
+      AND (p.claimed_at IS NULL OR p.claimed_at < $1)
+    ORDER BY p.batch_id ASC, p.row_no ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING r.batch_id, r.row_no, r.created, r.uuid, r.link, r.task_id, b.source_ip, b.user_agent, b.api_key_id, b.account
+`
+
+type ClaimBatchRowRow struct {
+	BatchID   int64
+	RowNo     int32
+	Created   pgtype.Timestamptz
+	Uuid      string
+	Link      string
+	TaskID    pgtype.Int8
+	SourceIp  pgtype.Text
+	UserAgent pgtype.Text
+	ApiKeyID  pgtype.Text
+	Account   pgtype.Text
+}
+
+func (q *Queries) ClaimBatchRow(ctx context.Context, claimedBefore pgtype.Timestamptz) (ClaimBatchRowRow, error) {
+	row := q.db.QueryRow(ctx, claimBatchRow, claimedBefore)
+	var i ClaimBatchRowRow
+	err := row.Scan(
+		&i.BatchID,
+		&i.RowNo,
+		&i.Created,
+		&i.Uuid,
+		&i.Link,
+		&i.TaskID,
+		&i.SourceIp,
+		&i.UserAgent,
+		&i.ApiKeyID,
+		&i.Account,
+	)
+	return i, err
+}
+
+const claimModalityRetry = `-- name: ClaimModalityRetry :execrows
+UPDATE modality_request SET
+  attempts = attempts + 1,
+  sent_at = now()
+WHERE task_id = $1
+  AND modality = $2
+  AND attempts = $3
+  AND received_at IS NULL
+`
+
+type ClaimModalityRetryParams struct {
+	TaskID   int64
+	Modality string
+	Attempts int32
+}
+
+func (q *Queries) ClaimModalityRetry(ctx context.Context, arg ClaimModalityRetryParams) (int64, error) {
+	result, err := q.db.Exec(ctx, claimModalityRetry, arg.TaskID, arg.Modality, arg.Attempts)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimOutbox = `-- name: ClaimOutbox :many
+SELECT id, task_id, modality, created_at, sent_at, priority, attempts, last_error, retry_at, parked_at FROM outbox
+WHERE sent_at IS NULL
+  AND parked_at IS NULL
+  AND (retry_at IS NULL OR retry_at <= now())
+ORDER BY priority ASC, id ASC
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) ClaimOutbox(ctx context.Context, limit int32) ([]Outbox, error) {
+	rows, err := q.db.Query(ctx, claimOutbox, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Outbox
+	for rows.Next() {
+		var i Outbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Modality,
+			&i.CreatedAt,
+			&i.SentAt,
+			&i.Priority,
+			&i.Attempts,
+			&i.LastError,
+			&i.RetryAt,
+			&i.ParkedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimVerdictEvents = `-- name: ClaimVerdictEvents :many
+SELECT e.id, a.id AS audit_id, a.occurred_at, a.actor, a.action, a.task_id, a.details
+FROM verdict_event e
+JOIN audit_log a ON a.id = e.audit_id
+WHERE e.seq IS NULL
+ORDER BY e.id ASC
+LIMIT $1
+`
+
+type ClaimVerdictEventsRow struct {
+	ID         int64
+	AuditID    int64
+	OccurredAt pgtype.Timestamptz
+	Actor      string
+	Action     string
+	TaskID     pgtype.Int8
+	Details    []byte
+}
+
+func (q *Queries) ClaimVerdictEvents(ctx context.Context, limit int32) ([]ClaimVerdictEventsRow, error) {
+	rows, err := q.db.Query(ctx, claimVerdictEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimVerdictEventsRow
+	for rows.Next() {
+		var i ClaimVerdictEventsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AuditID,
+			&i.OccurredAt,
+			&i.Actor,
+			&i.Action,
+			&i.TaskID,
+			&i.Details,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const clearTaskAudioCopyright = `-- name: ClearTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = NULL,
+  status = 'in_progress',
+  stage = CASE WHEN video_copyright IS NULL THEN 'queued' ELSE 'video_checked' END::task_stage,
+  failure_reason = NULL,
+  deadline_at = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND (status <> 'in_progress' OR audio_copyright IS NOT NULL)
+`
+
+type ClearTaskAudioCopyrightParams struct {
+	TaskID     int64
+	DeadlineAt pgtype.Timestamptz
+	Version    int64
+}
+
+func (q *Queries) ClearTaskAudioCopyright(ctx context.Context, arg ClearTaskAudioCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, clearTaskAudioCopyright, arg.TaskID, arg.DeadlineAt, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const clearTaskVideoCopyright = `-- name: ClearTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = NULL,
+  status = 'in_progress',
+  stage = CASE WHEN audio_copyright IS NULL THEN 'queued' ELSE 'audio_checked' END::task_stage,
+  failure_reason = NULL,
+  deadline_at = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $3
+  AND (status <> 'in_progress' OR video_copyright IS NOT NULL)
+`
+
+type ClearTaskVideoCopyrightParams struct {
+	TaskID     int64
+	DeadlineAt pgtype.Timestamptz
+	Version    int64
+}
+
+func (q *Queries) ClearTaskVideoCopyright(ctx context.Context, arg ClearTaskVideoCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, clearTaskVideoCopyright, arg.TaskID, arg.DeadlineAt, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const completeBatchRow = `-- name: CompleteBatchRow :exec
+UPDATE batch_row SET
+  processed_at = now(),
+  failed = $3,
+  is_duplicate = $4,
+  duplicate_for = $5,
+  score = $6,
+  latency_ms = $7
+WHERE batch_id = $1
+  AND row_no = $2
+  AND processed_at IS NULL
+`
+
+type CompleteBatchRowParams struct {
+	BatchID      int64
+	RowNo        int32
+	Failed       bool
+	IsDuplicate  bool
+	DuplicateFor pgtype.Text
+	Score        float64
+	LatencyMs    float64
+}
+
+func (q *Queries) CompleteBatchRow(ctx context.Context, arg CompleteBatchRowParams) error {
+	_, err := q.db.Exec(ctx, completeBatchRow,
+		arg.BatchID,
+		arg.RowNo,
+		arg.Failed,
+		arg.IsDuplicate,
+		arg.DuplicateFor,
+		arg.Score,
+		arg.LatencyMs,
+	)
+	return err
+}
+
+const countTasksByStatus = `-- name: CountTasksByStatus :one
+SELECT count(*) FROM task
+WHERE status = $1::task_status
+  AND created_at < $2
+  AND deleted_at IS NULL
+`
+
+type CountTasksByStatusParams struct {
+	Status        TaskStatus
+	CreatedBefore pgtype.Timestamptz
+}
+
+func (q *Queries) CountTasksByStatus(ctx context.Context, arg CountTasksByStatusParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countTasksByStatus, arg.Status, arg.CreatedBefore)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createBatch = `-- name: CreateBatch :one
+INSERT INTO batch (
+  total_rows, source_ip, user_agent, api_key_id, account
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+RETURNING batch_id
+`
+
+type CreateBatchParams struct {
+	TotalRows int64
+	SourceIp  pgtype.Text
+	UserAgent pgtype.Text
+	ApiKeyID  pgtype.Text
+	Account   pgtype.Text
+}
+
+func (q *Queries) CreateBatch(ctx context.Context, arg CreateBatchParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createBatch,
+		arg.TotalRows,
+		arg.SourceIp,
+		arg.UserAgent,
+		arg.ApiKeyID,
+		arg.Account,
+	)
+	var batch_id int64
+	err := row.Scan(&batch_id)
+	return batch_id, err
+}
+
+const createBatchToken = `-- name: CreateBatchToken :exec
+INSERT INTO batch_token (
+  token_hash, batch_id, expires_at
+) VALUES (
+  $1, $2, $3
+)
+`
+
+type CreateBatchTokenParams struct {
+	TokenHash string
+	BatchID   int64
+	ExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) CreateBatchToken(ctx context.Context, arg CreateBatchTokenParams) error {
+	_, err := q.db.Exec(ctx, createBatchToken, arg.TokenHash, arg.BatchID, arg.ExpiresAt)
+	return err
+}
+
+const createTask = `-- name: CreateTask :one
+INSERT INTO task (
+  task_id, video_file, audio_file, preview_id, status, video_name, index_version, parent_task_id,
+  source_ip, user_agent, api_key_id, download_verification, trace_id, deadline_at, stage, priority,
+  duration_seconds, width, height, file_size, content_hash
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
+)
+ON CONFLICT (task_id) DO UPDATE SET
+  video_file = EXCLUDED.video_file,
+  audio_file = EXCLUDED.audio_file,
+  preview_id = EXCLUDED.preview_id,
+  status = EXCLUDED.status,
+  video_name = EXCLUDED.video_name,
+  index_version = EXCLUDED.index_version,
+  parent_task_id = EXCLUDED.parent_task_id,
+  source_ip = EXCLUDED.source_ip,
+  user_agent = EXCLUDED.user_agent,
+  api_key_id = EXCLUDED.api_key_id,
+  download_verification = EXCLUDED.download_verification,
+  trace_id = EXCLUDED.trace_id,
+  deadline_at = EXCLUDED.deadline_at,
+  stage = EXCLUDED.stage,
+  priority = EXCLUDED.priority,
+  duration_seconds = EXCLUDED.duration_seconds,
+  width = EXCLUDED.width,
+  height = EXCLUDED.height,
+  file_size = EXCLUDED.file_size,
+  content_hash = EXCLUDED.content_hash,
+  version = task.version + 1,
+  updated_at = now()
+WHERE task.status = 'in_progress'
+RETURNING task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash
+`
+
+type CreateTaskParams struct {
+	TaskID               int64
+	VideoFile            pgtype.Text
+	AudioFile            pgtype.Text
+	PreviewID            pgtype.Text
+	Status               NullTaskStatus
+	VideoName            pgtype.Text
+	IndexVersion         pgtype.Text
+	ParentTaskID         pgtype.Int8
+	SourceIp             pgtype.Text
+	UserAgent            pgtype.Text
+	ApiKeyID             pgtype.Text
+	DownloadVerification pgtype.Text
+	TraceID              pgtype.Text
+	DeadlineAt           pgtype.Timestamptz
+	Stage                TaskStage
+	Priority             TaskPriority
+	DurationSeconds      pgtype.Float8
+	Width                pgtype.Int4
+	Height               pgtype.Int4
+	FileSize             pgtype.Int8
+	ContentHash          pgtype.Text
+}
+
+func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (Task, error) {
+	row := q.db.QueryRow(ctx, createTask,
+		arg.TaskID,
+		arg.VideoFile,
+		arg.AudioFile,
+		arg.PreviewID,
+		arg.Status,
+		arg.VideoName,
+		arg.IndexVersion,
+		arg.ParentTaskID,
+		arg.SourceIp,
+		arg.UserAgent,
+		arg.ApiKeyID,
+		arg.DownloadVerification,
+		arg.TraceID,
+		arg.DeadlineAt,
+		arg.Stage,
+		arg.Priority,
+		arg.DurationSeconds,
+		arg.Width,
+		arg.Height,
+		arg.FileSize,
+		arg.ContentHash,
+	)
+	var i Task
+	err := row.Scan(
+		&i.TaskID,
+		&i.VideoName,
+		&i.AudioFile,
+		&i.VideoFile,
+		&i.PreviewID,
+		&i.Status,
+		&i.AudioCopyright,
+		&i.VideoCopyright,
+		&i.IndexVersion,
+		&i.ParentTaskID,
+		&i.SourceIp,
+		&i.UserAgent,
+		&i.ApiKeyID,
+		&i.DownloadVerification,
+		&i.TraceID,
+		&i.CreatedAt,
+		&i.FailureReason,
+		&i.UpdatedAt,
+		&i.DeadlineAt,
+		&i.Stage,
+		&i.Priority,
+		&i.DeletedAt,
+		&i.Version,
+		&i.DurationSeconds,
+		&i.Width,
+		&i.Height,
+		&i.FileSize,
+		&i.ContentHash,
+	)
+	return i, err
+}
+
+const deactivateIndexVersions = `-- name: DeactivateIndexVersions :exec
+UPDATE reference_index SET active = false
+WHERE active
+`
+
+func (q *Queries) DeactivateIndexVersions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deactivateIndexVersions)
+	return err
+}
+
+const deleteObjectRef = `-- name: DeleteObjectRef :exec
+DELETE FROM object_ref
+WHERE bucket = $1 AND object_key = $2 AND refs = 0
+`
+
+type DeleteObjectRefParams struct {
+	Bucket    string
+	ObjectKey string
+}
+
+func (q *Queries) DeleteObjectRef(ctx context.Context, arg DeleteObjectRefParams) error {
+	_, err := q.db.Exec(ctx, deleteObjectRef, arg.Bucket, arg.ObjectKey)
+	return err
+}
+
+const deleteOrigVideo = `-- name: DeleteOrigVideo :one
+DELETE FROM origvideo
+WHERE id = $1
+RETURNING video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at
+`
+
+func (q *Queries) DeleteOrigVideo(ctx context.Context, id int64) (Origvideo, error) {
+	row := q.db.QueryRow(ctx, deleteOrigVideo, id)
+	var i Origvideo
+	err := row.Scan(
+		&i.VideoID,
+		&i.VideoHash,
+		&i.SampleHash,
+		&i.PerceptualHash,
+		&i.AudioFingerprint,
+		&i.ID,
+		&i.RegisteredAt,
+	)
+	return i, err
+}
+
+const enqueueOutbox = `-- name: EnqueueOutbox :exec
+INSERT INTO outbox (
+  task_id, modality, priority
+) VALUES (
+  $1, $2, $3
+)
+`
+
+type EnqueueOutboxParams struct {
+	TaskID   int64
+	Modality string
+	Priority TaskPriority
+}
+
+func (q *Queries) EnqueueOutbox(ctx context.Context, arg EnqueueOutboxParams) error {
+	_, err := q.db.Exec(ctx, enqueueOutbox, arg.TaskID, arg.Modality, arg.Priority)
+	return err
+}
+
+const failStuckTasks = `-- name: FailStuckTasks :many
+UPDATE task SET
+  status = 'fail',
+  failure_reason = $1,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id IN (
+  SELECT task_id FROM task
+  WHERE status = 'in_progress'
+    AND deadline_at < now()
+  ORDER BY task_id ASC
+  LIMIT $2
+)
+  AND status = 'in_progress'
+RETURNING task_id, deadline_at
+`
+
+type FailStuckTasksParams struct {
+	FailureReason pgtype.Text
+	MaxRows       int32
+}
+
+type FailStuckTasksRow struct {
+	TaskID     int64
+	DeadlineAt pgtype.Timestamptz
+}
+
+func (q *Queries) FailStuckTasks(ctx context.Context, arg FailStuckTasksParams) ([]FailStuckTasksRow, error) {
+	rows, err := q.db.Query(ctx, failStuckTasks, arg.FailureReason, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FailStuckTasksRow
+	for rows.Next() {
+		var i FailStuckTasksRow
+		if err := rows.Scan(&i.TaskID, &i.DeadlineAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const finishBatch = `-- name: FinishBatch :execrows
+UPDATE batch SET
+  finished_at = now(),
+  rows_processed = $2,
+  duplicates = $3,
+  failures = $4,
+  avg_latency_ms = $5,
+  score_histogram = $6,
+  result_csv = $7
+WHERE batch_id = $1
+  AND finished_at IS NULL
+`
+
+type FinishBatchParams struct {
+	BatchID        int64
+	RowsProcessed  int64
+	Duplicates     int64
+	Failures       int64
+	AvgLatencyMs   float64
+	ScoreHistogram []byte
+	ResultCsv      []byte
+}
+
+func (q *Queries) FinishBatch(ctx context.Context, arg FinishBatchParams) (int64, error) {
+	result, err := q.db.Exec(ctx, finishBatch,
+		arg.BatchID,
+		arg.RowsProcessed,
+		arg.Duplicates,
+		arg.Failures,
+		arg.AvgLatencyMs,
+		arg.ScoreHistogram,
+		arg.ResultCsv,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const finishTaskStage = `-- name: FinishTaskStage :exec
+UPDATE task_stage_timing SET
+  finished_at = now()
+WHERE task_id = $1
+  AND stage = $2
+  AND finished_at IS NULL
+`
+
+type FinishTaskStageParams struct {
+	TaskID int64
+	Stage  string
+}
+
+func (q *Queries) FinishTaskStage(ctx context.Context, arg FinishTaskStageParams) error {
+	_, err := q.db.Exec(ctx, finishTaskStage, arg.TaskID, arg.Stage)
+	return err
+}
+
+const getActiveIndexVersion = `-- name: GetActiveIndexVersion :one
+SELECT version FROM reference_index
+WHERE active LIMIT 1
+`
+
+func (q *Queries) GetActiveIndexVersion(ctx context.Context) (string, error) {
+	row := q.db.QueryRow(ctx, getActiveIndexVersion)
+	var version string
+	err := row.Scan(&version)
+	return version, err
+}
+
+const getApiKeyUsage = `-- name: GetApiKeyUsage :one
+SELECT api_key_id, tasks, video_seconds, bytes_stored, updated_at FROM api_key_usage
+WHERE api_key_id = $1
+`
+
+func (q *Queries) GetApiKeyUsage(ctx context.Context, apiKeyID string) (ApiKeyUsage, error) {
+	row := q.db.QueryRow(ctx, getApiKeyUsage, apiKeyID)
+	var i ApiKeyUsage
+	err := row.Scan(
+		&i.ApiKeyID,
+		&i.Tasks,
+		&i.VideoSeconds,
+		&i.BytesStored,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getBatch = `-- name: GetBatch :one
+SELECT batch_id, started_at, finished_at, rows_processed, duplicates, failures, avg_latency_ms, score_histogram, result_csv, total_rows, source_ip, user_agent, api_key_id, account FROM batch
+WHERE batch_id = $1
+`
+
+func (q *Queries) GetBatch(ctx context.Context, batchID int64) (Batch, error) {
+	row := q.db.QueryRow(ctx, getBatch, batchID)
+	var i Batch
+	err := row.Scan(
+		&i.BatchID,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.RowsProcessed,
+		&i.Duplicates,
+		&i.Failures,
+		&i.AvgLatencyMs,
+		&i.ScoreHistogram,
+		&i.ResultCsv,
+		&i.TotalRows,
+		&i.SourceIp,
+		&i.UserAgent,
+		&i.ApiKeyID,
+		&i.Account,
+	)
+	return i, err
+}
+
+const getBatchProgress = `-- name: GetBatchProgress :one
+SELECT
+  count(*) FILTER (WHERE processed_at IS NOT NULL) AS processed,
+  count(*) FILTER (WHERE is_duplicate) AS duplicates,
+  count(*) FILTER (WHERE failed) AS failures
+FROM batch_row
+WHERE batch_id = $1
+`
+
+type GetBatchProgressRow struct {
+	Processed  int64
+	Duplicates int64
+	Failures   int64
+}
+
+func (q *Queries) GetBatchProgress(ctx context.Context, batchID int64) (GetBatchProgressRow, error) {
+	row := q.db.QueryRow(ctx, getBatchProgress, batchID)
+	var i GetBatchProgressRow
+	err := row.Scan(&i.Processed, &i.Duplicates, &i.Failures)
+	return i, err
+}
+
+const getBatchResourceUsage = `-- name: GetBatchResourceUsage :one
+SELECT
+  COALESCE(sum(u.bytes_downloaded), 0)::bigint AS bytes_downloaded,
+  COALESCE(sum(u.bytes_stored), 0)::bigint AS bytes_stored,
+  COALESCE(sum(u.ffmpeg_cpu_seconds), 0)::double precision AS ffmpeg_cpu_seconds,
+  COALESCE(sum(u.ml_requests), 0)::bigint AS ml_requests
+FROM batch_row r
+JOIN task_resource_usage u ON u.task_id = r.task_id
+WHERE r.batch_id = $1
+`
+
+type GetBatchResourceUsageRow struct {
+	BytesDownloaded  int64
+	BytesStored      int64
+	FfmpegCpuSeconds float64
+	MlRequests       int64
+}
+
+func (q *Queries) GetBatchResourceUsage(ctx context.Context, batchID int64) (GetBatchResourceUsageRow, error) {
+	row := q.db.QueryRow(ctx, getBatchResourceUsage, batchID)
+	var i GetBatchResourceUsageRow
+	err := row.Scan(
+		&i.BytesDownloaded,
+		&i.BytesStored,
+		&i.FfmpegCpuSeconds,
+		&i.MlRequests,
+	)
+	return i, err
+}
+
+const getBatchRows = `-- name: GetBatchRows :many
+SELECT batch_id, row_no, created, uuid, link, task_id, claimed_at, processed_at, failed, is_duplicate, duplicate_for, score, latency_ms FROM batch_row
+WHERE batch_id = $1
+ORDER BY row_no ASC
+`
+
+func (q *Queries) GetBatchRows(ctx context.Context, batchID int64) ([]BatchRow, error) {
+	rows, err := q.db.Query(ctx, getBatchRows, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BatchRow
+	for rows.Next() {
+		var i BatchRow
+		if err := rows.Scan(
+			&i.BatchID,
+			&i.RowNo,
+			&i.Created,
+			&i.Uuid,
+			&i.Link,
+			&i.TaskID,
+			&i.ClaimedAt,
+			&i.ProcessedAt,
+			&i.Failed,
+			&i.IsDuplicate,
+			&i.DuplicateFor,
+			&i.Score,
+			&i.LatencyMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeadLetter = `-- name: GetDeadLetter :one
+SELECT id, topic, msg_partition, msg_offset, msg_key, msg_value, headers, error, attempts, dead_lettered_at, replayed_at FROM kafka_dead_letter
+WHERE id = $1
+`
+
+func (q *Queries) GetDeadLetter(ctx context.Context, id int64) (KafkaDeadLetter, error) {
+	row := q.db.QueryRow(ctx, getDeadLetter, id)
+	var i KafkaDeadLetter
+	err := row.Scan(
+		&i.ID,
+		&i.Topic,
+		&i.MsgPartition,
+		&i.MsgOffset,
+		&i.MsgKey,
+		&i.MsgValue,
+		&i.Headers,
+		&i.Error,
+		&i.Attempts,
+		&i.DeadLetteredAt,
+		&i.ReplayedAt,
+	)
+	return i, err
+}
+
+const getDeletedTasks = `-- name: GetDeletedTasks :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id > $1
+  AND deleted_at IS NOT NULL
+ORDER BY task_id ASC
+LIMIT $2
+`
+
+type GetDeletedTasksParams struct {
+	TaskID int64
+	Limit  int32
+}
+
+func (q *Queries) GetDeletedTasks(ctx context.Context, arg GetDeletedTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getDeletedTasks, arg.TaskID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestReferenceTaskID = `-- name: GetLatestReferenceTaskID :one
+SELECT COALESCE(max(task_id), 0)::bigint AS task_id FROM reference_registration
+WHERE status = 'registered'
+`
+
+func (q *Queries) GetLatestReferenceTaskID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, getLatestReferenceTaskID)
+	var task_id int64
+	err := row.Scan(&task_id)
+	return task_id, err
+}
+
+const getModalityRequests = `-- name: GetModalityRequests :many
+SELECT task_id, modality, attempts, requested_at, sent_at, received_at FROM modality_request
+WHERE task_id = $1
+ORDER BY modality ASC
+`
+
+func (q *Queries) GetModalityRequests(ctx context.Context, taskID int64) ([]ModalityRequest, error) {
+	rows, err := q.db.Query(ctx, getModalityRequests, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ModalityRequest
+	for rows.Next() {
+		var i ModalityRequest
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.Modality,
+			&i.Attempts,
+			&i.RequestedAt,
+			&i.SentAt,
+			&i.ReceivedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrigVideo = `-- name: GetOrigVideo :one
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+WHERE video_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetOrigVideo(ctx context.Context, videoID pgtype.Text) (Origvideo, error) {
+	row := q.db.QueryRow(ctx, getOrigVideo, videoID)
+	var i Origvideo
+	err := row.Scan(
+		&i.VideoID,
+		&i.VideoHash,
+		&i.SampleHash,
+		&i.PerceptualHash,
+		&i.AudioFingerprint,
+		&i.ID,
+		&i.RegisteredAt,
+	)
+	return i, err
+}
+
+const getOrigVideos = `-- name: GetOrigVideos :many
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+ORDER BY video_id DESC
+LIMIT $1 OFFSET $2
+`
+
+type GetOrigVideosParams struct {
+	Limit  int32
+	Offset int32
+}
+
+func (q *Queries) GetOrigVideos(ctx context.Context, arg GetOrigVideosParams) ([]Origvideo, error) {
+	rows, err := q.db.Query(ctx, getOrigVideos, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Origvideo
+	for rows.Next() {
+		var i Origvideo
+		if err := rows.Scan(
+			&i.VideoID,
+			&i.VideoHash,
+			&i.SampleHash,
+			&i.PerceptualHash,
+			&i.AudioFingerprint,
+			&i.ID,
+			&i.RegisteredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrigVideosByHash = `-- name: GetOrigVideosByHash :many
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+WHERE video_hash = $1
+ORDER BY video_id DESC
+`
+
+func (q *Queries) GetOrigVideosByHash(ctx context.Context, videoHash pgtype.Text) ([]Origvideo, error) {
+	rows, err := q.db.Query(ctx, getOrigVideosByHash, videoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Origvideo
+	for rows.Next() {
+		var i Origvideo
+		if err := rows.Scan(
+			&i.VideoID,
+			&i.VideoHash,
+			&i.SampleHash,
+			&i.PerceptualHash,
+			&i.AudioFingerprint,
+			&i.ID,
+			&i.RegisteredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrigVideosWithAudioFingerprint = `-- name: GetOrigVideosWithAudioFingerprint :many
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+WHERE audio_fingerprint IS NOT NULL
+ORDER BY video_id DESC
+`
+
+func (q *Queries) GetOrigVideosWithAudioFingerprint(ctx context.Context) ([]Origvideo, error) {
+	rows, err := q.db.Query(ctx, getOrigVideosWithAudioFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Origvideo
+	for rows.Next() {
+		var i Origvideo
+		if err := rows.Scan(
+			&i.VideoID,
+			&i.VideoHash,
+			&i.SampleHash,
+			&i.PerceptualHash,
+			&i.AudioFingerprint,
+			&i.ID,
+			&i.RegisteredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrigVideosWithPerceptualHash = `-- name: GetOrigVideosWithPerceptualHash :many
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+WHERE perceptual_hash IS NOT NULL
+ORDER BY video_id DESC
+`
+
+func (q *Queries) GetOrigVideosWithPerceptualHash(ctx context.Context) ([]Origvideo, error) {
+	rows, err := q.db.Query(ctx, getOrigVideosWithPerceptualHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Origvideo
+	for rows.Next() {
+		var i Origvideo
+		if err := rows.Scan(
+			&i.VideoID,
+			&i.VideoHash,
+			&i.SampleHash,
+			&i.PerceptualHash,
+			&i.AudioFingerprint,
+			&i.ID,
+			&i.RegisteredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOverdueModalityRequests = `-- name: GetOverdueModalityRequests :many
+SELECT r.task_id, r.modality, r.attempts, r.requested_at, r.sent_at, r.received_at FROM modality_request r
+JOIN task t ON t.task_id = r.task_id
+WHERE t.status = 'in_progress'
+  AND r.received_at IS NULL
+  AND r.sent_at < CASE r.modality WHEN 'audio' THEN $1::timestamptz ELSE $2::timestamptz END
+ORDER BY r.task_id ASC
+LIMIT $3
+`
+
+type GetOverdueModalityRequestsParams struct {
+	AudioSentBefore pgtype.Timestamptz
+	VideoSentBefore pgtype.Timestamptz
+	MaxRows         int32
+}
+
+func (q *Queries) GetOverdueModalityRequests(ctx context.Context, arg GetOverdueModalityRequestsParams) ([]ModalityRequest, error) {
+	rows, err := q.db.Query(ctx, getOverdueModalityRequests, arg.AudioSentBefore, arg.VideoSentBefore, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ModalityRequest
+	for rows.Next() {
+		var i ModalityRequest
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.Modality,
+			&i.Attempts,
+			&i.RequestedAt,
+			&i.SentAt,
+			&i.ReceivedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReferenceChecks = `-- name: GetReferenceChecks :many
+SELECT c.task_id, r.video_name, c.check_task_id, c.checked_at, t.status, t.audio_copyright, t.video_copyright
+FROM reference_check c
+JOIN task r ON r.task_id = c.task_id
+JOIN task t ON t.task_id = c.check_task_id
+ORDER BY c.task_id ASC
+`
+
+type GetReferenceChecksRow struct {
+	TaskID         int64
+	VideoName      pgtype.Text
+	CheckTaskID    int64
+	CheckedAt      pgtype.Timestamptz
+	Status         NullTaskStatus
+	AudioCopyright []byte
+	VideoCopyright []byte
+}
+
+func (q *Queries) GetReferenceChecks(ctx context.Context) ([]GetReferenceChecksRow, error) {
+	rows, err := q.db.Query(ctx, getReferenceChecks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReferenceChecksRow
+	for rows.Next() {
+		var i GetReferenceChecksRow
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.CheckTaskID,
+			&i.CheckedAt,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReferenceRegistrations = `-- name: GetReferenceRegistrations :many
+SELECT task_id, modality, status, attempts, last_error, updated_at FROM reference_registration
+WHERE task_id = $1
+ORDER BY modality ASC
+`
+
+func (q *Queries) GetReferenceRegistrations(ctx context.Context, taskID int64) ([]ReferenceRegistration, error) {
+	rows, err := q.db.Query(ctx, getReferenceRegistrations, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReferenceRegistration
+	for rows.Next() {
+		var i ReferenceRegistration
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.Modality,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReferencesDueForCheck = `-- name: GetReferencesDueForCheck :many
+SELECT DISTINCT r.task_id FROM reference_registration r
+LEFT JOIN reference_check c ON c.task_id = r.task_id
+WHERE r.status = 'registered'
+  AND (c.checked_at IS NULL OR c.checked_at < $1)
+ORDER BY r.task_id ASC
+LIMIT $2
+`
+
+type GetReferencesDueForCheckParams struct {
+	CheckedBefore pgtype.Timestamptz
+	MaxRows       int32
+}
+
+func (q *Queries) GetReferencesDueForCheck(ctx context.Context, arg GetReferencesDueForCheckParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getReferencesDueForCheck, arg.CheckedBefore, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var task_id int64
+		if err := rows.Scan(&task_id); err != nil {
+			return nil, err
+		}
+		items = append(items, task_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStageTimingPercentiles = `-- name: GetStageTimingPercentiles :many
+SELECT
+  stage,
+  count(*) AS tasks,
+  percentile_cont(0.5) WITHIN GROUP (ORDER BY seconds)::float8 AS p50,
+  percentile_cont(0.9) WITHIN GROUP (ORDER BY seconds)::float8 AS p90,
+  percentile_cont(0.99) WITHIN GROUP (ORDER BY seconds)::float8 AS p99
+FROM (
+  SELECT stage, extract(epoch FROM finished_at - started_at)::float8 AS seconds
+  FROM task_stage_timing
+  WHERE finished_at >= $1
+) AS timing
+GROUP BY stage
+ORDER BY stage
+`
+
+type GetStageTimingPercentilesRow struct {
+	Stage string
+	Tasks int64
+	P50   float64
+	P90   float64
+	P99   float64
+}
+
+func (q *Queries) GetStageTimingPercentiles(ctx context.Context, finishedAfter pgtype.Timestamptz) ([]GetStageTimingPercentilesRow, error) {
+	rows, err := q.db.Query(ctx, getStageTimingPercentiles, finishedAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetStageTimingPercentilesRow
+	for rows.Next() {
+		var i GetStageTimingPercentilesRow
+		if err := rows.Scan(
+			&i.Stage,
+			&i.Tasks,
+			&i.P50,
+			&i.P90,
+			&i.P99,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTask = `-- name: GetTask :one
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetTask(ctx context.Context, taskID int64) (Task, error) {
+	row := q.db.QueryRow(ctx, getTask, taskID)
+	var i Task
+	err := row.Scan(
+		&i.TaskID,
+		&i.VideoName,
+		&i.AudioFile,
+		&i.VideoFile,
+		&i.PreviewID,
+		&i.Status,
+		&i.AudioCopyright,
+		&i.VideoCopyright,
+		&i.IndexVersion,
+		&i.ParentTaskID,
+		&i.SourceIp,
+		&i.UserAgent,
+		&i.ApiKeyID,
+		&i.DownloadVerification,
+		&i.TraceID,
+		&i.CreatedAt,
+		&i.FailureReason,
+		&i.UpdatedAt,
+		&i.DeadlineAt,
+		&i.Stage,
+		&i.Priority,
+		&i.DeletedAt,
+		&i.Version,
+		&i.DurationSeconds,
+		&i.Width,
+		&i.Height,
+		&i.FileSize,
+		&i.ContentHash,
+	)
+	return i, err
+}
+
+const getTaskAudioFingerprint = `-- name: GetTaskAudioFingerprint :one
+SELECT fingerprint FROM task_audio_fingerprint
+WHERE task_id = $1
+`
+
+func (q *Queries) GetTaskAudioFingerprint(ctx context.Context, taskID int64) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getTaskAudioFingerprint, taskID)
+	var fingerprint []byte
+	err := row.Scan(&fingerprint)
+	return fingerprint, err
+}
+
+const getTaskEvents = `-- name: GetTaskEvents :many
+SELECT id, task_id, status, previous_status, stage, actor, reason, occurred_at FROM task_event
+WHERE task_id = $1
+ORDER BY id ASC
+`
+
+func (q *Queries) GetTaskEvents(ctx context.Context, taskID int64) ([]TaskEvent, error) {
+	rows, err := q.db.Query(ctx, getTaskEvents, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskEvent
+	for rows.Next() {
+		var i TaskEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Status,
+			&i.PreviousStatus,
+			&i.Stage,
+			&i.Actor,
+			&i.Reason,
+			&i.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskForUpdate = `-- name: GetTaskForUpdate :one
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetTaskForUpdate(ctx context.Context, taskID int64) (Task, error) {
+	row := q.db.QueryRow(ctx, getTaskForUpdate, taskID)
+	var i Task
+	err := row.Scan(
+		&i.TaskID,
+		&i.VideoName,
+		&i.AudioFile,
+		&i.VideoFile,
+		&i.PreviewID,
+		&i.Status,
+		&i.AudioCopyright,
+		&i.VideoCopyright,
+		&i.IndexVersion,
+		&i.ParentTaskID,
+		&i.SourceIp,
+		&i.UserAgent,
+		&i.ApiKeyID,
+		&i.DownloadVerification,
+		&i.TraceID,
+		&i.CreatedAt,
+		&i.FailureReason,
+		&i.UpdatedAt,
+		&i.DeadlineAt,
+		&i.Stage,
+		&i.Priority,
+		&i.DeletedAt,
+		&i.Version,
+		&i.DurationSeconds,
+		&i.Width,
+		&i.Height,
+		&i.FileSize,
+		&i.ContentHash,
+	)
+	return i, err
+}
+
+const getTaskHashes = `-- name: GetTaskHashes :many
+SELECT task_id, algorithm, digest FROM task_hash
+WHERE task_id = $1
+ORDER BY algorithm ASC
+`
+
+func (q *Queries) GetTaskHashes(ctx context.Context, taskID int64) ([]TaskHash, error) {
+	rows, err := q.db.Query(ctx, getTaskHashes, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskHash
+	for rows.Next() {
+		var i TaskHash
+		if err := rows.Scan(&i.TaskID, &i.Algorithm, &i.Digest); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskMetadata = `-- name: GetTaskMetadata :one
+SELECT task_id, format, video_codec, audio_codec, width, height, frame_rate, bit_rate, duration_seconds FROM task_metadata
+WHERE task_id = $1
+`
+
+func (q *Queries) GetTaskMetadata(ctx context.Context, taskID int64) (TaskMetadatum, error) {
+	row := q.db.QueryRow(ctx, getTaskMetadata, taskID)
+	var i TaskMetadatum
+	err := row.Scan(
+		&i.TaskID,
+		&i.Format,
+		&i.VideoCodec,
+		&i.AudioCodec,
+		&i.Width,
+		&i.Height,
+		&i.FrameRate,
+		&i.BitRate,
+		&i.DurationSeconds,
+	)
+	return i, err
+}
+
+const getTaskQueuePosition = `-- name: GetTaskQueuePosition :one
+SELECT count(*) FROM task
+WHERE status = 'in_progress'
+  AND stage NOT IN ('uploading', 'audio_extraction')
+  AND (priority, task_id) <= (SELECT t.priority, t.task_id FROM task t WHERE t.task_id = $1)
+`
+
+func (q *Queries) GetTaskQueuePosition(ctx context.Context, taskID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, getTaskQueuePosition, taskID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getTaskRechecks = `-- name: GetTaskRechecks :many
+SELECT c.recheck_task_id, c.references_through, c.created_at, t.status, t.audio_copyright, t.video_copyright
+FROM task_recheck c
+JOIN task t ON t.task_id = c.recheck_task_id
+WHERE c.task_id = $1
+ORDER BY c.id ASC
+`
+
+type GetTaskRechecksRow struct {
+	RecheckTaskID     int64
+	ReferencesThrough int64
+	CreatedAt         pgtype.Timestamptz
+	Status            NullTaskStatus
+	AudioCopyright    []byte
+	VideoCopyright    []byte
+}
+
+func (q *Queries) GetTaskRechecks(ctx context.Context, taskID int64) ([]GetTaskRechecksRow, error) {
+	rows, err := q.db.Query(ctx, getTaskRechecks, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTaskRechecksRow
+	for rows.Next() {
+		var i GetTaskRechecksRow
+		if err := rows.Scan(
+			&i.RecheckTaskID,
+			&i.ReferencesThrough,
+			&i.CreatedAt,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskScene = `-- name: GetTaskScene :one
+SELECT task_id, scene, start_seconds, end_seconds, keyframe_seconds, keyframe_key FROM task_scene
+WHERE task_id = $1 AND scene = $2
+`
+
+type GetTaskSceneParams struct {
+	TaskID int64
+	Scene  int32
+}
+
+func (q *Queries) GetTaskScene(ctx context.Context, arg GetTaskSceneParams) (TaskScene, error) {
+	row := q.db.QueryRow(ctx, getTaskScene, arg.TaskID, arg.Scene)
+	var i TaskScene
+	err := row.Scan(
+		&i.TaskID,
+		&i.Scene,
+		&i.StartSeconds,
+		&i.EndSeconds,
+		&i.KeyframeSeconds,
+		&i.KeyframeKey,
+	)
+	return i, err
+}
+
+const getTaskScenes = `-- name: GetTaskScenes :many
+SELECT task_id, scene, start_seconds, end_seconds, keyframe_seconds, keyframe_key FROM task_scene
+WHERE task_id = $1
+ORDER BY scene ASC
+`
+
+func (q *Queries) GetTaskScenes(ctx context.Context, taskID int64) ([]TaskScene, error) {
+	rows, err := q.db.Query(ctx, getTaskScenes, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskScene
+	for rows.Next() {
+		var i TaskScene
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.Scene,
+			&i.StartSeconds,
+			&i.EndSeconds,
+			&i.KeyframeSeconds,
+			&i.KeyframeKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskSourceReport = `-- name: GetTaskSourceReport :many
+SELECT
+  COALESCE(CASE $1::text
+    WHEN 'user_agent' THEN user_agent
+    WHEN 'api_key' THEN api_key_id
+    ELSE source_ip
+  END, '')::text AS source,
+  count(*) AS tasks,
+  count(*) FILTER (WHERE status = 'in_progress') AS in_progress,
+  count(*) FILTER (WHERE status = 'fail') AS failed
+FROM task
+GROUP BY 1
+ORDER BY tasks DESC
+LIMIT $2
+`
+
+type GetTaskSourceReportParams struct {
+	Dimension string
+	MaxRows   int32
+}
+
+type GetTaskSourceReportRow struct {
+	Source     string
+	Tasks      int64
+	InProgress int64
+	Failed     int64
+}
+
+func (q *Queries) GetTaskSourceReport(ctx context.Context, arg GetTaskSourceReportParams) ([]GetTaskSourceReportRow, error) {
+	rows, err := q.db.Query(ctx, getTaskSourceReport, arg.Dimension, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTaskSourceReportRow
+	for rows.Next() {
+		var i GetTaskSourceReportRow
+		if err := rows.Scan(
+			&i.Source,
+			&i.Tasks,
+			&i.InProgress,
+			&i.Failed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskSprite = `-- name: GetTaskSprite :one
+SELECT task_id, object_key, grid_columns, grid_rows, frame_width, frame_height, timestamps FROM task_sprite
+WHERE task_id = $1
+`
+
+func (q *Queries) GetTaskSprite(ctx context.Context, taskID int64) (TaskSprite, error) {
+	row := q.db.QueryRow(ctx, getTaskSprite, taskID)
+	var i TaskSprite
+	err := row.Scan(
+		&i.TaskID,
+		&i.ObjectKey,
+		&i.GridColumns,
+		&i.GridRows,
+		&i.FrameWidth,
+		&i.FrameHeight,
+		&i.Timestamps,
+	)
+	return i, err
+}
+
+const getTaskStageTimings = `-- name: GetTaskStageTimings :many
+SELECT task_id, stage, started_at, finished_at FROM task_stage_timing
+WHERE task_id = $1
+ORDER BY started_at ASC, stage ASC
+`
+
+func (q *Queries) GetTaskStageTimings(ctx context.Context, taskID int64) ([]TaskStageTiming, error) {
+	rows, err := q.db.Query(ctx, getTaskStageTimings, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskStageTiming
+	for rows.Next() {
+		var i TaskStageTiming
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.Stage,
+			&i.StartedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasks = `-- name: GetTasks :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id > $1
+  AND deleted_at IS NULL
+ORDER BY task_id ASC
+LIMIT $2
+`
+
+type GetTasksParams struct {
+	TaskID int64
+	Limit  int32
+}
+
+func (q *Queries) GetTasks(ctx context.Context, arg GetTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasks, arg.TaskID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByHash = `-- name: GetTasksByHash :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id IN (
+  SELECT task_id FROM task_hash
+  WHERE algorithm = $1 AND digest = $2
+)
+ORDER BY task_id ASC
+LIMIT $3
+`
+
+type GetTasksByHashParams struct {
+	Algorithm string
+	Digest    string
+	Limit     int32
+}
+
+func (q *Queries) GetTasksByHash(ctx context.Context, arg GetTasksByHashParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksByHash, arg.Algorithm, arg.Digest, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByParent = `-- name: GetTasksByParent :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE parent_task_id = $1
+ORDER BY task_id ASC
+`
+
+func (q *Queries) GetTasksByParent(ctx context.Context, parentTaskID pgtype.Int8) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksByParent, parentTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByStatus = `-- name: GetTasksByStatus :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE status = $1::task_status
+  AND created_at < $2
+  AND task_id > $3
+  AND deleted_at IS NULL
+ORDER BY task_id ASC
+LIMIT $4
+`
+
+type GetTasksByStatusParams struct {
+	Status        TaskStatus
+	CreatedBefore pgtype.Timestamptz
+	TaskID        int64
+	MaxRows       int32
+}
+
+func (q *Queries) GetTasksByStatus(ctx context.Context, arg GetTasksByStatusParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksByStatus,
+		arg.Status,
+		arg.CreatedBefore,
+		arg.TaskID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksCount = `-- name: GetTasksCount :one
+SELECT count(*) FROM task
+WHERE deleted_at IS NULL
+`
+
+func (q *Queries) GetTasksCount(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, getTasksCount)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getTasksDesc = `-- name: GetTasksDesc :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id < $1
+  AND deleted_at IS NULL
+ORDER BY task_id DESC
+LIMIT $2
+`
+
+type GetTasksDescParams struct {
+	TaskID int64
+	Limit  int32
+}
+
+func (q *Queries) GetTasksDesc(ctx context.Context, arg GetTasksDescParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksDesc, arg.TaskID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.VideoName,
+			&i.AudioFile,
+			&i.VideoFile,
+			&i.PreviewID,
+			&i.Status,
+			&i.AudioCopyright,
+			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksDueForRecheck = `-- name: GetTasksDueForRecheck :many
+SELECT DISTINCT r.task_id FROM reference_registration r
+WHERE r.status = 'registered'
+  AND NOT EXISTS (
+    SELECT 1 FROM task_recheck c
+    WHERE c.task_id = r.task_id AND c.created_at >= $1
+  )
+  AND $2::bigint > COALESCE(
+    (SELECT max(c.references_through) FROM task_recheck c WHERE c.task_id = r.task_id),
+    r.task_id
+  )
+ORDER BY r.task_id ASC
+LIMIT $3
+`
+
+type GetTasksDueForRecheckParams struct {
+	CheckedAfter      pgtype.Timestamptz
+	ReferencesThrough int64
+	MaxRows           int32
+}
+
+func (q *Queries) GetTasksDueForRecheck(ctx context.Context, arg GetTasksDueForRecheckParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getTasksDueForRecheck, arg.CheckedAfter, arg.ReferencesThrough, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var task_id int64
+		if err := rows.Scan(&task_id); err != nil {
+			return nil, err
+		}
+		items = append(items, task_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const hasOrigVideoCandidates = `-- name: HasOrigVideoCandidates :one
+SELECT EXISTS (
+  SELECT 1 FROM origvideo
+  WHERE sample_hash = $1 OR sample_hash IS NULL
+)
+`
+
+func (q *Queries) HasOrigVideoCandidates(ctx context.Context, sampleHash pgtype.Text) (bool, error) {
+	row := q.db.QueryRow(ctx, hasOrigVideoCandidates, sampleHash)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const hasTaskForVideoName = `-- name: HasTaskForVideoName :one
+SELECT EXISTS (
+  SELECT 1 FROM task
+  WHERE video_name = $1
+)
+`
+
+func (q *Queries) HasTaskForVideoName(ctx context.Context, videoName pgtype.Text) (bool, error) {
+	row := q.db.QueryRow(ctx, hasTaskForVideoName, videoName)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const hasValidBatchToken = `-- name: HasValidBatchToken :one
+SELECT EXISTS (
+  SELECT 1 FROM batch_token
+  WHERE token_hash = $1
+    AND batch_id = $2
+    AND revoked_at IS NULL
+    AND expires_at > now()
+)
+`
+
+type HasValidBatchTokenParams struct {
+	TokenHash string
+	BatchID   int64
+}
+
+func (q *Queries) HasValidBatchToken(ctx context.Context, arg HasValidBatchTokenParams) (bool, error) {
+	row := q.db.QueryRow(ctx, hasValidBatchToken, arg.TokenHash, arg.BatchID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const insertAuditEvent = `-- name: InsertAuditEvent :exec
+INSERT INTO audit_log (
+  actor, action, task_id, details
+) VALUES (
+  $1, $2, $3, $4
+)
+`
+
+type InsertAuditEventParams struct {
+	Actor   string
+	Action  string
+	TaskID  pgtype.Int8
+	Details []byte
+}
+
+func (q *Queries) InsertAuditEvent(ctx context.Context, arg InsertAuditEventParams) error {
+	_, err := q.db.Exec(ctx, insertAuditEvent,
+		arg.Actor,
+		arg.Action,
+		arg.TaskID,
+		arg.Details,
+	)
+	return err
+}
+
+const insertBatchRow = `-- name: InsertBatchRow :exec
+INSERT INTO batch_row (
+  batch_id, row_no, created, uuid, link
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+`
+
+type InsertBatchRowParams struct {
+	BatchID int64
+	RowNo   int32
+	Created pgtype.Timestamptz
+	Uuid    string
+	Link    string
+}
+
+func (q *Queries) InsertBatchRow(ctx context.Context, arg InsertBatchRowParams) error {
+	_, err := q.db.Exec(ctx, insertBatchRow,
+		arg.BatchID,
+		arg.RowNo,
+		arg.Created,
+		arg.Uuid,
+		arg.Link,
+	)
+	return err
+}
+
+const insertDeadLetter = `-- name: InsertDeadLetter :exec
+INSERT INTO kafka_dead_letter (
+  topic, msg_partition, msg_offset, msg_key, msg_value, headers, error, attempts
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (topic, msg_partition, msg_offset) DO UPDATE
+SET msg_key = EXCLUDED.msg_key,
+    msg_value = EXCLUDED.msg_value,
+    headers = EXCLUDED.headers,
+    error = EXCLUDED.error,
+    attempts = EXCLUDED.attempts,
+    dead_lettered_at = now(),
+    replayed_at = NULL
+`
+
+type InsertDeadLetterParams struct {
+	Topic        string
+	MsgPartition int32
+	MsgOffset    int64
+	MsgKey       []byte
+	MsgValue     []byte
+	Headers      []byte
+	Error        string
+	Attempts     int32
+}
+
+func (q *Queries) InsertDeadLetter(ctx context.Context, arg InsertDeadLetterParams) error {
+	_, err := q.db.Exec(ctx, insertDeadLetter,
+		arg.Topic,
+		arg.MsgPartition,
+		arg.MsgOffset,
+		arg.MsgKey,
+		arg.MsgValue,
+		arg.Headers,
+		arg.Error,
+		arg.Attempts,
+	)
+	return err
+}
+
+const insertTaskAudioFingerprint = `-- name: InsertTaskAudioFingerprint :exec
+INSERT INTO task_audio_fingerprint (
+  task_id, fingerprint
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id) DO NOTHING
+`
+
+type InsertTaskAudioFingerprintParams struct {
+	TaskID      int64
+	Fingerprint []byte
+}
+
+func (q *Queries) InsertTaskAudioFingerprint(ctx context.Context, arg InsertTaskAudioFingerprintParams) error {
+	_, err := q.db.Exec(ctx, insertTaskAudioFingerprint, arg.TaskID, arg.Fingerprint)
+	return err
+}
+
+const insertTaskHash = `-- name: InsertTaskHash :exec
+INSERT INTO task_hash (
+  task_id, algorithm, digest
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (task_id, algorithm) DO UPDATE SET digest = EXCLUDED.digest
+`
+
+type InsertTaskHashParams struct {
+	TaskID    int64
+	Algorithm string
+	Digest    string
+}
+
+func (q *Queries) InsertTaskHash(ctx context.Context, arg InsertTaskHashParams) error {
+	_, err := q.db.Exec(ctx, insertTaskHash, arg.TaskID, arg.Algorithm, arg.Digest)
+	return err
+}
+
+const insertTaskMetadata = `-- name: InsertTaskMetadata :exec
+INSERT INTO task_metadata (
+  task_id, format, video_codec, audio_codec, width, height, frame_rate, bit_rate, duration_seconds
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9
+)
+ON CONFLICT (task_id) DO NOTHING
+`
+
+type InsertTaskMetadataParams struct {
+	TaskID          int64
+	Format          string
+	VideoCodec      string
+	AudioCodec      string
+	Width           int32
+	Height          int32
+	FrameRate       float64
+	BitRate         int64
+	DurationSeconds float64
+}
+
+func (q *Queries) InsertTaskMetadata(ctx context.Context, arg InsertTaskMetadataParams) error {
+	_, err := q.db.Exec(ctx, insertTaskMetadata,
+		arg.TaskID,
+		arg.Format,
+		arg.VideoCodec,
+		arg.AudioCodec,
+		arg.Width,
+		arg.Height,
+		arg.FrameRate,
+		arg.BitRate,
+		arg.DurationSeconds,
+	)
+	return err
+}
+
+const insertTaskRecheck = `-- name: InsertTaskRecheck :exec
+INSERT INTO task_recheck (
+  task_id, recheck_task_id, references_through
+) VALUES (
+  $1, $2, $3
+)
+`
+
+type InsertTaskRecheckParams struct {
+	TaskID            int64
+	RecheckTaskID     int64
+	ReferencesThrough int64
+}
+
+func (q *Queries) InsertTaskRecheck(ctx context.Context, arg InsertTaskRecheckParams) error {
+	_, err := q.db.Exec(ctx, insertTaskRecheck, arg.TaskID, arg.RecheckTaskID, arg.ReferencesThrough)
+	return err
+}
+
+const insertTaskScene = `-- name: InsertTaskScene :exec
+INSERT INTO task_scene (
+  task_id, scene, start_seconds, end_seconds, keyframe_seconds, keyframe_key
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (task_id, scene) DO NOTHING
+`
+
+type InsertTaskSceneParams struct {
+	TaskID          int64
+	Scene           int32
+	StartSeconds    float64
+	EndSeconds      float64
+	KeyframeSeconds float64
+	KeyframeKey     string
+}
+
+func (q *Queries) InsertTaskScene(ctx context.Context, arg InsertTaskSceneParams) error {
+	_, err := q.db.Exec(ctx, insertTaskScene,
+		arg.TaskID,
+		arg.Scene,
+		arg.StartSeconds,
+		arg.EndSeconds,
+		arg.KeyframeSeconds,
+		arg.KeyframeKey,
+	)
+	return err
+}
+
+const insertTaskSprite = `-- name: InsertTaskSprite :exec
+INSERT INTO task_sprite (
+  task_id, object_key, grid_columns, grid_rows, frame_width, frame_height, timestamps
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+)
+ON CONFLICT (task_id) DO NOTHING
+`
+
+type InsertTaskSpriteParams struct {
+	TaskID      int64
+	ObjectKey   string
+	GridColumns int32
+	GridRows    int32
+	FrameWidth  int32
+	FrameHeight int32
+	Timestamps  []float64
+}
+
+func (q *Queries) InsertTaskSprite(ctx context.Context, arg InsertTaskSpriteParams) error {
+	_, err := q.db.Exec(ctx, insertTaskSprite,
+		arg.TaskID,
+		arg.ObjectKey,
+		arg.GridColumns,
+		arg.GridRows,
+		arg.FrameWidth,
+		arg.FrameHeight,
+		arg.Timestamps,
+	)
+	return err
+}
+
+const insertVerdictAuditEvent = `-- name: InsertVerdictAuditEvent :exec
+WITH event AS (
+  INSERT INTO audit_log (
+    actor, action, task_id, details
+  ) VALUES (
+    $1, $2, $3, $4
+  )
+  RETURNING id
+)
+INSERT INTO verdict_event (audit_id)
+SELECT id FROM event
+`
+
+type InsertVerdictAuditEventParams struct {
+	Actor   string
+	Action  string
+	TaskID  pgtype.Int8
+	Details []byte
+}
+
+func (q *Queries) InsertVerdictAuditEvent(ctx context.Context, arg InsertVerdictAuditEventParams) error {
+	_, err := q.db.Exec(ctx, insertVerdictAuditEvent,
+		arg.Actor,
+		arg.Action,
+		arg.TaskID,
+		arg.Details,
+	)
+	return err
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, occurred_at, actor, action, task_id, details FROM audit_log
+WHERE id > $1
+  AND ($2::text IS NULL OR actor = $2)
+  AND ($3::text IS NULL OR action = $3)
+  AND ($4::bigint IS NULL OR task_id = $4)
+ORDER BY id ASC
+LIMIT $5
+`
+
+type ListAuditEventsParams struct {
+	ID      int64
+	Actor   pgtype.Text
+	Action  pgtype.Text
+	TaskID  pgtype.Int8
+	MaxRows int32
+}
+
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.ID,
+		arg.Actor,
+		arg.Action,
+		arg.TaskID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.OccurredAt,
+			&i.Actor,
+			&i.Action,
+			&i.TaskID,
+			&i.Details,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeadLetters = `-- name: ListDeadLetters :many
+SELECT id, topic, msg_partition, msg_offset, msg_key, msg_value, headers, error, attempts, dead_lettered_at, replayed_at FROM kafka_dead_letter
+WHERE id > $1
+  AND ($2::text IS NULL OR topic = $2)
+  AND (NOT $3::boolean OR replayed_at IS NULL)
+ORDER BY id ASC
+LIMIT $4
+`
+
+type ListDeadLettersParams struct {
+	ID      int64
+	Topic   pgtype.Text
+	Pending bool
+	MaxRows int32
+}
+
+func (q *Queries) ListDeadLetters(ctx context.Context, arg ListDeadLettersParams) ([]KafkaDeadLetter, error) {
+	rows, err := q.db.Query(ctx, listDeadLetters,
+		arg.ID,
+		arg.Topic,
+		arg.Pending,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []KafkaDeadLetter
+	for rows.Next() {
+		var i KafkaDeadLetter
+		if err := rows.Scan(
+			&i.ID,
+			&i.Topic,
+			&i.MsgPartition,
+			&i.MsgOffset,
+			&i.MsgKey,
+			&i.MsgValue,
+			&i.Headers,
+			&i.Error,
+			&i.Attempts,
+			&i.DeadLetteredAt,
+			&i.ReplayedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listIndexVersions = `-- name: ListIndexVersions :many
+SELECT version, active, created_at FROM reference_index
+ORDER BY created_at ASC
+`
+
+func (q *Queries) ListIndexVersions(ctx context.Context) ([]ReferenceIndex, error) {
+	rows, err := q.db.Query(ctx, listIndexVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReferenceIndex
+	for rows.Next() {
+		var i ReferenceIndex
+		if err := rows.Scan(&i.Version, &i.Active, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockVerdictStream = `-- name: LockVerdictStream :one
+SELECT last_seq FROM verdict_stream
+FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) LockVerdictStream(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, lockVerdictStream)
+	var last_seq int64
+	err := row.Scan(&last_seq)
+	return last_seq, err
+}
+
+const markDeadLetterReplayed = `-- name: MarkDeadLetterReplayed :exec
+UPDATE kafka_dead_letter SET replayed_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) MarkDeadLetterReplayed(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markDeadLetterReplayed, id)
+	return err
+}
+
+const markMessageProcessed = `-- name: MarkMessageProcessed :execrows
+INSERT INTO kafka_processed_message (
+  topic, msg_partition, msg_offset
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT DO NOTHING
+`
+
+type MarkMessageProcessedParams struct {
+	Topic        string
+	MsgPartition int32
+	MsgOffset    int64
+}
+
+func (q *Queries) MarkMessageProcessed(ctx context.Context, arg MarkMessageProcessedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markMessageProcessed, arg.Topic, arg.MsgPartition, arg.MsgOffset)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markModalityReceived = `-- name: MarkModalityReceived :one
+UPDATE modality_request SET received_at = now()
+WHERE task_id = $1
+  AND modality = $2
+  AND received_at IS NULL
+RETURNING requested_at
+`
+
+type MarkModalityReceivedParams struct {
+	TaskID   int64
+	Modality string
+}
+
+func (q *Queries) MarkModalityReceived(ctx context.Context, arg MarkModalityReceivedParams) (pgtype.Timestamptz, error) {
+	row := q.db.QueryRow(ctx, markModalityReceived, arg.TaskID, arg.Modality)
+	var requested_at pgtype.Timestamptz
+	err := row.Scan(&requested_at)
+	return requested_at, err
+}
+
+const markOutboxSent = `-- name: MarkOutboxSent :exec
+UPDATE outbox SET sent_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxSent(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markOutboxSent, id)
+	return err
+}
+
+const markResultPushed = `-- name: MarkResultPushed :execrows
+INSERT INTO pushed_result (
+  modality, result_id
 ) VALUES (
   $1, $2
 )
-RETURNING video_id, video_hash
+ON CONFLICT DO NOTHING
+`
+
+type MarkResultPushedParams struct {
+	Modality string
+	ResultID string
+}
+
+func (q *Queries) MarkResultPushed(ctx context.Context, arg MarkResultPushedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markResultPushed, arg.Modality, arg.ResultID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markTaskDone = `-- name: MarkTaskDone :execrows
+UPDATE task SET
+  status = 'done',
+  stage = 'done',
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress'
+  AND audio_copyright IS NOT NULL
+  AND video_copyright IS NOT NULL
+`
+
+func (q *Queries) MarkTaskDone(ctx context.Context, taskID int64) (int64, error) {
+	result, err := q.db.Exec(ctx, markTaskDone, taskID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markTaskFailed = `-- name: MarkTaskFailed :execrows
+UPDATE task SET
+  status = 'fail',
+  failure_reason = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress'
+`
+
+type MarkTaskFailedParams struct {
+	TaskID        int64
+	FailureReason pgtype.Text
+}
+
+func (q *Queries) MarkTaskFailed(ctx context.Context, arg MarkTaskFailedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markTaskFailed, arg.TaskID, arg.FailureReason)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markVerdictEventPublished = `-- name: MarkVerdictEventPublished :exec
+UPDATE verdict_event SET
+  seq = $2,
+  published_at = now()
+WHERE id = $1
+`
+
+type MarkVerdictEventPublishedParams struct {
+	ID  int64
+	Seq pgtype.Int8
+}
+
+func (q *Queries) MarkVerdictEventPublished(ctx context.Context, arg MarkVerdictEventPublishedParams) error {
+	_, err := q.db.Exec(ctx, markVerdictEventPublished, arg.ID, arg.Seq)
+	return err
+}
+
+const pruneProcessedMessages = `-- name: PruneProcessedMessages :execrows
+DELETE FROM kafka_processed_message
+WHERE (topic, msg_partition, msg_offset) IN (
+  SELECT p.topic, p.msg_partition, p.msg_offset FROM kafka_processed_message p
+  WHERE p.processed_at < $1
+  ORDER BY p.processed_at ASC
+  LIMIT $2
+)
 `
 
-type CreateOrigVideoParams struct {
-	VideoID   pgtype.Text
-	VideoHash pgtype.Text
+type PruneProcessedMessagesParams struct {
+	ProcessedBefore pgtype.Timestamptz
+	MaxRows         int32
 }
 
-func (q *Queries) CreateOrigVideo(ctx context.Context, arg CreateOrigVideoParams) (Origvideo, error) {
-	row := q.db.QueryRow(ctx, createOrigVideo, arg.VideoID, arg.VideoHash)
-	var i Origvideo
-	err := row.Scan(&i.VideoID, &i.VideoHash)
-	return i, err
+func (q *Queries) PruneProcessedMessages(ctx context.Context, arg PruneProcessedMessagesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, pruneProcessedMessages, arg.ProcessedBefore, arg.MaxRows)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const createTask = `-- name: CreateTask :one
-INSERT INTO task (
-  video_file, audio_file, preview_id, status, video_name
+const prunePushedResults = `-- name: PrunePushedResults :execrows
+DELETE FROM pushed_result
+WHERE (modality, result_id) IN (
+  SELECT p.modality, p.result_id FROM pushed_result p
+  WHERE p.pushed_at < $1
+  ORDER BY p.pushed_at ASC
+  LIMIT $2
+)
+`
+
+type PrunePushedResultsParams struct {
+	PushedBefore pgtype.Timestamptz
+	MaxRows      int32
+}
+
+func (q *Queries) PrunePushedResults(ctx context.Context, arg PrunePushedResultsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, prunePushedResults, arg.PushedBefore, arg.MaxRows)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const pruneSentOutbox = `-- name: PruneSentOutbox :execrows
+DELETE FROM outbox
+WHERE id IN (
+  SELECT id FROM outbox
+  WHERE sent_at < $1
+  ORDER BY sent_at ASC
+  LIMIT $2
+)
+`
+
+type PruneSentOutboxParams struct {
+	SentBefore pgtype.Timestamptz
+	MaxRows    int32
+}
+
+func (q *Queries) PruneSentOutbox(ctx context.Context, arg PruneSentOutboxParams) (int64, error) {
+	result, err := q.db.Exec(ctx, pruneSentOutbox, arg.SentBefore, arg.MaxRows)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeTask = `-- name: PurgeTask :execrows
+WITH
+  resource_usage AS (DELETE FROM task_resource_usage WHERE task_resource_usage.task_id = $1),
+  registrations AS (DELETE FROM reference_registration WHERE reference_registration.task_id = $1),
+  checks AS (DELETE FROM reference_check WHERE reference_check.task_id = $1 OR check_task_id = $1),
+  outbox_rows AS (DELETE FROM outbox WHERE outbox.task_id = $1),
+  hashes AS (DELETE FROM task_hash WHERE task_hash.task_id = $1),
+  requests AS (DELETE FROM modality_request WHERE modality_request.task_id = $1),
+  rechecks AS (DELETE FROM task_recheck WHERE task_recheck.task_id = $1 OR recheck_task_id = $1),
+  sprites AS (DELETE FROM task_sprite WHERE task_sprite.task_id = $1),
+  metadata AS (DELETE FROM task_metadata WHERE task_metadata.task_id = $1),
+  scenes AS (DELETE FROM task_scene WHERE task_scene.task_id = $1),
+  fingerprints AS (DELETE FROM task_audio_fingerprint WHERE task_audio_fingerprint.task_id = $1),
+  events AS (DELETE FROM task_event WHERE task_event.task_id = $1),
+  timings AS (DELETE FROM task_stage_timing WHERE task_stage_timing.task_id = $1),
+  batch_rows AS (UPDATE batch_row SET task_id = NULL WHERE batch_row.task_id = $1),
+  children AS (UPDATE task SET parent_task_id = NULL WHERE parent_task_id = $1)
+DELETE FROM task
+WHERE task.task_id = $1
+  AND task.version = $2
+  AND deleted_at IS NOT NULL
+`
+
+type PurgeTaskParams struct {
+	TaskID  int64
+	Version int64
+}
+
+func (q *Queries) PurgeTask(ctx context.Context, arg PurgeTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeTask, arg.TaskID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordModalityRequest = `-- name: RecordModalityRequest :exec
+INSERT INTO modality_request (
+  task_id, modality
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2
 )
-RETURNING task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  attempts = modality_request.attempts + 1,
+  sent_at = now()
 `
 
-type CreateTaskParams struct {
-	VideoFile pgtype.Text
-	AudioFile pgtype.Text
-	PreviewID pgtype.Text
-	Status    NullTaskStatus
-	VideoName pgtype.Text
+type RecordModalityRequestParams struct {
+	TaskID   int64
+	Modality string
 }
 
-func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (Task, error) {
-	row := q.db.QueryRow(ctx, createTask,
-		arg.VideoFile,
-		arg.AudioFile,
-		arg.PreviewID,
-		arg.Status,
-		arg.VideoName,
+func (q *Queries) RecordModalityRequest(ctx context.Context, arg RecordModalityRequestParams) error {
+	_, err := q.db.Exec(ctx, recordModalityRequest, arg.TaskID, arg.Modality)
+	return err
+}
+
+const recordOutboxFailure = `-- name: RecordOutboxFailure :exec
+UPDATE outbox SET
+  attempts = attempts + 1,
+  last_error = $1,
+  retry_at = $2,
+  parked_at = CASE WHEN $3::boolean THEN now() END
+WHERE id = $4
+`
+
+type RecordOutboxFailureParams struct {
+	LastError pgtype.Text
+	RetryAt   pgtype.Timestamptz
+	Park      bool
+	ID        int64
+}
+
+func (q *Queries) RecordOutboxFailure(ctx context.Context, arg RecordOutboxFailureParams) error {
+	_, err := q.db.Exec(ctx, recordOutboxFailure,
+		arg.LastError,
+		arg.RetryAt,
+		arg.Park,
+		arg.ID,
 	)
-	var i Task
-	err := row.Scan(
-		&i.TaskID,
-		&i.VideoName,
-		&i.AudioFile,
-		&i.VideoFile,
-		&i.PreviewID,
-		&i.Status,
-		&i.AudioCopyright,
-		&i.VideoCopyright,
+	return err
+}
+
+const releaseApiKeyTask = `-- name: ReleaseApiKeyTask :exec
+UPDATE api_key_usage SET
+  tasks = tasks - 1,
+  updated_at = now()
+WHERE api_key_id = $1
+  AND tasks > 0
+`
+
+func (q *Queries) ReleaseApiKeyTask(ctx context.Context, apiKeyID string) error {
+	_, err := q.db.Exec(ctx, releaseApiKeyTask, apiKeyID)
+	return err
+}
+
+const releaseObjectRef = `-- name: ReleaseObjectRef :one
+UPDATE object_ref SET refs = refs - 1
+WHERE bucket = $1 AND object_key = $2 AND refs > 0
+RETURNING refs
+`
+
+type ReleaseObjectRefParams struct {
+	Bucket    string
+	ObjectKey string
+}
+
+func (q *Queries) ReleaseObjectRef(ctx context.Context, arg ReleaseObjectRefParams) (int32, error) {
+	row := q.db.QueryRow(ctx, releaseObjectRef, arg.Bucket, arg.ObjectKey)
+	var refs int32
+	err := row.Scan(&refs)
+	return refs, err
+}
+
+const reserveApiKeyTask = `-- name: ReserveApiKeyTask :one
+INSERT INTO api_key_usage (
+  api_key_id, tasks
+) VALUES (
+  $1, 1
+)
+ON CONFLICT (api_key_id) DO UPDATE SET
+  tasks = api_key_usage.tasks + 1,
+  updated_at = now()
+WHERE ($2::bigint = 0 OR api_key_usage.tasks < $2::bigint)
+  AND ($3::double precision = 0 OR api_key_usage.video_seconds < $3::double precision)
+  AND ($4::bigint = 0 OR api_key_usage.bytes_stored < $4::bigint)
+RETURNING tasks
+`
+
+type ReserveApiKeyTaskParams struct {
+	ApiKeyID        string
+	MaxTasks        int64
+	MaxVideoSeconds float64
+	MaxBytes        int64
+}
+
+func (q *Queries) ReserveApiKeyTask(ctx context.Context, arg ReserveApiKeyTaskParams) (int64, error) {
+	row := q.db.QueryRow(ctx, reserveApiKeyTask,
+		arg.ApiKeyID,
+		arg.MaxTasks,
+		arg.MaxVideoSeconds,
+		arg.MaxBytes,
 	)
-	return i, err
+	var tasks int64
+	err := row.Scan(&tasks)
+	return tasks, err
 }
 
-const getOrigVideo = `-- name: GetOrigVideo :one
-SELECT video_id, video_hash FROM origvideo
-WHERE video_id = $1 LIMIT 1
+const reserveTaskID = `-- name: ReserveTaskID :one
+SELECT nextval(pg_get_serial_sequence('task', 'task_id'))::bigint AS task_id
 `
 
-func (q *Queries) GetOrigVideo(ctx context.Context, videoID pgtype.Text) (Origvideo, error) {
-	row := q.db.QueryRow(ctx, getOrigVideo, videoID)
-	var i Origvideo
-	err := row.Scan(&i.VideoID, &i.VideoHash)
-	return i, err
+func (q *Queries) ReserveTaskID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, reserveTaskID)
+	var task_id int64
+	err := row.Scan(&task_id)
+	return task_id, err
 }
 
-const getOrigVideos = `-- name: GetOrigVideos :many
-SELECT video_id, video_hash FROM origvideo
-ORDER BY video_id DESC
-LIMIT $1 OFFSET $2
+const resetModalityRequest = `-- name: ResetModalityRequest :exec
+INSERT INTO modality_request (
+  task_id, modality, attempts
+) VALUES (
+  $1, $2, 0
+)
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  attempts = 0,
+  requested_at = now(),
+  sent_at = now(),
+  received_at = NULL
 `
 
-type GetOrigVideosParams struct {
-	Limit  int32
-	Offset int32
+type ResetModalityRequestParams struct {
+	TaskID   int64
+	Modality string
 }
 
-func (q *Queries) GetOrigVideos(ctx context.Context, arg GetOrigVideosParams) ([]Origvideo, error) {
-	rows, err := q.db.Query(ctx, getOrigVideos, arg.Limit, arg.Offset)
+func (q *Queries) ResetModalityRequest(ctx context.Context, arg ResetModalityRequestParams) error {
+	_, err := q.db.Exec(ctx, resetModalityRequest, arg.TaskID, arg.Modality)
+	return err
+}
+
+const restoreTask = `-- name: RestoreTask :execrows
+UPDATE task SET
+  deleted_at = NULL,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $2
+  AND deleted_at IS NOT NULL
+`
+
+type RestoreTaskParams struct {
+	TaskID  int64
+	Version int64
+}
+
+func (q *Queries) RestoreTask(ctx context.Context, arg RestoreTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreTask, arg.TaskID, arg.Version)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
-	var items []Origvideo
-	for rows.Next() {
-		var i Origvideo
-		if err := rows.Scan(&i.VideoID, &i.VideoHash); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	return result.RowsAffected(), nil
+}
+
+const revokeBatchTokens = `-- name: RevokeBatchTokens :execrows
+UPDATE batch_token SET revoked_at = now()
+WHERE batch_id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeBatchTokens(ctx context.Context, batchID int64) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeBatchTokens, batchID)
+	if err != nil {
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const getOrigVideosByHash = `-- name: GetOrigVideosByHash :many
-SELECT video_id, video_hash FROM origvideo
-WHERE video_hash = $1
-ORDER BY video_id DESC
+const searchOrigVideos = `-- name: SearchOrigVideos :many
+SELECT video_id, video_hash, sample_hash, perceptual_hash, audio_fingerprint, id, registered_at FROM origvideo
+WHERE id > $1
+  AND ($2::text IS NULL OR video_id ILIKE '%' || $2 || '%')
+  AND ($3::text IS NULL OR video_hash = $3)
+  AND ($4::timestamptz IS NULL OR registered_at >= $4)
+  AND ($5::timestamptz IS NULL OR registered_at < $5)
+ORDER BY id ASC
+LIMIT $6
 `
 
-func (q *Queries) GetOrigVideosByHash(ctx context.Context, videoHash pgtype.Text) ([]Origvideo, error) {
-	rows, err := q.db.Query(ctx, getOrigVideosByHash, videoHash)
+type SearchOrigVideosParams struct {
+	ID               int64
+	Name             pgtype.Text
+	VideoHash        pgtype.Text
+	RegisteredAfter  pgtype.Timestamptz
+	RegisteredBefore pgtype.Timestamptz
+	MaxRows          int32
+}
+
+func (q *Queries) SearchOrigVideos(ctx context.Context, arg SearchOrigVideosParams) ([]Origvideo, error) {
+	rows, err := q.db.Query(ctx, searchOrigVideos,
+		arg.ID,
+		arg.Name,
+		arg.VideoHash,
+		arg.RegisteredAfter,
+		arg.RegisteredBefore,
+		arg.MaxRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +3163,15 @@ func (q *Queries) GetOrigVideosByHash(ctx context.Context, videoHash pgtype.Text
 	var items []Origvideo
 	for rows.Next() {
 		var i Origvideo
-		if err := rows.Scan(&i.VideoID, &i.VideoHash); err != nil {
+		if err := rows.Scan(
+			&i.VideoID,
+			&i.VideoHash,
+			&i.SampleHash,
+			&i.PerceptualHash,
+			&i.AudioFingerprint,
+			&i.ID,
+			&i.RegisteredAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -140,40 +3182,33 @@ func (q *Queries) GetOrigVideosByHash(ctx context.Context, videoHash pgtype.Text
 	return items, nil
 }
 
-const getTask = `-- name: GetTask :one
-SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright FROM task
-WHERE task_id = $1 LIMIT 1
-`
-
-func (q *Queries) GetTask(ctx context.Context, taskID int64) (Task, error) {
-	row := q.db.QueryRow(ctx, getTask, taskID)
-	var i Task
-	err := row.Scan(
-		&i.TaskID,
-		&i.VideoName,
-		&i.AudioFile,
-		&i.VideoFile,
-		&i.PreviewID,
-		&i.Status,
-		&i.AudioCopyright,
-		&i.VideoCopyright,
-	)
-	return i, err
-}
-
-const getTasks = `-- name: GetTasks :many
-SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright FROM task
+const searchTasks = `-- name: SearchTasks :many
+SELECT task_id, video_name, audio_file, video_file, preview_id, status, audio_copyright, video_copyright, index_version, parent_task_id, source_ip, user_agent, api_key_id, download_verification, trace_id, created_at, failure_reason, updated_at, deadline_at, stage, priority, deleted_at, version, duration_seconds, width, height, file_size, content_hash FROM task
+WHERE task_id > $1
+  AND ($2::text IS NULL OR source_ip = $2)
+  AND ($3::text IS NULL OR user_agent ILIKE '%' || $3 || '%')
+  AND ($4::text IS NULL OR api_key_id = $4)
+  AND deleted_at IS NULL
 ORDER BY task_id ASC
-LIMIT $1 OFFSET $2
+LIMIT $5
 `
 
-type GetTasksParams struct {
-	Limit  int32
-	Offset int32
+type SearchTasksParams struct {
+	TaskID    int64
+	SourceIp  pgtype.Text
+	UserAgent pgtype.Text
+	ApiKeyID  pgtype.Text
+	MaxRows   int32
 }
 
-func (q *Queries) GetTasks(ctx context.Context, arg GetTasksParams) ([]Task, error) {
-	rows, err := q.db.Query(ctx, getTasks, arg.Limit, arg.Offset)
+func (q *Queries) SearchTasks(ctx context.Context, arg SearchTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, searchTasks,
+		arg.TaskID,
+		arg.SourceIp,
+		arg.UserAgent,
+		arg.ApiKeyID,
+		arg.MaxRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -190,6 +3225,26 @@ func (q *Queries) GetTasks(ctx context.Context, arg GetTasksParams) ([]Task, err
 			&i.Status,
 			&i.AudioCopyright,
 			&i.VideoCopyright,
+			&i.IndexVersion,
+			&i.ParentTaskID,
+			&i.SourceIp,
+			&i.UserAgent,
+			&i.ApiKeyID,
+			&i.DownloadVerification,
+			&i.TraceID,
+			&i.CreatedAt,
+			&i.FailureReason,
+			&i.UpdatedAt,
+			&i.DeadlineAt,
+			&i.Stage,
+			&i.Priority,
+			&i.DeletedAt,
+			&i.Version,
+			&i.DurationSeconds,
+			&i.Width,
+			&i.Height,
+			&i.FileSize,
+			&i.ContentHash,
 		); err != nil {
 			return nil, err
 		}
@@ -201,34 +3256,174 @@ func (q *Queries) GetTasks(ctx context.Context, arg GetTasksParams) ([]Task, err
 	return items, nil
 }
 
-const getTasksCount = `-- name: GetTasksCount :one
-SELECT count(*) FROM task
+const setAuditContext = `-- name: SetAuditContext :exec
+SELECT set_config('bff.actor', $1::text, true), set_config('bff.reason', $2::text, true)
 `
 
-func (q *Queries) GetTasksCount(ctx context.Context) (int64, error) {
-	row := q.db.QueryRow(ctx, getTasksCount)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
+type SetAuditContextParams struct {
+	Actor  string
+	Reason string
+}
+
+func (q *Queries) SetAuditContext(ctx context.Context, arg SetAuditContextParams) error {
+	_, err := q.db.Exec(ctx, setAuditContext, arg.Actor, arg.Reason)
+	return err
+}
+
+const setBatchRowTask = `-- name: SetBatchRowTask :exec
+UPDATE batch_row SET task_id = $3
+WHERE batch_id = $1
+  AND row_no = $2
+`
+
+type SetBatchRowTaskParams struct {
+	BatchID int64
+	RowNo   int32
+	TaskID  pgtype.Int8
+}
+
+func (q *Queries) SetBatchRowTask(ctx context.Context, arg SetBatchRowTaskParams) error {
+	_, err := q.db.Exec(ctx, setBatchRowTask, arg.BatchID, arg.RowNo, arg.TaskID)
+	return err
+}
+
+const setTaskStage = `-- name: SetTaskStage :exec
+UPDATE task SET
+  stage = $2,
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND status = 'in_progress'
+`
+
+type SetTaskStageParams struct {
+	TaskID int64
+	Stage  TaskStage
+}
+
+func (q *Queries) SetTaskStage(ctx context.Context, arg SetTaskStageParams) error {
+	_, err := q.db.Exec(ctx, setTaskStage, arg.TaskID, arg.Stage)
+	return err
+}
+
+const shareObjectRef = `-- name: ShareObjectRef :exec
+UPDATE object_ref SET refs = refs + 1
+WHERE bucket = $1 AND object_key = $2 AND refs > 0
+`
+
+type ShareObjectRefParams struct {
+	Bucket    string
+	ObjectKey string
+}
+
+func (q *Queries) ShareObjectRef(ctx context.Context, arg ShareObjectRefParams) error {
+	_, err := q.db.Exec(ctx, shareObjectRef, arg.Bucket, arg.ObjectKey)
+	return err
+}
+
+const softDeleteTask = `-- name: SoftDeleteTask :execrows
+UPDATE task SET
+  deleted_at = now(),
+  version = version + 1,
+  updated_at = now()
+WHERE task_id = $1
+  AND version = $2
+  AND deleted_at IS NULL
+`
+
+type SoftDeleteTaskParams struct {
+	TaskID  int64
+	Version int64
+}
+
+func (q *Queries) SoftDeleteTask(ctx context.Context, arg SoftDeleteTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, softDeleteTask, arg.TaskID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const startTask = `-- name: StartTask :exec
+INSERT INTO task (
+  task_id, video_name, status, stage, source_ip, user_agent, api_key_id, deadline_at, priority
+) VALUES (
+  $1, $2, 'in_progress', 'uploading', $3, $4, $5, $6, $7
+)
+`
+
+type StartTaskParams struct {
+	TaskID     int64
+	VideoName  pgtype.Text
+	SourceIp   pgtype.Text
+	UserAgent  pgtype.Text
+	ApiKeyID   pgtype.Text
+	DeadlineAt pgtype.Timestamptz
+	Priority   TaskPriority
+}
+
+func (q *Queries) StartTask(ctx context.Context, arg StartTaskParams) error {
+	_, err := q.db.Exec(ctx, startTask,
+		arg.TaskID,
+		arg.VideoName,
+		arg.SourceIp,
+		arg.UserAgent,
+		arg.ApiKeyID,
+		arg.DeadlineAt,
+		arg.Priority,
+	)
+	return err
+}
+
+const startTaskStage = `-- name: StartTaskStage :exec
+INSERT INTO task_stage_timing (
+  task_id, stage
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id, stage) DO UPDATE SET
+  started_at = now(),
+  finished_at = NULL
+`
+
+type StartTaskStageParams struct {
+	TaskID int64
+	Stage  string
+}
+
+func (q *Queries) StartTaskStage(ctx context.Context, arg StartTaskStageParams) error {
+	_, err := q.db.Exec(ctx, startTaskStage, arg.TaskID, arg.Stage)
+	return err
 }
 
-const updateTaskAudioCopyright = `-- name: UpdateTaskAudioCopyright :exec
-UPDATE task SET audio_copyright = $2
+const updateTaskAudioCopyright = `-- name: UpdateTaskAudioCopyright :execrows
+UPDATE task SET
+  audio_copyright = $2,
+  version = version + 1,
+  updated_at = now()
 WHERE task_id = $1
+  AND version = $3
 `
 
 type UpdateTaskAudioCopyrightParams struct {
 	TaskID         int64
 	AudioCopyright []byte
+	Version        int64
 }
 
-func (q *Queries) UpdateTaskAudioCopyright(ctx context.Context, arg UpdateTaskAudioCopyrightParams) error {
-	_, err := q.db.Exec(ctx, updateTaskAudioCopyright, arg.TaskID, arg.AudioCopyright)
-	return err
+func (q *Queries) UpdateTaskAudioCopyright(ctx context.Context, arg UpdateTaskAudioCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateTaskAudioCopyright, arg.TaskID, arg.AudioCopyright, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const updateTaskStatus = `-- name: UpdateTaskStatus :exec
-UPDATE task SET status = $2
+UPDATE task SET
+  status = $2,
+  version = version + 1,
+  updated_at = now()
 WHERE task_id = $1
 `
 
@@ -242,17 +3437,78 @@ func (q *Queries) UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusPara
 	return err
 }
 
-const updateTaskVideoCopyright = `-- name: UpdateTaskVideoCopyright :exec
-UPDATE task SET video_copyright = $2
+const updateTaskVideoCopyright = `-- name: UpdateTaskVideoCopyright :execrows
+UPDATE task SET
+  video_copyright = $2,
+  version = version + 1,
+  updated_at = now()
 WHERE task_id = $1
+  AND version = $3
 `
 
 type UpdateTaskVideoCopyrightParams struct {
 	TaskID         int64
 	VideoCopyright []byte
+	Version        int64
+}
+
+func (q *Queries) UpdateTaskVideoCopyright(ctx context.Context, arg UpdateTaskVideoCopyrightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateTaskVideoCopyright, arg.TaskID, arg.VideoCopyright, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upsertReferenceCheck = `-- name: UpsertReferenceCheck :exec
+INSERT INTO reference_check (
+  task_id, check_task_id
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (task_id) DO UPDATE SET
+  check_task_id = EXCLUDED.check_task_id,
+  checked_at = now()
+`
+
+type UpsertReferenceCheckParams struct {
+	TaskID      int64
+	CheckTaskID int64
+}
+
+func (q *Queries) UpsertReferenceCheck(ctx context.Context, arg UpsertReferenceCheckParams) error {
+	_, err := q.db.Exec(ctx, upsertReferenceCheck, arg.TaskID, arg.CheckTaskID)
+	return err
+}
+
+const upsertReferenceRegistration = `-- name: UpsertReferenceRegistration :exec
+INSERT INTO reference_registration (
+  task_id, modality, status, attempts, last_error
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (task_id, modality) DO UPDATE SET
+  status = EXCLUDED.status,
+  attempts = reference_registration.attempts + EXCLUDED.attempts,
+  last_error = EXCLUDED.last_error,
+  updated_at = now()
+`
+
+type UpsertReferenceRegistrationParams struct {
+	TaskID    int64
+	Modality  string
+	Status    string
+	Attempts  int32
+	LastError pgtype.Text
 }
 
-func (q *Queries) UpdateTaskVideoCopyright(ctx context.Context, arg UpdateTaskVideoCopyrightParams) error {
-	_, err := q.db.Exec(ctx, updateTaskVideoCopyright, arg.TaskID, arg.VideoCopyright)
+func (q *Queries) UpsertReferenceRegistration(ctx context.Context, arg UpsertReferenceRegistrationParams) error {
+	_, err := q.db.Exec(ctx, upsertReferenceRegistration,
+		arg.TaskID,
+		arg.Modality,
+		arg.Status,
+		arg.Attempts,
+		arg.LastError,
+	)
 	return err
 }
@@ -0,0 +1,165 @@
+package submissions
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/gulldan/cp2024yappy/bff/internal/model"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/csvdialect"
+	"github.com/rs/zerolog"
+)
+
+// ErrMalformedRow is returned for a submission row that cannot be parsed.
+var ErrMalformedRow = errors.New("malformed submission row")
+
+// Importer records historical decisions; it reports false for a decision that is already recorded.
+type Importer interface {
+	ImportDecision(ctx context.Context, d model.Decision) (bool, error)
+}
+
+// columns is the column order of files without a header, as the CSV run writes them.
+var columns = []string{"created", "uuid", "link", "is_duplicate", "duplicate_for"}
+
+// Run parses the command line arguments and imports the decisions of the submission files, mapping
+// their header names by aliases. Rows that fail are logged and skipped; Run fails when any row did.
+func Run(ctx context.Context, imp Importer, aliases map[string]string, args []string, log *zerolog.Logger) error {
+	fs := flag.NewFlagSet("import-submissions", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: bff import-submissions file.csv...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no submission files given")
+	}
+
+	var imported, skipped, failed int
+	for _, path := range fs.Args() {
+		decisions, rowErrs, err := readFile(path, aliases)
+		if err != nil {
+			return err
+		}
+		for _, err := range rowErrs {
+			log.Error().Err(err).Str("file", path).Msg("skip row")
+		}
+		failed += len(rowErrs)
+
+		for _, d := range decisions {
+			ok, err := imp.ImportDecision(ctx, d)
+			switch {
+			case err != nil:
+				failed++
+				log.Error().Err(err).Str("file", path).Str("uuid", d.UUID).Msg("import decision failed")
+			case ok:
+				imported++
+			default:
+				skipped++
+			}
+		}
+	}
+
+	log.Info().Int("imported", imported).Int("skipped", skipped).Int("failed", failed).Msg("submissions imported")
+	if failed != 0 {
+		return fmt.Errorf("%d rows failed", failed)
+	}
+
+	return nil
+}
+
+// readFile parses the decisions of a submission file in the dialect sniffed from it. The header is
+// optional and its names may be mapped by aliases; without one the columns are expected in the order
+// the CSV run writes them. Malformed rows are returned as errors.
+func readFile(path string, aliases map[string]string) ([]model.Decision, []error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open submission file failed: %w", err)
+	}
+	defer f.Close()
+
+	r, err := csvdialect.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read submission file failed: %w", err)
+	}
+
+	// Map the columns by the header, when there is one.
+	first, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read submission file failed: %w", err)
+	}
+	index := csvdialect.MapHeader(first, aliases)
+	hasHeader := index.Has("uuid")
+	if !hasHeader {
+		index = csvdialect.Positional(columns...)
+	}
+
+	var decisions []model.Decision
+	var rowErrs []error
+	line := 1
+	if !hasHeader {
+		d, err := parseRow(first, index, path)
+		if err != nil {
+			rowErrs = append(rowErrs, fmt.Errorf("line %d: %w", line, err))
+		} else {
+			decisions = append(decisions, d)
+		}
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, nil, fmt.Errorf("read submission file failed: %w", err)
+		}
+
+		d, err := parseRow(record, index, path)
+		if err != nil {
+			rowErrs = append(rowErrs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		decisions = append(decisions, d)
+	}
+
+	return decisions, rowErrs, nil
+}
+
+// parseRow converts a submission row to a decision.
+func parseRow(record []string, index csvdialect.Columns, path string) (model.Decision, error) {
+	d := model.Decision{
+		UUID: index.Field(record, "uuid"),
+		Link: index.Field(record, "link"),
+		File: path,
+	}
+	if d.UUID == "" {
+		return model.Decision{}, fmt.Errorf("%w: empty uuid", ErrMalformedRow)
+	}
+
+	created, err := csvdialect.ParseTime(index.Field(record, "created"))
+	if err != nil {
+		return model.Decision{}, fmt.Errorf("%w: created: %w", ErrMalformedRow, err)
+	}
+	d.Created = created
+
+	duplicate, err := strconv.ParseBool(index.Field(record, "is_duplicate"))
+	if err != nil {
+		return model.Decision{}, fmt.Errorf("%w: is_duplicate: %w", ErrMalformedRow, err)
+	}
+	if duplicate {
+		d.DuplicateFor = index.Field(record, "duplicate_for")
+		if d.DuplicateFor == "" {
+			return model.Decision{}, fmt.Errorf("%w: duplicate without duplicate_for", ErrMalformedRow)
+		}
+	}
+
+	return d, nil
+}
@@ -3,54 +3,188 @@ package main
 import (
 	"context"
 	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/controller/task_controller"
+	"github.com/gulldan/cp2024yappy/bff/internal/loadtest"
+	"github.com/gulldan/cp2024yappy/bff/internal/submissions"
 	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 )
 
-//go:embed swagger-ui/docs
-var swaggerDocsFS embed.FS
+//go:embed swagger-ui/docs dashboard
+var staticFS embed.FS
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+		if err := loadtest.Run(context.Background(), os.Args[2:], &log); err != nil {
+			log.Error().Err(err).Msg("load test failed")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		gin.SetMode(gin.ReleaseMode)
+		if err := json.NewEncoder(os.Stdout).Encode(newSpec(&API{})); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	cfg, logLevel, err := config.InitConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	log := zerolog.New(os.Stdout).Level(*logLevel).With().Timestamp().Logger()
-	a, err := New(cfg, &log, &swaggerDocsFS)
+	if len(os.Args) > 1 && os.Args[1] == "import-submissions" {
+		log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(*logLevel).With().Timestamp().Logger()
+		if err := importSubmissions(cfg, os.Args[2:], &log); err != nil {
+			log.Error().Err(err).Msg("import submissions failed")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(*logLevel).With().Timestamp().Logger()
+		if err := runMigrate(cfg, os.Args[2:], &log); err != nil {
+			log.Error().Err(err).Msg("migrate failed")
+			os.Exit(1)
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("bff", flag.ExitOnError)
+	role := fs.String("role", cfg.Role, "what to run: api serves HTTP, worker consumes copyright results, all runs both")
+	_ = fs.Parse(os.Args[1:])
+
+	log := zerolog.New(os.Stdout).Level(*logLevel).With().Timestamp().Str("role", *role).Logger()
+
+	log.Debug().Interface("config", cfg.Redacted()).Msg("config loaded")
+
+	runAPI, runWorker, err := parseRole(*role)
 	if err != nil {
-		log.Error().Err(err).Msg("start http server failed")
+		log.Error().Err(err).Msg("invalid role")
+		os.Exit(2)
+	}
+
+	ctl, err := taskcontroller.New(cfg, &log)
+	if err != nil {
+		log.Error().Err(err).Msg("create task controller failed")
 		return
 	}
+	defer ctl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Publish the requests of new tasks, including those a previous run left unsent.
+	ctl.StartOutboxRelay(ctx)
+
+	// Check the storage periodically for the readiness probe.
+	ctl.StartHealthChecks(ctx)
+
+	if runWorker {
+		ctl.StartConsumers(ctx)
+	}
 
 	go func() {
-		err = a.Start()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		// Workers serve no API, so the readiness probe is also served here.
+		mux.Handle("/readyz", readyz(ctl))
+		if err := http.ListenAndServe(":"+cfg.MetricsPort, mux); err != nil {
+			log.Error().Err(err).Msg("start metrics server failed")
+		}
+	}()
+
+	var a *API
+	if runAPI {
+		a, err = New(cfg, &log, &staticFS, ctl)
 		if err != nil {
 			log.Error().Err(err).Msg("start http server failed")
+			return
 		}
-	}()
 
-	if err := gracefulShutdown(&log); err != nil {
+		// Check the rows of submitted batches, including those a previous run left unchecked.
+		a.StartBatchWorkers(ctx)
+
+		go func() {
+			if err := a.Start(); err != nil {
+				log.Error().Err(err).Msg("start http server failed")
+			}
+		}()
+	}
+
+	if err := gracefulShutdown(&log, cfg.Server, a, ctl); err != nil {
 		log.Error().Err(err).Msg("graceful shutdown failed")
 	}
 }
 
-func gracefulShutdown(logger *zerolog.Logger) error {
+// importSubmissions records the decisions of previous submission files as historical tasks.
+func importSubmissions(cfg *config.Config, args []string, log *zerolog.Logger) error {
+	ctl, err := taskcontroller.New(cfg, log)
+	if err != nil {
+		return fmt.Errorf("create task controller failed: %w", err)
+	}
+	defer ctl.Close()
+
+	return submissions.Run(taskcontroller.WithActor(context.Background(), "import-submissions"), ctl, cfg.Batch.Columns, args, log)
+}
+
+// Process roles.
+const (
+	roleAPI    = "api"
+	roleWorker = "worker"
+	roleAll    = "all"
+)
+
+// parseRole reports whether the role serves HTTP and whether it consumes results.
+func parseRole(role string) (api, worker bool, err error) {
+	switch role {
+	case roleAPI:
+		return true, false, nil
+	case roleWorker:
+		return false, true, nil
+	case roleAll:
+		return true, true, nil
+	default:
+		return false, false, fmt.Errorf("unknown role %q, want api, worker or all", role)
+	}
+}
+
+// gracefulShutdown waits for a termination signal and lets the API, when it runs, finish in-flight requests.
+// Running ffmpeg jobs get a grace period before they are killed. The Kafka readers are closed next, their
+// handled messages committed; requests the outbox relay has not published by then are published on the
+// next start.
+func gracefulShutdown(logger *zerolog.Logger, cfg config.ServerConfig, a *API, ctl *taskcontroller.TaskController) error {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
-
 	sig := <-sigs
 	logger.Info().Str("signal", sig.String()).Msg("signal received, graceful shutdown")
 
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
-	return nil
+	ctl.KillJobsAfter(cfg.JobGracePeriod)
+
+	var err error
+	if a != nil {
+		err = a.Shutdown(ctx)
+	}
+
+	// Stop consuming once the requests waiting for results have ended.
+	return errors.Join(err, ctl.StopConsumers(ctx), ctl.Drain(ctx))
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	pgsql "github.com/gulldan/cp2024yappy/bff/internal/repository/postgres"
+	"github.com/gulldan/cp2024yappy/bff/internal/repository/postgres/migrations"
+	"github.com/gulldan/cp2024yappy/bff/pkg/config"
+	"github.com/rs/zerolog"
+)
+
+// errMigrateUsage is returned for a migrate subcommand called with invalid arguments.
+var errMigrateUsage = errors.New("invalid migrate arguments")
+
+// runMigrate controls the schema migrations of the database by hand: up applies the missing ones,
+// down reverts the last n, one by default, version prints the version of the database, and force
+// records the database at a version without applying anything, clearing a migration that failed halfway.
+func runMigrate(cfg *config.Config, args []string, log *zerolog.Logger) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: bff migrate up | down [n] | version | force version")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errMigrateUsage
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgsql.NewPool(ctx, cfg.Postgres)
+	if err != nil {
+		return fmt.Errorf("postgres connect failed: %w", err)
+	}
+	defer pool.Close()
+
+	migrator, err := migrations.New(pool, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := migrator.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close migrator")
+		}
+	}()
+
+	switch cmd, rest := fs.Arg(0), fs.Args()[1:]; {
+	case cmd == "up" && len(rest) == 0:
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("applied", applied).Int64("version", migrator.Latest()).Msg("schema up to date")
+	case cmd == "down" && len(rest) <= 1:
+		steps := 1
+		if len(rest) == 1 {
+			if steps, err = strconv.Atoi(rest[0]); err != nil || steps < 1 {
+				return fmt.Errorf("%w: down takes a positive number of migrations", errMigrateUsage)
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("reverted", reverted).Msg("migrations reverted")
+	case cmd == "version" && len(rest) == 0:
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Println(version, "(dirty: the migration failed halfway, fix it by hand and force the version)")
+		} else {
+			fmt.Println(version)
+		}
+	case cmd == "force" && len(rest) == 1:
+		version, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: force takes a version", errMigrateUsage)
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		log.Info().Int64("version", version).Msg("schema version forced")
+	default:
+		fs.Usage()
+		return errMigrateUsage
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"io/fs"
+	"strings"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/rs/zerolog"
@@ -10,41 +12,505 @@ import (
 
 type Config struct {
 	LogLevel string `yaml:"LOG_LEVEL" env:"LOG_LEVEL" env-default:"info"`
+	// Role selects what the process runs: api, worker or all; the --role flag overrides it.
+	Role string `yaml:"ROLE" env:"ROLE" env-default:"all"`
 
 	Grpc          GrpcConfig `yaml:"http"`
 	Minio         MinioConfig
+	Storage       StorageConfig
+	Retention     RetentionConfig
 	Postgres      PostgresConfig
 	Kafka         KafkaConfig
-	HTTPPort      string `env:"HTTP_PORT" env-default:"8888"`
+	Temp          TempConfig
+	FFmpeg        FFmpegConfig
+	Download      DownloadConfig
+	Auth          AuthConfig
+	RateLimit     RateLimitConfig
+	Quota         QuotaConfig
+	Upload        UploadConfig
+	Registration  RegistrationConfig
+	Dedup         DedupConfig
+	Hash          HashConfig
+	Audio         AudioConfig
+	Loudness      LoudnessConfig
+	Preview       PreviewConfig
+	Playback      PlaybackConfig
+	Scenes        SceneConfig
+	Server        ServerConfig
+	Outbox        OutboxConfig
+	Verdict       VerdictConfig
+	Modality      ModalityConfig
+	Decision      DecisionConfig
+	Similarity    SimilarityConfig
+	Recheck       RecheckConfig
+	Batch         BatchConfig
+	Secrets       SecretsConfig
+	MetricsPort   string `env:"METRICS_PORT" env-default:"3737"`
+	HTTPPort      string `env:"HTTP_PORT" env-default:"7083"`
 	Wav2VecAddr   string `env:"WAV2VEC_ADDR" env-default:"wav2vec:8000"`
 	VideocopyAddr string `env:"VIDEOCOPY_ADDR" env-default:"video_copy:8000"`
+	IndexVersion  string `env:"INDEX_VERSION" env-default:"v1"`
+	// TraceURLTemplate links task responses to their trace; {trace_id} is replaced with the trace ID,
+	// e.g. https://jaeger.example.com/trace/{trace_id}.
+	TraceURLTemplate string `env:"TRACE_URL_TEMPLATE"`
+	// LegacyAPISunset is the HTTP-date announced in the Sunset header of unversioned routes.
+	LegacyAPISunset string `env:"LEGACY_API_SUNSET"`
+	// TrustedProxies are the networks whose X-Forwarded-For header is trusted for the client IP.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" env-default:"127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"`
 }
 
 type KafkaConfig struct {
+	// Brokers are the bootstrap brokers of the cluster, e.g. "kafka-1:9092,kafka-2:9092".
+	Brokers []string `yaml:"kafka_brokers" env:"KAFKA_BROKERS"`
+	// Address is the single broker used when Brokers is empty.
 	Address             string `yaml:"kafka_address" env:"KAFKA_ADDRESS" env-default:":7083"`
 	AudioInputTopic     string `yaml:"kafka_audio_input_topic" env:"KAFKA_AUDIO_INPUT_TOPIC" env-default:"audio-input"`
 	VideoInputTopic     string `yaml:"kafka_video_input_topic" env:"KAFKA_VIDEO_INPUT_TOPIC" env-default:"video-input"`
 	VideoCopyrightTopic string `yaml:"kafka_video_copyright_topic" env:"KAFKA_AUDIO_COPYRIGHT_TOPIC" env-default:"audio-copyright"`
 	AudioCopyrightTopic string `yaml:"kafka_audio_copyright_topic" env:"KAFKA_VIDEO_COPYRIGHT_TOPIC" env-default:"video-copyright"`
+	// ObjectEventsTopic receives the bucket notifications MinIO publishes for the watched bucket.
+	ObjectEventsTopic string `yaml:"kafka_object_events_topic" env:"KAFKA_OBJECT_EVENTS_TOPIC" env-default:"minio-events"`
+	// Partitions is the partition count of topics created on start; workers of a consumer group scale up to it.
+	Partitions int `yaml:"kafka_partitions" env:"KAFKA_PARTITIONS" env-default:"1"`
+	// ReplicationFactor is the replica count of topics created on start; it must not exceed the brokers.
+	ReplicationFactor int `yaml:"kafka_replication_factor" env:"KAFKA_REPLICATION_FACTOR" env-default:"1"`
+	// TopicPartitions and TopicReplicationFactors override Partitions and ReplicationFactor per topic name,
+	// e.g. "video-input:8,video-copyright:8". Topics that exist already are left unchanged.
+	TopicPartitions         map[string]int `yaml:"kafka_topic_partitions" env:"KAFKA_TOPIC_PARTITIONS"`
+	TopicReplicationFactors map[string]int `yaml:"kafka_topic_replication_factors" env:"KAFKA_TOPIC_REPLICATION_FACTORS"`
+	// ReaderMinBytes and ReaderMaxBytes bound the size of the batches the consumers fetch; a fetch waits
+	// for ReaderMinBytes, and a single message larger than ReaderMaxBytes cannot be read.
+	ReaderMinBytes int `yaml:"kafka_reader_min_bytes" env:"KAFKA_READER_MIN_BYTES" env-default:"1"`
+	ReaderMaxBytes int `yaml:"kafka_reader_max_bytes" env:"KAFKA_READER_MAX_BYTES" env-default:"10000000"`
+	// SASLMechanism enables SASL authentication: plain, scram-sha-256 or scram-sha-512.
+	SASLMechanism string `yaml:"kafka_sasl_mechanism" env:"KAFKA_SASL_MECHANISM"`
+	SASLUser      string `yaml:"kafka_sasl_user" env:"KAFKA_SASL_USER"`
+	SASLPassword  string `yaml:"kafka_sasl_password" env:"KAFKA_SASL_PASSWORD" secret:"true"`
+	// TLS encrypts broker connections; TLSCAFile replaces the system roots when set.
+	TLS       bool   `yaml:"kafka_tls" env:"KAFKA_TLS" env-default:"false"`
+	TLSCAFile string `yaml:"kafka_tls_ca_file" env:"KAFKA_TLS_CA_FILE"`
+	// DeadLetterTopic receives the result messages that cannot be applied, with the error in the headers;
+	// empty drops them after logging.
+	DeadLetterTopic string `yaml:"kafka_dead_letter_topic" env:"KAFKA_DEAD_LETTER_TOPIC" env-default:"bff-dead-letter"`
+	// RetryAttempts bounds the tries of a result message failing with a transient error;
+	// the delay between them doubles from RetryBackoff.
+	RetryAttempts int           `yaml:"kafka_retry_attempts" env:"KAFKA_RETRY_ATTEMPTS" env-default:"5"`
+	RetryBackoff  time.Duration `yaml:"kafka_retry_backoff" env:"KAFKA_RETRY_BACKOFF" env-default:"500ms"`
+	// LedgerRetention is how long the processed-message and pushed-result ledgers keep an entry before the
+	// reaper prunes it; zero keeps them. It must not be shorter than the retention of the topics, or a
+	// redelivered message is applied again. The entries of a topic must be deleted from
+	// kafka_processed_message when the topic is recreated, as its offsets start over and would be taken
+	// for processed messages.
+	LedgerRetention time.Duration `yaml:"kafka_ledger_retention" env:"KAFKA_LEDGER_RETENTION" env-default:"168h"`
+	// RestartBackoff is the first delay before a failed reader is created again; it doubles up to a minute
+	// while the reader keeps failing.
+	RestartBackoff time.Duration `yaml:"kafka_restart_backoff" env:"KAFKA_RESTART_BACKOFF" env-default:"1s"`
+	// VerdictTopic receives the verdict stream; it is created with a single partition so the events keep
+	// their order. Empty leaves the stream to the file sink.
+	VerdictTopic string `yaml:"kafka_verdict_topic" env:"KAFKA_VERDICT_TOPIC" env-default:"bff-verdicts"`
+	// MessageFormat serializes the requests to and the results of the ML services: json, protobuf or avro.
+	// Protobuf and Avro register their schemas with the registry at SchemaRegistryURL.
+	MessageFormat          string `yaml:"kafka_message_format" env:"KAFKA_MESSAGE_FORMAT" env-default:"json"`
+	SchemaRegistryURL      string `yaml:"kafka_schema_registry_url" env:"KAFKA_SCHEMA_REGISTRY_URL"`
+	SchemaRegistryUser     string `yaml:"kafka_schema_registry_user" env:"KAFKA_SCHEMA_REGISTRY_USER"`
+	SchemaRegistryPassword string `yaml:"kafka_schema_registry_password" env:"KAFKA_SCHEMA_REGISTRY_PASSWORD" secret:"true"`
+}
+
+// BrokerAddrs returns the bootstrap brokers of the cluster.
+func (c KafkaConfig) BrokerAddrs() []string {
+	if len(c.Brokers) != 0 {
+		return c.Brokers
+	}
+
+	return []string{c.Address}
+}
+
+// AuthConfig enables JWT authentication when Issuer is set. The roles granted by the tokens are viewer,
+// uploader, worker and admin, each including the lower ones; the ML workers pushing results need worker.
+// Without an issuer the routes requiring worker or admin are refused, unless Disabled opts out of
+// authentication altogether.
+type AuthConfig struct {
+	Issuer     string        `yaml:"oidc_issuer" env:"OIDC_ISSUER"`
+	Audience   string        `yaml:"oidc_audience" env:"OIDC_AUDIENCE"`
+	RolesClaim string        `yaml:"oidc_roles_claim" env:"OIDC_ROLES_CLAIM" env-default:"roles"`
+	KeysMaxAge time.Duration `yaml:"oidc_keys_max_age" env:"OIDC_KEYS_MAX_AGE" env-default:"1h"`
+	// BatchTokenTTL is the lifetime of the tokens issued with every batch, which let the frontend
+	// read the summary and the result of that batch only.
+	BatchTokenTTL time.Duration `yaml:"batch_token_ttl" env:"BATCH_TOKEN_TTL" env-default:"1h"`
+	// Disabled serves every route unauthenticated when no issuer is set, e.g. behind an authenticating proxy.
+	Disabled bool `yaml:"auth_disabled" env:"AUTH_DISABLED" env-default:"false"`
+}
+
+// RateLimitConfig limits check and upload requests per client IP and per API key.
+// A zero rate disables the corresponding limit.
+type RateLimitConfig struct {
+	IPRate   float64       `yaml:"rate_limit_ip_rps" env:"RATE_LIMIT_IP_RPS" env-default:"2"`
+	IPBurst  int           `yaml:"rate_limit_ip_burst" env:"RATE_LIMIT_IP_BURST" env-default:"20"`
+	KeyRate  float64       `yaml:"rate_limit_key_rps" env:"RATE_LIMIT_KEY_RPS" env-default:"5"`
+	KeyBurst int           `yaml:"rate_limit_key_burst" env:"RATE_LIMIT_KEY_BURST" env-default:"20"`
+	IdleTTL  time.Duration `yaml:"rate_limit_idle_ttl" env:"RATE_LIMIT_IDLE_TTL" env-default:"10m"`
+}
+
+// UploadConfig limits the videos tasks are created for; zero disables a limit.
+type UploadConfig struct {
+	MaxSize     int64         `yaml:"upload_max_size" env:"UPLOAD_MAX_SIZE" env-default:"2147483648"`
+	MaxDuration time.Duration `yaml:"upload_max_duration" env:"UPLOAD_MAX_DURATION" env-default:"1h"`
+}
+
+// RegistrationConfig controls how references are registered with the ML services.
+type RegistrationConfig struct {
+	Attempts int           `yaml:"registration_attempts" env:"REGISTRATION_ATTEMPTS" env-default:"3"`
+	Backoff  time.Duration `yaml:"registration_backoff" env:"REGISTRATION_BACKOFF" env-default:"2s"`
+	Timeout  time.Duration `yaml:"registration_timeout" env:"REGISTRATION_TIMEOUT" env-default:"5m"`
+}
+
+// DedupConfig selects how objects uploaded directly to storage are hashed for exact duplicate detection.
+// In sample mode only the size and the first and last SampleSize bytes are hashed, and the full hash
+// is computed only when an original shares the sample. Originals without a sample hash match every
+// sample, so the mode pays off once sample hashes are backfilled.
+//
+// Re-encoded copies are caught by the perceptual hash of PerceptualFrames evenly spaced screenshots:
+// a video whose frames differ from those of an original by at most PerceptualMaxDistance of their
+// 64 bits on average is decided as its duplicate without the ML services. Zero frames disable it.
+//
+// The audio is fingerprinted with fpcalc over its first AudioFingerprintLength: audio whose fingerprint
+// agrees with that of an original in at least AudioMinSimilarity of its bits is decided as its copy
+// without the audio ML service, and a task whose audio requests run out of retries is answered from
+// the fingerprints instead of failing. Zero length disables it.
+type DedupConfig struct {
+	Mode                   string        `yaml:"dedup_hash_mode" env:"DEDUP_HASH_MODE" env-default:"full"`
+	SampleSize             int64         `yaml:"dedup_sample_size" env:"DEDUP_SAMPLE_SIZE" env-default:"4194304"`
+	PerceptualFrames       int           `yaml:"dedup_perceptual_frames" env:"DEDUP_PERCEPTUAL_FRAMES" env-default:"8"`
+	PerceptualMaxDistance  float64       `yaml:"dedup_perceptual_max_distance" env:"DEDUP_PERCEPTUAL_MAX_DISTANCE" env-default:"5"`
+	AudioFingerprintLength time.Duration `yaml:"dedup_audio_fingerprint_length" env:"DEDUP_AUDIO_FINGERPRINT_LENGTH" env-default:"2m"`
+	AudioMinSimilarity     float64       `yaml:"dedup_audio_min_similarity" env:"DEDUP_AUDIO_MIN_SIMILARITY" env-default:"0.95"`
+}
+
+// HashConfig selects the digests computed for every video and stored on its task:
+// md5, sha256 and xxh3 are supported.
+type HashConfig struct {
+	Algorithms []string `yaml:"hash_algorithms" env:"HASH_ALGORITHMS" env-default:"md5,sha256,xxh3"`
+}
+
+// PreviewConfig sizes the sprite sheet generated for every video for hover previews: SpriteColumns x
+// SpriteRows frames evenly spaced over the video, each SpriteFrameWidth pixels wide. Zero columns or rows
+// disable it.
+type PreviewConfig struct {
+	SpriteColumns    int `yaml:"preview_sprite_columns" env:"PREVIEW_SPRITE_COLUMNS" env-default:"5"`
+	SpriteRows       int `yaml:"preview_sprite_rows" env:"PREVIEW_SPRITE_ROWS" env-default:"5"`
+	SpriteFrameWidth int `yaml:"preview_sprite_frame_width" env:"PREVIEW_SPRITE_FRAME_WIDTH" env-default:"160"`
+}
+
+// AudioConfig is the format the audio of the videos is extracted in for the audio ML service and the
+// fingerprints. Profile is stereo, 44.1 kHz stereo 16-bit PCM, or speech, 16 kHz mono 16-bit PCM;
+// SampleRate in Hz, Channels and Codec, a PCM codec such as pcm_f32le, override the profile when set.
+// DownmixMono mixes all channels into one whatever the profile.
+type AudioConfig struct {
+	Profile     string `yaml:"audio_profile" env:"AUDIO_PROFILE" env-default:"stereo"`
+	SampleRate  int    `yaml:"audio_sample_rate" env:"AUDIO_SAMPLE_RATE" env-default:"0"`
+	Channels    int    `yaml:"audio_channels" env:"AUDIO_CHANNELS" env-default:"0"`
+	Codec       string `yaml:"audio_codec" env:"AUDIO_CODEC"`
+	DownmixMono bool   `yaml:"audio_downmix_mono" env:"AUDIO_DOWNMIX_MONO" env-default:"false"`
+}
+
+// LoudnessConfig enables the EBU R128 loudness normalization of the extracted audio before it is sent
+// to the audio ML service, so copies at another volume match their originals. Integrated is the target
+// loudness in LUFS, TruePeak the maximum true peak in dBTP and Range the loudness range in LU.
+// Audio that fails to normalize is sent as extracted.
+type LoudnessConfig struct {
+	Normalize  bool    `yaml:"audio_loudnorm" env:"AUDIO_LOUDNORM" env-default:"false"`
+	Integrated float64 `yaml:"audio_loudnorm_integrated" env:"AUDIO_LOUDNORM_INTEGRATED" env-default:"-23"`
+	TruePeak   float64 `yaml:"audio_loudnorm_true_peak" env:"AUDIO_LOUDNORM_TRUE_PEAK" env-default:"-2"`
+	Range      float64 `yaml:"audio_loudnorm_range" env:"AUDIO_LOUDNORM_RANGE" env-default:"7"`
+}
+
+// PlaybackConfig enables the HLS rendition of every video, so reviewers play it in the dashboard without
+// downloading the original. The transcode runs with the audio extraction and delays the checks by its
+// duration. Segments last about SegmentDuration; the rendition is at most MaxHeight pixels high.
+type PlaybackConfig struct {
+	HLS             bool          `yaml:"playback_hls" env:"PLAYBACK_HLS" env-default:"false"`
+	SegmentDuration time.Duration `yaml:"playback_segment_duration" env:"PLAYBACK_SEGMENT_DURATION" env-default:"6s"`
+	MaxHeight       int           `yaml:"playback_max_height" env:"PLAYBACK_MAX_HEIGHT" env-default:"720"`
+}
+
+// SceneConfig configures the scene cut detection: a frame differing from the previous one by more than
+// Threshold, between 0 and 1, starts a scene, and a keyframe from the middle of every scene, FrameWidth
+// pixels wide, is stored with the task and sent to the video-copy service. Of videos with more than
+// MaxScenes scenes evenly spaced cuts are kept; zero disables the detection.
+type SceneConfig struct {
+	Threshold  float64 `yaml:"scene_threshold" env:"SCENE_THRESHOLD" env-default:"0.4"`
+	MaxScenes  int     `yaml:"scene_max_scenes" env:"SCENE_MAX_SCENES" env-default:"32"`
+	FrameWidth int     `yaml:"scene_frame_width" env:"SCENE_FRAME_WIDTH" env-default:"320"`
+}
+
+// QuotaConfig limits the total usage of every account, the subject of the token of a request or else its
+// registered API key; zero means unlimited. With a limit set, tasks are only created for requests with
+// an account.
+type QuotaConfig struct {
+	MaxTasks        int64   `yaml:"quota_max_tasks" env:"QUOTA_MAX_TASKS" env-default:"0"`
+	MaxVideoMinutes float64 `yaml:"quota_max_video_minutes" env:"QUOTA_MAX_VIDEO_MINUTES" env-default:"0"`
+	MaxBytes        int64   `yaml:"quota_max_bytes" env:"QUOTA_MAX_BYTES" env-default:"0"`
+	// APIKeys are the registered API keys separated by commas, a string so that it may be a secret reference;
+	// any other key in X-API-Key is rejected while a limit is set.
+	APIKeys string `yaml:"quota_api_keys" env:"QUOTA_API_KEYS" secret:"true"`
+}
+
+// Keys returns the registered API keys.
+func (c QuotaConfig) Keys() []string {
+	var keys []string
+	for _, k := range strings.Split(c.APIKeys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// Enabled reports whether any limit is set.
+func (c QuotaConfig) Enabled() bool {
+	return c.MaxTasks > 0 || c.MaxVideoMinutes > 0 || c.MaxBytes > 0
+}
+
+type DownloadConfig struct {
+	Attempts int           `yaml:"download_attempts" env:"DOWNLOAD_ATTEMPTS" env-default:"3"`
+	Backoff  time.Duration `yaml:"download_backoff" env:"DOWNLOAD_BACKOFF" env-default:"1s"`
+	Timeout  time.Duration `yaml:"download_timeout" env:"DOWNLOAD_TIMEOUT" env-default:"10m"`
+	// CacheDir keeps downloads with an ETag so repeated links are not fetched again.
+	CacheDir string `yaml:"download_cache_dir" env:"DOWNLOAD_CACHE_DIR" env-default:"/tmp/bff-cache"`
+	// CacheSize bounds the cache in bytes; 0 disables it.
+	CacheSize int64 `yaml:"download_cache_size" env:"DOWNLOAD_CACHE_SIZE" env-default:"10737418240"`
+	// Schemes, AllowHosts and DenyHosts restrict the links users may submit; host entries cover subdomains
+	// and an empty allow list allows every host.
+	Schemes    []string `yaml:"download_schemes" env:"DOWNLOAD_SCHEMES" env-default:"http,https"`
+	AllowHosts []string `yaml:"download_allow_hosts" env:"DOWNLOAD_ALLOW_HOSTS"`
+	DenyHosts  []string `yaml:"download_deny_hosts" env:"DOWNLOAD_DENY_HOSTS" env-default:"localhost,metadata.google.internal"`
+	// AllowPrivate permits links resolving to loopback, private and link-local addresses; for local development only.
+	AllowPrivate bool  `yaml:"download_allow_private" env:"DOWNLOAD_ALLOW_PRIVATE" env-default:"false"`
+	MaxRedirects int   `yaml:"download_max_redirects" env:"DOWNLOAD_MAX_REDIRECTS" env-default:"5"`
+	MaxSize      int64 `yaml:"download_max_size" env:"DOWNLOAD_MAX_SIZE" env-default:"2147483648"`
+}
+
+// ServerConfig tunes the API server. TLS is terminated by the server when both the certificate and the key are set.
+type ServerConfig struct {
+	TLSCertFile       string        `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" env-default:"10s"`
+	// WriteTimeout also bounds synchronous checks, which wait for the detection result.
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" env-default:"15m"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" env-default:"2m"`
+	// CheckWaitBudget is how long a synchronous check waits for the detection result before it answers
+	// 202 with the task to poll; zero waits until the write timeout. CheckRetryAfter is the poll interval
+	// suggested in the Retry-After header of that answer.
+	CheckWaitBudget time.Duration `yaml:"check_wait_budget" env:"CHECK_WAIT_BUDGET" env-default:"60s"`
+	CheckRetryAfter time.Duration `yaml:"check_retry_after" env:"CHECK_RETRY_AFTER" env-default:"10s"`
+	// MaxTaskWaiters caps the requests woken in process when one task finishes, so a burst of checks
+	// of the same task cannot grow the registry without bound; further waiters poll the task. Zero is unlimited.
+	MaxTaskWaiters int `yaml:"max_task_waiters" env:"MAX_TASK_WAITERS" env-default:"64"`
+	// ShutdownTimeout is how long in-flight requests may finish after a termination signal.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" env-default:"30s"`
+	// JobGracePeriod is how long running ffmpeg jobs may finish after a termination signal before they are killed.
+	JobGracePeriod time.Duration `yaml:"job_grace_period" env:"SHUTDOWN_JOB_GRACE_PERIOD" env-default:"20s"`
+}
+
+// OutboxConfig tunes the relay publishing the requests to the ML services recorded with new tasks.
+type OutboxConfig struct {
+	// PollInterval is how often the outbox is checked for requests recorded by other processes.
+	PollInterval time.Duration `yaml:"outbox_poll_interval" env:"OUTBOX_POLL_INTERVAL" env-default:"1s"`
+	BatchSize    int           `yaml:"outbox_batch_size" env:"OUTBOX_BATCH_SIZE" env-default:"100"`
+	// MaxAttempts is how many times a request is published before it is parked and no longer claimed.
+	MaxAttempts int `yaml:"outbox_max_attempts" env:"OUTBOX_MAX_ATTEMPTS" env-default:"10"`
+	// RetryBackoff is how long a failed request waits before it is claimed again, doubled with every attempt.
+	RetryBackoff time.Duration `yaml:"outbox_retry_backoff" env:"OUTBOX_RETRY_BACKOFF" env-default:"1s"`
+	// SentRetention is how long sent requests are kept before the reaper prunes them; zero keeps them.
+	SentRetention time.Duration `yaml:"outbox_sent_retention" env:"OUTBOX_SENT_RETENTION" env-default:"168h"`
+}
+
+// VerdictConfig configures the sinks of the verdict stream besides Kafka.VerdictTopic. The stream is
+// published by the outbox relay at its poll interval.
+type VerdictConfig struct {
+	// File receives the verdict stream as JSON lines appended to it; empty disables the file sink.
+	File string `yaml:"verdict_file" env:"VERDICT_FILE"`
+}
+
+// ModalityConfig bounds the wait for the result of each modality, since audio results arrive in seconds
+// while video takes minutes. A request without a result after its timeout is sent again up to Retries
+// times, then the task fails. Results arriving later than the SLA after the first request are counted as breaches.
+type ModalityConfig struct {
+	AudioTimeout time.Duration `yaml:"audio_result_timeout" env:"AUDIO_RESULT_TIMEOUT" env-default:"2m"`
+	AudioRetries int           `yaml:"audio_result_retries" env:"AUDIO_RESULT_RETRIES" env-default:"2"`
+	AudioSLA     time.Duration `yaml:"audio_result_sla" env:"AUDIO_RESULT_SLA" env-default:"30s"`
+	VideoTimeout time.Duration `yaml:"video_result_timeout" env:"VIDEO_RESULT_TIMEOUT" env-default:"30m"`
+	VideoRetries int           `yaml:"video_result_retries" env:"VIDEO_RESULT_RETRIES" env-default:"1"`
+	VideoSLA     time.Duration `yaml:"video_result_sla" env:"VIDEO_RESULT_SLA" env-default:"10m"`
+	// ReapInterval is how often requests past their timeout are looked for.
+	ReapInterval time.Duration `yaml:"result_reap_interval" env:"RESULT_REAP_INTERVAL" env-default:"30s"`
+	// TaskDeadline is the deadline of a task from its creation or from the request of a modality again:
+	// tasks still in progress past it are failed whatever their requests are waiting for, and their
+	// pending modalities count as overdue. Zero gives tasks no deadline. It should exceed the timeouts
+	// times the attempts.
+	TaskDeadline time.Duration `yaml:"task_deadline" env:"TASK_DEADLINE" env-default:"2h"`
+}
+
+// ModalityLimits are the timeout, retry and SLA settings of one modality.
+type ModalityLimits struct {
+	Timeout time.Duration
+	Retries int
+	SLA     time.Duration
+}
+
+// Audio returns the settings of the audio modality.
+func (c ModalityConfig) Audio() ModalityLimits {
+	return ModalityLimits{Timeout: c.AudioTimeout, Retries: c.AudioRetries, SLA: c.AudioSLA}
+}
+
+// Video returns the settings of the video modality.
+func (c ModalityConfig) Video() ModalityLimits {
+	return ModalityLimits{Timeout: c.VideoTimeout, Retries: c.VideoRetries, SLA: c.VideoSLA}
+}
+
+// DecisionConfig tunes how the probabilities the modalities report for a reference are fused into
+// one score, and the score from which a checked video is a duplicate of the reference.
+// Strategy is harmonic, weighted, geometric, min, max or video_only; a modality of weight zero is left out.
+type DecisionConfig struct {
+	Threshold   float64 `yaml:"decision_threshold" env:"DECISION_THRESHOLD" env-default:"0.75"`
+	Strategy    string  `yaml:"decision_strategy" env:"DECISION_STRATEGY" env-default:"harmonic"`
+	VideoWeight float64 `yaml:"decision_video_weight" env:"DECISION_VIDEO_WEIGHT" env-default:"1"`
+	AudioWeight float64 `yaml:"decision_audio_weight" env:"DECISION_AUDIO_WEIGHT" env-default:"1"`
+}
+
+// SimilarityConfig schedules the checks of the reference videos against the reference index that build
+// their similarity graph. Every SweepInterval up to BatchSize references last checked longer than
+// RecheckAfter ago are checked again; zero RecheckAfter disables the checks.
+type SimilarityConfig struct {
+	SweepInterval time.Duration `yaml:"similarity_sweep_interval" env:"SIMILARITY_SWEEP_INTERVAL" env-default:"1h"`
+	RecheckAfter  time.Duration `yaml:"similarity_recheck_after" env:"SIMILARITY_RECHECK_AFTER" env-default:"168h"`
+	BatchSize     int           `yaml:"similarity_batch_size" env:"SIMILARITY_BATCH_SIZE" env-default:"50"`
+}
+
+// RecheckConfig schedules the re-checks of the library, the videos registered as originals, against the
+// references registered after their last check. Every SweepInterval up to BatchSize videos are checked
+// again, each at most once per MinInterval; zero SweepInterval disables the re-checks.
+type RecheckConfig struct {
+	SweepInterval time.Duration `yaml:"recheck_sweep_interval" env:"RECHECK_SWEEP_INTERVAL" env-default:"6h"`
+	MinInterval   time.Duration `yaml:"recheck_min_interval" env:"RECHECK_MIN_INTERVAL" env-default:"24h"`
+	BatchSize     int           `yaml:"recheck_batch_size" env:"RECHECK_BATCH_SIZE" env-default:"50"`
+}
+
+// BatchConfig tunes the workers of the API checking the rows of submission CSV batches in the background.
+// A worker holds a row for RowLease; the rows of a stopped worker are claimed again after it, so it must
+// exceed the longest check of a video.
+type BatchConfig struct {
+	Workers      int           `yaml:"batch_workers" env:"BATCH_WORKERS" env-default:"4"`
+	PollInterval time.Duration `yaml:"batch_poll_interval" env:"BATCH_POLL_INTERVAL" env-default:"5s"`
+	RowLease     time.Duration `yaml:"batch_row_lease" env:"BATCH_ROW_LEASE" env-default:"1h"`
+	// Columns maps header names of submission files to the columns they hold, e.g.
+	// "date:created,id:uuid,url:link"; the column names themselves need no mapping.
+	Columns map[string]string `yaml:"batch_csv_columns" env:"BATCH_CSV_COLUMNS"`
+}
+
+// SecretsConfig configures the Vault server secret references of the form vault://<path>#<key> are read from.
+// The token itself may be a file:// or env:// reference, e.g. to a mounted Kubernetes secret.
+type SecretsConfig struct {
+	VaultAddr    string        `yaml:"vault_addr" env:"VAULT_ADDR"`
+	VaultToken   string        `yaml:"vault_token" env:"VAULT_TOKEN" secret:"true"`
+	VaultTimeout time.Duration `yaml:"vault_timeout" env:"VAULT_TIMEOUT" env-default:"10s"`
+}
+
+type TempConfig struct {
+	Dir        string        `yaml:"temp_dir" env:"TEMP_DIR" env-default:"/tmp/bff"`
+	StaleAfter time.Duration `yaml:"temp_stale_after" env:"TEMP_STALE_AFTER" env-default:"1h"`
+}
+
+// FFmpegConfig bounds the ffmpeg and ffprobe processes running at once; further ones wait for a free
+// slot. Zero allows one per CPU.
+//
+// HWAccel decodes videos on the GPU: nvenc, vaapi or qsv; empty decodes in software. A method the ffmpeg
+// binary lacks is ignored, and a run failing on the GPU is repeated in software. HWAccelDevice selects
+// the vaapi device. ExtraFlags are added to every ffmpeg run before its input, e.g. -threads,2.
+type FFmpegConfig struct {
+	MaxProcesses  int      `yaml:"ffmpeg_max_processes" env:"FFMPEG_MAX_PROCESSES" env-default:"0"`
+	HWAccel       string   `yaml:"ffmpeg_hwaccel" env:"FFMPEG_HWACCEL"`
+	HWAccelDevice string   `yaml:"ffmpeg_hwaccel_device" env:"FFMPEG_HWACCEL_DEVICE"`
+	ExtraFlags    []string `yaml:"ffmpeg_extra_flags" env:"FFMPEG_EXTRA_FLAGS"`
 }
 
 type GrpcConfig struct {
 	Address string `yaml:"address" env:"HTTP_ADDRESS" env-default:":7083"`
 }
 
+// PostgresConfig is the database of the tasks. Migrate applies the schema migrations embedded in the
+// binary on startup; without it the schema is migrated with the migrate subcommand.
+//
+// MaxConns and MinConns bound the connections of the pool, MaxConnLifetime closes older connections
+// and HealthCheckPeriod is how often idle connections are checked. StatementTimeout cancels slower
+// statements on the server. Zero keeps the default of pgxpool and the server.
 type PostgresConfig struct {
-	Addr string `yaml:"pg_addr" env:"PG_ADDR"`
+	Addr              string        `yaml:"pg_addr" env:"PG_ADDR" secret:"true"`
+	Migrate           bool          `yaml:"pg_migrate" env:"PG_MIGRATE" env-default:"false"`
+	MaxConns          int32         `yaml:"pg_max_conns" env:"PG_MAX_CONNS" env-default:"0"`
+	MinConns          int32         `yaml:"pg_min_conns" env:"PG_MIN_CONNS" env-default:"0"`
+	MaxConnLifetime   time.Duration `yaml:"pg_max_conn_lifetime" env:"PG_MAX_CONN_LIFETIME" env-default:"0"`
+	HealthCheckPeriod time.Duration `yaml:"pg_health_check_period" env:"PG_HEALTH_CHECK_PERIOD" env-default:"0"`
+	StatementTimeout  time.Duration `yaml:"pg_statement_timeout" env:"PG_STATEMENT_TIMEOUT" env-default:"0"`
 }
 
 type MinioConfig struct {
 	Endpoint          string `yaml:"minio_addr" env:"MINIO_ADDR"`
-	AccessKey         string `yaml:"minio_access_key" env:"MINIO_ACCESS_KEY"`
-	SecretAccessKey   string `yaml:"secret_access_key" env:"MINIO_SECRET_ACCESS_KEY"`
+	AccessKey         string `yaml:"minio_access_key" env:"MINIO_ACCESS_KEY" secret:"true"`
+	SecretAccessKey   string `yaml:"secret_access_key" env:"MINIO_SECRET_ACCESS_KEY" secret:"true"`
 	IsUseSsl          bool   `yaml:"is_use_ssl" env:"MINIO_IS_USE_SSL"`
 	VideoBucket       string `yaml:"video_bucket" env:"VIDEO_BUCKET" env-default:"video"`
 	AudioBucket       string `yaml:"video_bucket" env:"VIDEO_BUCKET" env-default:"audio"`
 	PreviewBucket     string `yaml:"preview_bucket" env:"PREVIEW_BUCKET" env-default:"preview"`
 	OriginVideoBucket string `yaml:"orig_video_bucket" env:"ORIG_VIDEO_BUCKET" env-default:"origvideo"`
+	// WatchBucket is the bucket whose new objects get a checking task each, as announced by MinIO
+	// notifications on the object events topic; empty disables the watcher.
+	WatchBucket string `yaml:"watch_bucket" env:"MINIO_WATCH_BUCKET"`
+}
+
+// StorageConfig selects the object storage backend: minio, s3, gcs or fs. Every backend uses the buckets
+// of MinioConfig and all but fs its endpoint and keys; for s3 and gcs an empty endpoint is the public one
+// of the provider, s3 without keys authenticates like the AWS SDKs, and gcs takes the HMAC keys of a
+// service account. fs keeps objects under Root for development and tests, with presigned URLs served
+// by the API under /storage.
+type StorageConfig struct {
+	Backend string `yaml:"storage_backend" env:"STORAGE_BACKEND" env-default:"minio"`
+	// Region is the AWS region of the s3 buckets.
+	Region string `yaml:"storage_region" env:"STORAGE_REGION"`
+	Root   string `yaml:"storage_root" env:"STORAGE_ROOT" env-default:"objects"`
+	// PublicURL is the /storage route of the API as the ML services and clients reach it.
+	PublicURL string `yaml:"storage_public_url" env:"STORAGE_PUBLIC_URL" env-default:"http://localhost:7083/storage"`
+	// SigningKey signs the presigned URLs of fs; processes sharing Root need the same key.
+	// Empty generates a key per process.
+	SigningKey string `yaml:"storage_signing_key" env:"STORAGE_SIGNING_KEY" secret:"true"`
+	// Objects of MultipartThreshold bytes or more and streams of unknown size are uploaded to the S3
+	// backends in parts of MultipartPartSize bytes, MultipartConcurrency at a time, each held in memory.
+	// A failed part is retried up to MultipartRetries times before the upload is aborted.
+	// A zero threshold uploads every object in a single stream.
+	MultipartThreshold   int64 `yaml:"storage_multipart_threshold" env:"STORAGE_MULTIPART_THRESHOLD" env-default:"67108864"`
+	MultipartPartSize    int64 `yaml:"storage_multipart_part_size" env:"STORAGE_MULTIPART_PART_SIZE" env-default:"16777216"`
+	MultipartConcurrency int   `yaml:"storage_multipart_concurrency" env:"STORAGE_MULTIPART_CONCURRENCY" env-default:"4"`
+	MultipartRetries     int   `yaml:"storage_multipart_retries" env:"STORAGE_MULTIPART_RETRIES" env-default:"3"`
+	// RetryAttempts bounds the tries of an S3 operation failing with a network, throttling or server error;
+	// the delay between them doubles from RetryBackoff.
+	RetryAttempts int           `yaml:"storage_retry_attempts" env:"STORAGE_RETRY_ATTEMPTS" env-default:"4"`
+	RetryBackoff  time.Duration `yaml:"storage_retry_backoff" env:"STORAGE_RETRY_BACKOFF" env-default:"250ms"`
+	// HealthInterval is how often the storage is checked for /readyz.
+	HealthInterval time.Duration `yaml:"storage_health_interval" env:"STORAGE_HEALTH_INTERVAL" env-default:"10s"`
+}
+
+// RetentionConfig sets the lifecycle rules the storage applies to the buckets at startup, in days after an
+// object is written; zero disables a rule. Audio files are only extracted for the ML services, so they
+// expire; ExpireAudioDays must outlast the resets and rechecks that request a result again. Videos move to
+// VideoTransitionClass, a storage class of S3 or GCS or a remote tier of MinIO, after VideoTransitionDays.
+// Changes made through the admin API last until the next startup.
+type RetentionConfig struct {
+	ExpireAudioDays      int    `yaml:"retention_expire_audio_days" env:"RETENTION_EXPIRE_AUDIO_DAYS" env-default:"7"`
+	ExpirePreviewDays    int    `yaml:"retention_expire_preview_days" env:"RETENTION_EXPIRE_PREVIEW_DAYS"`
+	ExpireVideoDays      int    `yaml:"retention_expire_video_days" env:"RETENTION_EXPIRE_VIDEO_DAYS"`
+	VideoTransitionDays  int    `yaml:"retention_video_transition_days" env:"RETENTION_VIDEO_TRANSITION_DAYS"`
+	VideoTransitionClass string `yaml:"retention_video_transition_class" env:"RETENTION_VIDEO_TRANSITION_CLASS"`
 }
 
 func InitConfig() (*Config, *zerolog.Level, error) {
@@ -62,6 +528,10 @@ func InitConfig() (*Config, *zerolog.Level, error) {
 		}
 	}
 
+	if err := resolveSecrets(&cnf); err != nil {
+		return nil, nil, err
+	}
+
 	if cnf.LogLevel == "" {
 		cnf.LogLevel = "info"
 	}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gulldan/cp2024yappy/bff/pkg/secret"
+)
+
+// ErrVaultTokenReference is returned for a Vault token referencing Vault itself.
+var ErrVaultTokenReference = errors.New("vault token must be a plain value or a file:// or env:// reference")
+
+// resolveSecrets replaces the secret references in the fields tagged secret:"true" with the secrets
+// they point to. The Vault token is resolved first, since the other references may need it.
+func resolveSecrets(cnf *Config) error {
+	ctx := context.Background()
+
+	if strings.HasPrefix(cnf.Secrets.VaultToken, secret.SchemeVault) {
+		return ErrVaultTokenReference
+	}
+
+	token, err := secret.New(secret.Options{}).Resolve(ctx, cnf.Secrets.VaultToken)
+	if err != nil {
+		return fmt.Errorf("failed to resolve VAULT_TOKEN: %w", err)
+	}
+	cnf.Secrets.VaultToken = token
+
+	r := secret.New(secret.Options{
+		VaultAddr:  cnf.Secrets.VaultAddr,
+		VaultToken: cnf.Secrets.VaultToken,
+		Timeout:    cnf.Secrets.VaultTimeout,
+	})
+
+	return walkSecrets(reflect.ValueOf(cnf).Elem(), func(name string, v reflect.Value) error {
+		s, err := r.Resolve(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", name, err)
+		}
+		v.SetString(s)
+
+		return nil
+	})
+}
+
+// Redacted returns a copy of the config safe to log, with every secret set replaced.
+func (c Config) Redacted() Config {
+	_ = walkSecrets(reflect.ValueOf(&c).Elem(), func(_ string, v reflect.Value) error {
+		if v.String() != "" {
+			v.SetString(secret.Redacted)
+		}
+		return nil
+	})
+
+	return c
+}
+
+// walkSecrets calls fn with the environment variable name and the value of every string field tagged
+// secret:"true" in the struct v and the structs it embeds.
+func walkSecrets(v reflect.Value, fn func(name string, v reflect.Value) error) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		f, fv := t.Field(i), v.Field(i)
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			if err := walkSecrets(fv, fn); err != nil {
+				return err
+			}
+		case f.Tag.Get("secret") == "true" && f.Type.Kind() == reflect.String:
+			name := f.Tag.Get("env")
+			if name == "" {
+				name = f.Name
+			}
+			if err := fn(name, fv); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Schemes of secret references.
+const (
+	// SchemeFile reads the secret from a file, e.g. file:///run/secrets/pg_dsn; a trailing newline is dropped.
+	SchemeFile = "file://"
+	// SchemeEnv reads the secret from another environment variable, e.g. env://PG_DSN.
+	SchemeEnv = "env://"
+	// SchemeVault reads a key of a Vault KV secret, e.g. vault://secret/data/bff#minio_secret_key.
+	SchemeVault = "vault://"
+)
+
+// Redacted replaces secrets in logs.
+const Redacted = "[REDACTED]"
+
+var (
+	// ErrNotFound is returned for a reference to a secret that does not exist.
+	ErrNotFound = errors.New("secret not found")
+	// ErrVaultNotConfigured is returned for a Vault reference without a Vault address and token.
+	ErrVaultNotConfigured = errors.New("vault is not configured")
+	// ErrMalformedReference is returned for a reference without the parts of its scheme.
+	ErrMalformedReference = errors.New("malformed secret reference")
+)
+
+// Options configures the Vault backend; references to other backends need none.
+type Options struct {
+	VaultAddr  string
+	VaultToken string
+	Timeout    time.Duration
+}
+
+// Resolver resolves secret references to their values.
+type Resolver struct {
+	opts   Options
+	client *http.Client
+}
+
+// New returns a resolver reading Vault secrets as configured.
+func New(opts Options) *Resolver {
+	return &Resolver{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}
+}
+
+// IsReference reports whether a value is a secret reference rather than the secret itself.
+func IsReference(v string) bool {
+	return strings.HasPrefix(v, SchemeFile) || strings.HasPrefix(v, SchemeEnv) || strings.HasPrefix(v, SchemeVault)
+}
+
+// Resolve returns the secret a reference points to; a value that is no reference is returned as is.
+func (r *Resolver) Resolve(ctx context.Context, v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, SchemeFile):
+		b, err := os.ReadFile(strings.TrimPrefix(v, SchemeFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(v, SchemeEnv):
+		name := strings.TrimPrefix(v, SchemeEnv)
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%w: environment variable %s", ErrNotFound, name)
+		}
+		return s, nil
+	case strings.HasPrefix(v, SchemeVault):
+		return r.resolveVault(ctx, strings.TrimPrefix(v, SchemeVault))
+	default:
+		return v, nil
+	}
+}
+
+// resolveVault reads a key of a secret from the Vault HTTP API. The path is read as is, so KV v2
+// paths include their data segment; both the KV v2 and the KV v1 response shapes are understood.
+func (r *Resolver) resolveVault(ctx context.Context, ref string) (string, error) {
+	if r.opts.VaultAddr == "" || r.opts.VaultToken == "" {
+		return "", ErrVaultNotConfigured
+	}
+
+	secretPath, key, ok := strings.Cut(ref, "#")
+	if !ok || secretPath == "" || key == "" {
+		return "", fmt.Errorf("%w: want vault://<path>#<key>", ErrMalformedReference)
+	}
+
+	u, err := url.JoinPath(r.opts.VaultAddr, "v1", secretPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault address: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.opts.VaultToken)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: vault path %s", ErrNotFound, secretPath)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault answered %s for %s", resp.Status, secretPath)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+
+	// KV v2 nests the secret under data.data.
+	data := body.Data
+	if nested, ok := data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return "", fmt.Errorf("failed to decode vault secret: %w", err)
+		}
+	}
+
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("%w: key %s of vault path %s", ErrNotFound, key, secretPath)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("vault key %s of %s is not a string", key, secretPath)
+	}
+
+	return s, nil
+}
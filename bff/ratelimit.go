@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Rate limit scopes.
+const (
+	limitByIP     = "ip"
+	limitByAPIKey = "api_key"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_rate_limited_requests_total",
+	Help: "Requests rejected by the rate limiter.",
+}, []string{"scope"})
+
+// rateLimit rejects requests with 429 once the client IP or, when the request carries one,
+// its API key runs out of tokens. Both buckets are checked, so rotating keys does not lift the IP limit.
+func rateLimit(byIP, byKey *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := byIP.Allow(c.ClientIP()); !ok {
+			rejectRateLimited(c, limitByIP, wait)
+			return
+		}
+
+		if key := apiKeyID(c.GetHeader(apiKeyHeader)); key != "" {
+			if ok, wait := byKey.Allow(key); !ok {
+				rejectRateLimited(c, limitByAPIKey, wait)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func rejectRateLimited(c *gin.Context, scope string, wait time.Duration) {
+	rateLimited.WithLabelValues(scope).Inc()
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "rate limit exceeded: " + scope})
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/apispec"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/auth"
+	"github.com/gulldan/cp2024yappy/bff/internal/pkg/resultschema"
+)
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+const (
+	tagDuplicates = "duplicates"
+	tagTasks      = "tasks"
+	tagAdmin      = "admin"
+	tagResults    = "results"
+)
+
+var (
+	taskIDParam  = apispec.Param{Name: "id", In: apispec.InPath, Type: apispec.TypeInteger, Description: "task id"}
+	hlsFileParam = apispec.Param{Name: "file", In: apispec.InPath, Type: apispec.TypeString, Description: "index.m3u8, or a segment it lists"}
+	sceneParam   = apispec.Param{Name: "scene", In: apispec.InPath, Type: apispec.TypeInteger, Description: "index of the scene, from 0"}
+	origIDParam  = apispec.Param{Name: "id", In: apispec.InPath, Type: apispec.TypeInteger, Description: "original id"}
+)
+
+var (
+	batchIDParam    = apispec.Param{Name: "id", In: apispec.InPath, Type: apispec.TypeInteger, Description: "batch id, returned by the upload of the submission CSV"}
+	batchTokenParam = apispec.Param{Name: batchTokenQuery, In: apispec.InQuery, Type: apispec.TypeString, Description: "batch token, returned in the X-Batch-Token header of the upload; may be sent in that header instead"}
+)
+
+// newSpec builds the OpenAPI document of the current API version from the route table.
+func newSpec(a *API) *apispec.Spec {
+	spec := apispec.New("Video Duplicate Checker API", apiV1)
+	a.registerRoutes(gin.New().Group(latestPrefix), spec)
+
+	return spec
+}
+
+// handle registers a route with request validation and documents it in spec when spec is not nil.
+func handle(g *gin.RouterGroup, spec *apispec.Spec, op apispec.Operation, h gin.HandlerFunc) {
+	g.Handle(op.Method, op.Path, apispec.Validate(op), auditActor(), h)
+
+	if spec != nil {
+		spec.Add(joinPath(g.BasePath(), op.Path), op)
+	}
+}
+
+func joinPath(base, path string) string {
+	if base == "/" {
+		return path
+	}
+
+	return base + path
+}
+
+func (a *API) registerRoutes(g *gin.RouterGroup, spec *apispec.Spec) {
+	viewer := g.Group("", a.auth.Require(auth.RoleViewer))
+	uploader := g.Group("", a.auth.Require(auth.RoleUploader))
+	// Checks and uploads feed ffmpeg and the ML services, so they are rate limited.
+	submit := uploader.Group("", rateLimit(a.limitByIP, a.limitByKey))
+	// Tasks are counted against the account of the request by the quotas.
+	counted := submit.Group("", a.resolveAccount)
+	// A batch is also readable with a token issued with it, instead of API credentials.
+	batchReader := g.Group("", a.batchAccess(a.auth.Require(auth.RoleViewer)))
+
+	handle(counted, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/check-video-duplicate",
+		Summary: "Check a video by link for duplicates",
+		Tags:    []string{tagDuplicates},
+		Body:    VideoLinkRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                    {Description: "Check result", Body: CheckVideoDuplicateResponse{}},
+			http.StatusAccepted:              {Description: "Check outlasted the wait budget; poll the task in the Location header after Retry-After seconds", Body: CheckPendingResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid request or link forbidden by the download policy", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:          {Description: "No token or registered API key while quotas are enabled", Body: ErrorResponse{}},
+			http.StatusForbidden:             {Description: "Quota of the account exceeded", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Video exceeds the size limit", Body: ErrorResponse{}},
+			http.StatusUnsupportedMediaType:  {Description: "Payload is not a video in a supported container: mp4, mov, mkv, webm or avi", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Video exceeds the duration limit", Body: ErrorResponse{}},
+			http.StatusTooManyRequests:       {Description: "Rate limit exceeded", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.CheckVideoDuplicate)
+
+	handle(submit, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/quick-check",
+		Summary: "Check a video by link for duplicates from cached local evidence only, without the ML services",
+		Tags:    []string{tagDuplicates},
+		Body:    QuickCheckRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Quick check result", Body: QuickCheckResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusTooManyRequests:     {Description: "Rate limit exceeded", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.QuickCheck)
+
+	handle(counted, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/upload",
+		Summary:     "Start a batch job checking every video of a submission CSV in the background",
+		Tags:        []string{tagDuplicates},
+		Consumes:    []string{"multipart/form-data"},
+		MaxBodySize: maxSubmissionSize,
+		Params: []apispec.Param{
+			{Name: "file", In: apispec.InFormData, Type: apispec.TypeFile, Required: true, Description: "submission CSV"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusAccepted:              {Description: "Batch started; poll the status in the Location header. X-Batch-Token and X-Batch-Token-Expires carry a token reading the batch", Body: BatchJobResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:          {Description: "No token or registered API key while quotas are enabled", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Submission exceeds the size limit", Body: ErrorResponse{}},
+			http.StatusTooManyRequests:       {Description: "Rate limit exceeded", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.RunCSV)
+
+	handle(batchReader, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/batches/:id",
+		Summary: "Get the status and progress of a submission CSV batch job",
+		Tags:    []string{tagDuplicates},
+		Params:  []apispec.Param{batchIDParam, batchTokenParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Batch status", Body: BatchJobResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "Invalid batch token", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "Batch not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetBatch)
+
+	handle(batchReader, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/batches/:id/summary",
+		Summary: "Get the summary statistics of a submission CSV batch",
+		Tags:    []string{tagDuplicates},
+		Params:  []apispec.Param{batchIDParam, batchTokenParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Batch summary", Body: BatchSummaryResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "Invalid batch token", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "Batch not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetBatchSummary)
+
+	handle(batchReader, spec, apispec.Operation{
+		Method:   http.MethodGet,
+		Path:     "/batches/:id/result",
+		Summary:  "Download the result CSV of a finished submission CSV batch",
+		Tags:     []string{tagDuplicates},
+		Produces: []string{"text/csv"},
+		Params:   []apispec.Param{batchIDParam, batchTokenParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Result CSV"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "Invalid batch token", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "Batch not found", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "Batch still running", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetBatchResult)
+
+	handle(submit, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/task/upload-url",
+		Summary: "Get a presigned URL to upload a video directly to storage",
+		Tags:    []string{tagTasks},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Upload URL", Body: UploadURLResponse{}},
+			http.StatusTooManyRequests:     {Description: "Rate limit exceeded", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetUploadURL)
+
+	handle(counted, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/task/from-object",
+		Summary: "Create a task from an uploaded object",
+		Tags:    []string{tagTasks},
+		Body:    TaskFromObjectRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusCreated:               {Description: "Task created", Body: TaskCreatedResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:              {Description: "Object not uploaded", Body: ErrorResponse{}},
+			http.StatusUnauthorized:          {Description: "No token or registered API key while quotas are enabled", Body: ErrorResponse{}},
+			http.StatusForbidden:             {Description: "Quota of the account exceeded", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Video exceeds the size limit", Body: ErrorResponse{}},
+			http.StatusUnsupportedMediaType:  {Description: "Payload is not a video in a supported container: mp4, mov, mkv, webm or avi", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Video exceeds the duration limit", Body: ErrorResponse{}},
+			http.StatusTooManyRequests:       {Description: "Rate limit exceeded", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.CreateTaskFromObject)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id",
+		Summary: "Get a task",
+		Tags:    []string{tagTasks},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Task", Body: TaskResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTask)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id/rechecks",
+		Summary: "Get the re-checks of a library video against the references registered after its check",
+		Tags:    []string{tagTasks},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Re-checks, oldest first", Body: []RecheckResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskRechecks)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id/metadata",
+		Summary: "Get the codecs, resolution, frame rate, bit rate and duration of the video of a task, probed at ingest",
+		Tags:    []string{tagTasks},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Video metadata", Body: MetadataResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or metadata not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskMetadata)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:   http.MethodGet,
+		Path:     "/task/:id/preview",
+		Summary:  "Get the preview image of a task, a frame from the middle of its video",
+		Tags:     []string{tagTasks},
+		Produces: []string{previewContentType},
+		Params:   []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Preview image"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or preview not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskPreview)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id/sprite",
+		Summary: "Get the layout of the sprite sheet of a task, frames evenly spaced over its video for hover previews",
+		Tags:    []string{tagTasks},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Sprite sheet layout", Body: SpriteResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or sprite sheet not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskSprite)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:   http.MethodGet,
+		Path:     "/task/:id/sprite/image",
+		Summary:  "Get the sprite sheet image of a task",
+		Tags:     []string{tagTasks},
+		Produces: []string{spriteContentType},
+		Params:   []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Sprite sheet"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or sprite sheet not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskSpriteImage)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/task/:id/history",
+		Summary:     "Get the changes of the status of a task",
+		Description: "Tasks created before the history was recorded start with their status at that time.",
+		Tags:        []string{tagTasks},
+		Params:      []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Status changes, oldest first", Body: []TaskEventResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskHistory)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/task/:id/scenes",
+		Summary:     "Get the scenes of the video of a task, cut where the picture changes",
+		Description: "Empty for tasks created while the scene detection was disabled.",
+		Tags:        []string{tagTasks},
+		Params:      []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Scenes in order", Body: []SceneResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskScenes)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:   http.MethodGet,
+		Path:     "/task/:id/scenes/:scene/keyframe",
+		Summary:  "Get the keyframe of a scene of a task, a frame from its middle",
+		Tags:     []string{tagTasks},
+		Produces: []string{keyframeContentType},
+		Params:   []apispec.Param{taskIDParam, sceneParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Keyframe"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or scene not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskKeyframe)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/task/:id/hls/:file",
+		Summary:     "Get the HLS playlist of a task, or a segment it lists, to play the video in the browser",
+		Description: "Served when the HLS transcode is enabled; the playlist is index.m3u8 and lists its segments by relative URL.",
+		Tags:        []string{tagTasks},
+		Produces:    []string{hlsPlaylistContentType, hlsSegmentContentType},
+		Params:      []apispec.Param{taskIDParam, hlsFileParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Playlist or segment"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task or rendition not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskPlayback)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/tasks",
+		Summary: "List tasks",
+		Tags:    []string{tagTasks},
+		Params: []apispec.Param{
+			{Name: "order", In: apispec.InQuery, Type: apispec.TypeString, Description: "asc (default) or desc for the newest tasks first"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of tasks", Body: TaskListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTasks)
+
+	handle(viewer, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/tasks/by-hash/:algorithm/:digest",
+		Summary: "Find the tasks of a video by its digest",
+		Tags:    []string{tagTasks},
+		Params: []apispec.Param{
+			{Name: "algorithm", In: apispec.InPath, Type: apispec.TypeString, Description: "md5, sha256, xxh3 or dhash"},
+			{Name: "digest", In: apispec.InPath, Type: apispec.TypeString, Description: "hex digest"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Tasks with the digest, oldest first", Body: TaskListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTasksByHash)
+
+	handle(uploader, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/usage",
+		Summary: "Get the usage and quota of the account of the request",
+		Tags:    []string{tagTasks},
+		Params: []apispec.Param{
+			{Name: apiKeyHeader, In: apispec.InHeader, Type: apispec.TypeString, Description: "API key, unless the request carries a token"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Usage", Body: UsageResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "No token or registered API key", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetUsage)
+
+	// Results decide tasks, so only the ML workers may push them.
+	worker := g.Group("", a.auth.Require(auth.RoleWorker))
+
+	handle(worker, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/results/:modality",
+		Summary:     "Push a copyright result from a worker",
+		Description: "The body must match the result JSON schema of the version negotiated through the " + resultschema.Header + " header.",
+		Tags:        []string{tagResults},
+		MaxBodySize: maxResultSize,
+		Params: []apispec.Param{
+			{Name: "modality", In: apispec.InPath, Type: apispec.TypeString, Description: "audio or video"},
+			{Name: resultschema.Header, In: apispec.InHeader, Type: apispec.TypeString, Description: "result schema version, latest when omitted"},
+			{Name: "Idempotency-Key", In: apispec.InHeader, Type: apispec.TypeString, Description: "result id, the payload hash when omitted"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                    {Description: "Result already applied", Body: ResultPushResponse{}},
+			http.StatusAccepted:              {Description: "Result applied", Body: ResultPushResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid result", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:              {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Result exceeds the size limit", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.PushResult)
+
+	admin := g.Group("/admin", a.auth.Require(auth.RoleAdmin))
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/index-versions",
+		Summary: "List reference index versions",
+		Tags:    []string{tagAdmin},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Index versions", Body: []IndexVersionResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetIndexVersions)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodPut,
+		Path:    "/index-version",
+		Summary: "Switch new tasks to a reference index version",
+		Tags:    []string{tagAdmin},
+		Body:    SetIndexVersionRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Active index version", Body: IndexVersionResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.SetIndexVersion)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/task/:id/compare",
+		Summary: "Re-check a task against another index version",
+		Tags:    []string{tagAdmin},
+		Params:  []apispec.Param{taskIDParam},
+		Body:    CompareTaskRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusCreated:             {Description: "Comparison task created", Body: TaskCreatedResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.CompareTask)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/task/:id/modality/:modality/reset",
+		Summary:     "Clear the result of one modality of a task and request it again",
+		Description: "The task is in progress until the fresh result arrives and is then decided again with the result of the other modality.",
+		Tags:        []string{tagAdmin},
+		Params: []apispec.Param{
+			taskIDParam,
+			{Name: "modality", In: apispec.InPath, Type: apispec.TypeString, Description: "audio or video"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusAccepted:            {Description: "Result cleared and requested again", Body: TaskResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "The result of the modality is still pending", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.ResetModality)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id/comparisons",
+		Summary: "Get a task and its comparison tasks",
+		Tags:    []string{tagAdmin},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Tasks", Body: []TaskResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTaskComparisons)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/task/:id/registrations",
+		Summary: "Get the reference registrations of a task with the ML services",
+		Tags:    []string{tagAdmin},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Registrations", Body: []RegistrationResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetRegistrations)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/tasks",
+		Summary: "Search tasks by submitter",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "source_ip", In: apispec.InQuery, Type: apispec.TypeString, Description: "exact client IP"},
+			{Name: "user_agent", In: apispec.InQuery, Type: apispec.TypeString, Description: "user agent substring"},
+			{Name: "api_key_id", In: apispec.InQuery, Type: apispec.TypeString, Description: "API key fingerprint"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of tasks", Body: AdminTaskListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.SearchTasks)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/tasks/by-status",
+		Summary:     "List the tasks of a status by age",
+		Description: "Lists e.g. the tasks in progress for longer than older_than, oldest first, with their total number.",
+		Tags:        []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "status", In: apispec.InQuery, Type: apispec.TypeString, Description: "in_progress, done or failed", Required: true},
+			{Name: "older_than", In: apispec.InQuery, Type: apispec.TypeString, Description: "least age of the tasks, e.g. 30m; all tasks by default"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of tasks", Body: TaskStatusListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetTasksByStatus)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/tasks/deleted",
+		Summary: "List the deleted tasks",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of deleted tasks", Body: AdminTaskListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetDeletedTasks)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/task/:id",
+		Summary:     "Delete a task",
+		Description: "The task is hidden from GET /task/:id and the task listings until it is restored or purged; its results, media and audit events are kept.",
+		Tags:        []string{tagAdmin},
+		Params:      []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusNoContent:           {Description: "Task deleted"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "The task is deleted already", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.DeleteTask)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodPost,
+		Path:    "/task/:id/restore",
+		Summary: "Restore a deleted task",
+		Tags:    []string{tagAdmin},
+		Params:  []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Task restored", Body: TaskResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "The task is not deleted", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.RestoreTask)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/task/:id/purge",
+		Summary:     "Remove a deleted task for good",
+		Description: "Only deleted tasks are purged. The audit events of the task are kept; its media objects are left to the lifecycle of their buckets.",
+		Tags:        []string{tagAdmin},
+		Params:      []apispec.Param{taskIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusNoContent:           {Description: "Task purged"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Task not found", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "The task is not deleted", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.PurgeTask)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/reports/sources",
+		Summary: "Report the submitters with the most tasks",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "by", In: apispec.InQuery, Type: apispec.TypeString, Description: "ip (default), user_agent or api_key"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "number of sources"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Sources", Body: []SourceStatResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetSourceReport)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/reports/stages",
+		Summary:     "Report the percentiles of the durations of the processing stages",
+		Description: "Covers the upload, audio extraction, audio check and video check of the tasks that finished the stage within the window.",
+		Tags:        []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "window", In: apispec.InQuery, Type: apispec.TypeString, Description: "how far back to look, e.g. 1h; 24h by default"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Stages", Body: []StageStatsResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetStageStats)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/audit",
+		Summary: "Query the audit log",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "actor", In: apispec.InQuery, Type: apispec.TypeString, Description: "exact actor, e.g. subject:alice"},
+			{Name: "action", In: apispec.InQuery, Type: apispec.TypeString, Description: "exact action, e.g. task.decided"},
+			{Name: "task_id", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "task the action concerns"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of audit events, oldest first", Body: AuditLogResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetAuditLog)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/dead-letters",
+		Summary: "List the messages published to the dead-letter topic",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "topic", In: apispec.InQuery, Type: apispec.TypeString, Description: "topic the messages were read from"},
+			{Name: "pending", In: apispec.InQuery, Type: apispec.TypeBoolean, Description: "only messages not replayed yet"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of dead-lettered messages, oldest first", Body: DeadLetterListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.ListDeadLetters)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/dead-letters/:id/replay",
+		Summary:     "Replay a dead-lettered message",
+		Description: "The message is published to the topic it was read from again, with its original key, value and headers.",
+		Tags:        []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "id", In: apispec.InPath, Type: apispec.TypeInteger, Description: "dead letter id"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusNoContent:           {Description: "Message replayed"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Dead letter not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.ReplayDeadLetter)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodGet,
+		Path:    "/originals",
+		Summary: "List the original videos of the reference catalog",
+		Tags:    []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "name", In: apispec.InQuery, Type: apispec.TypeString, Description: "name substring, ignoring case"},
+			{Name: "hash", In: apispec.InQuery, Type: apispec.TypeString, Description: "exact MD5 of the video"},
+			{Name: "registered_after", In: apispec.InQuery, Type: apispec.TypeString, Description: "earliest registration time, RFC 3339"},
+			{Name: "registered_before", In: apispec.InQuery, Type: apispec.TypeString, Description: "registration time the videos precede, RFC 3339"},
+			{Name: "limit", In: apispec.InQuery, Type: apispec.TypeInteger, Description: "page size"},
+			{Name: "cursor", In: apispec.InQuery, Type: apispec.TypeString, Description: "next_cursor of the previous page"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Page of original videos in the order they were registered", Body: OrigVideoListResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.SearchOrigVideos)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodPost,
+		Path:        "/originals",
+		Summary:     "Register an uploaded video as an original",
+		Description: "The video is not checked for duplicates; its media are prepared as for a task, it is added to the reference catalog and pushed to the indexes of the ML services.",
+		Tags:        []string{tagAdmin},
+		Body:        RegisterOrigVideoRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusCreated:               {Description: "Original registered", Body: RegisteredOrigVideoResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:              {Description: "Object not uploaded", Body: ErrorResponse{}},
+			http.StatusConflict:              {Description: "An original with the same name or content is registered already", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Video exceeds the size limit", Body: ErrorResponse{}},
+			http.StatusUnsupportedMediaType:  {Description: "Payload is not a video in a supported container: mp4, mov, mkv, webm or avi", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Video exceeds the duration limit", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.RegisterOrigVideo)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/originals/:id",
+		Summary:     "Delist an original video",
+		Description: "The video is removed from the reference catalog, so uploads no longer match it by hash, perceptual hash or audio fingerprint. The ML services offer no removal: their indexes keep the video until they are rebuilt.",
+		Tags:        []string{tagAdmin},
+		Params:      []apispec.Param{origIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusNoContent:           {Description: "Original delisted"},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Original not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.DelistOrigVideo)
+
+	handle(admin, spec, apispec.Operation{
+		Method:   http.MethodGet,
+		Path:     "/references/graph",
+		Summary:  "Export the similarity graph of the reference videos",
+		Tags:     []string{tagAdmin},
+		Produces: []string{"application/json", "text/vnd.graphviz"},
+		Params: []apispec.Param{
+			{Name: "min_score", In: apispec.InQuery, Type: apispec.TypeNumber, Description: "lowest score of an edge, defaults to the decision threshold"},
+			{Name: "format", In: apispec.InQuery, Type: apispec.TypeString, Description: "json (default) or dot"},
+		},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Graph of the references, edges linking references found similar by their latest check", Body: ReferenceGraphResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetReferenceGraph)
+
+	handle(admin, spec, apispec.Operation{
+		Method:  http.MethodDelete,
+		Path:    "/batches/:id/tokens",
+		Summary: "Revoke the tokens reading a batch",
+		Tags:    []string{tagAdmin},
+		Params:  []apispec.Param{batchIDParam},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Tokens revoked", Body: RevokeBatchTokensResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Batch not found", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.RevokeBatchTokens)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodGet,
+		Path:        "/retention",
+		Summary:     "Get the lifecycle of the media buckets",
+		Description: "Audio files expire by default, being only extracted for the ML services; the buckets start with the configured lifecycle.",
+		Tags:        []string{tagAdmin},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Lifecycle of each bucket", Body: []RetentionResponse{}},
+			http.StatusNotImplemented:      {Description: "The storage backend does not manage retention", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.GetRetention)
+
+	handle(admin, spec, apispec.Operation{
+		Method:      http.MethodPut,
+		Path:        "/retention/:bucket",
+		Summary:     "Replace the lifecycle of a media bucket",
+		Description: "The change lasts until the next startup, which applies the configured lifecycle again. Lifecycle rules configured outside the service are kept.",
+		Tags:        []string{tagAdmin},
+		Params: []apispec.Param{
+			{Name: "bucket", In: apispec.InPath, Type: apispec.TypeString, Description: "bucket name, as listed by GET /admin/retention"},
+		},
+		Body: RetentionRequest{},
+		Responses: map[int]apispec.Response{
+			http.StatusOK:                  {Description: "Lifecycle of the bucket", Body: RetentionResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid request", Body: apispec.ValidationErrorResponse{}},
+			http.StatusNotFound:            {Description: "Bucket not found", Body: ErrorResponse{}},
+			http.StatusNotImplemented:      {Description: "The storage backend does not manage retention", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Server error", Body: ErrorResponse{}},
+		},
+	}, a.SetRetention)
+}
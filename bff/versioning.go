@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// API versions. Every route is registered once per version; handlers look up the
+// version of the current request to pick the response shape, so a newer version can
+// evolve its payloads while the older ones keep serving the shapes their consumers expect.
+const (
+	apiLegacy = "legacy"
+	apiV1     = "v1"
+
+	apiVersionKey = "api_version"
+	latestPrefix  = "/" + apiV1
+)
+
+var legacyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bff_legacy_api_requests_total",
+	Help: "Requests served by the deprecated unversioned routes.",
+}, []string{"route"})
+
+// withAPIVersion stores the API version of the route group in the request context.
+func withAPIVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, version)
+		c.Next()
+	}
+}
+
+// requestAPIVersion returns the API version the request was routed to.
+func requestAPIVersion(c *gin.Context) string {
+	return c.GetString(apiVersionKey)
+}
+
+// deprecated marks responses of legacy routes as deprecated, points clients to the
+// versioned successor and counts usage so remaining consumers can be found before the sunset.
+func deprecated(sunset string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if sunset != "" {
+			c.Header("Sunset", sunset)
+		}
+		c.Header("Link", "<"+latestPrefix+c.Request.URL.Path+`>; rel="successor-version"`)
+
+		legacyRequests.WithLabelValues(c.FullPath()).Inc()
+
+		c.Next()
+	}
+}
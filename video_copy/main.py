@@ -109,7 +109,11 @@ async def consume_messages_background():
             response = {"task_id": req.task_id, "copyright": [{"name": k, "probability": v} for k, v in results.items()]}
             response = SearchResponse(**response)
 
-            producer.produce(settings.kafka_produce_topic, value=response.model_dump_json().encode("utf-8"))
+            producer.produce(
+                settings.kafka_produce_topic,
+                key=str(req.task_id).encode("utf-8"),
+                value=response.model_dump_json().encode("utf-8"),
+            )
             producer.flush()
             os.remove("search.mp4")
 
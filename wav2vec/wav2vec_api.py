@@ -143,7 +143,11 @@ async def consume_messages_background():
                 "copyright": [{"name": item[0], "probability": item[1]} for item in answer],
             }
             response = CopyrightAnswer(**transformed_answer)
-            producer.produce(KAFKA_PRODUCE_TOPIC, value=response.model_dump_json().encode("utf-8"))
+            producer.produce(
+                KAFKA_PRODUCE_TOPIC,
+                key=str(request.task_id).encode("utf-8"),
+                value=response.model_dump_json().encode("utf-8"),
+            )
             producer.flush()
         except Exception as e:
             logging.error(f"ERROR: {e}")